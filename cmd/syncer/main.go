@@ -17,19 +17,46 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 
 	"k8s.io/klog"
 
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	metadatasyncer "sigs.k8s.io/vsphere-csi-driver/pkg/syncer"
 )
 
+var (
+	migrateClusterIDFrom = flag.String("migrate-cluster-id-from", "",
+		"Migrate the CNS metadata of all volumes from the given cluster ID to the cluster ID in the config, then exit")
+	dryRun         = flag.Bool("dry-run", false, "List the volumes which would be migrated without migrating them")
+	validateConfig = flag.Bool("validate-config", false,
+		"Check the configuration against vCenter, print the result and exit with a non-zero status if any check failed")
+)
+
 // main is ignored when this package is built as a go plug-in.
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
+	if *validateConfig {
+		if err := vsphere.CheckConfigFile(context.Background(), os.Stdout); err != nil {
+			klog.Errorf("Invalid configuration. Err: %v", err)
+			klog.Flush()
+			os.Exit(1)
+		}
+		return
+	}
 	metadataSyncer := metadatasyncer.NewInformer()
+	if *migrateClusterIDFrom != "" {
+		if err := metadataSyncer.MigrateClusterID(*migrateClusterIDFrom, *dryRun); err != nil {
+			klog.Errorf("Error migrating volumes from cluster %q. Err: %v", *migrateClusterIDFrom, err)
+			klog.Flush()
+			os.Exit(1)
+		}
+		klog.Flush()
+		return
+	}
 	if err := metadataSyncer.Init(); err != nil {
 		klog.Errorf("Error initializing Metadata Syncer")
 		os.Exit(1)
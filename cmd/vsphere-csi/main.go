@@ -19,18 +19,31 @@ package main
 import (
 	"context"
 	"flag"
+	"os"
 
 	"github.com/rexray/gocsi"
 	"k8s.io/klog"
 
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/provider"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service"
 )
 
+var validateConfig = flag.Bool("validate-config", false,
+	"Check the configuration against vCenter, print the result and exit with a non-zero status if any check failed")
+
 // main is ignored when this package is built as a go plug-in.
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
+	if *validateConfig {
+		if err := vsphere.CheckConfigFile(context.Background(), os.Stdout); err != nil {
+			klog.Errorf("Invalid configuration. Err: %v", err)
+			klog.Flush()
+			os.Exit(1)
+		}
+		return
+	}
 	gocsi.Run(
 		context.Background(),
 		service.Name,
@@ -0,0 +1,221 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/webhook"
+)
+
+const (
+	// ResultSuccess is the result of a successful operation
+	ResultSuccess = "success"
+	// ResultFailure is the result of a failed operation
+	ResultFailure = "failure"
+
+	// RedactedValue replaces the values of redacted keys
+	RedactedValue = "REDACTED"
+
+	// queueSize is the number of records buffered before new records are dropped
+	queueSize = 1000
+	// webhookTimeout bounds the time spent sending a record to the webhook
+	webhookTimeout = 10 * time.Second
+)
+
+// Record describes a volume lifecycle operation.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	// User is the vCenter user the operation was performed as
+	User       string            `json:"user,omitempty"`
+	VolumeID   string            `json:"volumeId,omitempty"`
+	VolumeName string            `json:"volumeName,omitempty"`
+	Node       string            `json:"node,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Result     string            `json:"result"`
+	Error      string            `json:"error,omitempty"`
+	DurationMs int64             `json:"durationMs"`
+	// OpID is the vCenter operation ID of the operation, which also identifies its CNS tasks in the vCenter logs
+	OpID      string `json:"opId,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Sink stores audit records.
+type Sink interface {
+	Write(record Record) error
+}
+
+// Logger sends audit records to its sinks in the background, so auditing
+// does not slow down operations. Records are dropped if the sinks can not
+// keep up.
+type Logger struct {
+	sinks   []Sink
+	records *webhook.Queue
+}
+
+// NewLogger returns a Logger writing to the given sinks.
+func NewLogger(sinks ...Sink) *Logger {
+	l := &Logger{sinks: sinks}
+	l.records = webhook.NewQueue(queueSize, func(item interface{}) {
+		l.write(item.(Record))
+	})
+	return l
+}
+
+// Log queues the record for the sinks of the logger. It is a no-op on a nil Logger.
+func (l *Logger) Log(record Record) {
+	if l == nil {
+		return
+	}
+	if !l.records.Add(record) {
+		klog.Errorf("Audit queue is full, dropping record %+v", record)
+	}
+}
+
+func (l *Logger) write(record Record) {
+	for _, sink := range l.sinks {
+		if err := sink.Write(record); err != nil {
+			klog.Errorf("Failed to write audit record %+v. Error: %v", record, err)
+		}
+	}
+}
+
+// Redact returns a copy of params with the values of the given keys
+// replaced by RedactedValue. Keys are matched case insensitively.
+func Redact(params map[string]string, keys []string) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(params))
+	for key, value := range params {
+		redacted[key] = value
+		for _, redactKey := range keys {
+			if strings.EqualFold(key, redactKey) {
+				redacted[key] = RedactedValue
+				break
+			}
+		}
+	}
+	return redacted
+}
+
+// fileSink appends records as JSON lines to a file. The file is rotated
+// once it exceeds maxSize, keeping maxBackups rotated files named
+// <path>.1 (newest) to <path>.<maxBackups> (oldest).
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	lock       sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+// NewFileSink returns a Sink appending records to the file at path. The
+// file is rotated once it exceeds maxSizeMB, no rotation happens if
+// maxSizeMB is not positive.
+func NewFileSink(path string, maxSizeMB int, maxBackups int) (Sink, error) {
+	s := &fileSink{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		klog.Errorf("Failed to open audit file %s. Error: %v", s.path, err)
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		klog.Errorf("Failed to stat audit file %s. Error: %v", s.path, err)
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) Write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.maxSize > 0 && s.size+int64(len(line)) > s.maxSize && s.size > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// rotate moves the current file to <path>.1, shifting older files, and opens a new file
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		klog.Warningf("Failed to close audit file %s. Error: %v", s.path, err)
+	}
+	if s.maxBackups <= 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.open()
+	}
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		older := fmt.Sprintf("%s.%d", s.path, i)
+		if err := os.Rename(older, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.open()
+}
+
+// webhookSink posts records as JSON to a URL
+type webhookSink struct {
+	client *webhook.Client
+}
+
+// NewWebhookSink returns a Sink posting each record as JSON to the given URL.
+func NewWebhookSink(url string) Sink {
+	return &webhookSink{client: webhook.NewClient(url, webhookTimeout)}
+}
+
+func (s *webhookSink) Write(record Record) error {
+	return s.client.Post(record)
+}
@@ -18,10 +18,11 @@ package node
 
 import (
 	"errors"
-	"strings"
 	"sync"
 
 	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 )
 
 // ErrNodeAlreadyExists is returned if there's exists a node with
@@ -77,7 +78,7 @@ type defaultCache struct {
 }
 
 func normalizeUUID(nodeUUID string) string {
-	return strings.ToLower(nodeUUID)
+	return vsphere.CanonicalUUID(nodeUUID)
 }
 
 func (c *defaultCache) DeleteNodeByUUID(nodeUUID string) (string, error) {
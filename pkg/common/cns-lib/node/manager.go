@@ -18,6 +18,7 @@ package node
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 
 	clientset "k8s.io/client-go/kubernetes"
@@ -34,12 +35,28 @@ var (
 	ErrEmptyProviderID = errors.New("node with empty providerId present in the cluster")
 )
 
+// Registration holds the UUID, name and metadata of a node to register.
+type Registration struct {
+	UUID     string
+	Name     string
+	Metadata *Metadata
+}
+
 // Manager provides functionality to manage nodes.
 type Manager interface {
 	// SetKubernetesClient sets kubernetes client for node manager
 	SetKubernetesClient(clientset.Interface)
-	// RegisterNode registers a node given its UUID, name.
-	RegisterNode(nodeUUID string, nodeName string) error
+	// RegisterNode registers a node given its UUID, name and metadata. It
+	// fails with ErrNodeAlreadyExists if the name is registered with another
+	// UUID.
+	RegisterNode(nodeUUID string, nodeName string, metadata *Metadata) error
+	// RegisterNodes registers the given nodes and discovers their VMs with
+	// a single query per datacenter, instead of a search per node.
+	RegisterNodes(registrations []Registration) error
+	// UpdateNodeMetadata replaces the metadata of a registered node given its name.
+	UpdateNodeMetadata(nodeName string, metadata *Metadata) error
+	// GetNodeMetadata returns the metadata of a registered node given its name.
+	GetNodeMetadata(nodeName string) (*Metadata, error)
 	// DiscoverNode discovers a registered node given its UUID. This method
 	// scans all virtual centers registered on the VirtualCenterManager for a
 	// virtual machine with the given UUID.
@@ -54,13 +71,12 @@ type Manager interface {
 	// nodes. If nodes are added or removed concurrently, they may or may not be
 	// reflected in the result of a call to this method.
 	GetAllNodes() ([]*vsphere.VirtualMachine, error)
+	// GetAllNodeNames returns the names of all registered nodes.
+	GetAllNodeNames() []string
 	// UnregisterNode unregisters a registered node given its name.
 	UnregisterNode(nodeName string) error
 }
 
-// Metadata represents node metadata.
-type Metadata interface{}
-
 var (
 	// managerInstance is a Manager singleton.
 	managerInstance *nodeManager
@@ -75,6 +91,7 @@ func GetManager() Manager {
 		managerInstance = &nodeManager{
 			nodeVMs: sync.Map{},
 		}
+		vsphere.RegisterFailoverHandler(managerInstance.forgetNodeVMs)
 		klog.V(1).Info("node.nodeManager initialized")
 	})
 	return managerInstance
@@ -84,8 +101,14 @@ func GetManager() Manager {
 type nodeManager struct {
 	// nodeVMs maps node UUIDs to VirtualMachine objects.
 	nodeVMs sync.Map
-	// node name to node UUI map.
+	// nodeNameToUUID indexes the node UUIDs by node name. Several names may
+	// map to the same UUID while a node is renamed, a name never maps to
+	// more than one UUID.
 	nodeNameToUUID sync.Map
+	// nodeMetadata maps node UUIDs to node Metadata.
+	nodeMetadata sync.Map
+	// lock serializes the changes to the name index.
+	lock sync.Mutex
 	// k8s client
 	k8sClient clientset.Interface
 }
@@ -95,10 +118,15 @@ func (m *nodeManager) SetKubernetesClient(client clientset.Interface) {
 	m.k8sClient = client
 }
 
-// RegisterNode registers a node with node manager using its UUID, name.
-func (m *nodeManager) RegisterNode(nodeUUID string, nodeName string) error {
-	m.nodeNameToUUID.Store(nodeName, nodeUUID)
-	klog.V(2).Infof("Successfully registered node: %q with nodeUUID %q", nodeName, nodeUUID)
+// RegisterNode registers a node with node manager using its UUID, name and metadata.
+func (m *nodeManager) RegisterNode(nodeUUID string, nodeName string, metadata *Metadata) error {
+	if err := m.register(nodeUUID, nodeName, metadata); err != nil {
+		return err
+	}
+	if _, discovered := m.nodeVMs.Load(nodeUUID); discovered {
+		// Already discovered by RegisterNodes
+		return nil
+	}
 	err := m.DiscoverNode(nodeUUID)
 	if err != nil {
 		klog.Errorf("Failed to discover VM with uuid: %q for node: %q", nodeUUID, nodeName)
@@ -108,6 +136,83 @@ func (m *nodeManager) RegisterNode(nodeUUID string, nodeName string) error {
 	return nil
 }
 
+// register adds a node to the name index and records its metadata. It fails
+// with ErrNodeAlreadyExists when the name is registered with another UUID,
+// e.g. when nodes of two clusters with the same names are managed together.
+// A node which is replaced by a new VM must be unregistered first.
+func (m *nodeManager) register(nodeUUID string, nodeName string, metadata *Metadata) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if existing, found := m.nodeNameToUUID.Load(nodeName); found {
+		existingUUID := existing.(string)
+		if existingUUID != "" && nodeUUID != "" && !vsphere.UUIDsEqual(existingUUID, nodeUUID) {
+			klog.Errorf("Node %q is already registered with nodeUUID %q, failed to register it with nodeUUID %q",
+				nodeName, existingUUID, nodeUUID)
+			return fmt.Errorf("couldn't register node %q with nodeUUID %q, it is registered with nodeUUID %q: %w",
+				nodeName, nodeUUID, existingUUID, ErrNodeAlreadyExists)
+		}
+	}
+	if nodeUUID != "" {
+		for _, otherName := range m.namesOf(nodeUUID) {
+			if otherName != nodeName {
+				klog.Warningf("Node %q has the same nodeUUID %q as node %q, expected only while a node is renamed",
+					nodeName, nodeUUID, otherName)
+			}
+		}
+	}
+	m.nodeNameToUUID.Store(nodeName, nodeUUID)
+	if metadata != nil && nodeUUID != "" {
+		m.nodeMetadata.Store(nodeUUID, metadata)
+	}
+	klog.V(2).Infof("Successfully registered node: %q with nodeUUID %q", nodeName, nodeUUID)
+	return nil
+}
+
+// namesOf returns the names of the registered nodes with the given UUID.
+func (m *nodeManager) namesOf(nodeUUID string) []string {
+	var nodeNames []string
+	m.nodeNameToUUID.Range(func(nodeName, uuid interface{}) bool {
+		if vsphere.UUIDsEqual(uuid.(string), nodeUUID) {
+			nodeNames = append(nodeNames, nodeName.(string))
+		}
+		return true
+	})
+	return nodeNames
+}
+
+// RegisterNodes registers the given nodes and discovers their VMs in bulk.
+// Nodes whose VM isn't found are still registered, and discovered on their
+// next lookup.
+func (m *nodeManager) RegisterNodes(registrations []Registration) error {
+	var nodeUUIDs []string
+	for _, registration := range registrations {
+		if err := m.register(registration.UUID, registration.Name, registration.Metadata); err != nil {
+			klog.Warningf("Failed to register node %q. Err: %v", registration.Name, err)
+			continue
+		}
+		if registration.UUID != "" {
+			nodeUUIDs = append(nodeUUIDs, registration.UUID)
+		}
+	}
+	if len(nodeUUIDs) == 0 {
+		return nil
+	}
+	vms, err := vsphere.GetVirtualMachinesByUUID(nodeUUIDs)
+	if err != nil {
+		klog.Errorf("Failed to discover VMs of %d nodes. Err: %v", len(nodeUUIDs), err)
+		return err
+	}
+	for _, registration := range registrations {
+		if vm, found := vms[vsphere.CanonicalUUID(registration.UUID)]; found {
+			m.nodeVMs.Store(registration.UUID, vm)
+		} else if registration.UUID != "" {
+			klog.Warningf("Couldn't find VM with nodeUUID %s of node %q", registration.UUID, registration.Name)
+		}
+	}
+	klog.V(2).Infof("Registered %d nodes, discovered %d of them", len(registrations), len(vms))
+	return nil
+}
+
 // DiscoverNode discovers a registered node given its UUID from vCenter.
 // If node is not found in the vCenter for the given UUID, for ErrVMNotFound is returned to the caller
 func (m *nodeManager) DiscoverNode(nodeUUID string) error {
@@ -121,13 +226,25 @@ func (m *nodeManager) DiscoverNode(nodeUUID string) error {
 	return nil
 }
 
+// forgetNodeVMs drops the VirtualMachine objects of the nodes on the given
+// virtual center, so they are discovered again on their next lookup.
+func (m *nodeManager) forgetNodeVMs(host string) {
+	m.nodeVMs.Range(func(nodeUUID, vmInf interface{}) bool {
+		if vm, ok := vmInf.(*vsphere.VirtualMachine); ok && vm.VirtualCenterHost == host {
+			m.nodeVMs.Delete(nodeUUID)
+			klog.V(2).Infof("Forgot VM %v of node with nodeUUID %s after failover of vCenter %q", vm, nodeUUID, host)
+		}
+		return true
+	})
+}
+
 // GetNodeByName refreshes and returns the VirtualMachine for a registered node
 // given its name.
 func (m *nodeManager) GetNodeByName(nodeName string) (*vsphere.VirtualMachine, error) {
 	nodeUUID, found := m.nodeNameToUUID.Load(nodeName)
 	if !found {
 		klog.Errorf("Node not found with nodeName %s", nodeName)
-		return nil, ErrNodeNotFound
+		return nil, fmt.Errorf("couldn't find node %q: %w", nodeName, ErrNodeNotFound)
 	}
 	if nodeUUID != nil && nodeUUID.(string) != "" {
 		return m.GetNode(nodeUUID.(string))
@@ -237,15 +354,62 @@ func (m *nodeManager) GetAllNodes() ([]*vsphere.VirtualMachine, error) {
 	return vms, nil
 }
 
+// GetAllNodeNames returns the names of all registered nodes.
+func (m *nodeManager) GetAllNodeNames() []string {
+	var nodeNames []string
+	m.nodeNameToUUID.Range(func(nodeName, nodeUUID interface{}) bool {
+		nodeNames = append(nodeNames, nodeName.(string))
+		return true
+	})
+	return nodeNames
+}
+
+// UpdateNodeMetadata replaces the metadata of a registered node given its name.
+func (m *nodeManager) UpdateNodeMetadata(nodeName string, metadata *Metadata) error {
+	nodeUUID, found := m.nodeNameToUUID.Load(nodeName)
+	if !found {
+		klog.Errorf("Node wasn't found, failed to update metadata of node: %q", nodeName)
+		return fmt.Errorf("couldn't update metadata of node %q: %w", nodeName, ErrNodeNotFound)
+	}
+	if nodeUUID.(string) == "" {
+		// Recorded once the node is registered again with its UUID
+		klog.V(4).Infof("Empty nodeUUID observed for the node: %q, not updating its metadata", nodeName)
+		return nil
+	}
+	m.nodeMetadata.Store(nodeUUID, metadata)
+	klog.V(4).Infof("Updated metadata of node %q to %+v", nodeName, *metadata)
+	return nil
+}
+
+// GetNodeMetadata returns the metadata of a registered node given its name.
+func (m *nodeManager) GetNodeMetadata(nodeName string) (*Metadata, error) {
+	nodeUUID, found := m.nodeNameToUUID.Load(nodeName)
+	if !found {
+		return nil, fmt.Errorf("couldn't find node %q: %w", nodeName, ErrNodeNotFound)
+	}
+	metadata, found := m.nodeMetadata.Load(nodeUUID)
+	if !found {
+		return nil, fmt.Errorf("couldn't find metadata of node %q: %w", nodeName, ErrNodeNotFound)
+	}
+	return metadata.(*Metadata), nil
+}
+
 // UnregisterNode unregisters a registered node given its name.
+// The VM and metadata of the node are kept while other names of the node are
+// registered.
 func (m *nodeManager) UnregisterNode(nodeName string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	nodeUUID, found := m.nodeNameToUUID.Load(nodeName)
 	if !found {
 		klog.Errorf("Node wasn't found, failed to unregister node: %q", nodeName)
-		return ErrNodeNotFound
+		return fmt.Errorf("couldn't unregister node %q: %w", nodeName, ErrNodeNotFound)
 	}
 	m.nodeNameToUUID.Delete(nodeName)
-	m.nodeVMs.Delete(nodeUUID)
+	if otherNames := m.namesOf(nodeUUID.(string)); len(otherNames) == 0 {
+		m.nodeMetadata.Delete(nodeUUID)
+		m.nodeVMs.Delete(nodeUUID)
+	}
 	klog.V(2).Infof("Successfully unregistered node with nodeName %s", nodeName)
 	return nil
 }
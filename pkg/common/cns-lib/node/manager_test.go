@@ -0,0 +1,59 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegisterDuplicateNodeName(t *testing.T) {
+	m := &nodeManager{}
+	if err := m.register("uuid-1", "node-1", &Metadata{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.register("UUID-1", "node-1", nil); err != nil {
+		t.Errorf("registering the same node again failed: %v", err)
+	}
+	if err := m.register("uuid-2", "node-1", nil); !errors.Is(err, ErrNodeAlreadyExists) {
+		t.Errorf("expected ErrNodeAlreadyExists, got %v", err)
+	}
+	if uuid, _ := m.nodeNameToUUID.Load("node-1"); uuid != "UUID-1" {
+		t.Errorf("node-1 should still have UUID-1, got %v", uuid)
+	}
+}
+
+func TestUnregisterRenamedNode(t *testing.T) {
+	m := &nodeManager{}
+	for _, name := range []string{"old-name", "new-name"} {
+		if err := m.register("uuid-1", name, &Metadata{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := m.UnregisterNode("old-name"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.GetNodeMetadata("new-name"); err != nil {
+		t.Errorf("metadata of the renamed node was dropped: %v", err)
+	}
+	if err := m.UnregisterNode("new-name"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, found := m.nodeMetadata.Load("uuid-1"); found {
+		t.Errorf("metadata of the unregistered node was kept")
+	}
+}
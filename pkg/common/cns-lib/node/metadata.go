@@ -0,0 +1,51 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// DriverVersionAnnotation is the annotation the node plugin sets on its node
+// with the version of the driver it runs
+const DriverVersionAnnotation = "csi.vsphere.vmware.com/driver-version"
+
+// Metadata represents node metadata, taken from the kubernetes node object.
+// It allows features depending on the node software, e.g. on the kernel or
+// on the node plugin version, to be enabled per node.
+type Metadata struct {
+	OSImage                 string `json:"osImage,omitempty"`
+	KernelVersion           string `json:"kernelVersion,omitempty"`
+	ContainerRuntimeVersion string `json:"containerRuntimeVersion,omitempty"`
+	KubeletVersion          string `json:"kubeletVersion,omitempty"`
+	Architecture            string `json:"architecture,omitempty"`
+	// DriverVersion is the version of the node plugin, empty until the
+	// node plugin has annotated the node.
+	DriverVersion string `json:"driverVersion,omitempty"`
+}
+
+// GetMetadata returns the metadata of the given kubernetes node
+func GetMetadata(node *v1.Node) *Metadata {
+	return &Metadata{
+		OSImage:                 node.Status.NodeInfo.OSImage,
+		KernelVersion:           node.Status.NodeInfo.KernelVersion,
+		ContainerRuntimeVersion: node.Status.NodeInfo.ContainerRuntimeVersion,
+		KubeletVersion:          node.Status.NodeInfo.KubeletVersion,
+		Architecture:            node.Status.NodeInfo.Architecture,
+		DriverVersion:           node.Annotations[DriverVersionAnnotation],
+	}
+}
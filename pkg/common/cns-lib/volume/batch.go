@@ -0,0 +1,312 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"fmt"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/object"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// batchSize is the maximum number of specs submitted to CNS in a single task
+const batchSize = 100
+
+// BatchResult is the outcome of one spec of a batch operation.
+type BatchResult struct {
+	// VolumeID is the ID of the volume the spec applies to. For created
+	// volumes it is the ID assigned by CNS, empty if the creation failed.
+	VolumeID string
+	// Err is the error of the spec, nil on success.
+	Err error
+}
+
+// CreateVolumes creates the volumes given their specs. The results are in the order of the specs.
+// The specs are submitted to CNS in batches of batchSize. The returned error is only set if no
+// batch could be submitted, the failure of a batch is reported in the results of its specs.
+func (m *volumeManager) CreateVolumes(ctx context.Context, specs []cnstypes.CnsVolumeCreateSpec) ([]BatchResult, error) {
+	err := validateManager(m)
+	if err != nil {
+		return nil, err
+	}
+	release, err := schedule(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(WithOpID(ctx, "cns-createvolumes"))
+	opID := GetOpID(ctx)
+	defer cancel()
+	// Set up the VC connection
+	err = m.virtualCenter.ConnectCNS(ctx)
+	if err != nil {
+		klog.Errorf("ConnectCNS failed with err: %+v", err)
+		return nil, err
+	}
+	s, err := m.virtualCenter.Client.SessionManager.UserSession(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get usersession with err: %v", err)
+		return nil, err
+	}
+	var results []BatchResult
+	for start := 0; start < len(specs); start += batchSize {
+		end := minInt(start+batchSize, len(specs))
+		batch := make([]cnstypes.CnsVolumeCreateSpec, end-start)
+		copy(batch, specs[start:end])
+		for i := range batch {
+			applyVSphereUserPolicy(getVSphereUserPolicy(), s.UserName, &batch[i].Metadata.ContainerCluster)
+		}
+		task, err := m.virtualCenter.CnsClient.CreateVolume(ctx, batch)
+		if err != nil {
+			klog.Errorf("CNS CreateVolume failed from vCenter %q for %d volumes with err: %v, opId: %q", m.virtualCenter.Config.Host, len(batch), err, opID)
+			results = append(results, failedBatchResults(len(batch), err)...)
+			continue
+		}
+		names := make([]string, len(batch))
+		for i := range batch {
+			names[i] = batch[i].Name
+		}
+		batchResults, err := m.waitForBatchTask(ctx, task, "CreateVolumes", opID, names, true, false)
+		if err != nil {
+			results = append(results, failedBatchResults(len(batch), err)...)
+			continue
+		}
+		for i, result := range batchResults {
+			if result.Err != nil {
+				klog.Errorf("failed to create cns volume. createSpec: %s, err: %v, opId: %q", SummarizeCreateSpec(&batch[i]), result.Err, opID)
+			}
+		}
+		results = append(results, batchResults...)
+	}
+	klog.V(2).Infof("CreateVolumes: %d volumes processed, opId: %q", len(specs), opID)
+	return results, nil
+}
+
+// DeleteVolumes deletes the given volumes. The results are in the order of the volume IDs.
+// Volumes which are not found are reported as deleted. Failures are reported as in CreateVolumes.
+func (m *volumeManager) DeleteVolumes(ctx context.Context, volumeIDs []string, deleteDisk bool) ([]BatchResult, error) {
+	err := validateManager(m)
+	if err != nil {
+		return nil, err
+	}
+	release, err := schedule(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(WithOpID(ctx, "cns-deletevolumes"))
+	opID := GetOpID(ctx)
+	defer cancel()
+	// Set up the VC connection
+	err = m.virtualCenter.ConnectCNS(ctx)
+	if err != nil {
+		klog.Errorf("ConnectCNS failed with err: %+v", err)
+		return nil, err
+	}
+	var results []BatchResult
+	for start := 0; start < len(volumeIDs); start += batchSize {
+		end := minInt(start+batchSize, len(volumeIDs))
+		var batch []cnstypes.CnsVolumeId
+		for _, volumeID := range volumeIDs[start:end] {
+			batch = append(batch, cnstypes.CnsVolumeId{Id: volumeID})
+		}
+		task, err := m.virtualCenter.CnsClient.DeleteVolume(ctx, batch, deleteDisk)
+		if err != nil {
+			klog.Errorf("CNS DeleteVolume failed from vCenter %q for %d volumes with err: %v, opId: %q", m.virtualCenter.Config.Host, len(batch), err, opID)
+			batchResults := failedBatchResults(len(batch), err)
+			for i := range batchResults {
+				batchResults[i].VolumeID = batch[i].Id
+			}
+			results = append(results, batchResults...)
+			continue
+		}
+		batchResults, err := m.waitForBatchTask(ctx, task, "DeleteVolumes", opID, volumeIDs[start:end], false, true)
+		if err != nil {
+			batchResults = failedBatchResults(len(batch), err)
+		}
+		for i := range batchResults {
+			batchResults[i].VolumeID = batch[i].Id
+			if batchResults[i].Err != nil {
+				klog.Errorf("Failed to delete volume: %q, err: %v, opId: %q", batch[i].Id, batchResults[i].Err, opID)
+			}
+		}
+		results = append(results, batchResults...)
+	}
+	klog.V(2).Infof("DeleteVolumes: %d volumes processed, opId: %q", len(volumeIDs), opID)
+	return results, nil
+}
+
+// UpdateVolumeMetadataBatch updates the metadata of volumes given their specs.
+// The results are in the order of the specs. Failures are reported as in CreateVolumes.
+func (m *volumeManager) UpdateVolumeMetadataBatch(ctx context.Context, specs []cnstypes.CnsVolumeMetadataUpdateSpec) ([]BatchResult, error) {
+	err := validateManager(m)
+	if err != nil {
+		return nil, err
+	}
+	release, err := schedule(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(WithOpID(ctx, "cns-updatevolumemetadatabatch"))
+	opID := GetOpID(ctx)
+	defer cancel()
+	// Set up the VC connection
+	err = m.virtualCenter.ConnectCNS(ctx)
+	if err != nil {
+		klog.Errorf("ConnectCNS failed with err: %+v", err)
+		return nil, err
+	}
+	s, err := m.virtualCenter.Client.SessionManager.UserSession(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get usersession with err: %v", err)
+		return nil, err
+	}
+	var results []BatchResult
+	for start := 0; start < len(specs); start += batchSize {
+		end := minInt(start+batchSize, len(specs))
+		batch := make([]cnstypes.CnsVolumeMetadataUpdateSpec, end-start)
+		copy(batch, specs[start:end])
+		for i := range batch {
+			applyVSphereUserPolicy(getVSphereUserPolicy(), s.UserName, &batch[i].Metadata.ContainerCluster)
+		}
+		task, err := m.virtualCenter.CnsClient.UpdateVolumeMetadata(ctx, batch)
+		if err != nil {
+			klog.Errorf("CNS UpdateVolume failed from vCenter %q for %d volumes with err: %v, opId: %q", m.virtualCenter.Config.Host, len(batch), err, opID)
+			batchResults := failedBatchResults(len(batch), err)
+			for i := range batchResults {
+				batchResults[i].VolumeID = batch[i].VolumeId.Id
+			}
+			results = append(results, batchResults...)
+			continue
+		}
+		volumeIDs := make([]string, len(batch))
+		for i := range batch {
+			volumeIDs[i] = batch[i].VolumeId.Id
+		}
+		batchResults, err := m.waitForBatchTask(ctx, task, "UpdateVolumeMetadataBatch", opID, volumeIDs, false, false)
+		if err != nil {
+			batchResults = failedBatchResults(len(batch), err)
+		}
+		for i := range batchResults {
+			batchResults[i].VolumeID = batch[i].VolumeId.Id
+			if batchResults[i].Err != nil {
+				klog.Errorf("Failed to update volume. updateSpec: %s, err: %v, opId: %q", SummarizeUpdateSpec(&batch[i]), batchResults[i].Err, opID)
+			}
+		}
+		results = append(results, batchResults...)
+	}
+	klog.V(2).Infof("UpdateVolumeMetadataBatch: %d volumes processed, opId: %q", len(specs), opID)
+	return results, nil
+}
+
+// waitForBatchTask waits for a CNS task submitted for the specs with the
+// given keys and returns the result of each spec, in the order of the specs.
+// The keys are the volume names of the specs if byName is set, their volume
+// IDs otherwise. NotFound faults are not reported as errors if
+// ignoreNotFound is set.
+func (m *volumeManager) waitForBatchTask(ctx context.Context, task *object.Task, operation string, opID string, keys []string, byName bool, ignoreNotFound bool) ([]BatchResult, error) {
+	taskInfo, err := WaitForTask(ctx, task, logTaskProgress(operation, opID))
+	if err != nil {
+		klog.Errorf("Failed to get taskInfo for %s task from vCenter %q with err: %v, opId: %q", operation, m.virtualCenter.Config.Host, err, opID)
+		return nil, err
+	}
+	klog.V(2).Infof("%s: %d specs, opId: %q, task: %q", operation, len(keys), opID, taskInfo.Task.Value)
+	batchResult, ok := taskInfo.Result.(cnstypes.CnsVolumeOperationBatchResult)
+	if !ok {
+		klog.Errorf("unexpected task result for %s task: %q, opId: %q, result: %+v", operation, taskInfo.Task.Value, opID, taskInfo.Result)
+		return nil, taskFaultError(fmt.Sprintf("expected %d volume results", len(keys)), opID, taskInfo.Task)
+	}
+	return demuxBatchResults(batchResult.VolumeResults, keys, byName, ignoreNotFound, opID, taskInfo.Task), nil
+}
+
+// demuxBatchResults matches the volume results of a CNS batch task to the
+// specs with the given keys, as the results are not guaranteed to be in the
+// order of the specs. A result is matched on the volume name of the spec if
+// byName is set, on its volume ID otherwise. Specs without a result are
+// reported as failed.
+func demuxBatchResults(volumeResults []cnstypes.BaseCnsVolumeOperationResult, keys []string, byName bool, ignoreNotFound bool, opID string, task vimtypes.ManagedObjectReference) []BatchResult {
+	// Specs may share a key, e.g. create specs with the same name, in which
+	// case their results are matched in order
+	pending := make(map[string][]int)
+	for i, key := range keys {
+		pending[key] = append(pending[key], i)
+	}
+	results := make([]BatchResult, len(keys))
+	matched := make([]bool, len(keys))
+	for _, baseResult := range volumeResults {
+		result := baseResult.GetCnsVolumeOperationResult()
+		key := result.VolumeId.Id
+		if byName {
+			key = batchResultName(baseResult)
+		}
+		indexes := pending[key]
+		if len(indexes) == 0 {
+			klog.Warningf("Ignoring unexpected volume result %q of task: %q, opId: %q", key, task.Value, opID)
+			continue
+		}
+		i := indexes[0]
+		pending[key] = indexes[1:]
+		matched[i] = true
+		results[i].VolumeID = result.VolumeId.Id
+		if result.Fault == nil {
+			continue
+		}
+		if _, notFound := cnsMethodFault(result.Fault).(*vimtypes.NotFound); notFound && ignoreNotFound {
+			continue
+		}
+		results[i].Err = taskFaultError(result.Fault.LocalizedMessage, opID, task)
+		if isResourceInUseFault(result.Fault) {
+			results[i].Err = fmt.Errorf("%w: %v", ErrVolumeInUse, results[i].Err)
+		}
+	}
+	for i := range results {
+		if !matched[i] {
+			results[i].Err = taskFaultError(fmt.Sprintf("no result for volume %q", keys[i]), opID, task)
+		}
+	}
+	return results
+}
+
+// batchResultName returns the volume name of a create volume result, empty
+// for other results
+func batchResultName(result cnstypes.BaseCnsVolumeOperationResult) string {
+	if r, ok := result.(*cnstypes.CnsVolumeCreateResult); ok {
+		return r.Name
+	}
+	return ""
+}
+
+// failedBatchResults returns the results of a batch of count specs which failed as a whole
+func failedBatchResults(count int, err error) []BatchResult {
+	results := make([]BatchResult, count)
+	for i := range results {
+		results[i].Err = err
+	}
+	return results
+}
+
+// minInt returns the smaller of a and b
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"testing"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+)
+
+var testTask = vimtypes.ManagedObjectReference{Type: "Task", Value: "task-1"}
+
+func volumeResult(volumeID string, fault vimtypes.BaseMethodFault) *cnstypes.CnsVolumeOperationResult {
+	result := &cnstypes.CnsVolumeOperationResult{VolumeId: cnstypes.CnsVolumeId{Id: volumeID}}
+	if fault != nil {
+		result.Fault = &cnstypes.CnsFault{Fault: &fault, LocalizedMessage: "failed"}
+	}
+	return result
+}
+
+func TestDemuxBatchResultsByVolumeID(t *testing.T) {
+	volumeResults := []cnstypes.BaseCnsVolumeOperationResult{
+		volumeResult("vol-3", nil),
+		volumeResult("vol-1", &vimtypes.NotFound{}),
+		volumeResult("vol-unknown", nil),
+	}
+	results := demuxBatchResults(volumeResults, []string{"vol-1", "vol-2", "vol-3"}, false, true, "op", testTask)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].VolumeID != "vol-1" || results[0].Err != nil {
+		t.Errorf("expected vol-1 to be ignored as not found, got %+v", results[0])
+	}
+	if results[1].Err == nil {
+		t.Errorf("expected vol-2 without a result to fail, got %+v", results[1])
+	}
+	if results[2].VolumeID != "vol-3" || results[2].Err != nil {
+		t.Errorf("expected vol-3 to succeed, got %+v", results[2])
+	}
+
+	results = demuxBatchResults(volumeResults[1:2], []string{"vol-1"}, false, false, "op", testTask)
+	if results[0].Err == nil {
+		t.Errorf("expected vol-1 to fail when not found faults are not ignored")
+	}
+}
+
+func TestDemuxBatchResultsByName(t *testing.T) {
+	createResult := func(name string, volumeID string, fault vimtypes.BaseMethodFault) *cnstypes.CnsVolumeCreateResult {
+		return &cnstypes.CnsVolumeCreateResult{CnsVolumeOperationResult: *volumeResult(volumeID, fault), Name: name}
+	}
+	volumeResults := []cnstypes.BaseCnsVolumeOperationResult{
+		createResult("pvc-b", "", &vimtypes.InvalidArgument{}),
+		createResult("pvc-a", "vol-a", nil),
+		createResult("pvc-c", "vol-c1", nil),
+		createResult("pvc-c", "vol-c2", nil),
+	}
+	results := demuxBatchResults(volumeResults, []string{"pvc-a", "pvc-b", "pvc-c", "pvc-c"}, true, false, "op", testTask)
+	if results[0].VolumeID != "vol-a" || results[0].Err != nil {
+		t.Errorf("expected pvc-a to be created as vol-a, got %+v", results[0])
+	}
+	if results[1].VolumeID != "" || results[1].Err == nil {
+		t.Errorf("expected pvc-b to fail, got %+v", results[1])
+	}
+	if results[2].VolumeID != "vol-c1" || results[3].VolumeID != "vol-c2" {
+		t.Errorf("expected specs sharing a name to be matched in order, got %+v and %+v", results[2], results[3])
+	}
+}
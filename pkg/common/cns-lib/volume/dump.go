@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/davecgh/go-spew/spew"
+	cnstypes "github.com/vmware/govmomi/cns/types"
+)
+
+// lazyDump formats its value with spew.Sdump when it is printed.
+type lazyDump struct {
+	v interface{}
+}
+
+func (d lazyDump) String() string {
+	return spew.Sdump(d.v)
+}
+
+// Dump returns v wrapped so that it is only formatted with spew.Sdump when
+// the message logging it is printed. Use it with klog.V(n) for large specs,
+// so they cost nothing when the verbosity discards the message.
+func Dump(v interface{}) fmt.Stringer {
+	return lazyDump{v: v}
+}
+
+// SummarizeCreateSpec returns a single line summary of a create spec, to log
+// instead of the full spec at INFO level and above.
+func SummarizeCreateSpec(spec *cnstypes.CnsVolumeCreateSpec) string {
+	var capacityInMb int64
+	if spec.BackingObjectDetails != nil {
+		capacityInMb = spec.BackingObjectDetails.GetCnsBackingObjectDetails().CapacityInMb
+	}
+	return fmt.Sprintf("{name: %s, type: %s, capacityInMb: %d, datastores: %d, profiles: %d, entities: [%s]}",
+		spec.Name, spec.VolumeType, capacityInMb, len(spec.Datastores), len(spec.Profile),
+		summarizeEntities(spec.Metadata.EntityMetadata))
+}
+
+// SummarizeUpdateSpec returns a single line summary of a metadata update spec,
+// to log instead of the full spec at INFO level and above.
+func SummarizeUpdateSpec(spec *cnstypes.CnsVolumeMetadataUpdateSpec) string {
+	return fmt.Sprintf("{volumeId: %s, entities: [%s]}", spec.VolumeId.Id,
+		summarizeEntities(spec.Metadata.EntityMetadata))
+}
+
+// summarizeEntities returns the kinds and names of the entities, e.g.
+// "POD default/web-0"
+func summarizeEntities(entities []cnstypes.BaseCnsEntityMetadata) string {
+	summaries := make([]string, 0, len(entities))
+	for _, entity := range entities {
+		summary := entity.GetCnsEntityMetadata().EntityName
+		if k8sEntity, ok := entity.(*cnstypes.CnsKubernetesEntityMetadata); ok {
+			if k8sEntity.Namespace != "" {
+				summary = k8sEntity.Namespace + "/" + summary
+			}
+			summary = k8sEntity.EntityType + " " + summary
+		}
+		if entity.GetCnsEntityMetadata().Delete {
+			summary += " (delete)"
+		}
+		summaries = append(summaries, summary)
+	}
+	return strings.Join(summaries, ", ")
+}
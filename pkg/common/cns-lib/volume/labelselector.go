@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// ErrUnsupportedLabelSelector is returned when a label selector can't be
+// expressed as a CNS query filter. CNS only matches labels by key and value,
+// so only equality requirements, and set requirements with a single value,
+// are supported.
+var ErrUnsupportedLabelSelector = errors.New("label selector is not supported by CNS queries")
+
+// AddLabelSelectorToQueryFilter adds the requirements of the given Kubernetes
+// label selector, e.g. "app=db,tier in (backend)", to the labels of the query
+// filter, so the volumes whose PV, PVC or Pod metadata carry these labels are
+// selected by CNS instead of being filtered client side. The query filter is
+// left unchanged if an error is returned.
+func AddLabelSelectorToQueryFilter(queryFilter *cnstypes.CnsQueryFilter, selector string) error {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return err
+	}
+	requirements, selectable := parsed.Requirements()
+	if !selectable {
+		return fmt.Errorf("%w: %q selects nothing", ErrUnsupportedLabelSelector, selector)
+	}
+	var selectorLabels []vimtypes.KeyValue
+	for _, requirement := range requirements {
+		values := requirement.Values().List()
+		sort.Strings(values)
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+			if len(values) != 1 {
+				return fmt.Errorf("%w: %q must have a single value", ErrUnsupportedLabelSelector, requirement.String())
+			}
+		default:
+			return fmt.Errorf("%w: operator of %q", ErrUnsupportedLabelSelector, requirement.String())
+		}
+		selectorLabels = append(selectorLabels, vimtypes.KeyValue{Key: requirement.Key(), Value: values[0]})
+	}
+	queryFilter.Labels = append(queryFilter.Labels, selectorLabels...)
+	return nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+)
+
+func TestAddLabelSelectorToQueryFilter(t *testing.T) {
+	tests := []struct {
+		selector string
+		labels   []vimtypes.KeyValue
+		err      error
+	}{
+		{selector: "", labels: nil},
+		{selector: "app=db", labels: []vimtypes.KeyValue{{Key: "app", Value: "db"}}},
+		{selector: "app==db,tier in (backend)",
+			labels: []vimtypes.KeyValue{{Key: "app", Value: "db"}, {Key: "tier", Value: "backend"}}},
+		{selector: "tier in (backend,frontend)", err: ErrUnsupportedLabelSelector},
+		{selector: "app!=db", err: ErrUnsupportedLabelSelector},
+		{selector: "app", err: ErrUnsupportedLabelSelector},
+		// A later requirement fails after an earlier one was converted
+		{selector: "app=db,tier!=web", err: ErrUnsupportedLabelSelector},
+	}
+	for _, test := range tests {
+		queryFilter := cnstypes.CnsQueryFilter{}
+		err := AddLabelSelectorToQueryFilter(&queryFilter, test.selector)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("Selector %q: expected error %v, got %v", test.selector, test.err, err)
+			}
+			if len(queryFilter.Labels) != 0 {
+				t.Errorf("Selector %q: expected the query filter to be left unchanged, got labels %v", test.selector, queryFilter.Labels)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Selector %q: unexpected error %v", test.selector, err)
+			continue
+		}
+		if !reflect.DeepEqual(queryFilter.Labels, test.labels) {
+			t.Errorf("Selector %q: expected labels %v, got %v", test.selector, test.labels, queryFilter.Labels)
+		}
+	}
+}
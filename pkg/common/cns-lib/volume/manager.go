@@ -19,11 +19,13 @@ package volume
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/vmware/govmomi/cns"
 	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/vim25/soap"
 	vimtypes "github.com/vmware/govmomi/vim25/types"
 	"k8s.io/klog"
@@ -34,21 +36,35 @@ import (
 // Manager provides functionality to manage volumes.
 type Manager interface {
 	// CreateVolume creates a new volume given its spec.
-	CreateVolume(spec *cnstypes.CnsVolumeCreateSpec) (*cnstypes.CnsVolumeId, error)
+	CreateVolume(ctx context.Context, spec *cnstypes.CnsVolumeCreateSpec) (*cnstypes.CnsVolumeId, error)
 	// AttachVolume attaches a volume to a virtual machine given the spec.
-	AttachVolume(vm *cnsvsphere.VirtualMachine, volumeID string) (string, error)
+	AttachVolume(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) (string, error)
 	// DetachVolume detaches a volume from the virtual machine given the spec.
-	DetachVolume(vm *cnsvsphere.VirtualMachine, volumeID string) error
+	DetachVolume(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) error
 	// DeleteVolume deletes a volume given its spec.
-	DeleteVolume(volumeID string, deleteDisk bool) error
+	DeleteVolume(ctx context.Context, volumeID string, deleteDisk bool) error
 	// UpdateVolumeMetadata updates a volume metadata given its spec.
-	UpdateVolumeMetadata(spec *cnstypes.CnsVolumeMetadataUpdateSpec) error
+	UpdateVolumeMetadata(ctx context.Context, spec *cnstypes.CnsVolumeMetadataUpdateSpec) error
+	// CreateVolumes creates volumes given their specs, submitting one CNS task for many specs.
+	CreateVolumes(ctx context.Context, specs []cnstypes.CnsVolumeCreateSpec) ([]BatchResult, error)
+	// DeleteVolumes deletes the given volumes, submitting one CNS task for many volumes.
+	DeleteVolumes(ctx context.Context, volumeIDs []string, deleteDisk bool) ([]BatchResult, error)
+	// UpdateVolumeMetadataBatch updates the metadata of volumes given their specs,
+	// submitting one CNS task for many specs.
+	UpdateVolumeMetadataBatch(ctx context.Context, specs []cnstypes.CnsVolumeMetadataUpdateSpec) ([]BatchResult, error)
 	// QueryVolume returns volumes matching the given filter.
-	QueryVolume(queryFilter cnstypes.CnsQueryFilter) (*cnstypes.CnsQueryResult, error)
+	QueryVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter) (*cnstypes.CnsQueryResult, error)
 	// QueryAllVolume returns all volumes matching the given filter and selection.
-	QueryAllVolume(queryFilter cnstypes.CnsQueryFilter, querySelection cnstypes.CnsQuerySelection) (*cnstypes.CnsQueryResult, error)
+	QueryAllVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter, querySelection cnstypes.CnsQuerySelection) (*cnstypes.CnsQueryResult, error)
+	// QueryVolumeByLabelSelector returns volumes matching the given filter and
+	// Kubernetes label selector.
+	QueryVolumeByLabelSelector(ctx context.Context, queryFilter cnstypes.CnsQueryFilter, selector string) (*cnstypes.CnsQueryResult, error)
 }
 
+// ErrVolumeInUse is returned when a volume can not be deleted as it is
+// attached to a virtual machine.
+var ErrVolumeInUse = errors.New("volume is in use")
+
 var (
 	// managerInstance is a Manager singleton.
 	managerInstance *volumeManager
@@ -71,15 +87,23 @@ func GetManager(vc *cnsvsphere.VirtualCenter) Manager {
 // DefaultManager provides functionality to manage volumes.
 type volumeManager struct {
 	virtualCenter *cnsvsphere.VirtualCenter
+	// deleteTasks are the CNS delete tasks in flight
+	deleteTasks pendingTasks
 }
 
 // CreateVolume creates a new volume given its spec.
-func (m *volumeManager) CreateVolume(spec *cnstypes.CnsVolumeCreateSpec) (*cnstypes.CnsVolumeId, error) {
+func (m *volumeManager) CreateVolume(ctx context.Context, spec *cnstypes.CnsVolumeCreateSpec) (*cnstypes.CnsVolumeId, error) {
 	err := validateManager(m)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	release, err := schedule(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(WithOpID(ctx, "cns-createvolume"))
+	opID := GetOpID(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -88,15 +112,13 @@ func (m *volumeManager) CreateVolume(spec *cnstypes.CnsVolumeCreateSpec) (*cnsty
 		return nil, err
 	}
 	// If the VSphereUser in the CreateSpec is different from session user, update the CreateSpec
+	// according to the configured policy
 	s, err := m.virtualCenter.Client.SessionManager.UserSession(ctx)
 	if err != nil {
 		klog.Errorf("Failed to get usersession with err: %v", err)
 		return nil, err
 	}
-	if s.UserName != spec.Metadata.ContainerCluster.VSphereUser {
-		klog.V(4).Infof("Update VSphereUser from %s to %s", spec.Metadata.ContainerCluster.VSphereUser, s.UserName)
-		spec.Metadata.ContainerCluster.VSphereUser = s.UserName
-	}
+	applyVSphereUserPolicy(getVSphereUserPolicy(), s.UserName, &spec.Metadata.ContainerCluster)
 
 	// Construct the CNS VolumeCreateSpec list
 	var cnsCreateSpecList []cnstypes.CnsVolumeCreateSpec
@@ -104,47 +126,87 @@ func (m *volumeManager) CreateVolume(spec *cnstypes.CnsVolumeCreateSpec) (*cnsty
 	// Call the CNS CreateVolume
 	task, err := m.virtualCenter.CnsClient.CreateVolume(ctx, cnsCreateSpecList)
 	if err != nil {
-		klog.Errorf("CNS CreateVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS CreateVolume failed from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
+		cnsvsphere.InvalidateDatastoreURLCacheOnNotFound(err)
 		return nil, err
 	}
 	// Get the taskInfo
-	taskInfo, err := cns.GetTaskInfo(ctx, task)
+	taskInfo, err := WaitForTask(ctx, task, logTaskProgress("CreateVolume", opID))
 	if err != nil {
-		klog.Errorf("Failed to get taskInfo for CreateVolume task from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("Failed to get taskInfo for CreateVolume task from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
 		return nil, err
 	}
-	klog.V(2).Infof("CreateVolume: VolumeName: %q, opId: %q", spec.Name, taskInfo.ActivationId)
+	klog.V(2).Infof("CreateVolume: VolumeName: %q, opId: %q, task: %q", spec.Name, opID, taskInfo.Task.Value)
 	// Get the taskResult
 	taskResult, err := cns.GetTaskResult(ctx, taskInfo)
 
 	if err != nil {
 		klog.Errorf("unable to find the task result for CreateVolume task from vCenter %q. taskID: %q, opId: %q createResults: %+v",
-			m.virtualCenter.Config.Host, taskInfo.Task.Value, taskInfo.ActivationId, taskResult)
-		return nil, err
+			m.virtualCenter.Config.Host, taskInfo.Task.Value, opID, taskResult)
+		return nil, taskFaultError(err.Error(), opID, taskInfo.Task)
 	}
 
 	if taskResult == nil {
-		klog.Errorf("taskResult is empty for CreateVolume task: %q", taskInfo.ActivationId)
-		return nil, errors.New("taskResult is empty")
+		klog.Errorf("taskResult is empty for CreateVolume task: %q, opId: %q", taskInfo.Task.Value, opID)
+		return nil, taskFaultError("taskResult is empty", opID, taskInfo.Task)
 	}
 	volumeOperationRes := taskResult.GetCnsVolumeOperationResult()
 	if volumeOperationRes.Fault != nil {
-		klog.Errorf("failed to create cns volume. createSpec: %q, fault: %q, opId: %q", spew.Sdump(spec), spew.Sdump(volumeOperationRes.Fault), taskInfo.ActivationId)
-		return nil, errors.New(volumeOperationRes.Fault.LocalizedMessage)
+		if isDuplicateNameFault(volumeOperationRes.Fault) {
+			// A retry raced a create which succeeded, return the volume it created
+			volumeID, err := m.getVolumeIDByName(ctx, spec.Name, spec.Metadata.ContainerCluster.ClusterId)
+			if err == nil && volumeID != nil {
+				klog.V(2).Infof("CreateVolume: Volume %q already exists with volumeID: %q, opId: %q", spec.Name, volumeID.Id, opID)
+				return volumeID, nil
+			}
+			klog.Warningf("Failed to find the existing volume %q after a duplicate name fault, opId: %q, err: %v", spec.Name, opID, err)
+		}
+		if cnsvsphere.IsManagedObjectNotFoundFault(cnsMethodFault(volumeOperationRes.Fault)) {
+			// A datastore of the spec may have been removed, look it up again on retry
+			cnsvsphere.InvalidateDatastoreURLCache()
+		}
+		klog.Errorf("failed to create cns volume. createSpec: %s, fault: %q, opId: %q", SummarizeCreateSpec(spec), spew.Sdump(volumeOperationRes.Fault), opID)
+		return nil, taskFaultError(volumeOperationRes.Fault.LocalizedMessage, opID, taskInfo.Task)
 	}
-	klog.V(2).Infof("CreateVolume: Volume created successfully. VolumeName: %q, opId: %q, volumeID: %q", spec.Name, taskInfo.ActivationId, volumeOperationRes.VolumeId.Id)
+	klog.V(2).Infof("CreateVolume: Volume created successfully. VolumeName: %q, opId: %q, volumeID: %q", spec.Name, opID, volumeOperationRes.VolumeId.Id)
 	return &cnstypes.CnsVolumeId{
 		Id: volumeOperationRes.VolumeId.Id,
 	}, nil
 }
 
+// getVolumeIDByName returns the ID of the volume with the given name in the
+// given cluster, or nil if there is none
+func (m *volumeManager) getVolumeIDByName(ctx context.Context, name string, clusterID string) (*cnstypes.CnsVolumeId, error) {
+	queryFilter := cnstypes.CnsQueryFilter{
+		Names:               []string{name},
+		ContainerClusterIds: []string{clusterID},
+	}
+	res, err := m.virtualCenter.CnsClient.QueryVolume(ctx, queryFilter)
+	if err != nil {
+		klog.Errorf("CNS QueryVolume failed from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, GetOpID(ctx))
+		return nil, err
+	}
+	for _, volume := range res.Volumes {
+		if volume.Name == name {
+			return &cnstypes.CnsVolumeId{Id: volume.VolumeId.Id}, nil
+		}
+	}
+	return nil, nil
+}
+
 // AttachVolume attaches a volume to a virtual machine given the spec.
-func (m *volumeManager) AttachVolume(vm *cnsvsphere.VirtualMachine, volumeID string) (string, error) {
+func (m *volumeManager) AttachVolume(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) (string, error) {
 	err := validateManager(m)
 	if err != nil {
 		return "", err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	release, err := schedule(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(WithOpID(ctx, "cns-attachvolume"))
+	opID := GetOpID(ctx)
 	defer cancel()
 
 	// Set up the VC connection
@@ -153,6 +215,15 @@ func (m *volumeManager) AttachVolume(vm *cnsvsphere.VirtualMachine, volumeID str
 		klog.Errorf("ConnectCNS failed with err: %+v", err)
 		return "", err
 	}
+	// Check if the volume is already attached to the VM, so retried attach
+	// requests succeed without issuing another reconfigure task on the VM.
+	diskUUID, err := GetDiskAttachedToVM(ctx, vm, volumeID)
+	if err != nil {
+		klog.Warningf("Failed to check if volume %q is attached to vm %q, continuing with attach. err: %v", volumeID, vm.String(), err)
+	} else if diskUUID != "" {
+		klog.V(2).Infof("AttachVolume: Volume %q is already attached to vm %q with diskUUID %q", volumeID, vm.String(), diskUUID)
+		return diskUUID, nil
+	}
 	// Construct the CNS AttachSpec list
 	var cnsAttachSpecList []cnstypes.CnsVolumeAttachDetachSpec
 	cnsAttachSpec := cnstypes.CnsVolumeAttachDetachSpec{
@@ -165,34 +236,34 @@ func (m *volumeManager) AttachVolume(vm *cnsvsphere.VirtualMachine, volumeID str
 	// Call the CNS AttachVolume
 	task, err := m.virtualCenter.CnsClient.AttachVolume(ctx, cnsAttachSpecList)
 	if err != nil {
-		klog.Errorf("CNS AttachVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS AttachVolume failed from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
 		return "", err
 	}
 	// Get the taskInfo
-	taskInfo, err := cns.GetTaskInfo(ctx, task)
+	taskInfo, err := WaitForTask(ctx, task, logTaskProgress("AttachVolume", opID))
 	if err != nil {
-		klog.Errorf("Failed to get taskInfo for AttachVolume task from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("Failed to get taskInfo for AttachVolume task from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
 		return "", err
 	}
-	klog.V(2).Infof("AttachVolume: volumeID: %q, vm: %q, opId: %q", volumeID, vm.String(), taskInfo.ActivationId)
+	klog.V(2).Infof("AttachVolume: volumeID: %q, vm: %q, opId: %q, task: %q", volumeID, vm.String(), opID, taskInfo.Task.Value)
 	// Get the taskResult
 	taskResult, err := cns.GetTaskResult(ctx, taskInfo)
 	if err != nil {
 		klog.Errorf("unable to find the task result for AttachVolume task from vCenter %q with taskID %s and attachResults %v",
 			m.virtualCenter.Config.Host, taskInfo.Task.Value, taskResult)
-		return "", err
+		return "", taskFaultError(err.Error(), opID, taskInfo.Task)
 	}
 
 	if taskResult == nil {
-		klog.Errorf("taskResult is empty for AttachVolume task: %q, opId: %q", taskInfo.Task.Value, taskInfo.ActivationId)
-		return "", errors.New("taskResult is empty")
+		klog.Errorf("taskResult is empty for AttachVolume task: %q, opId: %q", taskInfo.Task.Value, opID)
+		return "", taskFaultError("taskResult is empty", opID, taskInfo.Task)
 	}
 
 	volumeOperationRes := taskResult.GetCnsVolumeOperationResult()
 	if volumeOperationRes.Fault != nil {
 		if volumeOperationRes.Fault.LocalizedMessage == CNSVolumeResourceInUseFaultMessage {
 			// Volume is already attached to VM
-			diskUUID, err := GetDiskAttachedToVM(ctx, vm, volumeID)
+			diskUUID, err = GetDiskAttachedToVM(ctx, vm, volumeID)
 			if err != nil {
 				return "", err
 			}
@@ -200,21 +271,27 @@ func (m *volumeManager) AttachVolume(vm *cnsvsphere.VirtualMachine, volumeID str
 				return diskUUID, nil
 			}
 		}
-		klog.Errorf("failed to attach cns volume: %q to node vm: %q. fault: %q. opId: %q", volumeID, vm.String(), spew.Sdump(volumeOperationRes.Fault), taskInfo.ActivationId)
-		return "", errors.New(volumeOperationRes.Fault.LocalizedMessage)
+		klog.Errorf("failed to attach cns volume: %q to node vm: %q. fault: %q. opId: %q", volumeID, vm.String(), spew.Sdump(volumeOperationRes.Fault), opID)
+		return "", taskFaultError(volumeOperationRes.Fault.LocalizedMessage, opID, taskInfo.Task)
 	}
-	diskUUID := interface{}(taskResult).(*cnstypes.CnsVolumeAttachResult).DiskUUID
-	klog.V(2).Infof("AttachVolume: Volume attached successfully. volumeID: %q, opId: %q, vm: %q, diskUUID: %q", volumeID, taskInfo.ActivationId, vm.String(), diskUUID)
+	diskUUID = interface{}(taskResult).(*cnstypes.CnsVolumeAttachResult).DiskUUID
+	klog.V(2).Infof("AttachVolume: Volume attached successfully. volumeID: %q, opId: %q, vm: %q, diskUUID: %q", volumeID, opID, vm.String(), diskUUID)
 	return diskUUID, nil
 }
 
 // DetachVolume detaches a volume from the virtual machine given the spec.
-func (m *volumeManager) DetachVolume(vm *cnsvsphere.VirtualMachine, volumeID string) error {
+func (m *volumeManager) DetachVolume(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) error {
 	err := validateManager(m)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	release, err := schedule(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(WithOpID(ctx, "cns-detachvolume"))
+	opID := GetOpID(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -234,46 +311,52 @@ func (m *volumeManager) DetachVolume(vm *cnsvsphere.VirtualMachine, volumeID str
 	// Call the CNS DetachVolume
 	task, err := m.virtualCenter.CnsClient.DetachVolume(ctx, cnsDetachSpecList)
 	if err != nil {
-		klog.Errorf("CNS DetachVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS DetachVolume failed from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
 		return err
 	}
 	// Get the taskInfo
-	taskInfo, err := cns.GetTaskInfo(ctx, task)
+	taskInfo, err := WaitForTask(ctx, task, logTaskProgress("DetachVolume", opID))
 	if err != nil {
-		klog.Errorf("Failed to get taskInfo for DetachVolume task from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("Failed to get taskInfo for DetachVolume task from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
 		return err
 	}
-	klog.V(2).Infof("DetachVolume: volumeID: %q, vm: %q, opId: %q", volumeID, vm.String(), taskInfo.ActivationId)
+	klog.V(2).Infof("DetachVolume: volumeID: %q, vm: %q, opId: %q, task: %q", volumeID, vm.String(), opID, taskInfo.Task.Value)
 	// Get the task results for the given task
 	taskResult, err := cns.GetTaskResult(ctx, taskInfo)
 	if err != nil {
 		klog.Errorf("unable to find the task result for DetachVolume task from vCenter %q with taskID %s and detachResults %v",
 			m.virtualCenter.Config.Host, taskInfo.Task.Value, taskResult)
-		return err
+		return taskFaultError(err.Error(), opID, taskInfo.Task)
 	}
 
 	if taskResult == nil {
-		klog.Errorf("taskResult is empty for DetachVolume task: %q, opId: %q", taskInfo.Task.Value, taskInfo.ActivationId)
-		return errors.New("taskResult is empty")
+		klog.Errorf("taskResult is empty for DetachVolume task: %q, opId: %q", taskInfo.Task.Value, opID)
+		return taskFaultError("taskResult is empty", opID, taskInfo.Task)
 	}
 
 	volumeOperationRes := taskResult.GetCnsVolumeOperationResult()
 
 	if volumeOperationRes.Fault != nil {
-		klog.Errorf("failed to detach cns volume:%q from node vm: %q. fault: %q, opId: %q", volumeID, vm.InventoryPath, spew.Sdump(volumeOperationRes.Fault), taskInfo.ActivationId)
-		return errors.New(volumeOperationRes.Fault.LocalizedMessage)
+		klog.Errorf("failed to detach cns volume:%q from node vm: %q. fault: %q, opId: %q", volumeID, vm.InventoryPath, spew.Sdump(volumeOperationRes.Fault), opID)
+		return taskFaultError(volumeOperationRes.Fault.LocalizedMessage, opID, taskInfo.Task)
 	}
-	klog.V(2).Infof("DetachVolume: Volume detached successfully. volumeID: %q, vm: %q, opId: %q", volumeID, taskInfo.ActivationId, vm.String())
+	klog.V(2).Infof("DetachVolume: Volume detached successfully. volumeID: %q, vm: %q, opId: %q", volumeID, opID, vm.String())
 	return nil
 }
 
 // DeleteVolume deletes a volume given its spec.
-func (m *volumeManager) DeleteVolume(volumeID string, deleteDisk bool) error {
+func (m *volumeManager) DeleteVolume(ctx context.Context, volumeID string, deleteDisk bool) error {
 	err := validateManager(m)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	release, err := schedule(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(WithOpID(ctx, "cns-deletevolume"))
+	opID := GetOpID(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -286,9 +369,13 @@ func (m *volumeManager) DeleteVolume(volumeID string, deleteDisk bool) error {
 	cnsVolumeID := cnstypes.CnsVolumeId{
 		Id: volumeID,
 	}
-	// Call the CNS DeleteVolume
+	// Call the CNS DeleteVolume, unless a delete of the volume is still in flight
+	// from a previous call which timed out, in which case wait for it instead
 	cnsVolumeIDList = append(cnsVolumeIDList, cnsVolumeID)
-	task, err := m.virtualCenter.CnsClient.DeleteVolume(ctx, cnsVolumeIDList, deleteDisk)
+	taskKey := fmt.Sprintf("%s/%t", volumeID, deleteDisk)
+	task, inFlight, err := m.deleteTasks.getOrStart(taskKey, func() (*object.Task, error) {
+		return m.virtualCenter.CnsClient.DeleteVolume(ctx, cnsVolumeIDList, deleteDisk)
+	})
 	if err != nil {
 		if soap.IsSoapFault(err) {
 			soapFault := soap.ToSoapFault(err)
@@ -297,44 +384,62 @@ func (m *volumeManager) DeleteVolume(volumeID string, deleteDisk bool) error {
 				return nil
 			}
 		}
-		klog.Errorf("CNS DeleteVolume failed from the  vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS DeleteVolume failed from the  vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
 		return err
 	}
+	if inFlight {
+		klog.V(2).Infof("DeleteVolume: waiting for the delete task %q of volumeID: %q already in flight, opId: %q",
+			task.Reference().Value, volumeID, opID)
+	}
 	// Get the taskInfo
-	taskInfo, err := cns.GetTaskInfo(ctx, task)
+	taskInfo, err := WaitForTask(ctx, task, logTaskProgress("DeleteVolume", opID))
 	if err != nil {
-		klog.Errorf("Failed to get taskInfo for DeleteVolume task from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		if ctx.Err() == nil {
+			// The task failed or can't be read anymore, the next call issues a new one
+			m.deleteTasks.done(taskKey, task)
+		}
+		klog.Errorf("Failed to get taskInfo for DeleteVolume task from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
 		return err
 	}
-	klog.V(2).Infof("DeleteVolume: volumeID: %q, opId: %q", volumeID, taskInfo.ActivationId)
+	m.deleteTasks.done(taskKey, task)
+	klog.V(2).Infof("DeleteVolume: volumeID: %q, opId: %q, task: %q", volumeID, opID, taskInfo.Task.Value)
 	// Get the task results for the given task
 	taskResult, err := cns.GetTaskResult(ctx, taskInfo)
 	if err != nil {
 		klog.Errorf("unable to find the task result for DeleteVolume task from vCenter %q with taskID %s and deleteResults %v",
 			m.virtualCenter.Config.Host, taskInfo.Task.Value, taskResult)
-		return err
+		return taskFaultError(err.Error(), opID, taskInfo.Task)
 	}
 	if taskResult == nil {
-		klog.Errorf("taskResult is empty for DeleteVolume task: %q, opID: %q", taskInfo.Task.Value, taskInfo.ActivationId)
-		return errors.New("taskResult is empty")
+		klog.Errorf("taskResult is empty for DeleteVolume task: %q, opID: %q", taskInfo.Task.Value, opID)
+		return taskFaultError("taskResult is empty", opID, taskInfo.Task)
 	}
 
 	volumeOperationRes := taskResult.GetCnsVolumeOperationResult()
 	if volumeOperationRes.Fault != nil {
-		klog.Errorf("Failed to delete volume: %q, fault: %q, opID: %q", volumeID, spew.Sdump(volumeOperationRes.Fault), taskInfo.ActivationId)
-		return errors.New(volumeOperationRes.Fault.LocalizedMessage)
+		klog.Errorf("Failed to delete volume: %q, fault: %q, opID: %q", volumeID, spew.Sdump(volumeOperationRes.Fault), opID)
+		if isResourceInUseFault(volumeOperationRes.Fault) {
+			return fmt.Errorf("%w: %v", ErrVolumeInUse, taskFaultError(volumeOperationRes.Fault.LocalizedMessage, opID, taskInfo.Task))
+		}
+		return taskFaultError(volumeOperationRes.Fault.LocalizedMessage, opID, taskInfo.Task)
 	}
-	klog.V(2).Infof("DeleteVolume: Volume deleted successfully. volumeID: %q, opId: %q", volumeID, taskInfo.ActivationId)
+	klog.V(2).Infof("DeleteVolume: Volume deleted successfully. volumeID: %q, opId: %q", volumeID, opID)
 	return nil
 }
 
 // UpdateVolume updates a volume given its spec.
-func (m *volumeManager) UpdateVolumeMetadata(spec *cnstypes.CnsVolumeMetadataUpdateSpec) error {
+func (m *volumeManager) UpdateVolumeMetadata(ctx context.Context, spec *cnstypes.CnsVolumeMetadataUpdateSpec) error {
 	err := validateManager(m)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	release, err := schedule(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(WithOpID(ctx, "cns-updatevolumemetadata"))
+	opID := GetOpID(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -343,15 +448,13 @@ func (m *volumeManager) UpdateVolumeMetadata(spec *cnstypes.CnsVolumeMetadataUpd
 		return err
 	}
 	// If the VSphereUser in the VolumeMetadataUpdateSpec is different from session user, update the VolumeMetadataUpdateSpec
+	// according to the configured policy
 	s, err := m.virtualCenter.Client.SessionManager.UserSession(ctx)
 	if err != nil {
 		klog.Errorf("Failed to get usersession with err: %v", err)
 		return err
 	}
-	if s.UserName != spec.Metadata.ContainerCluster.VSphereUser {
-		klog.V(4).Infof("Update VSphereUser from %s to %s", spec.Metadata.ContainerCluster.VSphereUser, s.UserName)
-		spec.Metadata.ContainerCluster.VSphereUser = s.UserName
-	}
+	applyVSphereUserPolicy(getVSphereUserPolicy(), s.UserName, &spec.Metadata.ContainerCluster)
 
 	var cnsUpdateSpecList []cnstypes.CnsVolumeMetadataUpdateSpec
 	cnsUpdateSpec := cnstypes.CnsVolumeMetadataUpdateSpec{
@@ -363,44 +466,50 @@ func (m *volumeManager) UpdateVolumeMetadata(spec *cnstypes.CnsVolumeMetadataUpd
 	cnsUpdateSpecList = append(cnsUpdateSpecList, cnsUpdateSpec)
 	task, err := m.virtualCenter.CnsClient.UpdateVolumeMetadata(ctx, cnsUpdateSpecList)
 	if err != nil {
-		klog.Errorf("CNS UpdateVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS UpdateVolume failed from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
 		return err
 	}
 	// Get the taskInfo
-	taskInfo, err := cns.GetTaskInfo(ctx, task)
+	taskInfo, err := WaitForTask(ctx, task, logTaskProgress("UpdateVolume", opID))
 	if err != nil {
-		klog.Errorf("Failed to get taskInfo for UpdateVolume task from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("Failed to get taskInfo for UpdateVolume task from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
 		return err
 	}
-	klog.V(2).Infof("UpdateVolumeMetadata: volumeID: %q, opId: %q", spec.VolumeId.Id, taskInfo.ActivationId)
+	klog.V(2).Infof("UpdateVolumeMetadata: volumeID: %q, opId: %q, task: %q", spec.VolumeId.Id, opID, taskInfo.Task.Value)
 	// Get the task results for the given task
 	taskResult, err := cns.GetTaskResult(ctx, taskInfo)
 	if err != nil {
 		klog.Errorf("unable to find the task result for UpdateVolume task from vCenter %q with taskID %q, opId: %q and updateResults %+v",
-			m.virtualCenter.Config.Host, taskInfo.Task.Value, taskInfo.ActivationId, taskResult)
-		return err
+			m.virtualCenter.Config.Host, taskInfo.Task.Value, opID, taskResult)
+		return taskFaultError(err.Error(), opID, taskInfo.Task)
 	}
 
 	if taskResult == nil {
-		klog.Errorf("taskResult is empty for UpdateVolume task: %q, opId: %q", taskInfo.Task.Value, taskInfo.ActivationId)
-		return errors.New("taskResult is empty")
+		klog.Errorf("taskResult is empty for UpdateVolume task: %q, opId: %q", taskInfo.Task.Value, opID)
+		return taskFaultError("taskResult is empty", opID, taskInfo.Task)
 	}
 	volumeOperationRes := taskResult.GetCnsVolumeOperationResult()
 	if volumeOperationRes.Fault != nil {
-		klog.Errorf("Failed to update volume. updateSpec: %q, fault: %q, opID: %q", spew.Sdump(spec), spew.Sdump(volumeOperationRes.Fault), taskInfo.ActivationId)
-		return errors.New(volumeOperationRes.Fault.LocalizedMessage)
+		klog.Errorf("Failed to update volume. updateSpec: %s, fault: %q, opID: %q", SummarizeUpdateSpec(spec), spew.Sdump(volumeOperationRes.Fault), opID)
+		return taskFaultError(volumeOperationRes.Fault.LocalizedMessage, opID, taskInfo.Task)
 	}
-	klog.V(2).Infof("UpdateVolumeMetadata: Volume metadata updated successfully. volumeID: %q, opId: %q", spec.VolumeId.Id, taskInfo.ActivationId)
+	klog.V(2).Infof("UpdateVolumeMetadata: Volume metadata updated successfully. volumeID: %q, opId: %q", spec.VolumeId.Id, opID)
 	return nil
 }
 
 // QueryVolume returns volumes matching the given filter.
-func (m *volumeManager) QueryVolume(queryFilter cnstypes.CnsQueryFilter) (*cnstypes.CnsQueryResult, error) {
+func (m *volumeManager) QueryVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter) (*cnstypes.CnsQueryResult, error) {
 	err := validateManager(m)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	release, err := schedule(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(WithOpID(ctx, "cns-queryvolume"))
+	opID := GetOpID(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -411,19 +520,37 @@ func (m *volumeManager) QueryVolume(queryFilter cnstypes.CnsQueryFilter) (*cnsty
 	//Call the CNS QueryVolume
 	res, err := m.virtualCenter.CnsClient.QueryVolume(ctx, queryFilter)
 	if err != nil {
-		klog.Errorf("CNS QueryVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS QueryVolume failed from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
 		return nil, err
 	}
 	return res, err
 }
 
+// QueryVolumeByLabelSelector returns volumes matching the given filter and
+// Kubernetes label selector. See AddLabelSelectorToQueryFilter for the
+// supported selectors.
+func (m *volumeManager) QueryVolumeByLabelSelector(ctx context.Context, queryFilter cnstypes.CnsQueryFilter,
+	selector string) (*cnstypes.CnsQueryResult, error) {
+	if err := AddLabelSelectorToQueryFilter(&queryFilter, selector); err != nil {
+		klog.Errorf("Failed to translate label selector %q into a query filter with err: %v", selector, err)
+		return nil, err
+	}
+	return m.QueryVolume(ctx, queryFilter)
+}
+
 // QueryAllVolume returns all volumes matching the given filter and selection.
-func (m *volumeManager) QueryAllVolume(queryFilter cnstypes.CnsQueryFilter, querySelection cnstypes.CnsQuerySelection) (*cnstypes.CnsQueryResult, error) {
+func (m *volumeManager) QueryAllVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter, querySelection cnstypes.CnsQuerySelection) (*cnstypes.CnsQueryResult, error) {
 	err := validateManager(m)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	release, err := schedule(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(WithOpID(ctx, "cns-queryallvolume"))
+	opID := GetOpID(ctx)
 	defer cancel()
 	// Set up the VC connection
 	err = m.virtualCenter.ConnectCNS(ctx)
@@ -434,7 +561,7 @@ func (m *volumeManager) QueryAllVolume(queryFilter cnstypes.CnsQueryFilter, quer
 	//Call the CNS QueryAllVolume
 	res, err := m.virtualCenter.CnsClient.QueryAllVolume(ctx, queryFilter, querySelection)
 	if err != nil {
-		klog.Errorf("CNS QueryAllVolume failed from vCenter %q with err: %v", m.virtualCenter.Config.Host, err)
+		klog.Errorf("CNS QueryAllVolume failed from vCenter %q with err: %v, opId: %q", m.virtualCenter.Config.Host, err, opID)
 		return nil, err
 	}
 	return res, err
@@ -0,0 +1,56 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+)
+
+// WithOpID returns a context carrying a vCenter operation ID. govmomi sends
+// this ID as the opID header on every call made with the returned context,
+// so it shows up in vpxd and vsan-health logs. The ID is built from the given
+// prefix followed by a random suffix. If ctx already carries an operation ID,
+// ctx is returned unchanged.
+func WithOpID(ctx context.Context, prefix string) context.Context {
+	if GetOpID(ctx) != "" {
+		return ctx
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return context.WithValue(ctx, vimtypes.ID{}, prefix)
+	}
+	return context.WithValue(ctx, vimtypes.ID{}, fmt.Sprintf("%s-%s", prefix, hex.EncodeToString(suffix)))
+}
+
+// GetOpID returns the vCenter operation ID carried by ctx, or an empty
+// string if there is none.
+func GetOpID(ctx context.Context) string {
+	opID, _ := ctx.Value(vimtypes.ID{}).(string)
+	return opID
+}
+
+// taskFaultError builds the error returned for a failed CNS task, including
+// the operation ID and task moref so the failure can be matched with the
+// vCenter logs.
+func taskFaultError(msg string, opID string, task vimtypes.ManagedObjectReference) error {
+	return fmt.Errorf("%s (opId: %s, task: %s)", msg, opID, task.Value)
+}
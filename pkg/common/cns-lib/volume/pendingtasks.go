@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"sync"
+
+	"github.com/vmware/govmomi/object"
+)
+
+// pendingTasks records the CNS tasks in flight, keyed on the operation and
+// volume they were issued for. An operation retried while its task is still
+// running, e.g. after the CSI sidecar timed out waiting for a long delete,
+// waits for that task instead of issuing a duplicate one.
+type pendingTasks struct {
+	lock  sync.Mutex
+	tasks map[string]*pendingTask
+}
+
+type pendingTask struct {
+	lock sync.Mutex
+	task *object.Task
+}
+
+// getOrStart returns the task in flight for the key, or the task returned by
+// start if there is none. The returned bool is true if the task was already in
+// flight. Tasks of the same key are started one at a time, so concurrent calls
+// get the same task.
+func (p *pendingTasks) getOrStart(key string, start func() (*object.Task, error)) (*object.Task, bool, error) {
+	p.lock.Lock()
+	if p.tasks == nil {
+		p.tasks = make(map[string]*pendingTask)
+	}
+	entry, found := p.tasks[key]
+	if !found {
+		entry = &pendingTask{}
+		p.tasks[key] = entry
+	}
+	p.lock.Unlock()
+
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+	if entry.task != nil {
+		return entry.task, true, nil
+	}
+	task, err := start()
+	if err != nil {
+		p.remove(key, entry)
+		return nil, false, err
+	}
+	entry.task = task
+	return task, false, nil
+}
+
+// done forgets the task of the key, once it completed or can't be waited for anymore
+func (p *pendingTasks) done(key string, task *object.Task) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if entry, found := p.tasks[key]; found && entry.task == task {
+		delete(p.tasks, key)
+	}
+}
+
+func (p *pendingTasks) remove(key string, entry *pendingTask) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.tasks[key] == entry {
+		delete(p.tasks, key)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+// Priority is the priority of a volume operation
+type Priority int
+
+const (
+	// PriorityForeground is the priority of latency sensitive operations,
+	// such as those serving CSI RPCs. It is the default.
+	PriorityForeground Priority = iota
+	// PriorityBackground is the priority of operations which can wait,
+	// such as full sync. They are throttled when the foreground load is high.
+	PriorityBackground
+)
+
+// maxBackgroundWait bounds the time a background operation waits, so
+// background operations are not starved under sustained foreground load
+const maxBackgroundWait = 5 * time.Minute
+
+// priorityKey is the context key of the priority of an operation
+type priorityKey struct{}
+
+// WithPriority returns a context running volume operations with the given priority.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// getPriority returns the priority of the operations run with ctx
+func getPriority(ctx context.Context) Priority {
+	priority, _ := ctx.Value(priorityKey{}).(Priority)
+	return priority
+}
+
+// scheduler runs foreground operations right away and holds background
+// operations back while the foreground load is at or above the threshold,
+// so they do not compete with foreground operations for vCenter. The
+// foreground load is the number of foreground operations in progress in
+// this process plus the load reported by SetExternalForegroundLoad.
+type scheduler struct {
+	lock               sync.Mutex
+	threshold          int
+	maxBackground      int
+	foreground         int
+	externalForeground int
+	background         int
+	// changed is closed and replaced whenever the load decreases, waking
+	// the waiting background operations
+	changed chan struct{}
+}
+
+var operationScheduler = &scheduler{
+	threshold:     cnsconfig.DefaultForegroundLoadThreshold,
+	maxBackground: cnsconfig.DefaultMaxBackgroundOperations,
+	changed:       make(chan struct{}),
+}
+
+// ConfigureScheduler sets the foreground load from which background
+// operations wait, and the number of background operations allowed to run
+// at once. Values less than 1 leave the current setting unchanged.
+func ConfigureScheduler(foregroundLoadThreshold int, maxBackgroundOperations int) {
+	s := operationScheduler
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if foregroundLoadThreshold > 0 {
+		s.threshold = foregroundLoadThreshold
+	}
+	if maxBackgroundOperations > 0 {
+		s.maxBackground = maxBackgroundOperations
+	}
+	s.notify()
+}
+
+// SetExternalForegroundLoad reports the foreground operations in progress
+// outside this process, e.g. the CSI RPCs served by the controller when
+// running in the syncer.
+func SetExternalForegroundLoad(load int) {
+	s := operationScheduler
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if load < s.externalForeground {
+		s.notify()
+	}
+	s.externalForeground = load
+}
+
+// schedule waits until the operation run with ctx may start, and returns the
+// function to call once it is done. Background operations wait at most
+// maxBackgroundWait. It returns an error if ctx is done while waiting.
+func schedule(ctx context.Context) (func(), error) {
+	s := operationScheduler
+	if getPriority(ctx) != PriorityBackground {
+		s.lock.Lock()
+		s.foreground++
+		s.lock.Unlock()
+		return func() {
+			s.lock.Lock()
+			s.foreground--
+			s.notify()
+			s.lock.Unlock()
+		}, nil
+	}
+	timer := time.NewTimer(maxBackgroundWait)
+	defer timer.Stop()
+	expired := false
+	logged := false
+	for {
+		s.lock.Lock()
+		if expired || (s.foreground+s.externalForeground < s.threshold && s.background < s.maxBackground) {
+			if expired {
+				klog.Warningf("Background operation waited %v, running it despite a foreground load of %d and %d background operations",
+					maxBackgroundWait, s.foreground+s.externalForeground, s.background)
+			}
+			s.background++
+			s.lock.Unlock()
+			return func() {
+				s.lock.Lock()
+				s.background--
+				s.notify()
+				s.lock.Unlock()
+			}, nil
+		}
+		if !logged {
+			klog.V(4).Infof("Background operation waiting. Foreground load: %d, background operations: %d",
+				s.foreground+s.externalForeground, s.background)
+			logged = true
+		}
+		changed := s.changed
+		s.lock.Unlock()
+		select {
+		case <-changed:
+		case <-timer.C:
+			expired = true
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// notify wakes the waiting background operations. The lock must be held.
+func (s *scheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/progress"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+const (
+	// cnsTaskClientVersion and cnsTaskClientNamespace are required to read
+	// the info of CNS tasks, which carries CNS types
+	cnsTaskClientVersion   = "vSAN 6.7U3"
+	cnsTaskClientNamespace = "urn:vsan"
+
+	// DefaultTaskProgressInterval is the default interval between two progress reports of a CNS task
+	DefaultTaskProgressInterval = 30 * time.Second
+)
+
+// TaskWaitOptions controls how WaitForTask waits for a CNS task.
+type TaskWaitOptions struct {
+	// Timeout bounds the wait, on top of the deadline of the context.
+	// The wait is only bounded by the context when not set.
+	Timeout time.Duration
+	// ProgressInterval is the minimum interval between two calls to Progress.
+	ProgressInterval time.Duration
+	// Progress is called with the completion percentage of the task at most
+	// every ProgressInterval while the task is queued or running.
+	// Progress is not reported when not set.
+	Progress func(taskID string, percentage float32, elapsed time.Duration)
+}
+
+// logTaskProgress returns TaskWaitOptions logging the progress of the given
+// operation every DefaultTaskProgressInterval, so long running operations,
+// e.g. creating large eager zeroed volumes, can be followed in the logs.
+func logTaskProgress(operation string, opID string) TaskWaitOptions {
+	return TaskWaitOptions{
+		ProgressInterval: DefaultTaskProgressInterval,
+		Progress: func(taskID string, percentage float32, elapsed time.Duration) {
+			klog.Infof("%s: task %q is %.0f%% done after %v, opId: %q",
+				operation, taskID, percentage, elapsed.Round(time.Second), opID)
+		},
+	}
+}
+
+// taskProgressSink is a progress.Sinker forwarding the progress reports of a
+// task to TaskWaitOptions.Progress, at most every ProgressInterval.
+type taskProgressSink struct {
+	taskID string
+	opts   TaskWaitOptions
+	start  time.Time
+	done   chan struct{}
+}
+
+func newTaskProgressSink(taskID string, opts TaskWaitOptions, start time.Time) *taskProgressSink {
+	return &taskProgressSink{
+		taskID: taskID,
+		opts:   opts,
+		start:  start,
+		done:   make(chan struct{}),
+	}
+}
+
+// Sink returns the channel the task reports are sent to. The reports are
+// consumed until the channel is closed, after which done is closed.
+func (s *taskProgressSink) Sink() chan<- progress.Report {
+	ch := make(chan progress.Report)
+	go func() {
+		defer close(s.done)
+		lastProgress := s.start
+		for report := range ch {
+			if report.Error() != nil || time.Since(lastProgress) < s.opts.ProgressInterval {
+				continue
+			}
+			s.opts.Progress(s.taskID, report.Percentage(), time.Since(s.start))
+			lastProgress = time.Now()
+		}
+	}()
+	return ch
+}
+
+// WaitForTask waits for the CNS task to complete and returns its info.
+// Like cns.GetTaskInfo, the task info is watched through the property
+// collector instead of being polled, and the progress of the task is reported
+// while it runs. An error is returned if the task fails, the context is done
+// or the timeout expires.
+func WaitForTask(ctx context.Context, t *object.Task, opts TaskWaitOptions) (*vimtypes.TaskInfo, error) {
+	t.Client().Version = cnsTaskClientVersion
+	t.Client().Namespace = cnsTaskClientNamespace
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	var sinks []progress.Sinker
+	var sink *taskProgressSink
+	if opts.Progress != nil && opts.ProgressInterval > 0 {
+		sink = newTaskProgressSink(t.Reference().Value, opts, time.Now())
+		sinks = append(sinks, sink)
+	}
+	info, err := t.WaitForResult(ctx, sinks...)
+	if sink != nil {
+		<-sink.done
+	}
+	if err != nil {
+		klog.Errorf("Failed to wait for task %q with err: %v", t.Reference().Value, err)
+		return nil, err
+	}
+	return info, nil
+}
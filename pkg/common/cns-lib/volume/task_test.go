@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/progress"
+)
+
+type testReport struct {
+	percentage float32
+	err        error
+}
+
+func (r testReport) Percentage() float32 { return r.percentage }
+func (r testReport) Detail() string      { return "" }
+func (r testReport) Error() error        { return r.err }
+
+func sendReports(s progress.Sinker, reports ...progress.Report) {
+	ch := s.Sink()
+	for _, report := range reports {
+		ch <- report
+	}
+	close(ch)
+}
+
+func TestTaskProgressSink(t *testing.T) {
+	var reported []float32
+	opts := TaskWaitOptions{
+		ProgressInterval: time.Nanosecond,
+		Progress: func(taskID string, percentage float32, elapsed time.Duration) {
+			if taskID != "task-1" {
+				t.Errorf("unexpected task %q", taskID)
+			}
+			reported = append(reported, percentage)
+		},
+	}
+	sink := newTaskProgressSink("task-1", opts, time.Now().Add(-time.Second))
+	sendReports(sink, testReport{percentage: 10}, testReport{percentage: 50}, testReport{err: errors.New("failed")})
+	<-sink.done
+	if len(reported) != 2 || reported[0] != 10 || reported[1] != 50 {
+		t.Errorf("expected progress [10 50], got %v", reported)
+	}
+
+	// Reports within the progress interval are dropped
+	reported = nil
+	opts.ProgressInterval = time.Hour
+	sink = newTaskProgressSink("task-1", opts, time.Now())
+	sendReports(sink, testReport{percentage: 10}, testReport{percentage: 50})
+	<-sink.done
+	if len(reported) != 0 {
+		t.Errorf("expected no progress within the interval, got %v", reported)
+	}
+}
@@ -19,8 +19,17 @@ package volume
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
 	vimtypes "github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/govmomi/vslm"
 	"k8s.io/klog"
 
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
@@ -31,6 +40,10 @@ const (
 	CNSVolumeResourceInUseFaultMessage = "The resource 'volume' is in use."
 )
 
+// pendingPurgeSinceTimeFormat is the format of the time in the name of the
+// tag recording when a volume became pending purge
+const pendingPurgeSinceTimeFormat = "20060102T1504Z"
+
 func validateManager(m *volumeManager) error {
 	if m.virtualCenter == nil {
 		klog.Error(
@@ -65,3 +78,447 @@ func GetDiskAttachedToVM(ctx context.Context, vm *cnsvsphere.VirtualMachine, vol
 	klog.V(3).Infof("Volume %s is not attached to VM: %s", volumeID, vm.InventoryPath)
 	return "", nil
 }
+
+// RemoveDiskFromVM removes the virtual disk backing the volume from the VM
+// by reconfiguring the VM directly instead of going through CNS. The disk
+// files are kept. This is meant as a last resort when CNS detach keeps failing.
+func RemoveDiskFromVM(ctx context.Context, vm *cnsvsphere.VirtualMachine, volumeID string) error {
+	vmDevices, err := vm.Device(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get devices from vm: %s", vm.InventoryPath)
+		return err
+	}
+	for _, device := range vmDevices {
+		if virtualDisk, ok := device.(*vimtypes.VirtualDisk); ok {
+			if virtualDisk.VDiskId != nil && virtualDisk.VDiskId.Id == volumeID {
+				klog.V(2).Infof("Removing disk for volume %s from vm %s", volumeID, vm.InventoryPath)
+				if err := vm.RemoveDevice(ctx, true, device); err != nil {
+					klog.Errorf("Failed to remove disk for volume %s from vm %s with err: %v", volumeID, vm.InventoryPath, err)
+					return err
+				}
+				return nil
+			}
+		}
+	}
+	klog.V(3).Infof("Volume %s is not attached to VM: %s", volumeID, vm.InventoryPath)
+	return nil
+}
+
+// TagVolumeForPurge attaches the given tag to the FCD backing the volume, marking
+// it for deletion once the volume has been removed from CNS with the disk kept.
+// The time the volume became pending purge is recorded in a second tag of the
+// category, see PendingPurgeSinceTagName, which is created if needed. The tag
+// and its category must already exist on vCenter, and the category must allow
+// multiple tags per object.
+func TagVolumeForPurge(ctx context.Context, vc *cnsvsphere.VirtualCenter, volumeID string, category string, tag string, since time.Time) error {
+	sinceTag := PendingPurgeSinceTagName(tag, since)
+	err := createTagIfNotFound(ctx, vc, category, sinceTag)
+	if err != nil {
+		return err
+	}
+	// Record the time first, so a volume pending purge always has it
+	err = TagVolume(ctx, vc, volumeID, category, sinceTag)
+	if err != nil {
+		return err
+	}
+	return TagVolume(ctx, vc, volumeID, category, tag)
+}
+
+// PendingPurgeSinceTagName returns the name of the tag recording that a volume
+// became pending purge at the given time, e.g. "pending-purge-since-20191016T1602Z"
+// for the tag "pending-purge". The time is truncated to the minute, so volumes
+// soft deleted within the same minute share the tag.
+func PendingPurgeSinceTagName(tag string, since time.Time) string {
+	return tag + "-since-" + since.UTC().Format(pendingPurgeSinceTimeFormat)
+}
+
+// ParsePendingPurgeSince returns the time recorded by the pending purge time
+// tag among the given tags of a volume, and false if there is no such tag.
+func ParsePendingPurgeSince(volumeTags []vimtypes.VslmTagEntry, category string, tag string) (time.Time, bool) {
+	prefix := tag + "-since-"
+	for _, entry := range volumeTags {
+		if entry.ParentCategoryName != category || !strings.HasPrefix(entry.TagName, prefix) {
+			continue
+		}
+		since, err := time.Parse(pendingPurgeSinceTimeFormat, strings.TrimPrefix(entry.TagName, prefix))
+		if err == nil {
+			return since, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// GetVolumePendingPurgeSince returns the time the volume became pending purge
+// as recorded by TagVolumeForPurge, and false if the volume has no time tag,
+// e.g. because the pending purge tag was attached by hand.
+func GetVolumePendingPurgeSince(ctx context.Context, vc *cnsvsphere.VirtualCenter, volumeID string, category string, tag string) (time.Time, bool, error) {
+	volumeTags, err := ListVolumeTags(ctx, vc, volumeID)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	since, found := ParsePendingPurgeSince(volumeTags, category, tag)
+	return since, found, nil
+}
+
+// createTagIfNotFound creates the tag in the category unless it already exists.
+func createTagIfNotFound(ctx context.Context, vc *cnsvsphere.VirtualCenter, category string, tag string) error {
+	tagManager, err := vc.GetTagManager(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get tagManager. Error: %v", err)
+		return err
+	}
+	defer tagManager.Logout(ctx)
+	if _, err = tagManager.GetTagForCategory(ctx, tag, category); err == nil {
+		return nil
+	}
+	tagCategory, err := tagManager.GetCategory(ctx, category)
+	if err != nil {
+		klog.Errorf("Failed to get tag category %s with err: %v", category, err)
+		return err
+	}
+	_, err = tagManager.CreateTag(ctx, &tags.Tag{Name: tag, CategoryID: tagCategory.ID})
+	if err != nil {
+		// The tag may have been created concurrently
+		if _, getErr := tagManager.GetTagForCategory(ctx, tag, category); getErr == nil {
+			return nil
+		}
+		klog.Errorf("Failed to create tag %s/%s with err: %v", category, tag, err)
+		return err
+	}
+	klog.V(2).Infof("Created tag %s/%s", category, tag)
+	return nil
+}
+
+// DeleteTagIfUnused deletes the tag from the category if it is not attached
+// to any FCD, e.g. a pending purge time tag of volumes which were all purged.
+func DeleteTagIfUnused(ctx context.Context, vc *cnsvsphere.VirtualCenter, category string, tag string) error {
+	volumeIDs, err := ListVolumesPendingPurge(ctx, vc, category, tag)
+	if err != nil || len(volumeIDs) > 0 {
+		return err
+	}
+	tagManager, err := vc.GetTagManager(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get tagManager. Error: %v", err)
+		return err
+	}
+	defer tagManager.Logout(ctx)
+	unusedTag, err := tagManager.GetTagForCategory(ctx, tag, category)
+	if err != nil {
+		klog.Errorf("Failed to get tag %s/%s with err: %v", category, tag, err)
+		return err
+	}
+	if err = tagManager.DeleteTag(ctx, unusedTag); err != nil {
+		klog.Errorf("Failed to delete tag %s/%s with err: %v", category, tag, err)
+		return err
+	}
+	klog.V(2).Infof("Deleted unused tag %s/%s", category, tag)
+	return nil
+}
+
+// TagVolume attaches the given tag to the FCD backing the volume. The tag and
+// its category must already exist on vCenter.
+func TagVolume(ctx context.Context, vc *cnsvsphere.VirtualCenter, volumeID string, category string, tag string) error {
+	err := vc.Connect(ctx)
+	if err != nil {
+		klog.Errorf("Failed to connect to vCenter %q with err: %v", vc.Config.Host, err)
+		return err
+	}
+	objectManager := vslm.NewObjectManager(vc.Client.Client)
+	err = objectManager.AttachTag(ctx, volumeID, vimtypes.VslmTagEntry{TagName: tag, ParentCategoryName: category})
+	if err != nil {
+		klog.Errorf("Failed to attach tag %s/%s to volume %s with err: %v", category, tag, volumeID, err)
+		return err
+	}
+	klog.V(2).Infof("Attached tag %s/%s to volume %s", category, tag, volumeID)
+	return nil
+}
+
+// UntagVolumeForPurge detaches the given tag and the pending purge time tag
+// attached with it by TagVolumeForPurge from the FCD backing the volume.
+func UntagVolumeForPurge(ctx context.Context, vc *cnsvsphere.VirtualCenter, volumeID string, category string, tag string) error {
+	volumeTags, err := ListVolumeTags(ctx, vc, volumeID)
+	if err != nil {
+		return err
+	}
+	objectManager := vslm.NewObjectManager(vc.Client.Client)
+	sincePrefix := tag + "-since-"
+	for _, entry := range volumeTags {
+		if entry.ParentCategoryName != category || (entry.TagName != tag && !strings.HasPrefix(entry.TagName, sincePrefix)) {
+			continue
+		}
+		err = objectManager.DetachTag(ctx, volumeID, entry)
+		if err != nil {
+			klog.Errorf("Failed to detach tag %s/%s from volume %s with err: %v", category, entry.TagName, volumeID, err)
+			return err
+		}
+		klog.V(2).Infof("Detached tag %s/%s from volume %s", category, entry.TagName, volumeID)
+	}
+	return nil
+}
+
+// ListVolumeTags returns the tags attached to the FCD backing the volume.
+func ListVolumeTags(ctx context.Context, vc *cnsvsphere.VirtualCenter, volumeID string) ([]vimtypes.VslmTagEntry, error) {
+	err := vc.Connect(ctx)
+	if err != nil {
+		klog.Errorf("Failed to connect to vCenter %q with err: %v", vc.Config.Host, err)
+		return nil, err
+	}
+	objectManager := vslm.NewObjectManager(vc.Client.Client)
+	tags, err := objectManager.ListAttachedTags(ctx, volumeID)
+	if err != nil {
+		klog.Errorf("Failed to list tags attached to volume %s with err: %v", volumeID, err)
+		return nil, err
+	}
+	return tags, nil
+}
+
+// ListVolumeTagsByCategory returns the tags of the given categories attached
+// to FCDs, by volume ID. The FCDs are listed per tag of the categories, so the
+// number of vCenter calls does not grow with the number of volumes.
+func ListVolumeTagsByCategory(ctx context.Context, vc *cnsvsphere.VirtualCenter, categories []string) (map[string][]vimtypes.VslmTagEntry, error) {
+	err := vc.Connect(ctx)
+	if err != nil {
+		klog.Errorf("Failed to connect to vCenter %q with err: %v", vc.Config.Host, err)
+		return nil, err
+	}
+	tagManager, err := vc.GetTagManager(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get tagManager. Error: %v", err)
+		return nil, err
+	}
+	defer tagManager.Logout(ctx)
+	objectManager := vslm.NewObjectManager(vc.Client.Client)
+	volumeTags := make(map[string][]vimtypes.VslmTagEntry)
+	for _, category := range categories {
+		categoryTags, err := tagManager.GetTagsForCategory(ctx, category)
+		if err != nil {
+			klog.Errorf("Failed to get the tags of category %s with err: %v", category, err)
+			return nil, err
+		}
+		for _, tag := range categoryTags {
+			ids, err := objectManager.ListAttachedObjects(ctx, category, tag.Name)
+			if err != nil {
+				klog.Errorf("Failed to list volumes with tag %s/%s with err: %v", category, tag.Name, err)
+				return nil, err
+			}
+			for _, id := range ids {
+				volumeTags[id.Id] = append(volumeTags[id.Id], vimtypes.VslmTagEntry{TagName: tag.Name, ParentCategoryName: category})
+			}
+		}
+	}
+	return volumeTags, nil
+}
+
+// ListVolumesPendingPurge returns the IDs of the FCDs carrying the given tag.
+func ListVolumesPendingPurge(ctx context.Context, vc *cnsvsphere.VirtualCenter, category string, tag string) ([]string, error) {
+	err := vc.Connect(ctx)
+	if err != nil {
+		klog.Errorf("Failed to connect to vCenter %q with err: %v", vc.Config.Host, err)
+		return nil, err
+	}
+	objectManager := vslm.NewObjectManager(vc.Client.Client)
+	ids, err := objectManager.ListAttachedObjects(ctx, category, tag)
+	if err != nil {
+		klog.Errorf("Failed to list volumes with tag %s/%s with err: %v", category, tag, err)
+		return nil, err
+	}
+	var volumeIDs []string
+	for _, id := range ids {
+		volumeIDs = append(volumeIDs, id.Id)
+	}
+	return volumeIDs, nil
+}
+
+// PurgeVolume permanently deletes the FCD backing a volume which is no longer
+// managed by CNS. The FCD is looked up on the datastore with the given URL,
+// if set. The datastores of all datacenters are searched for the FCD when the
+// datastore is not known or the FCD is no longer on it. A volume which is not
+// found on any datastore is considered already deleted.
+func PurgeVolume(ctx context.Context, vc *cnsvsphere.VirtualCenter, volumeID string, datastoreURL string) error {
+	err := vc.Connect(ctx)
+	if err != nil {
+		klog.Errorf("Failed to connect to vCenter %q with err: %v", vc.Config.Host, err)
+		return err
+	}
+	datacenters, err := vc.GetDatacenters(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get datacenters from vCenter %q with err: %v", vc.Config.Host, err)
+		return err
+	}
+	objectManager := vslm.NewObjectManager(vc.Client.Client)
+	if datastoreURL != "" {
+		for _, dc := range datacenters {
+			ds, err := dc.GetDatastoreByURL(ctx, datastoreURL)
+			if err != nil {
+				if errors.Is(err, cnsvsphere.ErrDatastoreNotFound) {
+					continue
+				}
+				return err
+			}
+			purged, err := purgeVolumeFromDatastore(ctx, objectManager, ds, datastoreURL, volumeID)
+			if err != nil || purged {
+				return err
+			}
+			break
+		}
+		klog.V(2).Infof("Volume %s was not found on datastore %s, searching all datastores", volumeID, datastoreURL)
+	}
+	for _, dc := range datacenters {
+		datastores, err := dc.GetAllDatastores(ctx)
+		if err != nil {
+			klog.Errorf("Failed to get datastores from datacenter %s with err: %v", dc.InventoryPath, err)
+			return err
+		}
+		for _, ds := range datastores {
+			purged, err := purgeVolumeFromDatastore(ctx, objectManager, ds.Datastore, ds.Info.Url, volumeID)
+			if err != nil || purged {
+				return err
+			}
+		}
+	}
+	klog.V(2).Infof("Volume %s was not found on any datastore, nothing to purge", volumeID)
+	return nil
+}
+
+// purgeVolumeFromDatastore deletes the FCD backing the volume from the
+// datastore. It returns false if the FCD is not on the datastore.
+func purgeVolumeFromDatastore(ctx context.Context, objectManager *vslm.ObjectManager, ds mo.Reference, datastoreURL string, volumeID string) (bool, error) {
+	if _, err := objectManager.Retrieve(ctx, ds, volumeID); err != nil {
+		if isNotFoundFault(err) {
+			return false, nil
+		}
+		klog.Errorf("Failed to retrieve volume %s from datastore %s with err: %v", volumeID, datastoreURL, err)
+		return false, err
+	}
+	task, err := objectManager.Delete(ctx, ds, volumeID)
+	if err != nil {
+		klog.Errorf("Failed to delete volume %s from datastore %s with err: %v", volumeID, datastoreURL, err)
+		return false, err
+	}
+	if err = task.Wait(ctx); err != nil {
+		klog.Errorf("Delete task for volume %s failed with err: %v", volumeID, err)
+		return false, err
+	}
+	klog.V(2).Infof("Purged volume %s from datastore %s", volumeID, datastoreURL)
+	return true, nil
+}
+
+// GetVolumeBackingPath returns the path of the VMDK backing the volume, e.g.
+// "[vsanDatastore] fcd/0b3d5e5f1fbc4e0f8a5e9d1b7c7d4a3e.vmdk", given the URL
+// of the datastore the volume is on.
+func GetVolumeBackingPath(ctx context.Context, vc *cnsvsphere.VirtualCenter, volumeID string, datastoreURL string) (string, error) {
+	err := vc.Connect(ctx)
+	if err != nil {
+		klog.Errorf("Failed to connect to vCenter %q with err: %v", vc.Config.Host, err)
+		return "", err
+	}
+	ds, err := findDatastoreByURL(ctx, vc, datastoreURL)
+	if err != nil {
+		return "", err
+	}
+	storageObject, err := vslm.NewObjectManager(vc.Client.Client).Retrieve(ctx, ds.Datastore, volumeID)
+	if err != nil {
+		klog.Errorf("Failed to retrieve volume %s from datastore %s with err: %v", volumeID, datastoreURL, err)
+		return "", err
+	}
+	backing, ok := storageObject.Config.Backing.(*vimtypes.BaseConfigInfoDiskFileBackingInfo)
+	if !ok {
+		return "", fmt.Errorf("volume %s has no disk file backing", volumeID)
+	}
+	return backing.FilePath, nil
+}
+
+// EnableVolumeChangeTracking enables change block tracking (CBT) on the disk
+// of the volume, given the URL of the datastore the volume is on, if it is
+// not enabled yet. The setting belongs to the disk, so the node VMs the
+// volume is attached to are not reconfigured and their other disks are not
+// tracked.
+func EnableVolumeChangeTracking(ctx context.Context, vc *cnsvsphere.VirtualCenter, volumeID string, datastoreURL string) error {
+	err := vc.Connect(ctx)
+	if err != nil {
+		klog.Errorf("Failed to connect to vCenter %q with err: %v", vc.Config.Host, err)
+		return err
+	}
+	ds, err := findDatastoreByURL(ctx, vc, datastoreURL)
+	if err != nil {
+		return err
+	}
+	objectManager := vslm.NewObjectManager(vc.Client.Client)
+	storageObject, err := objectManager.Retrieve(ctx, ds.Datastore, volumeID)
+	if err != nil {
+		klog.Errorf("Failed to retrieve volume %s from datastore %s with err: %v", volumeID, datastoreURL, err)
+		return err
+	}
+	if enabled := storageObject.Config.ChangedBlockTrackingEnabled; enabled != nil && *enabled {
+		return nil
+	}
+	req := vimtypes.SetVStorageObjectControlFlags{
+		This:         objectManager.Reference(),
+		Id:           vimtypes.ID{Id: volumeID},
+		Datastore:    ds.Datastore.Reference(),
+		ControlFlags: []string{string(vimtypes.VslmVStorageObjectControlFlagEnableChangedBlockTracking)},
+	}
+	if _, err = methods.SetVStorageObjectControlFlags(ctx, vc.Client.Client, &req); err != nil {
+		klog.Errorf("Failed to enable change block tracking on volume %s with err: %v", volumeID, err)
+		return err
+	}
+	klog.V(2).Infof("Enabled change block tracking on volume %s", volumeID)
+	return nil
+}
+
+// findDatastoreByURL returns the datastore with the given URL, looked up in
+// all the datacenters of the vCenter
+func findDatastoreByURL(ctx context.Context, vc *cnsvsphere.VirtualCenter, datastoreURL string) (*cnsvsphere.Datastore, error) {
+	datacenters, err := vc.GetDatacenters(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get datacenters from vCenter %q with err: %v", vc.Config.Host, err)
+		return nil, err
+	}
+	for _, dc := range datacenters {
+		ds, err := dc.GetDatastoreByURL(ctx, datastoreURL)
+		if err != nil {
+			if errors.Is(err, cnsvsphere.ErrDatastoreNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		return ds, nil
+	}
+	return nil, fmt.Errorf("couldn't find datastore %s: %w", datastoreURL, cnsvsphere.ErrDatastoreNotFound)
+}
+
+// cnsMethodFault returns the method fault of a CNS fault, nil if not set
+func cnsMethodFault(fault *cnstypes.CnsFault) vimtypes.BaseMethodFault {
+	if fault == nil || fault.Fault == nil {
+		return nil
+	}
+	return *fault.Fault
+}
+
+// isResourceInUseFault returns true if the CNS operation failed as the volume is in use
+func isResourceInUseFault(fault *cnstypes.CnsFault) bool {
+	if fault.LocalizedMessage == CNSVolumeResourceInUseFaultMessage {
+		return true
+	}
+	_, ok := cnsMethodFault(fault).(*vimtypes.ResourceInUse)
+	return ok
+}
+
+// isDuplicateNameFault returns true if the fault reports that a volume with
+// the same name already exists
+func isDuplicateNameFault(fault *cnstypes.CnsFault) bool {
+	switch cnsMethodFault(fault).(type) {
+	case *vimtypes.DuplicateName, *vimtypes.AlreadyExists:
+		return true
+	}
+	return false
+}
+
+// isNotFoundFault returns true if err is a vim NotFound fault
+func isNotFoundFault(err error) bool {
+	if soap.IsSoapFault(err) {
+		_, ok := soap.ToSoapFault(err).VimFault().(vimtypes.NotFound)
+		return ok
+	}
+	return false
+}
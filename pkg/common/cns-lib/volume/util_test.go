@@ -0,0 +1,97 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"testing"
+	"time"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+)
+
+func TestPendingPurgeSinceTag(t *testing.T) {
+	since := time.Date(2019, 10, 16, 18, 2, 33, 0, time.FixedZone("CEST", 2*60*60))
+	tagName := PendingPurgeSinceTagName("pending-purge", since)
+	if tagName != "pending-purge-since-20191016T1602Z" {
+		t.Fatalf("PendingPurgeSinceTagName returned %q", tagName)
+	}
+	volumeTags := []vimtypes.VslmTagEntry{
+		{TagName: "gold", ParentCategoryName: "tier"},
+		{TagName: "pending-purge", ParentCategoryName: "cns"},
+		{TagName: tagName, ParentCategoryName: "cns"},
+	}
+	parsed, found := ParsePendingPurgeSince(volumeTags, "cns", "pending-purge")
+	if !found || !parsed.Equal(since.Truncate(time.Minute)) {
+		t.Errorf("ParsePendingPurgeSince returned %v, %t, expected %v", parsed, found, since.Truncate(time.Minute))
+	}
+}
+
+func TestParsePendingPurgeSinceWithoutTimeTag(t *testing.T) {
+	tests := [][]vimtypes.VslmTagEntry{
+		nil,
+		{{TagName: "pending-purge", ParentCategoryName: "cns"}},
+		// A time tag of another category
+		{{TagName: "pending-purge-since-20191016T1602Z", ParentCategoryName: "other"}},
+		// A tag which only looks like a time tag
+		{{TagName: "pending-purge-since-yesterday", ParentCategoryName: "cns"}},
+	}
+	for _, volumeTags := range tests {
+		if since, found := ParsePendingPurgeSince(volumeTags, "cns", "pending-purge"); found {
+			t.Errorf("ParsePendingPurgeSince(%v) returned %v, expected no time", volumeTags, since)
+		}
+	}
+}
+
+func TestIsResourceInUseFault(t *testing.T) {
+	var inUse vimtypes.BaseMethodFault = &vimtypes.ResourceInUse{}
+	var notFound vimtypes.BaseMethodFault = &vimtypes.NotFound{}
+	tests := []struct {
+		fault    *cnstypes.CnsFault
+		expected bool
+	}{
+		{&cnstypes.CnsFault{LocalizedMessage: CNSVolumeResourceInUseFaultMessage}, true},
+		{&cnstypes.CnsFault{Fault: &inUse}, true},
+		{&cnstypes.CnsFault{Fault: &notFound}, false},
+		{&cnstypes.CnsFault{LocalizedMessage: "failed"}, false},
+	}
+	for _, test := range tests {
+		if actual := isResourceInUseFault(test.fault); actual != test.expected {
+			t.Errorf("isResourceInUseFault(%+v) returned %t, expected %t", test.fault, actual, test.expected)
+		}
+	}
+}
+
+func TestIsDuplicateNameFault(t *testing.T) {
+	var duplicateName vimtypes.BaseMethodFault = &vimtypes.DuplicateName{}
+	var alreadyExists vimtypes.BaseMethodFault = &vimtypes.AlreadyExists{}
+	var inUse vimtypes.BaseMethodFault = &vimtypes.ResourceInUse{}
+	tests := []struct {
+		fault    *cnstypes.CnsFault
+		expected bool
+	}{
+		{&cnstypes.CnsFault{Fault: &duplicateName}, true},
+		{&cnstypes.CnsFault{Fault: &alreadyExists}, true},
+		{&cnstypes.CnsFault{Fault: &inUse}, false},
+		{&cnstypes.CnsFault{LocalizedMessage: "failed"}, false},
+	}
+	for _, test := range tests {
+		if actual := isDuplicateNameFault(test.fault); actual != test.expected {
+			t.Errorf("isDuplicateNameFault(%+v) returned %t, expected %t", test.fault, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"sync"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"k8s.io/klog"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+var (
+	vsphereUserPolicyLock sync.RWMutex
+	// vsphereUserPolicy is the policy applied to the VSphereUser of the
+	// container cluster of the specs sent to CNS
+	vsphereUserPolicy = cnsconfig.VSphereUserPolicyOverwrite
+)
+
+// ConfigureVSphereUserPolicy sets the policy applied to the VSphereUser of
+// the specs sent to CNS, one of the cnsconfig.VSphereUserPolicy* values.
+// The default is cnsconfig.VSphereUserPolicyOverwrite.
+func ConfigureVSphereUserPolicy(policy string) {
+	if policy == "" {
+		return
+	}
+	vsphereUserPolicyLock.Lock()
+	defer vsphereUserPolicyLock.Unlock()
+	vsphereUserPolicy = policy
+}
+
+// getVSphereUserPolicy returns the configured VSphereUser policy
+func getVSphereUserPolicy() string {
+	vsphereUserPolicyLock.RLock()
+	defer vsphereUserPolicyLock.RUnlock()
+	return vsphereUserPolicy
+}
+
+// applyVSphereUserPolicy sets the VSphereUser of the container cluster from
+// the user of the vCenter session according to the policy. Overwrite records
+// the session user, preserve keeps the user in the spec unless it is empty,
+// and record both records the session user followed by the user in the spec
+// when they differ.
+func applyVSphereUserPolicy(policy string, sessionUser string, cluster *cnstypes.CnsContainerCluster) {
+	specUser := cluster.VSphereUser
+	if specUser == sessionUser {
+		return
+	}
+	switch {
+	case specUser == "":
+		cluster.VSphereUser = sessionUser
+	case policy == cnsconfig.VSphereUserPolicyPreserve:
+		return
+	case policy == cnsconfig.VSphereUserPolicyRecordBoth:
+		cluster.VSphereUser = fmt.Sprintf("%s (%s)", sessionUser, specUser)
+	default:
+		cluster.VSphereUser = sessionUser
+	}
+	klog.V(4).Infof("Update VSphereUser from %s to %s", specUser, cluster.VSphereUser)
+}
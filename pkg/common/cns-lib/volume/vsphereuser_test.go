@@ -0,0 +1,51 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"testing"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+func TestApplyVSphereUserPolicy(t *testing.T) {
+	const session = "csi@vsphere.local"
+	const automation = "automation@vsphere.local"
+	tests := []struct {
+		policy   string
+		specUser string
+		expected string
+	}{
+		{cnsconfig.VSphereUserPolicyOverwrite, automation, session},
+		{cnsconfig.VSphereUserPolicyOverwrite, "", session},
+		{cnsconfig.VSphereUserPolicyPreserve, automation, automation},
+		{cnsconfig.VSphereUserPolicyPreserve, "", session},
+		{cnsconfig.VSphereUserPolicyRecordBoth, automation, session + " (" + automation + ")"},
+		{cnsconfig.VSphereUserPolicyRecordBoth, session, session},
+		{cnsconfig.VSphereUserPolicyRecordBoth, "", session},
+	}
+	for _, test := range tests {
+		cluster := cnstypes.CnsContainerCluster{VSphereUser: test.specUser}
+		applyVSphereUserPolicy(test.policy, session, &cluster)
+		if cluster.VSphereUser != test.expected {
+			t.Errorf("policy %q with spec user %q: expected VSphereUser %q, got %q",
+				test.policy, test.specUser, test.expected, cluster.VSphereUser)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vapi/tags"
+	"k8s.io/klog"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+// ConfigCheck is the result of checking that an object referenced in the
+// configuration exists on vCenter
+type ConfigCheck struct {
+	// Description of what was checked, e.g. datacenter "dc1" on vCenter "vc1"
+	Description string
+	// Err is nil if the check passed
+	Err error
+}
+
+// ConfigReport is the result of CheckConfig
+type ConfigReport struct {
+	Checks []ConfigCheck
+}
+
+// Failed returns the failed checks
+func (r *ConfigReport) Failed() []ConfigCheck {
+	var failed []ConfigCheck
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+// Print writes the report in a human readable form
+func (r *ConfigReport) Print(w io.Writer) {
+	for _, check := range r.Checks {
+		if check.Err == nil {
+			fmt.Fprintf(w, "[OK]   %s\n", check.Description)
+		} else {
+			fmt.Fprintf(w, "[FAIL] %s: %v\n", check.Description, check.Err)
+		}
+	}
+	fmt.Fprintf(w, "%d checks, %d failed\n", len(r.Checks), len(r.Failed()))
+}
+
+func (r *ConfigReport) add(err error, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, ConfigCheck{Description: fmt.Sprintf(format, args...), Err: err})
+}
+
+// CheckConfig connects to each vCenter of the configuration and checks that
+// the datacenters, datastores, tag categories and tags referenced in the
+// configuration exist, so misconfigurations are reported up front rather
+// than as provisioning failures. The configuration must have been validated,
+// e.g. read with GetCnsconfig.
+func CheckConfig(ctx context.Context, cfg *cnsconfig.Config) *ConfigReport {
+	report := &ConfigReport{}
+	var hosts []string
+	for host := range cfg.VirtualCenter {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	// Datastores of the zones, to be found on any of the vCenters
+	zoneDatastores := make(map[string]string)
+	for zone := range cfg.ZoneDatastores {
+		for _, url := range cfg.GetZoneDatastoreURLs(zone) {
+			zoneDatastores[url] = zone
+		}
+	}
+	foundDatastores := make(map[string]bool)
+	for _, host := range hosts {
+		checkVirtualCenter(ctx, cfg, host, zoneDatastores, foundDatastores, report)
+	}
+	var urls []string
+	for url := range zoneDatastores {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		var err error
+		if !foundDatastores[url] {
+			err = fmt.Errorf("datastore not found in the datacenters of any vCenter")
+		}
+		report.add(err, "datastore %q of zone %q", url, zoneDatastores[url])
+	}
+	return report
+}
+
+// checkVirtualCenter adds the checks of the vCenter with the given host to the
+// report, and records which of the given datastores were found on it
+func checkVirtualCenter(ctx context.Context, cfg *cnsconfig.Config, host string, datastores map[string]string,
+	foundDatastores map[string]bool, report *ConfigReport) {
+	vcConfig, err := GetVirtualCenterConfigForHost(cfg, host)
+	if err != nil {
+		report.add(err, "configuration of vCenter %q", host)
+		return
+	}
+	vc := &VirtualCenter{Config: vcConfig}
+	err = vc.connect(ctx)
+	report.add(err, "connection to vCenter %q as %q", host, vcConfig.Username)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err := vc.Disconnect(ctx); err != nil {
+			klog.V(4).Infof("Failed to disconnect from vCenter %q. err=%v", host, err)
+		}
+	}()
+
+	var dcs []*Datacenter
+	var dcPaths []string
+	for _, dcPath := range vcConfig.DatacenterPaths {
+		if dcPath != "" {
+			dcPaths = append(dcPaths, dcPath)
+		}
+	}
+	if len(dcPaths) == 0 {
+		dcs, err = vc.listDatacenters(ctx)
+		if err == nil && len(dcs) == 0 {
+			err = fmt.Errorf("no datacenter found")
+		}
+		report.add(err, "datacenters of vCenter %q", host)
+	}
+	finder := find.NewFinder(vc.Client.Client, false)
+	for _, dcPath := range dcPaths {
+		dcObj, err := finder.Datacenter(ctx, dcPath)
+		report.add(err, "datacenter %q on vCenter %q", dcPath, host)
+		if err == nil {
+			dcs = append(dcs, &Datacenter{Datacenter: dcObj, VirtualCenterHost: host})
+		}
+	}
+	for _, dc := range dcs {
+		dcDatastores, err := dc.GetAllDatastores(ctx)
+		if err != nil {
+			report.add(err, "datastores of datacenter %q on vCenter %q", dc.InventoryPath, host)
+			continue
+		}
+		for url := range dcDatastores {
+			if _, found := datastores[url]; found {
+				foundDatastores[url] = true
+			}
+		}
+	}
+	checkTags(ctx, cfg, vc, report)
+}
+
+// checkTags adds the checks of the tag categories and tags of the configuration on the given vCenter to the report
+func checkTags(ctx context.Context, cfg *cnsconfig.Config, vc *VirtualCenter, report *ConfigReport) {
+	categories := make(map[string]string)
+	if cfg.Labels.Zone != "" {
+		categories[cfg.Labels.Zone] = "zone"
+	}
+	if cfg.Labels.Region != "" {
+		categories[cfg.Labels.Region] = "region"
+	}
+	for _, category := range strings.Split(cfg.LabelSync.TagCategories, ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			categories[category] = "label sync"
+		}
+	}
+	softDelete := cfg.SoftDelete.RetentionMinutes > 0
+	if softDelete {
+		categories[cfg.SoftDelete.TagCategory] = "soft delete"
+	}
+	if len(categories) == 0 {
+		return
+	}
+	tagManager, err := vc.GetTagManager(ctx)
+	if err != nil {
+		report.add(err, "tagging service of vCenter %q", vc.Config.Host)
+		return
+	}
+	defer tagManager.Logout(ctx)
+	var names []string
+	for name := range categories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, err := tagManager.GetCategory(ctx, name)
+		report.add(err, "%s tag category %q on vCenter %q", categories[name], name, vc.Config.Host)
+	}
+	if softDelete {
+		err := checkTag(ctx, tagManager, cfg.SoftDelete.Tag, cfg.SoftDelete.TagCategory)
+		report.add(err, "soft delete tag %q of category %q on vCenter %q", cfg.SoftDelete.Tag, cfg.SoftDelete.TagCategory, vc.Config.Host)
+	}
+}
+
+// checkTag returns an error if the tag does not exist in the category
+func checkTag(ctx context.Context, tagManager *tags.Manager, tag string, category string) error {
+	_, err := tagManager.GetTagForCategory(ctx, tag, category)
+	return err
+}
+
+// CheckConfigFile reads the configuration file given by the VSPHERE_CSI_CONFIG
+// environment variable, or the default configuration file, checks it with
+// CheckConfig and writes the report. An error is returned if the file could not
+// be read or any check failed.
+func CheckConfigFile(ctx context.Context, w io.Writer) error {
+	cfgPath := os.Getenv(cnsconfig.EnvCloudConfig)
+	if cfgPath == "" {
+		cfgPath = cnsconfig.DefaultCloudConfigPath
+	}
+	cfg, err := cnsconfig.GetCnsconfig(cfgPath)
+	if err != nil {
+		fmt.Fprintf(w, "[FAIL] configuration file %q: %v\n", cfgPath, err)
+		return err
+	}
+	fmt.Fprintf(w, "[OK]   configuration file %q\n", cfgPath)
+	report := CheckConfig(ctx, cfg)
+	report.Print(w)
+	if failed := report.Failed(); len(failed) > 0 {
+		return fmt.Errorf("%d of %d configuration checks failed", len(failed), len(report.Checks))
+	}
+	return nil
+}
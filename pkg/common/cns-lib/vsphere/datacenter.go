@@ -18,12 +18,13 @@ package vsphere
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/view"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 	"k8s.io/klog"
@@ -46,13 +47,23 @@ func (dc *Datacenter) String() string {
 }
 
 // GetDatastoreByURL returns the *Datastore instance given its URL.
+// The datastore is looked up in the datastore URL cache of the datacenter
+// first, and all datastores are listed only on a cache miss.
 func (dc *Datacenter) GetDatastoreByURL(ctx context.Context, datastoreURL string) (*Datastore, error) {
+	if dsRef, ok := getCachedDatastoreRef(dc, datastoreURL); ok {
+		klog.V(4).Infof("Found Datastore %v given URL %q in cache", dsRef, datastoreURL)
+		return &Datastore{object.NewDatastore(dc.Client(), dsRef), dc}, nil
+	}
 	finder := find.NewFinder(dc.Datacenter.Client(), false)
 	finder.SetDatacenter(dc.Datacenter)
 	datastores, err := finder.DatastoreList(ctx, "*")
 	if err != nil {
 		klog.Errorf("Failed to get all the datastores. err: %+v", err)
-		return nil, err
+		var notFoundErr *find.NotFoundError
+		if errors.As(err, &notFoundErr) {
+			return nil, fmt.Errorf("couldn't find Datastore given URL %q: %w", datastoreURL, ErrDatastoreNotFound)
+		}
+		return nil, fmt.Errorf("failed to get all the datastores: %w", err)
 	}
 	var dsList []types.ManagedObjectReference
 	for _, ds := range datastores {
@@ -66,15 +77,18 @@ func (dc *Datacenter) GetDatastoreByURL(ctx context.Context, datastoreURL string
 	if err != nil {
 		klog.Errorf("Failed to get Datastore managed objects from datastore objects."+
 			" dsObjList: %+v, properties: %+v, err: %v", dsList, properties, err)
-		return nil, err
+		return nil, fmt.Errorf("failed to get Datastore managed objects: %w", err)
 	}
+	dsRefs := make(map[string]types.ManagedObjectReference)
 	for _, dsMo := range dsMoList {
-		if dsMo.Info.GetDatastoreInfo().Url == datastoreURL {
-			return &Datastore{object.NewDatastore(dc.Client(), dsMo.Reference()),
-				dc}, nil
-		}
+		dsRefs[dsMo.Info.GetDatastoreInfo().Url] = dsMo.Reference()
+	}
+	setCachedDatastoreRefs(dc, dsRefs)
+	if dsRef, ok := dsRefs[datastoreURL]; ok {
+		return &Datastore{object.NewDatastore(dc.Client(), dsRef),
+			dc}, nil
 	}
-	err = fmt.Errorf("Couldn't find Datastore given URL %q", datastoreURL)
+	err = fmt.Errorf("couldn't find Datastore given URL %q: %w", datastoreURL, ErrDatastoreNotFound)
 	klog.Error(err)
 	return nil, err
 }
@@ -85,15 +99,15 @@ func (dc *Datacenter) GetDatastoreByURL(ctx context.Context, datastoreURL string
 // If instanceUUID is set to false, then UUID is BIOS UUID.
 //  - In this case, this function searches for virtual machines whose BIOS UUID matches the given uuid.
 func (dc *Datacenter) GetVirtualMachineByUUID(ctx context.Context, uuid string, instanceUUID bool) (*VirtualMachine, error) {
-	uuid = strings.ToLower(strings.TrimSpace(uuid))
+	uuid = CanonicalUUID(uuid)
 	searchIndex := object.NewSearchIndex(dc.Datacenter.Client())
 	svm, err := searchIndex.FindByUuid(ctx, dc.Datacenter, uuid, true, &instanceUUID)
 	if err != nil {
 		klog.Errorf("Failed to find VM given uuid %s with err: %v", uuid, err)
-		return nil, err
+		return nil, fmt.Errorf("failed to find VM given uuid %s: %w", uuid, err)
 	} else if svm == nil {
 		klog.Errorf("Couldn't find VM given uuid %s", uuid)
-		return nil, ErrVMNotFound
+		return nil, fmt.Errorf("couldn't find VM given uuid %s: %w", uuid, ErrVMNotFound)
 	}
 	vm := &VirtualMachine{
 		VirtualCenterHost: dc.VirtualCenterHost,
@@ -104,6 +118,51 @@ func (dc *Datacenter) GetVirtualMachineByUUID(ctx context.Context, uuid string,
 	return vm, nil
 }
 
+// GetVirtualMachinesByUUID returns the VirtualMachine instances of the given
+// BIOS UUIDs found in the datacenter, keyed on the canonical UUID. The BIOS
+// UUIDs of all the virtual machines in the datacenter are retrieved with a
+// single property collector query rather than a search per UUID. UUIDs
+// which are not found are left out of the result.
+func (dc *Datacenter) GetVirtualMachinesByUUID(ctx context.Context, uuids []string) (map[string]*VirtualMachine, error) {
+	wanted := make(map[string]bool)
+	for _, uuid := range uuids {
+		wanted[CanonicalUUID(uuid)] = true
+	}
+	containerView, err := view.NewManager(dc.Client()).CreateContainerView(ctx, dc.Reference(), []string{"VirtualMachine"}, true)
+	if err != nil {
+		klog.Errorf("Failed to create container view of VMs on DC %v with err: %v", dc, err)
+		return nil, err
+	}
+	defer func() {
+		if err := containerView.Destroy(ctx); err != nil {
+			klog.Warningf("Failed to destroy container view of VMs on DC %v with err: %v", dc, err)
+		}
+	}()
+	var vmMoList []mo.VirtualMachine
+	if err := containerView.Retrieve(ctx, []string{"VirtualMachine"}, []string{"config.uuid"}, &vmMoList); err != nil {
+		klog.Errorf("Failed to retrieve the UUIDs of VMs on DC %v with err: %v", dc, err)
+		return nil, err
+	}
+	vms := make(map[string]*VirtualMachine)
+	for _, vmMo := range vmMoList {
+		// Config isn't set on inaccessible VMs
+		if vmMo.Config == nil {
+			continue
+		}
+		uuid := CanonicalUUID(vmMo.Config.Uuid)
+		if !wanted[uuid] {
+			continue
+		}
+		vms[uuid] = &VirtualMachine{
+			VirtualCenterHost: dc.VirtualCenterHost,
+			UUID:              uuid,
+			VirtualMachine:    object.NewVirtualMachine(dc.Datacenter.Client(), vmMo.Reference()),
+			Datacenter:        dc,
+		}
+	}
+	return vms, nil
+}
+
 // asyncGetAllDatacenters returns *Datacenter instances over the given
 // channel. If an error occurs, it will be returned via the given error channel.
 // If the given context is canceled, the processing will be stopped as soon as
@@ -216,11 +275,14 @@ func (dc *Datacenter) GetAllDatastores(ctx context.Context) (map[string]*Datasto
 		return nil, err
 	}
 	dsURLInfoMap := make(map[string]*DatastoreInfo)
+	dsRefs := make(map[string]types.ManagedObjectReference)
 	for _, dsMo := range dsMoList {
 		dsURLInfoMap[dsMo.Info.GetDatastoreInfo().Url] = &DatastoreInfo{
 			&Datastore{object.NewDatastore(dc.Client(), dsMo.Reference()),
 				dc},
 			dsMo.Info.GetDatastoreInfo()}
+		dsRefs[dsMo.Info.GetDatastoreInfo().Url] = dsMo.Reference()
 	}
+	setCachedDatastoreRefs(dc, dsRefs)
 	return dsURLInfoMap, nil
 }
@@ -18,6 +18,7 @@ package vsphere
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/vmware/govmomi/object"
@@ -27,6 +28,9 @@ import (
 	"k8s.io/klog"
 )
 
+// ErrDatastoreNotFound is returned when a datastore isn't found.
+var ErrDatastoreNotFound = errors.New("datastore wasn't found")
+
 // Datastore holds Datastore and Datacenter information.
 type Datastore struct {
 	// Datastore represents the govmomi Datastore instance.
@@ -45,6 +49,32 @@ func (di DatastoreInfo) String() string {
 	return fmt.Sprintf("Datastore: %+v, datastore URL: %s", di.Datastore, di.Info.Url)
 }
 
+// GetDatastoreSummaries retrieves the current summaries of the given datastores,
+// which must be on the same vCenter, and returns them keyed on the datastore URL.
+func GetDatastoreSummaries(ctx context.Context, datastores []*DatastoreInfo) (map[string]types.DatastoreSummary, error) {
+	summaries := make(map[string]types.DatastoreSummary)
+	if len(datastores) == 0 {
+		return summaries, nil
+	}
+	var refs []types.ManagedObjectReference
+	urls := make(map[types.ManagedObjectReference]string)
+	for _, datastore := range datastores {
+		refs = append(refs, datastore.Reference())
+		urls[datastore.Reference()] = datastore.Info.Url
+	}
+	var dsMos []mo.Datastore
+	pc := property.DefaultCollector(datastores[0].Client())
+	if err := pc.Retrieve(ctx, refs, []string{"summary"}, &dsMos); err != nil {
+		klog.Errorf("Failed to retrieve the summary of datastores %v. Err: %v", refs, err)
+		InvalidateDatastoreURLCacheOnNotFound(err)
+		return nil, err
+	}
+	for _, dsMo := range dsMos {
+		summaries[urls[dsMo.Reference()]] = dsMo.Summary
+	}
+	return summaries, nil
+}
+
 // GetDatastoreURL returns the URL of datastore
 func (ds *Datastore) GetDatastoreURL(ctx context.Context) (string, error) {
 	var dsMo mo.Datastore
@@ -52,7 +82,52 @@ func (ds *Datastore) GetDatastoreURL(ctx context.Context) (string, error) {
 	err := pc.RetrieveOne(ctx, ds.Datastore.Reference(), []string{"summary"}, &dsMo)
 	if err != nil {
 		klog.Errorf("Failed to retrieve datastore summary property: %v", err)
+		InvalidateDatastoreURLCacheOnNotFound(err)
 		return "", err
 	}
 	return dsMo.Summary.Url, nil
 }
+
+// GetVirtualMachineRefs returns the references of the VMs with files, e.g.
+// disks, on the datastore
+func (ds *Datastore) GetVirtualMachineRefs(ctx context.Context) ([]types.ManagedObjectReference, error) {
+	var dsMo mo.Datastore
+	pc := property.DefaultCollector(ds.Client())
+	err := pc.RetrieveOne(ctx, ds.Datastore.Reference(), []string{"vm"}, &dsMo)
+	if err != nil {
+		klog.Errorf("Failed to retrieve VMs of datastore %v: %v", ds, err)
+		InvalidateDatastoreURLCacheOnNotFound(err)
+		return nil, err
+	}
+	return dsMo.Vm, nil
+}
+
+// GetAccessibility returns whether the datastore is accessible and, if it
+// isn't, why. A datastore is inaccessible when vCenter reports it as such or
+// when a host mounting it reports an all paths down (APD) or permanent device
+// loss (PDL) condition.
+func (ds *Datastore) GetAccessibility(ctx context.Context) (bool, string, error) {
+	var dsMo mo.Datastore
+	pc := property.DefaultCollector(ds.Client())
+	err := pc.RetrieveOne(ctx, ds.Datastore.Reference(), []string{"summary", "host"}, &dsMo)
+	if err != nil {
+		klog.Errorf("Failed to retrieve accessibility of datastore %v: %v", ds, err)
+		InvalidateDatastoreURLCacheOnNotFound(err)
+		return false, "", err
+	}
+	if !dsMo.Summary.Accessible {
+		return false, "datastore is not accessible", nil
+	}
+	for _, hostMount := range dsMo.Host {
+		if hostMount.MountInfo.Accessible == nil || *hostMount.MountInfo.Accessible {
+			continue
+		}
+		switch hostMount.MountInfo.InaccessibleReason {
+		case string(types.HostMountInfoInaccessibleReasonAllPathsDown_Start),
+			string(types.HostMountInfoInaccessibleReasonAllPathsDown_Timeout),
+			string(types.HostMountInfoInaccessibleReasonPermanentDeviceLoss):
+			return false, fmt.Sprintf("%s on host %s", hostMount.MountInfo.InaccessibleReason, hostMount.Key.Value), nil
+		}
+	}
+	return true, "", nil
+}
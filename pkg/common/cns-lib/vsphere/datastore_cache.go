@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// datastoreURLCacheTTL is the duration after which the cached datastore
+// URL to MoRef mappings of a datacenter are considered stale.
+const datastoreURLCacheTTL = 5 * time.Minute
+
+// datastoreURLCacheEntry holds datastore URL to MoRef mappings of a datacenter.
+type datastoreURLCacheEntry struct {
+	dsRefs    map[string]types.ManagedObjectReference
+	expiresAt time.Time
+}
+
+var (
+	// datastoreURLCache maps datacenters to their datastore URL to MoRef mappings.
+	// Datacenter instances are created on every lookup, so the cache is keyed
+	// on the virtual center host and the datacenter MoRef.
+	datastoreURLCache     = make(map[string]*datastoreURLCacheEntry)
+	datastoreURLCacheLock sync.RWMutex
+)
+
+func datastoreURLCacheKey(dc *Datacenter) string {
+	return dc.VirtualCenterHost + "/" + dc.Datacenter.Reference().Value
+}
+
+// getCachedDatastoreRef returns the MoRef of the datastore with the given URL
+// in the datacenter, if it was cached and has not expired.
+func getCachedDatastoreRef(dc *Datacenter, datastoreURL string) (types.ManagedObjectReference, bool) {
+	datastoreURLCacheLock.RLock()
+	defer datastoreURLCacheLock.RUnlock()
+	entry, ok := datastoreURLCache[datastoreURLCacheKey(dc)]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return types.ManagedObjectReference{}, false
+	}
+	dsRef, ok := entry.dsRefs[datastoreURL]
+	return dsRef, ok
+}
+
+// setCachedDatastoreRefs replaces the cached datastore URL to MoRef mappings of the datacenter.
+func setCachedDatastoreRefs(dc *Datacenter, dsRefs map[string]types.ManagedObjectReference) {
+	datastoreURLCacheLock.Lock()
+	defer datastoreURLCacheLock.Unlock()
+	datastoreURLCache[datastoreURLCacheKey(dc)] = &datastoreURLCacheEntry{
+		dsRefs:    dsRefs,
+		expiresAt: time.Now().Add(datastoreURLCacheTTL),
+	}
+}
+
+// InvalidateDatastoreURLCache drops the cached datastore URL to MoRef
+// mappings of all datacenters. Callers should invalidate the cache when
+// they find a datastore returned from a lookup no longer exists.
+func InvalidateDatastoreURLCache() {
+	datastoreURLCacheLock.Lock()
+	defer datastoreURLCacheLock.Unlock()
+	datastoreURLCache = make(map[string]*datastoreURLCacheEntry)
+}
+
+// IsManagedObjectNotFoundFault returns true if the fault is vCenter not
+// finding a managed object, e.g. a datastore which was removed.
+func IsManagedObjectNotFoundFault(fault types.AnyType) bool {
+	switch fault.(type) {
+	case types.ManagedObjectNotFound, *types.ManagedObjectNotFound:
+		return true
+	}
+	return false
+}
+
+// InvalidateDatastoreURLCacheOnNotFound drops the cached datastore URL to
+// MoRef mappings if err is a managed object not found fault, which vCenter
+// returns for a cached datastore MoRef once the datastore is removed.
+// The next lookup then lists the datastores again.
+func InvalidateDatastoreURLCacheOnNotFound(err error) {
+	var fault types.AnyType
+	switch {
+	case err == nil:
+		return
+	case soap.IsSoapFault(err):
+		fault = soap.ToSoapFault(err).VimFault()
+	case soap.IsVimFault(err):
+		fault = soap.ToVimFault(err)
+	default:
+		if taskErr, ok := err.(task.Error); ok {
+			fault = taskErr.Fault()
+		}
+	}
+	if IsManagedObjectNotFoundFault(fault) {
+		klog.V(2).Infof("Invalidating the datastore URL cache after a managed object not found fault: %v", err)
+		InvalidateDatastoreURLCache()
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const testDatastoreURL = "ds:///vmfs/volumes/5d8a1b2c-3e4f5a6b/"
+
+func newTestDatacenter() *Datacenter {
+	return &Datacenter{
+		Datacenter:        object.NewDatacenter(nil, types.ManagedObjectReference{Type: "Datacenter", Value: "datacenter-1"}),
+		VirtualCenterHost: "vc1",
+	}
+}
+
+func cacheTestDatastore(dc *Datacenter) types.ManagedObjectReference {
+	dsRef := types.ManagedObjectReference{Type: "Datastore", Value: "datastore-1"}
+	setCachedDatastoreRefs(dc, map[string]types.ManagedObjectReference{testDatastoreURL: dsRef})
+	return dsRef
+}
+
+func TestDatastoreURLCache(t *testing.T) {
+	defer InvalidateDatastoreURLCache()
+	dc := newTestDatacenter()
+	if _, ok := getCachedDatastoreRef(dc, testDatastoreURL); ok {
+		t.Fatalf("Datastore %q found in an empty cache", testDatastoreURL)
+	}
+	dsRef := cacheTestDatastore(dc)
+	if cached, ok := getCachedDatastoreRef(dc, testDatastoreURL); !ok || cached != dsRef {
+		t.Errorf("getCachedDatastoreRef returned %v, %t, expected %v", cached, ok, dsRef)
+	}
+	if _, ok := getCachedDatastoreRef(dc, "ds:///vmfs/volumes/unknown/"); ok {
+		t.Errorf("Unknown datastore URL found in the cache")
+	}
+	other := newTestDatacenter()
+	other.VirtualCenterHost = "vc2"
+	if _, ok := getCachedDatastoreRef(other, testDatastoreURL); ok {
+		t.Errorf("Datastore of %v found in the cache of %v", dc, other)
+	}
+
+	datastoreURLCacheLock.Lock()
+	datastoreURLCache[datastoreURLCacheKey(dc)].expiresAt = time.Now().Add(-time.Second)
+	datastoreURLCacheLock.Unlock()
+	if _, ok := getCachedDatastoreRef(dc, testDatastoreURL); ok {
+		t.Errorf("Datastore %q found in an expired cache", testDatastoreURL)
+	}
+}
+
+func TestInvalidateDatastoreURLCacheOnNotFound(t *testing.T) {
+	defer InvalidateDatastoreURLCache()
+	notFoundSoapFault := &soap.Fault{String: "The object has already been deleted or has not been completely created"}
+	notFoundSoapFault.Detail.Fault = types.ManagedObjectNotFound{}
+	otherSoapFault := &soap.Fault{String: "Permission to perform this operation was denied."}
+	otherSoapFault.Detail.Fault = types.NoPermission{}
+
+	tests := []struct {
+		name        string
+		err         error
+		invalidated bool
+	}{
+		{"soap fault", soap.WrapSoapFault(notFoundSoapFault), true},
+		{"vim fault", soap.WrapVimFault(&types.ManagedObjectNotFound{}), true},
+		{"task fault", task.Error{LocalizedMethodFault: &types.LocalizedMethodFault{Fault: &types.ManagedObjectNotFound{}}}, true},
+		{"other soap fault", soap.WrapSoapFault(otherSoapFault), false},
+		{"other task fault", task.Error{LocalizedMethodFault: &types.LocalizedMethodFault{Fault: &types.InvalidArgument{}}}, false},
+		{"plain error", errors.New("connection reset by peer"), false},
+		{"no error", nil, false},
+	}
+	dc := newTestDatacenter()
+	for _, test := range tests {
+		cacheTestDatastore(dc)
+		InvalidateDatastoreURLCacheOnNotFound(test.err)
+		_, cached := getCachedDatastoreRef(dc, testDatastoreURL)
+		if cached == test.invalidated {
+			t.Errorf("%s: cache invalidated: %t, expected %t", test.name, !cached, test.invalidated)
+		}
+	}
+}
@@ -18,8 +18,10 @@ package vsphere
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/vmware/govmomi/pbm"
+	pbmtypes "github.com/vmware/govmomi/pbm/types"
 	"k8s.io/klog"
 )
 
@@ -58,3 +60,17 @@ func (vc *VirtualCenter) GetStoragePolicyIDByName(ctx context.Context, storagePo
 	}
 	return storagePolicyID, nil
 }
+
+// GetStoragePolicyNameByID gets storage policy name by ID.
+func (vc *VirtualCenter) GetStoragePolicyNameByID(ctx context.Context, storagePolicyID string) (string, error) {
+	profiles, err := vc.PbmClient.RetrieveContent(ctx, []pbmtypes.PbmProfileId{{UniqueId: storagePolicyID}})
+	if err != nil {
+		klog.Errorf("Failed to get StoragePolicyName from StoragePolicyID %s with err: %v", storagePolicyID, err)
+		return "", err
+	}
+	if len(profiles) == 0 {
+		klog.Errorf("StoragePolicyID %s not found", storagePolicyID)
+		return "", fmt.Errorf("storage policy %s not found", storagePolicyID)
+	}
+	return profiles[0].GetPbmProfile().Name, nil
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
 
 	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
 )
@@ -84,16 +85,33 @@ func GetVirtualCenterConfig(cfg *config.Config) (*VirtualCenterConfig, error) {
 	if err != nil {
 		return nil, err
 	}
-	host := vCenterIPs[0]
+	return GetVirtualCenterConfigForHost(cfg, vCenterIPs[0])
+}
+
+// GetVirtualCenterConfigForHost returns the VirtualCenterConfig of the vCenter
+// with the given host in the vSphere Configuration
+func GetVirtualCenterConfigForHost(cfg *config.Config, host string) (*VirtualCenterConfig, error) {
+	if _, found := cfg.VirtualCenter[host]; !found {
+		return nil, fmt.Errorf("vCenter %s is not configured", host)
+	}
 	port, err := strconv.Atoi(cfg.VirtualCenter[host].VCenterPort)
 	if err != nil {
 		return nil, err
 	}
+	credentialProvider, err := config.GetCredentialProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	username, password, err := credentialProvider.GetCredentials(host)
+	if err != nil {
+		klog.Errorf("Failed to get the credentials of vCenter %s. Err: %v", host, err)
+		return nil, err
+	}
 	vcConfig := &VirtualCenterConfig{
 		Host:            host,
 		Port:            port,
-		Username:        cfg.VirtualCenter[host].User,
-		Password:        cfg.VirtualCenter[host].Password,
+		Username:        username,
+		Password:        password,
 		Insecure:        cfg.VirtualCenter[host].InsecureFlag,
 		DatacenterPaths: strings.Split(cfg.VirtualCenter[host].Datacenters, ","),
 	}
@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidUUID is returned when a string can't be parsed as a UUID.
+var ErrInvalidUUID = errors.New("invalid UUID")
+
+// NormalizeUUID returns the given UUID in the canonical lower case
+// 8-4-4-4-12 form. Surrounding white space, upper case letters and the
+// spaces and hyphens vSphere puts in BIOS UUIDs (for example
+// "42 20 8c 6b 0d d1 d0 37-15 6f 43 5f 99 9d 94 c1") are accepted.
+// ErrInvalidUUID is returned if the UUID doesn't have 32 hex digits.
+func NormalizeUUID(uuid string) (string, error) {
+	digits := strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(uuid)))
+	if len(digits) != 32 {
+		return "", fmt.Errorf("%w %q: expected 32 hex digits, got %d", ErrInvalidUUID, uuid, len(digits))
+	}
+	for _, c := range digits {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return "", fmt.Errorf("%w %q: unexpected character %q", ErrInvalidUUID, uuid, c)
+		}
+	}
+	return digits[0:8] + "-" + digits[8:12] + "-" + digits[12:16] + "-" + digits[16:20] + "-" + digits[20:32], nil
+}
+
+// CanonicalUUID returns the normalized form of the given UUID, to be used
+// as a map key or compared with other UUIDs. UUIDs which can't be
+// normalized are only trimmed and lower cased, so callers keep working
+// with whatever was handed in.
+func CanonicalUUID(uuid string) string {
+	normalized, err := NormalizeUUID(uuid)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(uuid))
+	}
+	return normalized
+}
+
+// UUIDsEqual returns true if the given UUIDs are the same once normalized.
+// UUIDs in different byte orders aren't considered equal, see
+// SwapUUIDByteOrder.
+func UUIDsEqual(uuid1, uuid2 string) bool {
+	return CanonicalUUID(uuid1) == CanonicalUUID(uuid2)
+}
+
+// SwapUUIDByteOrder converts a UUID between the little endian byte order of
+// its first three fields used by SMBIOS, which is what the guest reads from
+// /sys/class/dmi/id/product_uuid, and the big endian form vSphere reports
+// as the BIOS UUID of the VM. The conversion is its own inverse.
+//
+//	input uuid:    6B8C2042-0DD1-D037-156F-435F999D94C1
+//	returned uuid: 42208c6b-d10d-37d0-156f-435f999d94c1
+func SwapUUIDByteOrder(uuid string) (string, error) {
+	u, err := NormalizeUUID(uuid)
+	if err != nil {
+		return "", err
+	}
+	return u[6:8] + u[4:6] + u[2:4] + u[0:2] + "-" +
+		u[11:13] + u[9:11] + "-" +
+		u[16:18] + u[14:16] + "-" +
+		u[19:36], nil
+}
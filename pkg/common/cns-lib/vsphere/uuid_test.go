@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"errors"
+	"testing"
+)
+
+const (
+	// biosUUID is the BIOS UUID of a VM as reported by vSphere.
+	biosUUID = "42208c6b-d10d-37d0-156f-435f999d94c1"
+	// smbiosUUID is the same BIOS UUID as read by the guest from SMBIOS.
+	smbiosUUID = "6B8C2042-0DD1-D037-156F-435F999D94C1"
+	// instanceUUID is the vCenter instance UUID of the same VM.
+	instanceUUID = "502b4c3f-8d6e-4a77-9f1c-2b8b1e4d7a10"
+)
+
+func TestNormalizeUUID(t *testing.T) {
+	tests := []struct {
+		uuid     string
+		expected string
+	}{
+		{biosUUID, biosUUID},
+		{instanceUUID, instanceUUID},
+		{"42208C6B-D10D-37D0-156F-435F999D94C1", biosUUID},
+		{"  42208c6b-d10d-37d0-156f-435f999d94c1\n", biosUUID},
+		{"42208c6bd10d37d0156f435f999d94c1", biosUUID},
+		{"42 20 8c 6b d1 0d 37 d0-15 6f 43 5f 99 9d 94 c1", biosUUID},
+		{"502B4C3F8D6E4A779F1C2B8B1E4D7A10", instanceUUID},
+		{smbiosUUID, "6b8c2042-0dd1-d037-156f-435f999d94c1"},
+	}
+	for _, test := range tests {
+		normalized, err := NormalizeUUID(test.uuid)
+		if err != nil {
+			t.Errorf("NormalizeUUID(%q) failed: %v", test.uuid, err)
+		} else if normalized != test.expected {
+			t.Errorf("NormalizeUUID(%q) = %q, expected %q", test.uuid, normalized, test.expected)
+		}
+	}
+}
+
+func TestNormalizeInvalidUUID(t *testing.T) {
+	for _, uuid := range []string{
+		"",
+		"uuid-1",
+		"vsphere://" + biosUUID,
+		"42208c6b-d10d-37d0-156f-435f999d94c",
+		"42208c6b-d10d-37d0-156f-435f999d94c1a",
+		"42208c6b-d10d-37d0-156f-435f999d94cg",
+		"{42208c6b-d10d-37d0-156f-435f999d94c1}",
+	} {
+		if normalized, err := NormalizeUUID(uuid); !errors.Is(err, ErrInvalidUUID) {
+			t.Errorf("NormalizeUUID(%q) = %q, %v, expected ErrInvalidUUID", uuid, normalized, err)
+		}
+	}
+}
+
+func TestCanonicalUUID(t *testing.T) {
+	if uuid := CanonicalUUID(" 42208C6BD10D37D0156F435F999D94C1 "); uuid != biosUUID {
+		t.Errorf("expected %q, got %q", biosUUID, uuid)
+	}
+	if uuid := CanonicalUUID(" UUID-1 "); uuid != "uuid-1" {
+		t.Errorf("invalid UUID should be trimmed and lower cased, got %q", uuid)
+	}
+}
+
+func TestUUIDsEqual(t *testing.T) {
+	tests := []struct {
+		uuid1, uuid2 string
+		equal        bool
+	}{
+		{biosUUID, biosUUID, true},
+		{biosUUID, "42208C6B-D10D-37D0-156F-435F999D94C1", true},
+		{biosUUID, "42208c6bd10d37d0156f435f999d94c1", true},
+		{"UUID-1", "uuid-1", true},
+		{biosUUID, smbiosUUID, false},
+		{biosUUID, instanceUUID, false},
+		{biosUUID, "", false},
+	}
+	for _, test := range tests {
+		if equal := UUIDsEqual(test.uuid1, test.uuid2); equal != test.equal {
+			t.Errorf("UUIDsEqual(%q, %q) = %t, expected %t", test.uuid1, test.uuid2, equal, test.equal)
+		}
+	}
+}
+
+func TestSwapUUIDByteOrder(t *testing.T) {
+	tests := []struct {
+		uuid     string
+		expected string
+	}{
+		{smbiosUUID, biosUUID},
+		{biosUUID, "6b8c2042-0dd1-d037-156f-435f999d94c1"},
+		{"6b8c20420dd1d037156f435f999d94c1", biosUUID},
+		{instanceUUID, "3f4c2b50-6e8d-774a-9f1c-2b8b1e4d7a10"},
+	}
+	for _, test := range tests {
+		swapped, err := SwapUUIDByteOrder(test.uuid)
+		if err != nil {
+			t.Errorf("SwapUUIDByteOrder(%q) failed: %v", test.uuid, err)
+			continue
+		}
+		if swapped != test.expected {
+			t.Errorf("SwapUUIDByteOrder(%q) = %q, expected %q", test.uuid, swapped, test.expected)
+		}
+		if back, _ := SwapUUIDByteOrder(swapped); !UUIDsEqual(back, test.uuid) {
+			t.Errorf("swapping %q twice gave %q", test.uuid, back)
+		}
+	}
+	if _, err := SwapUUIDByteOrder("uuid-1"); !errors.Is(err, ErrInvalidUUID) {
+		t.Errorf("expected ErrInvalidUUID, got %v", err)
+	}
+}
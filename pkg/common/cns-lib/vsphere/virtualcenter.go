@@ -25,15 +25,21 @@ import (
 	neturl "net/url"
 	"strconv"
 	"sync"
+	"time"
 
 	csictx "github.com/rexray/gocsi/context"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/cns"
+	cnstypes "github.com/vmware/govmomi/cns/types"
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/pbm"
+	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/session"
 	"github.com/vmware/govmomi/sts"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/view"
 	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/soap"
@@ -61,6 +67,9 @@ type VirtualCenter struct {
 	// CnsClient represents the CNS client instance.
 	CnsClient       *cns.Client
 	credentialsLock sync.Mutex
+	// sessionCheckedAt is when the session was last found to be valid. It is
+	// guarded by clientMutex.
+	sessionCheckedAt time.Time
 }
 
 func (vc *VirtualCenter) String() string {
@@ -103,6 +112,34 @@ func (vcc *VirtualCenterConfig) String() string {
 // clientMutex is used for exclusive connection creation.
 var clientMutex sync.Mutex
 
+var (
+	// failoverHandlers are called when a virtual center is found to have failed over
+	failoverHandlers     []func(host string)
+	failoverHandlersLock sync.Mutex
+)
+
+// RegisterFailoverHandler registers a handler called with the host of a
+// virtual center when reconnecting to it finds a different vCenter instance,
+// e.g. after a vCenter HA failover to a node restored from backup. Handlers
+// should drop the inventory they cached for the virtual center.
+func RegisterFailoverHandler(handler func(host string)) {
+	failoverHandlersLock.Lock()
+	defer failoverHandlersLock.Unlock()
+	failoverHandlers = append(failoverHandlers, handler)
+}
+
+// notifyFailover invalidates the caches of this package and calls the registered failover handlers
+func notifyFailover(host string) {
+	InvalidateDatastoreURLCache()
+	failoverHandlersLock.Lock()
+	handlers := make([]func(host string), len(failoverHandlers))
+	copy(handlers, failoverHandlers)
+	failoverHandlersLock.Unlock()
+	for _, handler := range handlers {
+		handler(host)
+	}
+}
+
 // newClient creates a new govmomi Client instance.
 func (vc *VirtualCenter) newClient(ctx context.Context) (*govmomi.Client, error) {
 	if vc.Config.Scheme == "" {
@@ -202,7 +239,7 @@ func (vc *VirtualCenter) Connect(ctx context.Context) error {
 		return err
 	}
 	klog.V(2).Infof("Invalid credentials. Cannot connect to server %q. "+
-		"Fetching credentials from secret.", vc.Config.Host)
+		"Fetching credentials from the credential provider.", vc.Config.Host)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -217,7 +254,7 @@ func (vc *VirtualCenter) Connect(ctx context.Context) error {
 		klog.Errorf("Failed to read config with err: %v", err)
 		return err
 	}
-	vcenterconfig, err := GetVirtualCenterConfig(cfg)
+	vcenterconfig, err := GetVirtualCenterConfigForHost(cfg, vc.Config.Host)
 	if err != nil {
 		klog.Errorf("Failed to get VirtualCenterConfig. err=%v", err)
 		return err
@@ -228,6 +265,7 @@ func (vc *VirtualCenter) Connect(ctx context.Context) error {
 
 // connect creates a connection to the virtual center host.
 func (vc *VirtualCenter) connect(ctx context.Context) error {
+	calledAt := time.Now()
 	clientMutex.Lock()
 	defer clientMutex.Unlock()
 
@@ -238,25 +276,42 @@ func (vc *VirtualCenter) connect(ctx context.Context) error {
 			klog.Errorf("Failed to create govmomi client with err: %v", err)
 			return err
 		}
+		vc.sessionCheckedAt = time.Now()
+		return nil
+	}
+	// Concurrent callers wait on clientMutex while one of them checks the
+	// session. If the session was found valid after this call started, share
+	// that result instead of checking it again, so that a burst of RPCs costs
+	// a single round trip to vCenter.
+	if !vc.sessionCheckedAt.Before(calledAt) {
 		return nil
 	}
 
 	// If session hasn't expired, nothing to do.
 	sessionMgr := session.NewManager(vc.Client.Client)
+	checkedAt := time.Now()
 	// SessionMgr.UserSession(ctx) retrieves and returns the SessionManager's CurrentSession field
 	// Nil is returned if the session is not authenticated or timed out.
 	if userSession, err := sessionMgr.UserSession(ctx); err != nil {
-		klog.Errorf("Failed to obtain user session with err: %v", err)
-		return err
+		// The session can't be read after a vCenter HA failover or a vCenter
+		// restart, log in again instead of failing until the controller restarts.
+		klog.Warningf("Failed to obtain user session with err: %v", err)
 	} else if userSession != nil {
+		vc.sessionCheckedAt = checkedAt
 		return nil
 	}
 	// If session has expired, create a new instance.
 	klog.Warning("Creating a new client session as the existing session isn't valid or not authenticated")
+	instanceUUID := vc.Client.ServiceContent.About.InstanceUuid
 	if vc.Client, err = vc.newClient(ctx); err != nil {
 		klog.Errorf("Failed to create govmomi client with err: %v", err)
 		return err
 	}
+	if newInstanceUUID := vc.Client.ServiceContent.About.InstanceUuid; newInstanceUUID != instanceUUID {
+		klog.Warningf("Instance UUID of vCenter %q changed from %q to %q, invalidating cached inventory",
+			vc.Config.Host, instanceUUID, newInstanceUUID)
+		notifyFailover(vc.Config.Host)
+	}
 	// Recreate PbmClient If created using timed out VC Client
 	if vc.PbmClient != nil {
 		if vc.PbmClient, err = pbm.NewClient(ctx, vc.Client.Client); err != nil {
@@ -271,6 +326,7 @@ func (vc *VirtualCenter) connect(ctx context.Context) error {
 			return err
 		}
 	}
+	vc.sessionCheckedAt = time.Now()
 	return nil
 }
 
@@ -341,6 +397,29 @@ func (vc *VirtualCenter) UpdateCredentials(username, password string) {
 	vc.Config.Password = password
 }
 
+// GetUsername returns the username of the credentials in use
+func (vc *VirtualCenter) GetUsername() string {
+	vc.credentialsLock.Lock()
+	defer vc.credentialsLock.Unlock()
+	return vc.Config.Username
+}
+
+// getCredentials returns the username and password in use. They are copied
+// under credentialsLock, so logins don't race with UpdateCredentials.
+func (vc *VirtualCenter) getCredentials() (string, string) {
+	vc.credentialsLock.Lock()
+	defer vc.credentialsLock.Unlock()
+	return vc.Config.Username, vc.Config.Password
+}
+
+// GetContainerCluster returns the CNS container cluster with the given ID
+// for the user connected to the virtual center. The user is taken from the
+// credentials in use, which are only in the vSphere config file with the
+// default credential provider.
+func (vc *VirtualCenter) GetContainerCluster(clusterID string) cnstypes.CnsContainerCluster {
+	return GetContainerCluster(clusterID, vc.GetUsername())
+}
+
 // GetHostsByCluster return hosts inside the cluster using cluster moref.
 func (vc *VirtualCenter) GetHostsByCluster(ctx context.Context, clusterMorefValue string) ([]*HostSystem, error) {
 	clusterMoref := types.ManagedObjectReference{
@@ -362,3 +441,66 @@ func (vc *VirtualCenter) GetHostsByCluster(ctx context.Context, clusterMorefValu
 	}
 	return hostObjList, nil
 }
+
+// WatchDatastores calls onChange with the update of the datastore inventory
+// of the virtual center, and then whenever a datastore is added or removed or
+// the hosts mounting a datastore change. The changes are watched with a
+// property collector, so the inventory is not walked until it changes.
+// WatchDatastores blocks until ctx is done or watching fails.
+func (vc *VirtualCenter) WatchDatastores(ctx context.Context, onChange func()) error {
+	containerView, err := view.NewManager(vc.Client.Client).CreateContainerView(ctx, vc.Client.ServiceContent.RootFolder, []string{"Datastore"}, true)
+	if err != nil {
+		klog.Errorf("Failed to create container view of datastores on vCenter %q with err: %v", vc.Config.Host, err)
+		return err
+	}
+	defer func() {
+		if err := containerView.Destroy(context.Background()); err != nil {
+			klog.Warningf("Failed to destroy container view of datastores on vCenter %q with err: %v", vc.Config.Host, err)
+		}
+	}()
+	filter := new(property.WaitFilter).Add(containerView.Reference(), "Datastore", []string{"host"},
+		&types.TraversalSpec{Type: "ContainerView", Path: "view"})
+	filter.Spec.ObjectSet[0].Skip = types.NewBool(true)
+	err = property.WaitForUpdates(ctx, property.DefaultCollector(vc.Client.Client), filter, func(updates []types.ObjectUpdate) bool {
+		onChange()
+		return false
+	})
+	if err != nil && ctx.Err() == nil {
+		klog.Errorf("Failed to watch datastores on vCenter %q with err: %v", vc.Config.Host, err)
+		return err
+	}
+	return ctx.Err()
+}
+
+// GetTagManager returns a tag manager logged in to the virtual center.
+// The caller must log it out once done.
+func (vc *VirtualCenter) GetTagManager(ctx context.Context) (*tags.Manager, error) {
+	username, password := vc.getCredentials()
+	return newTagManager(ctx, vc.Client.Client, username, password)
+}
+
+// newTagManager returns a tag manager logged in with the given credentials, by token if possible
+func newTagManager(ctx context.Context, client *vim25.Client, username string, password string) (*tags.Manager, error) {
+	restClient := rest.NewClient(client)
+	signer, err := signer(ctx, client, username, password)
+	if err != nil {
+		klog.Errorf("Failed to create the Signer. Error: %v", err)
+		return nil, err
+	}
+	if signer == nil {
+		klog.V(3).Info("Using plain text username and password")
+		user := neturl.UserPassword(username, password)
+		err = restClient.Login(ctx, user)
+	} else {
+		klog.V(3).Info("Using certificate and private key")
+		err = restClient.LoginByToken(restClient.WithSigner(ctx, signer))
+	}
+	if err != nil {
+		klog.Errorf("Failed to login for the rest client. Error: %v", err)
+	}
+	tagManager := tags.NewManager(restClient)
+	if tagManager == nil {
+		klog.Errorf("Failed to create a tagManager")
+	}
+	return tagManager, nil
+}
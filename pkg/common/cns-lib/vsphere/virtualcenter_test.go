@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/simulator"
+)
+
+func TestWatchDatastores(t *testing.T) {
+	model := simulator.VPX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	s := model.Service.NewServer()
+	defer s.Close()
+	// Canceled before closing the server, which waits for the watch to end
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, err := govmomi.NewClient(ctx, s.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vc := &VirtualCenter{Config: &VirtualCenterConfig{Host: s.URL.Host}, Client: client}
+
+	changes := make(chan struct{}, 10)
+	done := make(chan error)
+	go func() {
+		done <- vc.WatchDatastores(ctx, func() { changes <- struct{}{} })
+	}()
+	expectChange := func(what string) {
+		select {
+		case <-changes:
+		case err := <-done:
+			t.Fatalf("WatchDatastores returned before %s: %v", what, err)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected a change on %s", what)
+		}
+	}
+	expectChange("the initial inventory")
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected WatchDatastores to return %v once canceled, got %v", context.Canceled, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("WatchDatastores didn't return once canceled")
+	}
+}
@@ -19,6 +19,7 @@ package vsphere
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 
 	"k8s.io/klog"
@@ -79,7 +80,7 @@ func (m *defaultVirtualCenterManager) GetVirtualCenter(host string) (*VirtualCen
 		return vc.(*VirtualCenter), nil
 	}
 	klog.Errorf("Couldn't find VC %s in registry", host)
-	return nil, ErrVCNotFound
+	return nil, fmt.Errorf("couldn't find VC %s: %w", host, ErrVCNotFound)
 }
 
 func (m *defaultVirtualCenterManager) GetAllVirtualCenters() []*VirtualCenter {
@@ -99,7 +100,7 @@ func (m *defaultVirtualCenterManager) GetAllVirtualCenters() []*VirtualCenter {
 func (m *defaultVirtualCenterManager) RegisterVirtualCenter(config *VirtualCenterConfig) (*VirtualCenter, error) {
 	if _, exists := m.virtualCenters.Load(config.Host); exists {
 		klog.Errorf("VC was already found in registry, failed to register with config %v", config)
-		return nil, ErrVCAlreadyRegistered
+		return nil, fmt.Errorf("couldn't register VC %s: %w", config.Host, ErrVCAlreadyRegistered)
 	}
 
 	vc := &VirtualCenter{Config: config} // Note that the Client isn't initialized here.
@@ -20,11 +20,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/vmware/govmomi/object"
-	"github.com/vmware/govmomi/vapi/rest"
 	"github.com/vmware/govmomi/vapi/tags"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
@@ -34,6 +34,22 @@ import (
 // ErrVMNotFound is returned when a virtual machine isn't found.
 var ErrVMNotFound = errors.New("virtual machine wasn't found")
 
+// ErrChangeTrackingNotSupported is returned when change block tracking
+// can't be enabled on a virtual machine, e.g. due to its hardware version.
+var ErrChangeTrackingNotSupported = errors.New("change block tracking is not supported by the virtual machine")
+
+// ErrAttachPrerequisites is returned when a virtual machine doesn't meet the
+// prerequisites for attaching volumes.
+var ErrAttachPrerequisites = errors.New("virtual machine doesn't meet the prerequisites for attaching volumes")
+
+// ErrDiskUUIDNotEnabled is returned when disk.EnableUUID is not set on a
+// virtual machine, so the disks attached to it can't be found by UUID.
+var ErrDiskUUIDNotEnabled = errors.New("disk.EnableUUID is not set to TRUE on the virtual machine")
+
+// MinVMHardwareVersion is the minimum hardware version of a virtual machine
+// volumes can be attached to.
+const MinVMHardwareVersion = 13
+
 // VirtualMachine holds details of a virtual machine instance.
 type VirtualMachine struct {
 	// VirtualCenterHost represents the virtual machine's vCenter host.
@@ -64,6 +80,31 @@ func (vm *VirtualMachine) IsActive(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
+// GetDisks returns the number of disks of the virtual machine, including its
+// boot disk, and the volume IDs of its first class disks.
+func (vm *VirtualMachine) GetDisks(ctx context.Context) (int, []string, error) {
+	devices, err := vm.Device(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get devices of VM %v. err: %v", vm, err)
+		return 0, nil, err
+	}
+	count, volumeIDs := GetDisksOfDevices(devices)
+	return count, volumeIDs, nil
+}
+
+// GetDisksOfDevices returns the number of disks among the given devices of a
+// virtual machine and the volume IDs of its first class disks.
+func GetDisksOfDevices(devices object.VirtualDeviceList) (int, []string) {
+	disks := devices.SelectByType((*types.VirtualDisk)(nil))
+	var volumeIDs []string
+	for _, device := range disks {
+		if disk, ok := device.(*types.VirtualDisk); ok && disk.VDiskId != nil {
+			volumeIDs = append(volumeIDs, disk.VDiskId.Id)
+		}
+	}
+	return len(disks), volumeIDs
+}
+
 // renew renews the virtual machine and datacenter objects given its virtual center.
 func (vm *VirtualMachine) renew(vc *VirtualCenter) {
 	vm.VirtualMachine = object.NewVirtualMachine(vc.Client.Client, vm.VirtualMachine.Reference())
@@ -162,7 +203,7 @@ func GetVirtualMachineByUUID(uuid string, instanceUUID bool) (*VirtualMachine, e
 					// Found some Datacenter object.
 					klog.V(2).Infof("AsyncGetAllDatacenters with uuid %s sent a dc %v", uuid, dc)
 					if vm, err := dc.GetVirtualMachineByUUID(context.Background(), uuid, instanceUUID); err != nil {
-						if err == ErrVMNotFound {
+						if errors.Is(err, ErrVMNotFound) {
 							// Didn't find VM on this DC, so, continue searching on other DCs.
 							klog.V(2).Infof("Couldn't find VM given uuid %s on DC %v with err: %v, continuing search", uuid, dc, err)
 							continue
@@ -194,8 +235,114 @@ func GetVirtualMachineByUUID(uuid string, instanceUUID bool) (*VirtualMachine, e
 		return nil, poolErr
 	} else {
 		klog.Errorf("Returning VM not found err for UUID %s", uuid)
-		return nil, ErrVMNotFound
+		return nil, fmt.Errorf("couldn't find VM given uuid %s: %w", uuid, ErrVMNotFound)
+	}
+}
+
+// GetVirtualMachinesByUUID returns the virtual machines of the given BIOS
+// UUIDs in all the registered VCs, keyed on the lower case UUID. It issues a
+// single query per datacenter, which is much faster than calling
+// GetVirtualMachineByUUID for each UUID when discovering hundreds of nodes.
+// UUIDs which are not found are left out of the result.
+func GetVirtualMachinesByUUID(uuids []string) (map[string]*VirtualMachine, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vms := make(map[string]*VirtualMachine)
+	dcsChan, errChan := AsyncGetAllDatacenters(ctx, dcBufferSize)
+	for dc := range dcsChan {
+		dcVMs, err := dc.GetVirtualMachinesByUUID(ctx, uuids)
+		if err != nil {
+			klog.Errorf("Failed to find VMs on DC %v with err: %v", dc, err)
+			return nil, err
+		}
+		for uuid, vm := range dcVMs {
+			vms[uuid] = vm
+		}
+	}
+	if err, ok := <-errChan; ok && err != nil {
+		klog.Errorf("Failed to list datacenters with err: %v", err)
+		return nil, err
+	}
+	klog.V(2).Infof("Found %d of %d VMs by UUID", len(vms), len(uuids))
+	return vms, nil
+}
+
+// ValidateChangeTrackingSupported returns ErrChangeTrackingNotSupported if
+// the virtual machine can't track the changed blocks of its disks, e.g. due
+// to its hardware version. The virtual machine is not reconfigured.
+func (vm *VirtualMachine) ValidateChangeTrackingSupported(ctx context.Context) error {
+	var oVM mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"capability.changeTrackingSupported"}, &oVM)
+	if err != nil {
+		klog.Errorf("Failed to get change tracking capability of vm: %v. err: %+v", vm, err)
+		return err
 	}
+	if oVM.Capability.ChangeTrackingSupported == nil || !*oVM.Capability.ChangeTrackingSupported {
+		return fmt.Errorf("couldn't track changed blocks on vm %v: %w", vm, ErrChangeTrackingNotSupported)
+	}
+	return nil
+}
+
+// ValidateAttachPrerequisites checks that volumes can be attached to the
+// virtual machine: its hardware version must be MinVMHardwareVersion or later
+// and it must have a VMware Paravirtual SCSI controller. Otherwise an error
+// wrapping ErrAttachPrerequisites is returned, telling how to fix the
+// virtual machine. The devices of the virtual machine are returned, so they
+// aren't fetched again before the attach.
+func (vm *VirtualMachine) ValidateAttachPrerequisites(ctx context.Context) (object.VirtualDeviceList, error) {
+	var oVM mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.version", "config.hardware.device"}, &oVM)
+	if err != nil {
+		klog.Errorf("Failed to get hardware properties of vm: %v. err: %+v", vm, err)
+		return nil, err
+	}
+	if oVM.Config == nil {
+		return nil, fmt.Errorf("couldn't get the configuration of vm %v", vm)
+	}
+	devices := object.VirtualDeviceList(oVM.Config.Hardware.Device)
+	version, err := strconv.Atoi(strings.TrimPrefix(oVM.Config.Version, "vmx-"))
+	if err != nil {
+		klog.Warningf("Failed to parse hardware version %q of vm: %v. err: %+v", oVM.Config.Version, vm, err)
+	} else if version < MinVMHardwareVersion {
+		return nil, fmt.Errorf("vm %v has hardware version %s, volumes require hardware version vmx-%d or later. "+
+			"Upgrade the compatibility of the virtual machine to ESXi 6.5 or later: %w",
+			vm, oVM.Config.Version, MinVMHardwareVersion, ErrAttachPrerequisites)
+	}
+	for _, device := range devices {
+		if _, ok := device.(*types.ParaVirtualSCSIController); ok {
+			return devices, nil
+		}
+	}
+	return nil, fmt.Errorf("vm %v has no VMware Paravirtual SCSI controller, which volumes are attached to. "+
+		"Power off the virtual machine and add a SCSI controller of type VMware Paravirtual: %w", vm, ErrAttachPrerequisites)
+}
+
+// ValidateDiskUUIDEnabled returns an error wrapping ErrDiskUUIDNotEnabled
+// unless disk.EnableUUID is TRUE in the extra configuration of the virtual
+// machine. Without it the guest doesn't see the UUIDs of the disks, so
+// attached volumes can't be found on the node.
+func (vm *VirtualMachine) ValidateDiskUUIDEnabled(ctx context.Context) error {
+	var oVM mo.VirtualMachine
+	err := vm.Properties(ctx, vm.Reference(), []string{"config.extraConfig"}, &oVM)
+	if err != nil {
+		klog.Errorf("Failed to get extra configuration of vm: %v. err: %+v", vm, err)
+		return err
+	}
+	if oVM.Config == nil {
+		return fmt.Errorf("couldn't get the configuration of vm %v", vm)
+	}
+	for _, option := range oVM.Config.ExtraConfig {
+		value := option.GetOptionValue()
+		if strings.EqualFold(value.Key, "disk.EnableUUID") {
+			if enabled, ok := value.Value.(string); ok && strings.EqualFold(enabled, "TRUE") {
+				return nil
+			}
+			break
+		}
+	}
+	return fmt.Errorf("vm %v can't find attached volumes by UUID. "+
+		"Power off the virtual machine and set disk.EnableUUID to TRUE in its advanced configuration: %w", vm, ErrDiskUUIDNotEnabled)
 }
 
 // GetHostSystem returns HostSystem object of the virtual machine
@@ -217,33 +364,13 @@ func (vm *VirtualMachine) GetHostSystem(ctx context.Context) (*object.HostSystem
 
 // GetTagManager returns tagManager using vm client
 func (vm *VirtualMachine) GetTagManager(ctx context.Context) (*tags.Manager, error) {
-	restClient := rest.NewClient(vm.Client())
 	virtualCenter, err := GetVirtualCenterManager().GetVirtualCenter(vm.VirtualCenterHost)
 	if err != nil {
 		klog.Errorf("Failed to get virtualCenter. Error: %v", err)
 		return nil, err
 	}
-	signer, err := signer(ctx, vm.Client(), virtualCenter.Config.Username, virtualCenter.Config.Password)
-	if err != nil {
-		klog.Errorf("Failed to create the Signer. Error: %v", err)
-		return nil, err
-	}
-	if signer == nil {
-		klog.V(3).Info("Using plain text username and password")
-		user := url.UserPassword(virtualCenter.Config.Username, virtualCenter.Config.Password)
-		err = restClient.Login(ctx, user)
-	} else {
-		klog.V(3).Info("Using certificate and private key")
-		err = restClient.LoginByToken(restClient.WithSigner(ctx, signer))
-	}
-	if err != nil {
-		klog.Errorf("Failed to login for the rest client. Error: %v", err)
-	}
-	tagManager := tags.NewManager(restClient)
-	if tagManager == nil {
-		klog.Errorf("Failed to create a tagManager")
-	}
-	return tagManager, nil
+	username, password := virtualCenter.getCredentials()
+	return newTagManager(ctx, vm.Client(), username, password)
 }
 
 // GetAncestors returns ancestors of VM
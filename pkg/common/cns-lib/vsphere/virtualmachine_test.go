@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestValidateDiskUUIDEnabled(t *testing.T) {
+	model := simulator.VPX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	s := model.Service.NewServer()
+	defer s.Close()
+	ctx := context.Background()
+	client, err := govmomi.NewClient(ctx, s.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finder := find.NewFinder(client.Client, true)
+	vms, err := finder.VirtualMachineList(ctx, "/DC0/vm/*")
+	if err != nil || len(vms) < 3 {
+		t.Fatalf("Failed to find 3 simulator VMs: %v", err)
+	}
+	// Each case uses its own VM, as the simulator appends extra configuration
+	// options instead of replacing them
+	tests := []struct {
+		enableUUID string
+		expected   error
+	}{
+		{"", ErrDiskUUIDNotEnabled},
+		{"FALSE", ErrDiskUUIDNotEnabled},
+		{"TRUE", nil},
+	}
+	for i, test := range tests {
+		vm := &VirtualMachine{VirtualMachine: vms[i]}
+		if test.enableUUID != "" {
+			task, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{
+				ExtraConfig: []types.BaseOptionValue{&types.OptionValue{Key: "disk.EnableUUID", Value: test.enableUUID}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := task.Wait(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := vm.ValidateDiskUUIDEnabled(ctx)
+		if test.expected == nil && err != nil || test.expected != nil && !errors.Is(err, test.expected) {
+			t.Errorf("disk.EnableUUID %q: expected %v, got %v", test.enableUUID, test.expected, err)
+		}
+	}
+}
@@ -26,6 +26,8 @@ import (
 
 	"gopkg.in/gcfg.v1"
 	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/logging"
 )
 
 const (
@@ -38,6 +40,44 @@ const (
 	DefaultCloudConfigPath = "/etc/cloud/csi-vsphere.conf"
 	// EnvCloudConfig contains the path to the CSI vSphere Config
 	EnvCloudConfig = "VSPHERE_CSI_CONFIG"
+	// DefaultSoftDeleteTagCategory is the default tag category of disks pending purge
+	DefaultSoftDeleteTagCategory = "cns"
+	// DefaultSoftDeleteTag is the default tag of disks pending purge
+	DefaultSoftDeleteTag = "pending-purge"
+	// DefaultOperationHardTimeoutMinutes is the default number of minutes after
+	// which an in-flight controller operation is cancelled
+	DefaultOperationHardTimeoutMinutes = 30
+	// DefaultAuditMaxSizeMB is the default size in MB after which the audit file is rotated
+	DefaultAuditMaxSizeMB = 100
+	// DefaultAuditMaxBackups is the default number of rotated audit files kept
+	DefaultAuditMaxBackups = 5
+	// DefaultForegroundLoadThreshold is the default number of foreground
+	// operations in progress from which background operations wait
+	DefaultForegroundLoadThreshold = 8
+	// DefaultMaxBackgroundOperations is the default number of background
+	// operations allowed to run at once
+	DefaultMaxBackgroundOperations = 2
+	// DefaultAlarmWindowMinutes is the default number of minutes failures are
+	// counted over for vCenter alarms
+	DefaultAlarmWindowMinutes = 10
+	// DefaultVolumeSizeMB is the default size in MB of volumes created without a required size
+	DefaultVolumeSizeMB = 10 * 1024
+	// DefaultMinVolumeSizeMB is the default smallest size in MB of the volumes
+	// created, the smallest size of a CNS block volume
+	DefaultMinVolumeSizeMB = 1
+	// DefaultTelemetryIntervalHours is the default number of hours between
+	// two telemetry reports
+	DefaultTelemetryIntervalHours = 24
+	// VSphereUserPolicyOverwrite records the user of the vCenter session as
+	// the vSphere user in the CNS metadata of volumes
+	VSphereUserPolicyOverwrite = "overwrite"
+	// VSphereUserPolicyPreserve keeps the vSphere user set in the metadata,
+	// e.g. an automation account, and only records the session user when it
+	// is not set
+	VSphereUserPolicyPreserve = "preserve"
+	// VSphereUserPolicyRecordBoth records the session user followed by the
+	// vSphere user set in the metadata, e.g. "csi-user (automation-user)"
+	VSphereUserPolicyRecordBoth = "record-both"
 )
 
 // Errors
@@ -55,6 +95,41 @@ var (
 	// ErrMissingVCenter is returned when the provided configuration does not
 	// define any vCenters.
 	ErrMissingVCenter = errors.New("No Virtual Center hosts defined")
+
+	// ErrInvalidDefaultVolumeSize is returned when the configured default
+	// volume size is smaller than the configured minimum volume size.
+	ErrInvalidDefaultVolumeSize = errors.New("default-volume-size-mb is smaller than min-volume-size-mb")
+
+	// ErrIncompleteZoneRegion is returned when only one of the zone and
+	// region tag categories is configured.
+	ErrIncompleteZoneRegion = errors.New("zone and region tag categories must both be set in [Labels], or neither")
+
+	// ErrInvalidMinFreeSpacePercent is returned when the configured datastore
+	// free space percentage is not between 0 and 99.
+	ErrInvalidMinFreeSpacePercent = errors.New("datastore-min-free-space-percent must be between 0 and 99")
+
+	// ErrInvalidVSphereUserPolicy is returned when the configured vSphere
+	// user policy is not one of the supported policies.
+	ErrInvalidVSphereUserPolicy = errors.New("vsphere-user-policy must be overwrite, preserve or record-both")
+
+	// ErrUnknownCredentialProvider is returned when the configured credential
+	// provider is not registered.
+	ErrUnknownCredentialProvider = errors.New("unknown credential provider in [Credentials]")
+
+	// ErrCredentialsPathMissing is returned when the file credential provider
+	// is configured without a path.
+	ErrCredentialsPathMissing = errors.New("path is missing in [Credentials]")
+
+	// ErrInvalidNamespaceTags is returned when the tags configured for a
+	// namespace are not category:tag pairs.
+	ErrInvalidNamespaceTags = errors.New("tags in [Namespace] must be a comma separated list of category:tag")
+
+	// ErrInvalidLogFormat is returned when the configured log format is not
+	// one of the supported formats.
+	ErrInvalidLogFormat = errors.New("log format must be text or json in [Logging]")
+
+	// ErrInvalidLogLevel is returned when the configured log level is negative.
+	ErrInvalidLogLevel = errors.New("log level must not be negative in [Logging]")
 )
 
 func getEnvKeyValue(match string, partial bool) (string, string, error) {
@@ -121,12 +196,93 @@ func FromEnv(cfg *Config) error {
 			cfg.Global.InsecureFlag = InsecureFlag
 		}
 	}
+	if v := os.Getenv("VSPHERE_FORCE_DETACH_AFTER_FAILURES"); v != "" {
+		forceDetachAfterFailures, err := strconv.Atoi(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_FORCE_DETACH_AFTER_FAILURES: %s", err)
+		} else {
+			cfg.Global.ForceDetachAfterFailures = forceDetachAfterFailures
+		}
+	}
+	if v := os.Getenv("VSPHERE_DETACH_ORPHANED_BEFORE_DELETE"); v != "" {
+		detachOrphanedBeforeDelete, err := strconv.ParseBool(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_DETACH_ORPHANED_BEFORE_DELETE: %s", err)
+		} else {
+			cfg.Global.DetachOrphanedBeforeDelete = detachOrphanedBeforeDelete
+		}
+	}
+	if v := os.Getenv("VSPHERE_MAX_DISKS_PER_NODE"); v != "" {
+		maxDisksPerNode, err := strconv.Atoi(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_MAX_DISKS_PER_NODE: %s", err)
+		} else {
+			cfg.Global.MaxDisksPerNode = maxDisksPerNode
+		}
+	}
+	if v := os.Getenv("VSPHERE_OPERATION_HARD_TIMEOUT_MINUTES"); v != "" {
+		operationHardTimeoutMinutes, err := strconv.Atoi(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_OPERATION_HARD_TIMEOUT_MINUTES: %s", err)
+		} else {
+			cfg.Global.OperationHardTimeoutMinutes = operationHardTimeoutMinutes
+		}
+	}
+	if v := os.Getenv("VSPHERE_SOFT_DELETE_RETENTION_MINUTES"); v != "" {
+		retentionMinutes, err := strconv.Atoi(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_SOFT_DELETE_RETENTION_MINUTES: %s", err)
+		} else {
+			cfg.SoftDelete.RetentionMinutes = retentionMinutes
+		}
+	}
+	if v := os.Getenv("VSPHERE_LABEL_SYNC_TAG_CATEGORIES"); v != "" {
+		cfg.LabelSync.TagCategories = v
+	}
+	if v := os.Getenv("VSPHERE_TELEMETRY_ENDPOINT"); v != "" {
+		cfg.Telemetry.Endpoint = v
+	}
+	if v := os.Getenv("VSPHERE_LOG_FORMAT"); v != "" {
+		cfg.Logging.Format = v
+	}
+	if v := os.Getenv("VSPHERE_LOG_LEVEL"); v != "" {
+		logLevel, err := strconv.Atoi(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_LOG_LEVEL: %s", err)
+		} else {
+			cfg.Logging.Level = logLevel
+		}
+	}
+	if v := os.Getenv("VSPHERE_USER_POLICY"); v != "" {
+		cfg.Global.VSphereUserPolicy = v
+	}
+	if v := os.Getenv("VSPHERE_CREDENTIAL_PROVIDER"); v != "" {
+		cfg.Credentials.Provider = v
+	}
+	if v := os.Getenv("VSPHERE_CREDENTIALS_PATH"); v != "" {
+		cfg.Credentials.Path = v
+	}
+	if v := os.Getenv("VSPHERE_AUDIT_FILE"); v != "" {
+		cfg.Audit.File = v
+	}
+	if v := os.Getenv("VSPHERE_AUDIT_WEBHOOK_URL"); v != "" {
+		cfg.Audit.WebhookURL = v
+	}
+	if v := os.Getenv("VSPHERE_METADATA_WEBHOOK_URL"); v != "" {
+		cfg.MetadataBackend.WebhookURL = v
+	}
 	if v := os.Getenv("VSPHERE_LABEL_REGION"); v != "" {
 		cfg.Labels.Region = v
 	}
 	if v := os.Getenv("VSPHERE_LABEL_ZONE"); v != "" {
 		cfg.Labels.Zone = v
 	}
+	if v := os.Getenv("VSPHERE_LABEL_ZONE_TOPOLOGY_KEY"); v != "" {
+		cfg.Labels.ZoneTopologyKey = v
+	}
+	if v := os.Getenv("VSPHERE_LABEL_REGION_TOPOLOGY_KEY"); v != "" {
+		cfg.Labels.RegionTopologyKey = v
+	}
 	//Build VirtualCenter from ENVs
 	for _, e := range os.Environ() {
 		pair := strings.Split(e, "=")
@@ -197,6 +353,89 @@ func validateConfig(cfg *Config) error {
 	if cfg.Global.VCenterPort == "" {
 		cfg.Global.VCenterPort = DefaultVCenterPort
 	}
+	if cfg.Global.OperationHardTimeoutMinutes <= 0 {
+		cfg.Global.OperationHardTimeoutMinutes = DefaultOperationHardTimeoutMinutes
+	}
+	if cfg.Global.ForegroundLoadThreshold <= 0 {
+		cfg.Global.ForegroundLoadThreshold = DefaultForegroundLoadThreshold
+	}
+	if cfg.Global.MaxBackgroundOperations <= 0 {
+		cfg.Global.MaxBackgroundOperations = DefaultMaxBackgroundOperations
+	}
+	if cfg.Global.DefaultVolumeSizeMB <= 0 {
+		cfg.Global.DefaultVolumeSizeMB = DefaultVolumeSizeMB
+	}
+	if cfg.Global.MinVolumeSizeMB <= 0 {
+		cfg.Global.MinVolumeSizeMB = DefaultMinVolumeSizeMB
+	}
+	if cfg.Global.DefaultVolumeSizeMB < cfg.Global.MinVolumeSizeMB {
+		klog.Error(ErrInvalidDefaultVolumeSize)
+		return ErrInvalidDefaultVolumeSize
+	}
+	if cfg.Global.DatastoreMinFreeSpacePercent < 0 || cfg.Global.DatastoreMinFreeSpacePercent >= 100 {
+		klog.Error(ErrInvalidMinFreeSpacePercent)
+		return ErrInvalidMinFreeSpacePercent
+	}
+	switch cfg.Global.VSphereUserPolicy {
+	case "":
+		cfg.Global.VSphereUserPolicy = VSphereUserPolicyOverwrite
+	case VSphereUserPolicyOverwrite, VSphereUserPolicyPreserve, VSphereUserPolicyRecordBoth:
+	default:
+		klog.Error(ErrInvalidVSphereUserPolicy)
+		return ErrInvalidVSphereUserPolicy
+	}
+	switch cfg.Logging.Format {
+	case "":
+		cfg.Logging.Format = logging.FormatText
+	case logging.FormatText, logging.FormatJSON:
+	default:
+		klog.Error(ErrInvalidLogFormat)
+		return ErrInvalidLogFormat
+	}
+	if cfg.Logging.Level < 0 {
+		klog.Error(ErrInvalidLogLevel)
+		return ErrInvalidLogLevel
+	}
+	for namespace, namespaceConfig := range cfg.Namespace {
+		if namespaceConfig == nil {
+			continue
+		}
+		if _, err := parseVolumeTags(namespaceConfig.Tags); err != nil {
+			klog.Errorf("Invalid tags of namespace %q. Err: %v", namespace, err)
+			return err
+		}
+	}
+	if cfg.Telemetry.IntervalHours <= 0 {
+		cfg.Telemetry.IntervalHours = DefaultTelemetryIntervalHours
+	}
+	if cfg.Alarms.WindowMinutes <= 0 {
+		cfg.Alarms.WindowMinutes = DefaultAlarmWindowMinutes
+	}
+	if cfg.SoftDelete.TagCategory == "" {
+		cfg.SoftDelete.TagCategory = DefaultSoftDeleteTagCategory
+	}
+	if cfg.SoftDelete.Tag == "" {
+		cfg.SoftDelete.Tag = DefaultSoftDeleteTag
+	}
+	if cfg.Audit.MaxSizeMB <= 0 {
+		cfg.Audit.MaxSizeMB = DefaultAuditMaxSizeMB
+	}
+	if cfg.Audit.MaxBackups <= 0 {
+		cfg.Audit.MaxBackups = DefaultAuditMaxBackups
+	}
+	if cfg.Credentials.Provider == "" {
+		cfg.Credentials.Provider = CredentialProviderConfig
+	}
+	if !isCredentialProviderRegistered(cfg.Credentials.Provider) {
+		klog.Errorf("Credential provider %q is not registered", cfg.Credentials.Provider)
+		return ErrUnknownCredentialProvider
+	}
+	if cfg.Credentials.Provider == CredentialProviderFile && cfg.Credentials.Path == "" {
+		klog.Error(ErrCredentialsPathMissing)
+		return ErrCredentialsPathMissing
+	}
+	// Credentials are only required in the configuration when read from it
+	credentialsRequired := cfg.Credentials.Provider == CredentialProviderConfig
 	// Must have at least one vCenter defined
 	if len(cfg.VirtualCenter) == 0 {
 		klog.Error(ErrMissingVCenter)
@@ -211,14 +450,14 @@ func validateConfig(cfg *Config) error {
 
 		if vcConfig.User == "" {
 			vcConfig.User = cfg.Global.User
-			if vcConfig.User == "" {
+			if vcConfig.User == "" && credentialsRequired {
 				klog.Errorf("vcConfig.User is empty for vc %s!", vcServer)
 				return ErrUsernameMissing
 			}
 		}
 		if vcConfig.Password == "" {
 			vcConfig.Password = cfg.Global.Password
-			if vcConfig.Password == "" {
+			if vcConfig.Password == "" && credentialsRequired {
 				klog.Errorf("vcConfig.Password is empty for vc %s!", vcServer)
 				return ErrPasswordMissing
 			}
@@ -236,9 +475,83 @@ func validateConfig(cfg *Config) error {
 			vcConfig.InsecureFlag = cfg.Global.InsecureFlag
 		}
 	}
+	if (cfg.Labels.Zone == "") != (cfg.Labels.Region == "") {
+		klog.Error(ErrIncompleteZoneRegion)
+		return ErrIncompleteZoneRegion
+	}
+	if len(cfg.ZoneDatastores) > 0 && cfg.Labels.Zone == "" {
+		klog.Warningf("Zone datastores are configured for zones %v but zone category name is not specified. Zone datastores will be ignored.",
+			getZoneNames(cfg.ZoneDatastores))
+	}
 	return nil
 }
 
+// GetZoneDatastoreURLs returns the datastore URLs preferred for provisioning
+// volumes in the given zone.
+func (cfg *Config) GetZoneDatastoreURLs(zone string) []string {
+	zoneConfig, ok := cfg.ZoneDatastores[zone]
+	if !ok || zoneConfig == nil {
+		return nil
+	}
+	var datastoreURLs []string
+	for _, datastoreURL := range strings.Split(zoneConfig.DatastoreURLs, ",") {
+		if datastoreURL = strings.TrimSpace(datastoreURL); datastoreURL != "" {
+			datastoreURLs = append(datastoreURLs, datastoreURL)
+		}
+	}
+	return datastoreURLs
+}
+
+// GetMaxConcurrentCreates returns the number of volumes which may be created
+// at once on the datastore with the given URL, 0 if they are not limited.
+func (cfg *Config) GetMaxConcurrentCreates(datastoreURL string) int {
+	if datastoreConfig, ok := cfg.DatastoreConcurrency[datastoreURL]; ok && datastoreConfig != nil {
+		return datastoreConfig.MaxConcurrentCreates
+	}
+	return cfg.Global.MaxConcurrentCreatesPerDatastore
+}
+
+// VolumeTag is a vCenter tag attached to the disks of volumes.
+type VolumeTag struct {
+	Category string
+	Name     string
+}
+
+// GetNamespaceTags returns the tags attached to the disks of the volumes
+// created for the PVCs of the given namespace.
+func (cfg *Config) GetNamespaceTags(namespace string) []VolumeTag {
+	namespaceConfig, ok := cfg.Namespace[namespace]
+	if !ok || namespaceConfig == nil {
+		return nil
+	}
+	tags, _ := parseVolumeTags(namespaceConfig.Tags)
+	return tags
+}
+
+// parseVolumeTags parses a comma separated list of category:tag pairs.
+func parseVolumeTags(value string) ([]VolumeTag, error) {
+	var tags []VolumeTag
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("%q: %w", entry, ErrInvalidNamespaceTags)
+		}
+		tags = append(tags, VolumeTag{Category: strings.TrimSpace(parts[0]), Name: strings.TrimSpace(parts[1])})
+	}
+	return tags, nil
+}
+
+func getZoneNames(zoneDatastores map[string]*ZoneDatastoresConfig) []string {
+	var zones []string
+	for zone := range zoneDatastores {
+		zones = append(zones, zone)
+	}
+	return zones
+}
+
 // ReadConfig parses vSphere cloud config file and stores it into VSphereConfig.
 // Environment variables are also checked
 func ReadConfig(config io.Reader) (*Config, error) {
@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+const (
+	// CredentialProviderConfig reads the vCenter credentials from the
+	// configuration file and the environment
+	CredentialProviderConfig = "config"
+	// CredentialProviderFile reads the vCenter credentials from files in the
+	// directory given by [Credentials] path, e.g. a mounted kubernetes secret
+	// or files rendered by a Vault agent
+	CredentialProviderFile = "file"
+)
+
+// CredentialProvider returns the credentials used to log in to a vCenter.
+// Credentials are fetched again whenever a login fails with invalid
+// credentials, so providers backed by a secret store pick up rotated secrets.
+type CredentialProvider interface {
+	// GetCredentials returns the username and password of the vCenter with the given host
+	GetCredentials(host string) (username string, password string, err error)
+}
+
+// CredentialProviderFactory creates the CredentialProvider of a configuration
+type CredentialProviderFactory func(cfg *Config) (CredentialProvider, error)
+
+var (
+	credentialProvidersLock sync.RWMutex
+	credentialProviders     = map[string]CredentialProviderFactory{
+		CredentialProviderConfig: newConfigCredentialProvider,
+		CredentialProviderFile:   newFileCredentialProvider,
+	}
+)
+
+// RegisterCredentialProvider registers a credential provider under the given
+// name, to be selected with [Credentials] provider. Registering a name twice
+// replaces the previous provider.
+func RegisterCredentialProvider(name string, factory CredentialProviderFactory) {
+	credentialProvidersLock.Lock()
+	defer credentialProvidersLock.Unlock()
+	credentialProviders[name] = factory
+}
+
+func isCredentialProviderRegistered(name string) bool {
+	credentialProvidersLock.RLock()
+	defer credentialProvidersLock.RUnlock()
+	_, found := credentialProviders[name]
+	return found
+}
+
+// GetCredentialProvider returns the credential provider selected in the configuration
+func GetCredentialProvider(cfg *Config) (CredentialProvider, error) {
+	name := cfg.Credentials.Provider
+	if name == "" {
+		name = CredentialProviderConfig
+	}
+	credentialProvidersLock.RLock()
+	factory, found := credentialProviders[name]
+	credentialProvidersLock.RUnlock()
+	if !found {
+		klog.Errorf("Credential provider %q is not registered", name)
+		return nil, ErrUnknownCredentialProvider
+	}
+	return factory(cfg)
+}
+
+// configCredentialProvider returns the credentials of the configuration
+type configCredentialProvider struct {
+	cfg *Config
+}
+
+func newConfigCredentialProvider(cfg *Config) (CredentialProvider, error) {
+	return &configCredentialProvider{cfg: cfg}, nil
+}
+
+func (p *configCredentialProvider) GetCredentials(host string) (string, string, error) {
+	vcConfig, found := p.cfg.VirtualCenter[host]
+	if !found {
+		return "", "", fmt.Errorf("vCenter %s is not configured", host)
+	}
+	return vcConfig.User, vcConfig.Password, nil
+}
+
+// fileCredentialProvider reads the credentials from the files <host>.username
+// and <host>.password in a directory, or username and password when there are
+// no files for the host. Files are read on every call so rotated secrets are
+// picked up without restarting.
+type fileCredentialProvider struct {
+	path string
+}
+
+func newFileCredentialProvider(cfg *Config) (CredentialProvider, error) {
+	if cfg.Credentials.Path == "" {
+		klog.Error(ErrCredentialsPathMissing)
+		return nil, ErrCredentialsPathMissing
+	}
+	return &fileCredentialProvider{path: cfg.Credentials.Path}, nil
+}
+
+func (p *fileCredentialProvider) GetCredentials(host string) (string, string, error) {
+	username, err := p.read(host, "username")
+	if err != nil {
+		return "", "", err
+	}
+	password, err := p.read(host, "password")
+	if err != nil {
+		return "", "", err
+	}
+	return username, password, nil
+}
+
+// read returns the content of the file <host>.<name>, or of the file <name>
+// if the former does not exist, without surrounding whitespace
+func (p *fileCredentialProvider) read(host string, name string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(p.path, host+"."+name))
+	if os.IsNotExist(err) {
+		content, err = ioutil.ReadFile(filepath.Join(p.path, name))
+	}
+	if err != nil {
+		klog.Errorf("Failed to read the %s of vCenter %s from %s. Err: %v", name, host, p.path, err)
+		return "", err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "" {
+		return "", fmt.Errorf("%s of vCenter %s is empty in %s", name, host, p.path)
+	}
+	return value, nil
+}
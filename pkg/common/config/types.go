@@ -38,16 +38,192 @@ type Config struct {
 		CAFile string `gcfg:"ca-file"`
 		// Datacenter in which Node VMs are located.
 		Datacenters string `gcfg:"datacenters"`
+		// Number of consecutive failed detaches of a volume from a node after which
+		// the disk is removed from the node VM without going through CNS.
+		// Force detach is disabled when not set.
+		ForceDetachAfterFailures int `gcfg:"force-detach-after-failures"`
+		// Minutes after which an in-flight controller operation is cancelled.
+		// Defaults to DefaultOperationHardTimeoutMinutes.
+		OperationHardTimeoutMinutes int `gcfg:"operation-hard-timeout-minutes"`
+		// Detach a volume being deleted from a node VM when kubernetes has no
+		// VolumeAttachment for it, instead of failing the deletion.
+		DetachOrphanedBeforeDelete bool `gcfg:"detach-orphaned-before-delete"`
+		// Number of foreground operations in progress, such as those serving CSI
+		// RPCs, from which background operations such as full sync wait.
+		// Defaults to DefaultForegroundLoadThreshold.
+		ForegroundLoadThreshold int `gcfg:"foreground-load-threshold"`
+		// Number of background operations allowed to run at once.
+		// Defaults to DefaultMaxBackgroundOperations.
+		MaxBackgroundOperations int `gcfg:"max-background-operations"`
+		// Size in MB of volumes created without a required size.
+		// Defaults to DefaultVolumeSizeMB.
+		DefaultVolumeSizeMB int64 `gcfg:"default-volume-size-mb"`
+		// Smallest size in MB of the volumes created. Smaller required sizes
+		// are raised to it, unless it exceeds the limit of the requested
+		// capacity range. Defaults to DefaultMinVolumeSizeMB.
+		MinVolumeSizeMB int64 `gcfg:"min-volume-size-mb"`
+		// Number of volumes created at once on a datastore, unless overridden
+		// for the datastore in [DatastoreConcurrency]. Further creations
+		// wait for one of them to complete. Not limited when not set.
+		MaxConcurrentCreatesPerDatastore int `gcfg:"max-concurrent-creates-per-datastore"`
+		// Free space a datastore must keep after a volume is created on it, as
+		// a percentage of its capacity and in MB. Datastores which would go
+		// below either are not used for new volumes. Not checked when not set.
+		DatastoreMinFreeSpacePercent int   `gcfg:"datastore-min-free-space-percent"`
+		DatastoreMinFreeSpaceMB      int64 `gcfg:"datastore-min-free-space-mb"`
+		// How the vSphere user recorded in the CNS metadata of volumes is set
+		// when it differs from the user of the vCenter session:
+		// VSphereUserPolicyOverwrite, VSphereUserPolicyPreserve or
+		// VSphereUserPolicyRecordBoth. Defaults to VSphereUserPolicyOverwrite.
+		VSphereUserPolicy string `gcfg:"vsphere-user-policy"`
+		// Number of disks a node VM can have, including its boot disk, e.g.
+		// 60 for 4 SCSI controllers with 15 disks each. Attaching more
+		// volumes fails with ResourceExhausted, and the nodes advertise it
+		// minus the boot disk as their volume limit to the scheduler.
+		// Not limited when not set.
+		MaxDisksPerNode int `gcfg:"max-disks-per-node"`
 	}
 
 	// Virtual Center configurations
 	VirtualCenter map[string]*VirtualCenterConfig
 
+	// Source of the vCenter credentials
+	Credentials struct {
+		// Name of the credential provider, CredentialProviderConfig or
+		// CredentialProviderFile, or a provider registered with
+		// RegisterCredentialProvider. Defaults to CredentialProviderConfig.
+		Provider string `gcfg:"provider"`
+		// Directory the credentials are read from by CredentialProviderFile.
+		Path string `gcfg:"path"`
+	}
+
 	// Tag categories and tags which correspond to "built-in node labels: zones and region"
 	Labels struct {
 		Zone   string `gcfg:"zone"`
 		Region string `gcfg:"region"`
+		// Topology keys the zone and region of nodes and volumes are reported
+		// under. Default to the failure-domain.beta.kubernetes.io labels. Set
+		// them to topology.kubernetes.io/zone and topology.kubernetes.io/region
+		// for the GA labels, or to custom keys.
+		ZoneTopologyKey   string `gcfg:"zone-topology-key"`
+		RegionTopologyKey string `gcfg:"region-topology-key"`
+	}
+
+	// Datastores preferred for provisioning volumes in a zone, keyed on the zone tag name
+	ZoneDatastores map[string]*ZoneDatastoresConfig
+
+	// Limits of the volumes created at once on a datastore, keyed on the datastore URL
+	DatastoreConcurrency map[string]*DatastoreConcurrencyConfig
+
+	// Organization of the volumes created for the PVCs of a namespace, keyed on the namespace name
+	Namespace map[string]*NamespaceConfig
+
+	// Soft deletion of volumes. When enabled, DeleteVolume removes the volume from
+	// CNS but keeps the disk, tagging it as pending purge. Tagged disks are deleted
+	// permanently once the retention period has passed.
+	SoftDelete struct {
+		// Minutes a soft deleted disk is kept before it is purged.
+		// Soft deletion is disabled when not set.
+		RetentionMinutes int `gcfg:"retention-minutes"`
+		// Tag category and tag marking disks pending purge. Both must exist on vCenter.
+		// The category must allow multiple tags per object, as the time a disk became
+		// pending purge is recorded in a second tag of the category.
+		TagCategory string `gcfg:"tag-category"`
+		Tag         string `gcfg:"tag"`
+	}
+
+	// Reverse label sync. Tags attached on vCenter to the disk of a volume are
+	// reflected as labels on its PV, for the tag categories listed here.
+	LabelSync struct {
+		// Comma separated tag categories. Reverse label sync is disabled when not set.
+		TagCategories string `gcfg:"tag-categories"`
+	}
+
+	// Audit log of volume lifecycle operations. Records are written as JSON
+	// lines to a file, posted to a webhook, or both.
+	Audit struct {
+		// Path of the audit file. No file is written when not set.
+		File string `gcfg:"file"`
+		// Size in MB after which the audit file is rotated.
+		// Defaults to DefaultAuditMaxSizeMB.
+		MaxSizeMB int `gcfg:"max-size-mb"`
+		// Number of rotated audit files kept. Defaults to DefaultAuditMaxBackups.
+		MaxBackups int `gcfg:"max-backups"`
+		// URL records are posted to. No records are posted when not set.
+		WebhookURL string `gcfg:"webhook-url"`
+		// Comma separated StorageClass parameters whose values are redacted.
+		RedactParameters string `gcfg:"redact-parameters"`
+	}
+
+	// vCenter alarms on sustained driver failures. When an operation, e.g.
+	// attach, fails more than the threshold within the window, an event of type
+	// com.vmware.cns.csi.failures.raised is posted to vCenter, and one of type
+	// com.vmware.cns.csi.failures.cleared once it recovers. vCenter alarms
+	// triggered by these events notify the vSphere admins.
+	Alarms struct {
+		// Number of failures of an operation within the window above which the
+		// alarm is raised. Alarms are disabled when not set.
+		FailureThreshold int `gcfg:"failure-threshold"`
+		// Minutes failures are counted over. Defaults to DefaultAlarmWindowMinutes.
+		WindowMinutes int `gcfg:"window-minutes"`
 	}
+
+	// External metadata backend. The PV, PVC and Pod metadata the syncer pushes
+	// to CNS is also posted as JSON to this backend, e.g. a CMDB tracking
+	// storage inventory outside vCenter.
+	MetadataBackend struct {
+		// URL metadata updates are posted to. Disabled when not set.
+		WebhookURL string `gcfg:"webhook-url"`
+	}
+
+	// Opt-in telemetry. The syncer periodically posts anonymized aggregate
+	// usage statistics as JSON to the endpoint: volume counts and sizes,
+	// features enabled in this configuration and the vSphere version. No
+	// names, labels or addresses are reported.
+	Telemetry struct {
+		// URL the statistics are posted to. Disabled when not set.
+		Endpoint string `gcfg:"endpoint"`
+		// Hours between two reports. Defaults to DefaultTelemetryIntervalHours.
+		IntervalHours int `gcfg:"interval-hours"`
+	}
+
+	// Logging of the controller, node and syncer. The level can also be
+	// changed at runtime on /debug/loglevel of the metrics server, from
+	// inside the pod.
+	Logging struct {
+		// Log format, text or json. Defaults to text.
+		Format string `gcfg:"format"`
+		// klog verbosity. The -v flag is used when not set.
+		Level int `gcfg:"level"`
+	}
+}
+
+// ZoneDatastoresConfig contains the datastores preferred for provisioning
+// topology constrained volumes in a zone.
+type ZoneDatastoresConfig struct {
+	// Comma separated list of datastore URLs.
+	DatastoreURLs string `gcfg:"datastore-urls"`
+}
+
+// NamespaceConfig contains how the volumes created for the PVCs of a
+// namespace are organized in vCenter.
+type NamespaceConfig struct {
+	// Comma separated list of category:tag pairs attached to the disks of the
+	// volumes, e.g. for chargeback. The categories and tags must exist on
+	// vCenter. Volume creation fails if a tag can't be attached. Once any
+	// [Namespace] section is configured, CreateVolume fails for every volume
+	// whose PVC namespace can't be found, e.g. while its PVC isn't in the
+	// informer cache yet or if the volume name doesn't hold the PVC UID due to
+	// a custom --volume-name-prefix. Run external-provisioner with
+	// --extra-create-metadata so the namespace is always known.
+	Tags string `gcfg:"tags"`
+}
+
+// DatastoreConcurrencyConfig contains the limits of the volume operations run
+// at once on a datastore.
+type DatastoreConcurrencyConfig struct {
+	// Number of volumes created at once on the datastore. Not limited when 0.
+	MaxConcurrentCreates int `gcfg:"max-concurrent-creates"`
 }
 
 // VirtualCenterConfig contains information used to access a remote vCenter
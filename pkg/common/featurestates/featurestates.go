@@ -0,0 +1,166 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package featurestates implements feature state switches (FSS) backed by a
+// Kubernetes ConfigMap. Changes made to the ConfigMap are picked up without
+// restarting the driver, so features can be rolled out per cluster.
+package featurestates
+
+import (
+	"os"
+	"strconv"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// VolumeSnapshots is the feature state switch for volume snapshots
+	VolumeSnapshots = "volume-snapshots"
+
+	// DefaultConfigMapName is the name of the ConfigMap holding feature states
+	DefaultConfigMapName = "internal-feature-states.csi.vsphere.vmware.com"
+	// DefaultConfigMapNamespace is the namespace of the ConfigMap holding feature states
+	DefaultConfigMapNamespace = "kube-system"
+
+	// EnvConfigMapName is the env variable to override the name of the feature states ConfigMap
+	EnvConfigMapName = "FEATURE_STATES_CONFIGMAP_NAME"
+	// EnvConfigMapNamespace is the env variable to override the namespace of the feature states ConfigMap
+	EnvConfigMapNamespace = "FEATURE_STATES_CONFIGMAP_NAMESPACE"
+)
+
+var (
+	// defaultFeatureStates are the feature states used for features
+	// missing from the ConfigMap, or when the ConfigMap does not exist
+	defaultFeatureStates = map[string]bool{
+		VolumeSnapshots: false,
+	}
+
+	featureStates     = copyFeatureStates(defaultFeatureStates)
+	featureStatesLock = &sync.RWMutex{}
+	onceForInit       sync.Once
+)
+
+// Init reads the feature states ConfigMap and starts watching it for changes.
+// If the ConfigMap can't be read, the default feature states are used until
+// the watch picks it up. Init is safe to call multiple times, the ConfigMap
+// is watched only once.
+func Init(k8sclient clientset.Interface) {
+	onceForInit.Do(func() {
+		initFeatureStates(k8sclient)
+	})
+}
+
+// IsEnabled returns true if the feature state switch is enabled.
+// Unknown features are reported as disabled.
+func IsEnabled(featureName string) bool {
+	featureStatesLock.RLock()
+	defer featureStatesLock.RUnlock()
+	return featureStates[featureName]
+}
+
+func initFeatureStates(k8sclient clientset.Interface) {
+	name, namespace := getConfigMapNameAndNamespace()
+	configMap, err := k8sclient.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			klog.Warningf("Feature states ConfigMap %s/%s not found. Using default feature states: %v",
+				namespace, name, defaultFeatureStates)
+		} else {
+			// Don't fail the driver, e.g. on a deployment whose RBAC rules
+			// predate the ConfigMap and forbid reading it
+			klog.Errorf("Failed to get feature states ConfigMap %s/%s. Using default feature states: %v. err=%v",
+				namespace, name, defaultFeatureStates, err)
+		}
+	} else {
+		updateFeatureStates(configMap)
+	}
+
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(k8sclient, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	informerFactory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if configMap, ok := obj.(*v1.ConfigMap); ok {
+				updateFeatureStates(configMap)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if configMap, ok := newObj.(*v1.ConfigMap); ok {
+				updateFeatureStates(configMap)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			klog.Warningf("Feature states ConfigMap %s/%s deleted. Using default feature states: %v",
+				namespace, name, defaultFeatureStates)
+			setFeatureStates(copyFeatureStates(defaultFeatureStates))
+		},
+	})
+	informerFactory.Start(wait.NeverStop)
+}
+
+// updateFeatureStates replaces the current feature states with the ones in the ConfigMap
+func updateFeatureStates(configMap *v1.ConfigMap) {
+	states := copyFeatureStates(defaultFeatureStates)
+	for featureName, value := range configMap.Data {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			klog.Errorf("Invalid value %q for feature state %q in ConfigMap %s/%s. Using default: %v",
+				value, featureName, configMap.Namespace, configMap.Name, states[featureName])
+			continue
+		}
+		states[featureName] = enabled
+	}
+	klog.V(2).Infof("Feature states updated from ConfigMap %s/%s: %v", configMap.Namespace, configMap.Name, states)
+	setFeatureStates(states)
+}
+
+func setFeatureStates(states map[string]bool) {
+	featureStatesLock.Lock()
+	defer featureStatesLock.Unlock()
+	featureStates = states
+}
+
+func copyFeatureStates(states map[string]bool) map[string]bool {
+	statesCopy := make(map[string]bool, len(states))
+	for featureName, enabled := range states {
+		statesCopy[featureName] = enabled
+	}
+	return statesCopy
+}
+
+// getConfigMapNameAndNamespace returns the name and namespace of the feature states ConfigMap
+func getConfigMapNameAndNamespace() (string, string) {
+	name := DefaultConfigMapName
+	namespace := DefaultConfigMapNamespace
+	if v := os.Getenv(EnvConfigMapName); v != "" {
+		name = v
+	}
+	if v := os.Getenv(EnvConfigMapNamespace); v != "" {
+		namespace = v
+	}
+	return name, namespace
+}
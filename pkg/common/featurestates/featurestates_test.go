@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package featurestates
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newConfigMap(data map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DefaultConfigMapName,
+			Namespace: DefaultConfigMapNamespace,
+		},
+		Data: data,
+	}
+}
+
+func TestUpdateFeatureStates(t *testing.T) {
+	defer setFeatureStates(copyFeatureStates(defaultFeatureStates))
+	updateFeatureStates(newConfigMap(map[string]string{
+		VolumeSnapshots: "true",
+		"new-feature":   "not-a-bool",
+	}))
+	if !IsEnabled(VolumeSnapshots) {
+		t.Errorf("Expected %q to be enabled", VolumeSnapshots)
+	}
+	if IsEnabled("new-feature") {
+		t.Errorf("Expected feature with an invalid value to be disabled")
+	}
+	updateFeatureStates(newConfigMap(nil))
+	if IsEnabled(VolumeSnapshots) {
+		t.Errorf("Expected %q to be disabled once removed from the ConfigMap", VolumeSnapshots)
+	}
+}
+
+func TestInitFeatureStates(t *testing.T) {
+	defer setFeatureStates(copyFeatureStates(defaultFeatureStates))
+	initFeatureStates(testclient.NewSimpleClientset(newConfigMap(map[string]string{VolumeSnapshots: "true"})))
+	if !IsEnabled(VolumeSnapshots) {
+		t.Errorf("Expected %q to be enabled from the ConfigMap", VolumeSnapshots)
+	}
+}
+
+func TestInitFeatureStatesForbidden(t *testing.T) {
+	defer setFeatureStates(copyFeatureStates(defaultFeatureStates))
+	k8sclient := testclient.NewSimpleClientset()
+	k8sclient.PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, DefaultConfigMapName, nil)
+	})
+	// The driver must keep running with default feature states
+	initFeatureStates(k8sclient)
+	if IsEnabled(VolumeSnapshots) != defaultFeatureStates[VolumeSnapshots] {
+		t.Errorf("Expected default state of %q when the ConfigMap can't be read", VolumeSnapshots)
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// FormatText is the default klog text format
+	FormatText = "text"
+	// FormatJSON writes every log line as a JSON object
+	FormatJSON = "json"
+)
+
+// ErrInvalidLevel is returned when the requested log level is not a
+// non-negative integer.
+var ErrInvalidLevel = errors.New("log level must be a non-negative integer")
+
+// lock serializes the changes to the klog flags and outputs.
+var lock sync.Mutex
+
+// Configure switches the klog output to the given format and sets its
+// verbosity to level. level is left unchanged when 0, so that the -v flag
+// passed to the binary keeps applying.
+func Configure(format string, level int) error {
+	lock.Lock()
+	defer lock.Unlock()
+	switch format {
+	case "", FormatText:
+	case FormatJSON:
+		// klog only writes to the redirected outputs when not logging to
+		// stderr. All the lines reach the INFO output, the other severities
+		// are discarded to not write the lines more than once.
+		for name, value := range map[string]string{
+			"logtostderr":     "false",
+			"alsologtostderr": "false",
+			"stderrthreshold": "FATAL",
+		} {
+			if err := flag.Set(name, value); err != nil {
+				klog.Errorf("Failed to set klog flag %s. Err: %v", name, err)
+				return err
+			}
+		}
+		klog.SetOutputBySeverity("FATAL", ioutil.Discard)
+		klog.SetOutputBySeverity("ERROR", ioutil.Discard)
+		klog.SetOutputBySeverity("WARNING", ioutil.Discard)
+		klog.SetOutputBySeverity("INFO", &jsonWriter{out: os.Stderr})
+	default:
+		err := fmt.Errorf("unknown log format %q", format)
+		klog.Error(err)
+		return err
+	}
+	if level > 0 {
+		if err := setLevel(level); err != nil {
+			return err
+		}
+	}
+	klog.V(2).Infof("Logging configured with format %q and level %s", format, getLevel())
+	return nil
+}
+
+// setLevel sets the klog verbosity.
+func setLevel(level int) error {
+	if level < 0 {
+		return ErrInvalidLevel
+	}
+	return flag.Set("v", strconv.Itoa(level))
+}
+
+// getLevel returns the klog verbosity.
+func getLevel() string {
+	if f := flag.Lookup("v"); f != nil {
+		return f.Value.String()
+	}
+	return "0"
+}
+
+// LevelHandler returns the klog verbosity on GET and sets it from the level
+// query parameter on PUT or POST, e.g. PUT /debug/loglevel?level=5, so that
+// verbosity can be raised temporarily without restarting the container.
+// The metrics port it is served on is reachable by anything in the cluster,
+// so the level can only be set from inside the pod, e.g. through
+// kubectl port-forward or exec.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			if !isLoopback(r.RemoteAddr) {
+				klog.Warningf("Rejected log level change from %s", r.RemoteAddr)
+				http.Error(w, "log level can only be set from localhost", http.StatusForbidden)
+				return
+			}
+			level, err := strconv.Atoi(r.URL.Query().Get("level"))
+			if err != nil || level < 0 {
+				http.Error(w, ErrInvalidLevel.Error(), http.StatusBadRequest)
+				return
+			}
+			lock.Lock()
+			err = setLevel(level)
+			lock.Unlock()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			klog.Infof("Log level set to %d by %s", level, r.RemoteAddr)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fmt.Fprintln(w, getLevel())
+	})
+}
+
+// isLoopback returns whether the request remote address is a loopback address.
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// jsonLine is a klog line in JSON format.
+type jsonLine struct {
+	Time    string `json:"ts"`
+	Level   string `json:"level"`
+	Caller  string `json:"caller,omitempty"`
+	Message string `json:"msg"`
+}
+
+// levels maps the klog severity letters to level names.
+var levels = map[byte]string{
+	'I': "info",
+	'W': "warning",
+	'E': "error",
+	'F': "fatal",
+}
+
+// jsonWriter converts the klog lines written to it to JSON.
+type jsonWriter struct {
+	out io.Writer
+}
+
+// Write is called by klog with one complete line at a time.
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	data, err := json.Marshal(parseLine(p, time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// parseLine splits a klog line, formatted as
+// Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg, into its fields. klog does
+// not log the year, the time is taken from now instead. Lines which are not
+// in the klog format are kept whole in the message.
+func parseLine(p []byte, now time.Time) jsonLine {
+	line := jsonLine{
+		Time:    now.UTC().Format(time.RFC3339Nano),
+		Level:   "info",
+		Message: string(bytes.TrimRight(p, "\n")),
+	}
+	end := bytes.Index(p, []byte("] "))
+	if end < 0 {
+		return line
+	}
+	header := bytes.Fields(p[:end])
+	if len(header) != 4 || len(header[0]) != 5 {
+		return line
+	}
+	level, ok := levels[header[0][0]]
+	if !ok {
+		return line
+	}
+	line.Level = level
+	line.Caller = string(header[3])
+	line.Message = string(bytes.TrimRight(p[end+2:], "\n"))
+	return line
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	line := parseLine([]byte("E1001 12:00:00.000001    1234 controller.go:42] Failed to create volume. Err: x: y\n"), now)
+	if line.Level != "error" || line.Caller != "controller.go:42" || line.Message != "Failed to create volume. Err: x: y" {
+		t.Errorf("unexpected fields %+v", line)
+	}
+	if line.Time != "2019-10-01T12:00:00Z" {
+		t.Errorf("unexpected time %s", line.Time)
+	}
+	line = parseLine([]byte("not a klog line] with a bracket\n"), now)
+	if line.Level != "info" || line.Caller != "" || line.Message != "not a klog line] with a bracket" {
+		t.Errorf("unexpected fields %+v", line)
+	}
+}
+
+func TestLevelHandlerOnlyFromLocalhost(t *testing.T) {
+	handler := LevelHandler()
+	for remoteAddr, expected := range map[string]int{
+		"10.0.0.1:40000":  http.StatusForbidden,
+		"[::1]:40000":     http.StatusBadRequest,
+		"127.0.0.1:40000": http.StatusBadRequest,
+	} {
+		// The level is invalid, requests allowed to set it fail validation
+		r := httptest.NewRequest(http.MethodPut, "/debug/loglevel?level=x", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != expected {
+			t.Errorf("expected status %d for a request from %s, got %d", expected, remoteAddr, w.Code)
+		}
+	}
+}
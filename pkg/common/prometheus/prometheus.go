@@ -0,0 +1,139 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prometheus
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog"
+)
+
+const (
+	// EnvMetricsAddress is the env variable overriding the address metrics are served on
+	EnvMetricsAddress = "METRICS_ADDRESS"
+	// DefaultCsiMetricsAddress is the default address the CSI driver serves metrics on
+	DefaultCsiMetricsAddress = ":2112"
+	// DefaultSyncerMetricsAddress is the default address the syncer serves metrics on
+	DefaultSyncerMetricsAddress = ":2113"
+)
+
+var (
+	// CsiInfo is a gauge metric to observe the build of the running CSI driver
+	CsiInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vsphere_csi_info",
+		Help: "CSI driver build information",
+	}, []string{"version", "git_commit", "build_date", "vsphere_api_level", "mode"})
+
+	// SyncerInfo is a gauge metric to observe the build of the running syncer
+	SyncerInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vsphere_syncer_info",
+		Help: "Syncer build information",
+	}, []string{"version", "git_commit", "build_date", "vsphere_api_level"})
+
+	// InflightOperations is a gauge metric to observe the number of CSI
+	// operations which are in progress
+	InflightOperations = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vsphere_csi_inflight_operations",
+		Help: "Number of CSI operations in progress",
+	}, []string{"operation"})
+
+	// CancelledOperations is a counter metric to observe the number of CSI
+	// operations cancelled for exceeding the operation hard timeout
+	CancelledOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vsphere_csi_cancelled_operations_total",
+		Help: "Number of CSI operations cancelled for exceeding the operation hard timeout",
+	}, []string{"operation"})
+
+	// DatastoreCreateQueueLength is a gauge metric to observe the number of
+	// volume creations waiting for a create slot of a datastore
+	DatastoreCreateQueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vsphere_csi_datastore_create_queue_length",
+		Help: "Number of volume creations waiting for a create slot of the datastore",
+	}, []string{"datastore_url"})
+
+	// CSINodeDrift is a gauge metric to observe the number of nodes whose
+	// CSINode registration of the driver drifted, by reason
+	CSINodeDrift = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vsphere_csi_csinode_drift_nodes",
+		Help: "Number of nodes whose CSINode registration of the driver drifted",
+	}, []string{"reason"})
+
+	// FullSyncGeneration is a gauge metric to observe the number of full sync
+	// cycles completed by the syncer since it started
+	FullSyncGeneration = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vsphere_syncer_fullsync_generation",
+		Help: "Number of full sync cycles completed since the syncer started",
+	})
+
+	// FullSyncSkippedVolumes is a gauge metric to observe the number of
+	// volumes whose PVC or pods could not be fetched in the last full sync
+	// cycle, and whose PVC and pod metadata was therefore left on CNS
+	FullSyncSkippedVolumes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vsphere_syncer_fullsync_skipped_volumes",
+		Help: "Number of volumes whose PVC or pods could not be fetched in the last full sync cycle",
+	})
+
+	// MetadataRetryQueueLength is a gauge metric to observe the number of
+	// failed metadata updates waiting to be retried by the syncer
+	MetadataRetryQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vsphere_syncer_metadata_retry_queue_length",
+		Help: "Number of failed metadata updates waiting to be retried",
+	})
+
+	// MetadataRetries is a counter metric to observe the number of metadata
+	// update retries made by the syncer
+	MetadataRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "vsphere_syncer_metadata_retries_total",
+		Help: "Number of metadata update retries",
+	})
+
+	// MetadataDeadLetters is a counter metric to observe the number of
+	// metadata updates the syncer gave up on
+	MetadataDeadLetters = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "vsphere_syncer_metadata_dead_letters_total",
+		Help: "Number of metadata updates given up on after exhausting their retries",
+	})
+)
+
+// StartMetricsServer serves the registered metrics on /metrics in the background.
+// Debug handlers registered with HandleDebug are served as well.
+// The address is taken from EnvMetricsAddress if set, else defaultAddress is used.
+func StartMetricsServer(defaultAddress string) {
+	address := os.Getenv(EnvMetricsAddress)
+	if address == "" {
+		address = defaultAddress
+	}
+	serveMux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		klog.V(2).Infof("Serving metrics on %s", address)
+		if err := http.ListenAndServe(address, serveMux); err != nil {
+			klog.Errorf("Metrics server on %s stopped. Err: %v", address, err)
+		}
+	}()
+}
+
+// serveMux serves the metrics and the debug handlers
+var serveMux = http.NewServeMux()
+
+// HandleDebug serves handler on /debug/<name> next to the metrics.
+func HandleDebug(name string, handler http.Handler) {
+	serveMux.Handle("/debug/"+name, handler)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook posts JSON documents to the HTTP endpoints the driver
+// reports to, such as the audit, metadata and telemetry webhooks.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Client posts JSON documents to a URL.
+type Client struct {
+	url    string
+	client *http.Client
+}
+
+// NewClient returns a Client posting to url, each post bounded by timeout.
+func NewClient(url string, timeout time.Duration) *Client {
+	return &Client{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// URL returns the URL the client posts to.
+func (c *Client) URL() string {
+	return c.url
+}
+
+// Post posts v as JSON to the URL of the client. Any response status
+// other than 2xx is returned as an error.
+func (c *Client) Post(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %s", c.url, resp.Status)
+	}
+	return nil
+}
+
+// Queue hands items to a handler in a background goroutine, so callers are
+// not held up by a slow endpoint. Items are dropped once size items are
+// waiting for the handler.
+type Queue struct {
+	items  chan interface{}
+	handle func(item interface{})
+}
+
+// NewQueue returns a Queue of the given size, calling handle for each item.
+func NewQueue(size int, handle func(item interface{})) *Queue {
+	q := &Queue{
+		items:  make(chan interface{}, size),
+		handle: handle,
+	}
+	go q.run()
+	return q
+}
+
+// Add queues item for the handler. It returns false, dropping the item, if
+// the queue is full.
+func (q *Queue) Add(item interface{}) bool {
+	select {
+	case q.items <- item:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *Queue) run() {
+	for item := range q.items {
+		q.handle(item)
+	}
+}
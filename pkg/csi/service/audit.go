@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	csictx "github.com/rexray/gocsi/context"
+	"google.golang.org/grpc"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/audit"
+	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// newAuditInterceptor returns an interceptor recording the volume lifecycle
+// RPCs in the audit log configured in cfg, or nil if no audit log is configured.
+func newAuditInterceptor(cfg *cnsconfig.Config) (grpc.UnaryServerInterceptor, error) {
+	var sinks []audit.Sink
+	if cfg.Audit.File != "" {
+		sink, err := audit.NewFileSink(cfg.Audit.File, cfg.Audit.MaxSizeMB, cfg.Audit.MaxBackups)
+		if err != nil {
+			klog.Errorf("Failed to create audit file sink. Error: %v", err)
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.Audit.WebhookURL != "" {
+		sinks = append(sinks, audit.NewWebhookSink(cfg.Audit.WebhookURL))
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	var redactParameters []string
+	for _, key := range strings.Split(cfg.Audit.RedactParameters, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			redactParameters = append(redactParameters, key)
+		}
+	}
+	vCenterIPs, err := cnsvsphere.GetVcenterIPs(cfg)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter of the audit log user. Error: %v", err)
+		return nil, err
+	}
+	vcHost := vCenterIPs[0]
+	logger := audit.NewLogger(sinks...)
+	klog.Infof("Audit log enabled, file: %q, webhook: %q", cfg.Audit.File, cfg.Audit.WebhookURL)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		record, audited := newAuditRecord(req, redactParameters)
+		if !audited {
+			return handler(ctx, req)
+		}
+		// Set the opID here, so the controller uses it for the CNS tasks of the request
+		ctx = common.WithRequestOpID(ctx, strings.ToLower(path.Base(info.FullMethod)))
+		if reqID, ok := csictx.GetRequestID(ctx); ok {
+			record.RequestID = fmt.Sprintf("%d", reqID)
+		}
+		record.Operation = path.Base(info.FullMethod)
+		record.User = getAuditUser(vcHost)
+		record.OpID = cnsvolume.GetOpID(ctx)
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		record.Time = start
+		record.DurationMs = int64(time.Since(start) / time.Millisecond)
+		record.Result = audit.ResultSuccess
+		if err != nil {
+			record.Result = audit.ResultFailure
+			record.Error = err.Error()
+		}
+		if createResp, ok := resp.(*csi.CreateVolumeResponse); ok && createResp.GetVolume() != nil {
+			record.VolumeID = createResp.GetVolume().VolumeId
+		}
+		logger.Log(record)
+		return resp, err
+	}, nil
+}
+
+// getAuditUser returns the vCenter user the controller acts as, taken from the
+// credentials in use so rotated credentials are reflected
+func getAuditUser(vcHost string) string {
+	vc, err := cnsvsphere.GetVirtualCenterManager().GetVirtualCenter(vcHost)
+	if err != nil {
+		klog.Warningf("Failed to get vCenter %s for the audit log user. Error: %v", vcHost, err)
+		return ""
+	}
+	return vc.GetUsername()
+}
+
+// newAuditRecord returns the audit record of the request, and false if the
+// request is not a volume lifecycle operation. Secrets are never recorded.
+func newAuditRecord(req interface{}, redactParameters []string) (audit.Record, bool) {
+	switch r := req.(type) {
+	case *csi.CreateVolumeRequest:
+		return audit.Record{
+			VolumeName: r.Name,
+			Parameters: audit.Redact(r.Parameters, redactParameters),
+		}, true
+	case *csi.DeleteVolumeRequest:
+		return audit.Record{VolumeID: r.VolumeId}, true
+	case *csi.ControllerPublishVolumeRequest:
+		return audit.Record{VolumeID: r.VolumeId, Node: r.NodeId}, true
+	case *csi.ControllerUnpublishVolumeRequest:
+		return audit.Record{VolumeID: r.VolumeId, Node: r.NodeId}, true
+	}
+	return audit.Record{}, false
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+// getPluginCapabilities returns the plugin capabilities of the driver running
+// in the given mode with the given configuration, so the sidecars configure
+// themselves for the deployment. The controller service is advertised unless
+// only the node service runs. Volume accessibility constraints are advertised
+// when zone and region tag categories are configured, or when the
+// configuration is unknown.
+func getPluginCapabilities(mode string, cfg *cnsconfig.Config) []*csi.PluginCapability {
+	var capabilities []*csi.PluginCapability
+	if !strings.EqualFold(mode, "node") {
+		capabilities = append(capabilities, newPluginServiceCapability(csi.PluginCapability_Service_CONTROLLER_SERVICE))
+	}
+	if cfg == nil || (cfg.Labels.Zone != "" && cfg.Labels.Region != "") {
+		capabilities = append(capabilities, newPluginServiceCapability(csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS))
+	}
+	return capabilities
+}
+
+func newPluginServiceCapability(capabilityType csi.PluginCapability_Service_Type) *csi.PluginCapability {
+	return &csi.PluginCapability{
+		Type: &csi.PluginCapability_Service_{
+			Service: &csi.PluginCapability_Service{
+				Type: capabilityType,
+			},
+		},
+	}
+}
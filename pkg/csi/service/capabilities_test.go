@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+func TestGetPluginCapabilities(t *testing.T) {
+	zonedCfg := &cnsconfig.Config{}
+	zonedCfg.Labels.Zone = "k8s-zone"
+	zonedCfg.Labels.Region = "k8s-region"
+	tests := []struct {
+		mode     string
+		cfg      *cnsconfig.Config
+		expected []csi.PluginCapability_Service_Type
+	}{
+		{
+			mode: "",
+			cfg:  nil,
+			expected: []csi.PluginCapability_Service_Type{
+				csi.PluginCapability_Service_CONTROLLER_SERVICE,
+				csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+			},
+		},
+		{
+			mode: "controller",
+			cfg:  &cnsconfig.Config{},
+			expected: []csi.PluginCapability_Service_Type{
+				csi.PluginCapability_Service_CONTROLLER_SERVICE,
+			},
+		},
+		{
+			mode: "controller",
+			cfg:  zonedCfg,
+			expected: []csi.PluginCapability_Service_Type{
+				csi.PluginCapability_Service_CONTROLLER_SERVICE,
+				csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+			},
+		},
+		{
+			mode:     "node",
+			cfg:      &cnsconfig.Config{},
+			expected: nil,
+		},
+		{
+			mode: "node",
+			cfg:  zonedCfg,
+			expected: []csi.PluginCapability_Service_Type{
+				csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		capabilities := getPluginCapabilities(tt.mode, tt.cfg)
+		if len(capabilities) != len(tt.expected) {
+			t.Errorf("mode %q: expected %d capabilities, got %d", tt.mode, len(tt.expected), len(capabilities))
+			continue
+		}
+		for i, capability := range capabilities {
+			if got := capability.GetService().GetType(); got != tt.expected[i] {
+				t.Errorf("mode %q: expected capability %v, got %v", tt.mode, tt.expected[i], got)
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/event"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+const (
+	// failureAlarmRaisedEventType is the type of the vCenter event posted when
+	// an operation fails repeatedly. vCenter alarms can be defined on it.
+	failureAlarmRaisedEventType = "com.vmware.cns.csi.failures.raised"
+	// failureAlarmClearedEventType is the type of the vCenter event posted when
+	// an operation which failed repeatedly recovers
+	failureAlarmClearedEventType = "com.vmware.cns.csi.failures.cleared"
+	// failureAlarmPostTimeout bounds the time posting an event to vCenter takes
+	failureAlarmPostTimeout = time.Minute
+	// failureAlarmEvaluationInterval is the interval between two checks for
+	// raised alarms whose failures aged out of the window
+	failureAlarmEvaluationInterval = time.Minute
+)
+
+// failureAlarms posts a vCenter event when an operation fails more than the
+// configured number of times within the configured window, and another one
+// once the failures fall back to or below that number, so vSphere admins are
+// notified of sustained driver failures through vCenter alarms.
+type failureAlarms struct {
+	manager   *common.Manager
+	threshold int
+	window    time.Duration
+	lock      sync.Mutex
+	// failures holds the times of the recent failures of each operation
+	failures map[string][]time.Time
+	// raised is set for the operations whose raised event was posted last
+	raised map[string]bool
+	// post posts an event to vCenter, overridable in tests
+	post func(eventTypeID string, severity string, message string)
+}
+
+// newFailureAlarms returns the failure alarms configured for the manager, or
+// nil when they are disabled
+func newFailureAlarms(manager *common.Manager) *failureAlarms {
+	cfg := manager.CnsConfig.Alarms
+	if cfg.FailureThreshold <= 0 {
+		return nil
+	}
+	a := &failureAlarms{
+		manager:   manager,
+		threshold: cfg.FailureThreshold,
+		window:    time.Duration(cfg.WindowMinutes) * time.Minute,
+		failures:  make(map[string][]time.Time),
+		raised:    make(map[string]bool),
+	}
+	a.post = a.postEvent
+	return a
+}
+
+// record records the outcome of an operation. Errors caused by invalid
+// requests are not counted as failures. Nothing is recorded when a is nil.
+func (a *failureAlarms) record(operation string, err error) {
+	if a == nil || status.Code(err) == codes.InvalidArgument {
+		return
+	}
+	now := time.Now()
+	a.lock.Lock()
+	recent := a.recentFailures(operation, now)
+	if err != nil {
+		recent = append(recent, now)
+	}
+	a.failures[operation] = recent
+	count := len(recent)
+	raise := count > a.threshold && !a.raised[operation]
+	clear := count <= a.threshold && a.raised[operation]
+	if raise || clear {
+		a.raised[operation] = raise
+	}
+	a.lock.Unlock()
+
+	if raise {
+		msg := fmt.Sprintf("Kubernetes cluster %q: %d %s failures in the last %v, last error: %v",
+			a.manager.CnsConfig.Global.ClusterID, count, operation, a.window, err)
+		klog.Warningf("Raising vCenter alarm. %s", msg)
+		go a.post(failureAlarmRaisedEventType, "warning", msg)
+	} else if clear {
+		a.postCleared(operation, count)
+	}
+}
+
+// run clears the raised alarms whose failures aged out of the window
+// periodically, so an alarm is cleared even if the operation is not called
+// again. It never returns.
+func (a *failureAlarms) run() {
+	ticker := time.NewTicker(failureAlarmEvaluationInterval)
+	for range ticker.C {
+		a.evaluate(time.Now())
+	}
+}
+
+// evaluate clears the raised alarms of the operations which failed at most
+// threshold times within the window before now
+func (a *failureAlarms) evaluate(now time.Time) {
+	cleared := make(map[string]int)
+	a.lock.Lock()
+	for operation, raised := range a.raised {
+		if !raised {
+			continue
+		}
+		recent := a.recentFailures(operation, now)
+		a.failures[operation] = recent
+		if len(recent) <= a.threshold {
+			a.raised[operation] = false
+			cleared[operation] = len(recent)
+		}
+	}
+	a.lock.Unlock()
+	for operation, count := range cleared {
+		a.postCleared(operation, count)
+	}
+}
+
+// recentFailures returns the failures of the operation within the window
+// before now. a.lock must be held.
+func (a *failureAlarms) recentFailures(operation string, now time.Time) []time.Time {
+	var recent []time.Time
+	for _, failure := range a.failures[operation] {
+		if now.Sub(failure) < a.window {
+			recent = append(recent, failure)
+		}
+	}
+	return recent
+}
+
+// postCleared posts the event clearing the alarm of the operation
+func (a *failureAlarms) postCleared(operation string, count int) {
+	msg := fmt.Sprintf("Kubernetes cluster %q: %s failures back to %d in the last %v",
+		a.manager.CnsConfig.Global.ClusterID, operation, count, a.window)
+	klog.Infof("Clearing vCenter alarm. %s", msg)
+	go a.post(failureAlarmClearedEventType, "info", msg)
+}
+
+// postEvent posts an event of the given type to vCenter
+func (a *failureAlarms) postEvent(eventTypeID string, severity string, message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), failureAlarmPostTimeout)
+	defer cancel()
+	vc, err := common.GetVCenter(ctx, a.manager)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter to post event %q. Err: %v", eventTypeID, err)
+		return
+	}
+	eventToPost := &vimtypes.EventEx{
+		EventTypeId: eventTypeID,
+		Severity:    severity,
+		Message:     message,
+	}
+	if err := event.NewManager(vc.Client.Client).PostEvent(ctx, eventToPost); err != nil {
+		klog.Errorf("Failed to post event %q to vCenter %q. Err: %v", eventTypeID, vc.Config.Host, err)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestFailureAlarms(t *testing.T) {
+	cfg := &config.Config{}
+	if alarms := newFailureAlarms(&common.Manager{CnsConfig: cfg}); alarms != nil {
+		t.Fatalf("expected alarms to be disabled without a threshold")
+	}
+	cfg.Alarms.FailureThreshold = 2
+	cfg.Alarms.WindowMinutes = 10
+	alarms := newFailureAlarms(&common.Manager{CnsConfig: cfg})
+	posted := make(chan string, 10)
+	alarms.post = func(eventTypeID string, severity string, message string) {
+		posted <- eventTypeID
+	}
+	expectPosted := func(eventTypeID string) {
+		select {
+		case got := <-posted:
+			if got != eventTypeID {
+				t.Fatalf("expected event %q, got %q", eventTypeID, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected event %q to be posted", eventTypeID)
+		}
+	}
+
+	failure := errors.New("attach failed")
+	alarms.record("attach", failure)
+	alarms.record("attach", failure)
+	alarms.record("attach", status.Error(codes.InvalidArgument, "invalid"))
+	alarms.record("detach", failure)
+	alarms.record("detach", failure)
+	alarms.record("detach", failure)
+	expectPosted(failureAlarmRaisedEventType)
+	alarms.record("attach", failure)
+	expectPosted(failureAlarmRaisedEventType)
+	alarms.record("attach", failure)
+
+	// Age the failures out of the window so the next success clears the alarm.
+	alarms.lock.Lock()
+	for i := range alarms.failures["attach"] {
+		alarms.failures["attach"][i] = time.Now().Add(-time.Hour)
+	}
+	alarms.lock.Unlock()
+	alarms.record("attach", nil)
+	expectPosted(failureAlarmClearedEventType)
+	select {
+	case got := <-posted:
+		t.Fatalf("unexpected event %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFailureAlarmsClearedOnTimer(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Alarms.FailureThreshold = 1
+	cfg.Alarms.WindowMinutes = 10
+	alarms := newFailureAlarms(&common.Manager{CnsConfig: cfg})
+	posted := make(chan string, 10)
+	alarms.post = func(eventTypeID string, severity string, message string) {
+		posted <- eventTypeID
+	}
+	failure := errors.New("detach failed")
+	alarms.record("detach", failure)
+	alarms.record("detach", failure)
+	select {
+	case got := <-posted:
+		if got != failureAlarmRaisedEventType {
+			t.Fatalf("expected event %q, got %q", failureAlarmRaisedEventType, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected event %q to be posted", failureAlarmRaisedEventType)
+	}
+
+	// The alarm stays raised while the failures are within the window
+	alarms.evaluate(time.Now())
+	// and is cleared once they aged out, without another call of the operation
+	alarms.evaluate(time.Now().Add(time.Hour))
+	select {
+	case got := <-posted:
+		if got != failureAlarmClearedEventType {
+			t.Fatalf("expected event %q, got %q", failureAlarmClearedEventType, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected event %q to be posted", failureAlarmClearedEventType)
+	}
+	alarms.evaluate(time.Now().Add(time.Hour))
+	select {
+	case got := <-posted:
+		t.Fatalf("unexpected event %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -18,23 +18,37 @@ package cns
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math/rand"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	cnstypes "github.com/vmware/govmomi/cns/types"
 	"github.com/vmware/govmomi/units"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 
+	cnsnode "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/node"
 	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/featurestates"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
 	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
+)
+
+const (
+	// eventSourceComponent is the component name of events emitted by the controller
+	eventSourceComponent = "vsphere-csi-controller"
 )
 
 var (
@@ -48,13 +62,33 @@ var (
 type nodeManager interface {
 	Initialize() error
 	GetSharedDatastoresInK8SCluster(ctx context.Context) ([]*cnsvsphere.DatastoreInfo, error)
-	GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, zoneKey string, regionKey string) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error)
+	GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, zoneCategoryName string, regionCategoryName string,
+		topologyKeys csitypes.TopologyKeys) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error)
 	GetNodeByName(nodeName string) (*cnsvsphere.VirtualMachine, error)
+	GetAllNodeNames() []string
 }
 
 type controller struct {
-	manager *common.Manager
-	nodeMgr nodeManager
+	manager       *common.Manager
+	nodeMgr       nodeManager
+	k8sclient     clientset.Interface
+	eventRecorder record.EventRecorder
+	// informMgr is the informer manager of the node manager
+	informMgr *k8s.InformerManager
+	// detachFailures counts consecutive failed detaches per volume and node
+	detachFailures     map[string]map[string]int
+	detachFailuresLock sync.Mutex
+	// diskCounts tracks the disks of the node VMs, by node name
+	diskCounts     map[string]*nodeDisks
+	diskCountsLock sync.Mutex
+	// softDelete is set when soft deletion of volumes is enabled
+	softDelete *softDeleteJanitor
+	// operations tracks in-flight operations
+	operations *operationJanitor
+	// datastoreHealth tracks the datastores which are inaccessible
+	datastoreHealth *datastoreHealthMonitor
+	// failureAlarms raises vCenter alarms on sustained failures, nil when disabled
+	failureAlarms *failureAlarms
 }
 
 // New creates a CNS controller
@@ -84,6 +118,8 @@ func (c *controller) Init(config *config.Config) error {
 		VolumeManager:  cnsvolume.GetManager(vcenter),
 		VcenterManager: cnsvsphere.GetVirtualCenterManager(),
 	}
+	cnsvolume.ConfigureScheduler(config.Global.ForegroundLoadThreshold, config.Global.MaxBackgroundOperations)
+	cnsvolume.ConfigureVSphereUserPolicy(config.Global.VSphereUserPolicy)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -97,12 +133,56 @@ func (c *controller) Init(config *config.Config) error {
 		klog.Errorf("checkAPI failed for vcenter API version: %s, err=%v", vc.Client.ServiceContent.About.ApiVersion, err)
 		return err
 	}
-	c.nodeMgr = &Nodes{}
+	nodes := &Nodes{}
+	c.nodeMgr = nodes
 	err = c.nodeMgr.Initialize()
 	if err != nil {
 		klog.Errorf("Failed to initialize nodeMgr. err=%v", err)
 		return err
 	}
+	c.informMgr = nodes.informMgr
+	// The PVs are needed to find the retain-disk annotation of deleted volumes
+	c.informMgr.GetPVLister()
+	if err := c.informMgr.IndexPVsByVolumeHandle(); err != nil {
+		klog.Warningf("Failed to index the PVs by volume handle, listing them instead. Err: %v", err)
+	}
+	// The VolumeAttachments are needed to find the node a volume being deleted is attached to
+	c.informMgr.GetVolumeAttachmentLister()
+	if len(config.Namespace) > 0 {
+		// The PVCs are needed to find the namespace of the volumes to tag
+		if err := c.informMgr.IndexPVCsByUID(); err != nil {
+			klog.Warningf("Failed to index the PVCs by UID, listing them instead. Err: %v", err)
+		}
+	}
+	c.informMgr.Listen()
+	if !c.informMgr.WaitForCacheSync() {
+		return errors.New("failed to sync the informer caches")
+	}
+	k8sclient, err := k8s.NewClient()
+	if err != nil {
+		klog.Errorf("Creating Kubernetes client failed. Err: %v", err)
+		return err
+	}
+	c.k8sclient = k8sclient
+	featurestates.Init(k8sclient)
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8sclient.CoreV1().Events("")})
+	c.eventRecorder = eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventSourceComponent})
+	c.operations = newOperationJanitor(c.manager)
+	go c.operations.run()
+	c.datastoreHealth = newDatastoreHealthMonitor(c)
+	go c.datastoreHealth.run()
+	go newDatastoreInventoryWatcher(c).run()
+	go newCSINodeChecker(c).run()
+	c.failureAlarms = newFailureAlarms(c.manager)
+	if c.failureAlarms != nil {
+		go c.failureAlarms.run()
+	}
+	if config.SoftDelete.RetentionMinutes > 0 {
+		klog.Infof("Soft deletion of volumes is enabled with a retention of %d minutes", config.SoftDelete.RetentionMinutes)
+		c.softDelete = newSoftDeleteJanitor(c.manager)
+		go c.softDelete.run()
+	}
 	return nil
 }
 
@@ -110,24 +190,39 @@ func (c *controller) Init(config *config.Config) error {
 // in CreateVolumeRequest
 func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (
 	*csi.CreateVolumeResponse, error) {
+	resp, err := c.createVolumeRequest(ctx, req)
+	c.failureAlarms.record("create", err)
+	return resp, err
+}
 
-	klog.V(4).Infof("CreateVolume: called with args %+v", *req)
+func (c *controller) createVolumeRequest(ctx context.Context, req *csi.CreateVolumeRequest) (
+	*csi.CreateVolumeResponse, error) {
+
+	ctx = common.WithRequestOpID(ctx, "createvolume")
+	klog.V(4).Infof("CreateVolume: called with args %+v, opId: %q", *req, cnsvolume.GetOpID(ctx))
+	ctx, done := c.operations.track(ctx, "createvolume", req.Name)
+	defer done()
 	err := validateVanillaCreateVolumeRequest(req)
 	if err != nil {
 		klog.Errorf("Failed to validate Create Volume Request with err: %v", err)
 		return nil, err
 	}
 
-	// Volume Size - Default is 10 GiB
-	volSizeBytes := int64(common.DefaultGbDiskSize * common.GbInBytes)
-	if req.GetCapacityRange() != nil && req.GetCapacityRange().RequiredBytes != 0 {
-		volSizeBytes = int64(req.GetCapacityRange().GetRequiredBytes())
+	// Volume Size - Default is 10 GiB unless configured
+	defaultSizeMB := c.manager.CnsConfig.Global.DefaultVolumeSizeMB
+	if defaultSizeMB <= 0 {
+		defaultSizeMB = common.DefaultGbDiskSize * 1024
+	}
+	volSizeMB, err := common.GetVolumeSizeMB(req.GetCapacityRange(), defaultSizeMB*common.MbInBytes,
+		c.manager.CnsConfig.Global.MinVolumeSizeMB*common.MbInBytes)
+	if err != nil {
+		return nil, err
 	}
-	volSizeMB := int64(common.RoundUpSize(volSizeBytes, common.MbInBytes))
 
 	var datastoreURL string
 	var storagePolicyName string
 	var fsType string
+	var changeBlockTracking string
 
 	// Support case insensitive parameters
 	for paramName := range req.Parameters {
@@ -138,6 +233,8 @@ func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequ
 			storagePolicyName = req.Parameters[paramName]
 		} else if param == common.AttributeFsType {
 			fsType = req.Parameters[common.AttributeFsType]
+		} else if param == common.AttributeChangeBlockTracking {
+			changeBlockTracking = strings.ToLower(req.Parameters[paramName])
 		}
 	}
 
@@ -149,6 +246,7 @@ func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequ
 	}
 	var sharedDatastores []*cnsvsphere.DatastoreInfo
 	var datastoreTopologyMap = make(map[string][]map[string]string)
+	var preferredZone string
 
 	// Get accessibility
 	topologyRequirement := req.GetAccessibilityRequirements()
@@ -161,7 +259,8 @@ func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequ
 			klog.Errorf(errMsg)
 			return nil, status.Error(codes.NotFound, errMsg)
 		}
-		sharedDatastores, datastoreTopologyMap, err = c.nodeMgr.GetSharedDatastoresInTopology(ctx, topologyRequirement, c.manager.CnsConfig.Labels.Zone, c.manager.CnsConfig.Labels.Region)
+		sharedDatastores, datastoreTopologyMap, err = c.nodeMgr.GetSharedDatastoresInTopology(ctx, topologyRequirement, c.manager.CnsConfig.Labels.Zone, c.manager.CnsConfig.Labels.Region,
+			csitypes.GetTopologyKeys(c.manager.CnsConfig))
 		if err != nil || len(sharedDatastores) == 0 {
 			msg := fmt.Sprintf("Failed to get shared datastores in topology: %+v. Error: %+v", topologyRequirement, err)
 			klog.Errorf(msg)
@@ -183,6 +282,9 @@ func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequ
 				klog.Errorf(errMsg)
 				return nil, status.Error(codes.InvalidArgument, errMsg)
 			}
+		} else {
+			// Prefer zone local datastores over datastores shared across zones
+			sharedDatastores, preferredZone = getZonePreferredDatastores(c.manager.CnsConfig, topologyRequirement, sharedDatastores, datastoreTopologyMap)
 		}
 
 	} else {
@@ -194,15 +296,64 @@ func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequ
 			return nil, status.Errorf(codes.Internal, msg)
 		}
 	}
+	// Avoid datastores in an all paths down or permanent device loss condition
+	sharedDatastores = c.datastoreHealth.filterHealthy(sharedDatastores)
+	if len(sharedDatastores) == 0 {
+		msg := "Failed to create volume. All shared datastores are inaccessible"
+		klog.Error(msg)
+		return nil, status.Errorf(codes.Unavailable, msg)
+	}
+	if createVolumeSpec.DatastoreURL != "" {
+		if reason, unhealthy := c.datastoreHealth.getUnhealthyReason(createVolumeSpec.DatastoreURL); unhealthy {
+			msg := fmt.Sprintf("Failed to create volume. DatastoreURL: %s specified in the storage class is inaccessible: %s", createVolumeSpec.DatastoreURL, reason)
+			klog.Error(msg)
+			return nil, status.Errorf(codes.Unavailable, msg)
+		}
+	}
+	// Avoid datastores which would be left with less free space than configured
+	sharedDatastores, err = filterDatastoresWithFreeSpace(ctx, c.manager.CnsConfig, sharedDatastores, volSizeMB)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to check the free space of the shared datastores. Error: %+v", err)
+		klog.Error(msg)
+		return nil, status.Errorf(codes.Internal, msg)
+	}
+	if len(sharedDatastores) == 0 || (createVolumeSpec.DatastoreURL != "" && !isDatastoreInList(sharedDatastores, createVolumeSpec.DatastoreURL)) {
+		msg := fmt.Sprintf("Failed to create volume. No shared datastore has enough free space for a volume of %d MB "+
+			"above the configured minimum free space", volSizeMB)
+		klog.Error(msg)
+		return nil, status.Errorf(codes.ResourceExhausted, msg)
+	}
+	// Find the namespace whose tags the volume gets before creating it, as
+	// the volume must not be left without them
+	namespace, err := c.getNamespaceToTag(req)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to find the namespace of volume %q to attach the tags configured in [Namespace]. Error: %+v", req.Name, err)
+		klog.Error(msg)
+		return nil, status.Errorf(codes.Unavailable, msg)
+	}
 	volumeID, err := common.CreateVolumeUtil(ctx, c.manager, &createVolumeSpec, sharedDatastores)
 	if err != nil {
 		msg := fmt.Sprintf("Failed to create volume. Error: %+v", err)
 		klog.Error(msg)
 		return nil, status.Errorf(codes.Internal, msg)
 	}
+	if err := c.tagNamespaceVolume(ctx, namespace, volumeID); err != nil {
+		msg := fmt.Sprintf("Failed to attach the tags of its namespace to volume %q. Error: %+v", volumeID, err)
+		klog.Error(msg)
+		// Don't leave an untagged volume behind, it is created again on retry
+		if err := common.DeleteVolumeUtil(ctx, c.manager, volumeID, true); err != nil {
+			klog.Errorf("Failed to delete untagged volume %q. Error: %+v", volumeID, err)
+		}
+		return nil, status.Errorf(codes.Internal, msg)
+	}
 	attributes := make(map[string]string)
 	attributes[common.AttributeDiskType] = common.DiskTypeString
 	attributes[common.AttributeFsType] = fsType
+	if changeBlockTracking == common.ChangeBlockTrackingPerClaim {
+		attributes[common.AttributeChangeBlockTracking] = common.ChangeBlockTrackingPerClaim
+	} else if enabled, _ := strconv.ParseBool(changeBlockTracking); enabled {
+		attributes[common.AttributeChangeBlockTracking] = "true"
+	}
 	resp := &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:      volumeID,
@@ -210,34 +361,36 @@ func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequ
 			VolumeContext: attributes,
 		},
 	}
-	// Call QueryVolume API and get the datastoreURL of the Provisioned Volume
-	var volumeAccessibleTopology = make(map[string]string)
-	if len(datastoreTopologyMap) > 0 {
-		volumeIds := []cnstypes.CnsVolumeId{{Id: volumeID}}
-		queryFilter := cnstypes.CnsQueryFilter{
-			VolumeIds: volumeIds,
-		}
-		queryResult, err := c.manager.VolumeManager.QueryVolume(queryFilter)
-		if err != nil {
-			klog.Errorf("QueryVolume failed for volumeID: %s", volumeID)
+	// Call QueryVolume API and get the datastoreURL and the storage policy of the Provisioned Volume
+	volumeIds := []cnstypes.CnsVolumeId{{Id: volumeID}}
+	queryFilter := cnstypes.CnsQueryFilter{
+		VolumeIds: volumeIds,
+	}
+	queryResult, err := c.manager.VolumeManager.QueryVolume(ctx, queryFilter)
+	if err != nil {
+		klog.Errorf("QueryVolume failed for volumeID: %s", volumeID)
+		if len(datastoreTopologyMap) > 0 {
 			return nil, status.Error(codes.Internal, err.Error())
 		}
+		return resp, nil
+	}
+	if len(queryResult.Volumes) > 0 {
+		// Record the storage policy CNS applied, which is the datastore default
+		// one when the storage class has no storage policy
+		c.setEffectiveStoragePolicy(ctx, attributes, queryResult.Volumes[0].StoragePolicyId)
+		c.setVmdkPath(ctx, attributes, volumeID, queryResult.Volumes[0].DatastoreUrl)
+	}
+	if len(datastoreTopologyMap) > 0 {
 		if len(queryResult.Volumes) > 0 {
-			// Find datastore topology from the retrieved datastoreURL
+			// Find datastore topology from the retrieved datastoreURL. All the topologies the
+			// datastore is accessible from are reported, e.g. both sites of a vSAN stretched
+			// cluster, so pods can be rescheduled to another zone after a site failure.
 			datastoreAccessibleTopology := datastoreTopologyMap[queryResult.Volumes[0].DatastoreUrl]
 			klog.V(3).Infof("Volume: %s is provisioned on the datastore: %s ", volumeID, queryResult.Volumes[0].DatastoreUrl)
-			if len(datastoreAccessibleTopology) > 0 {
-				rand.Seed(time.Now().Unix())
-				volumeAccessibleTopology = datastoreAccessibleTopology[rand.Intn(len(datastoreAccessibleTopology))]
-				klog.V(3).Infof("volumeAccessibleTopology: [%+v] is selected for datastore: %s ", volumeAccessibleTopology, queryResult.Volumes[0].DatastoreUrl)
-			}
-		}
-	}
-	if len(volumeAccessibleTopology) != 0 {
-		volumeTopology := &csi.Topology{
-			Segments: volumeAccessibleTopology,
+			resp.Volume.AccessibleTopology = getVolumeAccessibleTopology(datastoreAccessibleTopology,
+				csitypes.GetTopologyKeys(c.manager.CnsConfig).Zone, preferredZone)
+			klog.V(3).Infof("volumeAccessibleTopology: %+v is selected for datastore: %s ", resp.Volume.AccessibleTopology, queryResult.Volumes[0].DatastoreUrl)
 		}
-		resp.Volume.AccessibleTopology = append(resp.Volume.AccessibleTopology, volumeTopology)
 	}
 	return resp, nil
 }
@@ -245,18 +398,38 @@ func (c *controller) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequ
 // CreateVolume is deleting CNS Volume specified in DeleteVolumeRequest
 func (c *controller) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (
 	*csi.DeleteVolumeResponse, error) {
-	klog.V(4).Infof("DeleteVolume: called with args: %+v", *req)
+	resp, err := c.deleteVolumeRequest(ctx, req)
+	c.failureAlarms.record("delete", err)
+	return resp, err
+}
+
+func (c *controller) deleteVolumeRequest(ctx context.Context, req *csi.DeleteVolumeRequest) (
+	*csi.DeleteVolumeResponse, error) {
+	ctx = common.WithRequestOpID(ctx, "deletevolume")
+	klog.V(4).Infof("DeleteVolume: called with args %+v, opId: %q", *req, cnsvolume.GetOpID(ctx))
+	ctx, done := c.operations.track(ctx, "deletevolume", req.VolumeId)
+	defer done()
 	var err error
 	err = validateVanillaDeleteVolumeRequest(req)
 	if err != nil {
 		return nil, err
 	}
-	err = common.DeleteVolumeUtil(ctx, c.manager, req.VolumeId, true)
+	err = c.deleteVolume(ctx, req)
+	if errors.Is(err, cnsvolume.ErrVolumeInUse) {
+		var nodeName string
+		nodeName, err = c.handleDeleteAttachedVolume(ctx, req)
+		if err != nil && nodeName != "" {
+			msg := fmt.Sprintf("Failed to delete volume: %q as it is attached to node: %q. Error: %+v", req.VolumeId, nodeName, err)
+			klog.Error(msg)
+			return nil, status.Errorf(codes.FailedPrecondition, msg)
+		}
+	}
 	if err != nil {
 		msg := fmt.Sprintf("Failed to delete volume: %q. Error: %+v", req.VolumeId, err)
 		klog.Error(msg)
 		return nil, status.Errorf(codes.Internal, msg)
 	}
+	c.pruneDetachFailures(req.VolumeId)
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
@@ -264,8 +437,18 @@ func (c *controller) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequ
 // volume id and node name is retrieved from ControllerPublishVolumeRequest
 func (c *controller) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (
 	*csi.ControllerPublishVolumeResponse, error) {
+	resp, err := c.controllerPublishVolume(ctx, req)
+	c.failureAlarms.record("attach", err)
+	return resp, err
+}
 
-	klog.V(4).Infof("ControllerPublishVolume: called with args %+v", *req)
+func (c *controller) controllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (
+	*csi.ControllerPublishVolumeResponse, error) {
+
+	ctx = common.WithRequestOpID(ctx, "controllerpublishvolume")
+	klog.V(4).Infof("ControllerPublishVolume: called with args %+v, opId: %q", *req, cnsvolume.GetOpID(ctx))
+	ctx, done := c.operations.track(ctx, "controllerpublishvolume", req.VolumeId)
+	defer done()
 	err := validateVanillaControllerPublishVolumeRequest(req)
 	if err != nil {
 		msg := fmt.Sprintf("Validation for PublishVolume Request: %+v has failed. Error: %v", *req, err)
@@ -276,15 +459,70 @@ func (c *controller) ControllerPublishVolume(ctx context.Context, req *csi.Contr
 	if err != nil {
 		msg := fmt.Sprintf("Failed to find VirtualMachine for node:%q. Error: %v", req.NodeId, err)
 		klog.Error(msg)
+		if errors.Is(err, cnsnode.ErrNodeNotFound) || errors.Is(err, cnsvsphere.ErrVMNotFound) {
+			return nil, status.Errorf(codes.NotFound, msg)
+		}
 		return nil, status.Errorf(codes.Internal, msg)
 	}
 	klog.V(4).Infof("Found VirtualMachine for node:%q.", req.NodeId)
+	// The volume is queried once for the checks before the attach
+	volume, err := c.queryVolume(ctx, req.VolumeId)
+	if err != nil {
+		klog.Warningf("Failed to query volume: %q, attaching it without checking its datastore. Error: %v", req.VolumeId, err)
+	}
+	if err := c.datastoreHealth.checkVolumeDatastore(volume); err != nil {
+		msg := fmt.Sprintf("Failed to attach volume: %q to node: %q. Error: %v", req.VolumeId, req.NodeId, err)
+		klog.Error(msg)
+		return nil, status.Errorf(codes.Unavailable, msg)
+	}
+	if err := c.checkVolumeAccessibleFromNode(ctx, volume, req.NodeId, node); err != nil {
+		msg := fmt.Sprintf("Failed to attach volume: %q to node: %q. Error: %v", req.VolumeId, req.NodeId, err)
+		klog.Error(msg)
+		return nil, status.Errorf(codes.FailedPrecondition, msg)
+	}
+	// Reject nodes which can't have volumes attached with a precise error, rather than a reconfigure fault
+	devices, err := node.ValidateAttachPrerequisites(ctx)
+	if err != nil {
+		msg := fmt.Sprintf("Node: %q can not have volume: %q attached. Error: %v", req.NodeId, req.VolumeId, err)
+		klog.Error(msg)
+		if errors.Is(err, cnsvsphere.ErrAttachPrerequisites) {
+			return nil, status.Errorf(codes.FailedPrecondition, msg)
+		}
+		return nil, status.Errorf(codes.Internal, msg)
+	}
+	changeBlockTracking, err := c.isChangeBlockTrackingRequested(req)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to check whether change block tracking is requested for volume: %q. Error: %v", req.VolumeId, err)
+		klog.Error(msg)
+		return nil, status.Errorf(codes.Internal, msg)
+	}
+	if changeBlockTracking {
+		// Enable tracking on the disk before attaching it, so its changes are tracked from the start
+		if err := c.enableVolumeChangeTracking(ctx, req.VolumeId, volume, node); err != nil {
+			msg := fmt.Sprintf("Failed to enable change block tracking on node: %q for volume: %q. Error: %v", req.NodeId, req.VolumeId, err)
+			klog.Error(msg)
+			if errors.Is(err, cnsvsphere.ErrChangeTrackingNotSupported) {
+				return nil, status.Errorf(codes.FailedPrecondition, msg)
+			}
+			return nil, status.Errorf(codes.Internal, msg)
+		}
+	}
+	if err := c.checkDiskCapacity(ctx, req.NodeId, node, req.VolumeId, devices); err != nil {
+		return nil, err
+	}
 	diskUUID, err := common.AttachVolumeUtil(ctx, c.manager, node, req.VolumeId)
 	if err != nil {
 		msg := fmt.Sprintf("Failed to attach disk: %+q with node: %q err %+v", req.VolumeId, req.NodeId, err)
 		klog.Error(msg)
+		// The disk count may be stale, e.g. after disks were attached outside
+		// of kubernetes, count the disks again to tell if the VM is full
+		c.forgetDiskCount(req.NodeId)
+		if err := c.checkDiskCapacity(ctx, req.NodeId, node, req.VolumeId, nil); err != nil {
+			return nil, err
+		}
 		return nil, status.Errorf(codes.Internal, msg)
 	}
+	c.recordDiskAttached(req.NodeId, req.VolumeId)
 	publishInfo := make(map[string]string)
 	publishInfo[common.AttributeDiskType] = common.DiskTypeString
 	publishInfo[common.AttributeFirstClassDiskUUID] = common.FormatDiskUUID(diskUUID)
@@ -298,8 +536,18 @@ func (c *controller) ControllerPublishVolume(ctx context.Context, req *csi.Contr
 // volume id and node name is retrieved from ControllerUnpublishVolumeRequest
 func (c *controller) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (
 	*csi.ControllerUnpublishVolumeResponse, error) {
+	resp, err := c.controllerUnpublishVolume(ctx, req)
+	c.failureAlarms.record("detach", err)
+	return resp, err
+}
 
-	klog.V(4).Infof("ControllerUnpublishVolume: called with args %+v", *req)
+func (c *controller) controllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (
+	*csi.ControllerUnpublishVolumeResponse, error) {
+
+	ctx = common.WithRequestOpID(ctx, "controllerunpublishvolume")
+	klog.V(4).Infof("ControllerUnpublishVolume: called with args %+v, opId: %q", *req, cnsvolume.GetOpID(ctx))
+	ctx, done := c.operations.track(ctx, "controllerunpublishvolume", req.VolumeId)
+	defer done()
 	err := validateVanillaControllerUnpublishVolumeRequest(req)
 	if err != nil {
 		msg := fmt.Sprintf("Validation for UnpublishVolume Request: %+v has failed. Error: %v", *req, err)
@@ -310,14 +558,32 @@ func (c *controller) ControllerUnpublishVolume(ctx context.Context, req *csi.Con
 	if err != nil {
 		msg := fmt.Sprintf("Failed to find VirtualMachine for node:%q. Error: %v", req.NodeId, err)
 		klog.Error(msg)
+		if errors.Is(err, cnsnode.ErrNodeNotFound) || errors.Is(err, cnsvsphere.ErrVMNotFound) {
+			return nil, status.Errorf(codes.NotFound, msg)
+		}
 		return nil, status.Errorf(codes.Internal, msg)
 	}
 	err = common.DetachVolumeUtil(ctx, c.manager, node, req.VolumeId)
 	if err != nil {
 		msg := fmt.Sprintf("Failed to detach disk: %+q from node: %q err %+v", req.VolumeId, req.NodeId, err)
 		klog.Error(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+		failures := c.incrementDetachFailures(req.VolumeId, req.NodeId)
+		forceDetachAfterFailures := c.manager.CnsConfig.Global.ForceDetachAfterFailures
+		if forceDetachAfterFailures <= 0 || failures < forceDetachAfterFailures {
+			return nil, status.Errorf(codes.Internal, msg)
+		}
+		klog.Warningf("Detach of disk: %q from node: %q failed %d times. Force detaching the disk", req.VolumeId, req.NodeId, failures)
+		err = common.ForceDetachVolumeUtil(ctx, c.manager, node, req.VolumeId)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to force detach disk: %+q from node: %q err %+v", req.VolumeId, req.NodeId, err)
+			klog.Error(msg)
+			return nil, status.Errorf(codes.Internal, msg)
+		}
+		c.recordNodeEvent(req.NodeId, v1.EventTypeWarning, "ForceDetachedVolume",
+			fmt.Sprintf("Volume %s was force detached from the node after %d failed detach attempts", req.VolumeId, failures))
 	}
+	c.pruneDetachFailures(req.VolumeId)
+	c.recordDiskDetached(req.NodeId, req.VolumeId)
 	resp := &csi.ControllerUnpublishVolumeResponse{}
 	return resp, nil
 }
@@ -355,6 +621,9 @@ func (c *controller) ControllerGetCapabilities(ctx context.Context, req *csi.Con
 	*csi.ControllerGetCapabilitiesResponse, error) {
 
 	klog.V(4).Infof("ControllerGetCapabilities: called with args %+v", *req)
+	// CREATE_DELETE_SNAPSHOT is not advertised until the snapshot RPCs are
+	// implemented, so the snapshotter sidecar is not pointed at a driver which
+	// would reject every request
 	var caps []*csi.ControllerServiceCapability
 	for _, cap := range controllerCaps {
 		c := &csi.ControllerServiceCapability{
@@ -373,6 +642,13 @@ func (c *controller) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshot
 	*csi.CreateSnapshotResponse, error) {
 
 	klog.V(4).Infof("CreateSnapshot: called with args %+v", *req)
+	if !featurestates.IsEnabled(featurestates.VolumeSnapshots) {
+		return nil, status.Errorf(codes.Unimplemented, "CreateSnapshot: feature %q is disabled", featurestates.VolumeSnapshots)
+	}
+	if err := c.checkVCenterFeatureSupported(ctx, common.VCenterFeatureSnapshots); err != nil {
+		klog.Errorf("CreateSnapshot: %v", err)
+		return nil, err
+	}
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
@@ -380,6 +656,13 @@ func (c *controller) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshot
 	*csi.DeleteSnapshotResponse, error) {
 
 	klog.V(4).Infof("DeleteSnapshot: called with args %+v", *req)
+	if !featurestates.IsEnabled(featurestates.VolumeSnapshots) {
+		return nil, status.Errorf(codes.Unimplemented, "DeleteSnapshot: feature %q is disabled", featurestates.VolumeSnapshots)
+	}
+	if err := c.checkVCenterFeatureSupported(ctx, common.VCenterFeatureSnapshots); err != nil {
+		klog.Errorf("DeleteSnapshot: %v", err)
+		return nil, err
+	}
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
@@ -387,5 +670,12 @@ func (c *controller) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRe
 	*csi.ListSnapshotsResponse, error) {
 
 	klog.V(4).Infof("ListSnapshots: called with args %+v", *req)
+	if !featurestates.IsEnabled(featurestates.VolumeSnapshots) {
+		return nil, status.Errorf(codes.Unimplemented, "ListSnapshots: feature %q is disabled", featurestates.VolumeSnapshots)
+	}
+	if err := c.checkVCenterFeatureSupported(ctx, common.VCenterFeatureSnapshots); err != nil {
+		klog.Errorf("ListSnapshots: %v", err)
+		return nil, err
+	}
 	return nil, status.Error(codes.Unimplemented, "")
 }
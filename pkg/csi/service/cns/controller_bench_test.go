@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// benchmarkCreateVolumeRequest returns the request creating the i-th volume of a benchmark
+func benchmarkCreateVolumeRequest(i int) *csi.CreateVolumeRequest {
+	params := make(map[string]string)
+	if v := os.Getenv("VSPHERE_DATASTORE_URL"); v != "" {
+		params[common.AttributeDatastoreURL] = v
+	}
+	return &csi.CreateVolumeRequest{
+		Name: fmt.Sprintf("bench-volume-%d-%d", time.Now().UnixNano(), i),
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 1 * common.GbInBytes,
+		},
+		Parameters: params,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+	}
+}
+
+// BenchmarkCreateVolume measures the latency of the CreateVolume RPC. The
+// volumes are deleted once the timer is stopped.
+func BenchmarkCreateVolume(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ct := getControllerTest(b)
+
+	volumeIDs := make([]string, 0, b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := ct.controller.CreateVolume(ctx, benchmarkCreateVolumeRequest(i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		volumeIDs = append(volumeIDs, resp.Volume.VolumeId)
+	}
+	b.StopTimer()
+	for _, volumeID := range volumeIDs {
+		if _, err := ct.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDeleteVolume measures the latency of the DeleteVolume RPC. The
+// volumes are created before the timer is started.
+func BenchmarkDeleteVolume(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ct := getControllerTest(b)
+
+	volumeIDs := make([]string, 0, b.N)
+	for i := 0; i < b.N; i++ {
+		resp, err := ct.controller.CreateVolume(ctx, benchmarkCreateVolumeRequest(i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		volumeIDs = append(volumeIDs, resp.Volume.VolumeId)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, volumeID := range volumeIDs {
+		if _, err := ct.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetVCenter measures the cost of looking up and connecting to the
+// vCenter, which every RPC does, under concurrent calls.
+func BenchmarkGetVCenter(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ct := getControllerTest(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := common.GetVCenter(ctx, ct.controller.manager); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -17,14 +17,29 @@ limitations under the License.
 package cns
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"github.com/vmware/govmomi/object"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
 
+	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
 )
 
 // validateVanillaCreateVolumeRequest is the helper function to validate
@@ -35,11 +50,24 @@ func validateVanillaCreateVolumeRequest(req *csi.CreateVolumeRequest) error {
 	params := req.GetParameters()
 	for paramName := range params {
 		paramName = strings.ToLower(paramName)
-		if paramName != common.AttributeDatastoreURL && paramName != common.AttributeStoragePolicyName && paramName != common.AttributeFsType {
+		if strings.HasPrefix(paramName, common.CSIParameterPrefix) {
+			continue
+		}
+		if paramName != common.AttributeDatastoreURL && paramName != common.AttributeStoragePolicyName && paramName != common.AttributeFsType &&
+			paramName != common.AttributeChangeBlockTracking {
 			msg := fmt.Sprintf("Volume parameter %s is not a valid Vanilla CSI parameter.", paramName)
 			return status.Error(codes.InvalidArgument, msg)
 		}
 	}
+	for paramName, value := range params {
+		if strings.ToLower(paramName) != common.AttributeChangeBlockTracking {
+			continue
+		}
+		if _, err := strconv.ParseBool(value); err != nil && strings.ToLower(value) != common.ChangeBlockTrackingPerClaim {
+			msg := fmt.Sprintf("Volume parameter %s has invalid value %q, expected true, false or %s.", paramName, value, common.ChangeBlockTrackingPerClaim)
+			return status.Error(codes.InvalidArgument, msg)
+		}
+	}
 	return common.ValidateCreateVolumeRequest(req)
 }
 
@@ -62,3 +90,701 @@ func validateVanillaControllerPublishVolumeRequest(req *csi.ControllerPublishVol
 func validateVanillaControllerUnpublishVolumeRequest(req *csi.ControllerUnpublishVolumeRequest) error {
 	return common.ValidateControllerUnpublishVolumeRequest(req)
 }
+
+// checkVCenterFeatureSupported is the helper function to check if the feature
+// is supported by the connected vCenter. Function returns Unimplemented error
+// mentioning the required vCenter version if not supported, otherwise returns nil.
+func (c *controller) checkVCenterFeatureSupported(ctx context.Context, feature common.VCenterFeature) error {
+	vc, err := common.GetVCenter(ctx, c.manager)
+	if err != nil {
+		klog.Errorf("Failed to get vcenter. err=%v", err)
+		return status.Errorf(codes.Internal, "failed to get vcenter: %v", err)
+	}
+	return common.CheckVCenterFeatureSupported(vc.Client.ServiceContent.About.ApiVersion, feature)
+}
+
+// getZonePreferredDatastores is the helper function to narrow down shared datastores
+// to the datastores configured as preferred for a zone in the topology requirement.
+// Zones of preferred topologies are considered before zones of requisite topologies, and
+// the first zone with a preferred datastore accessible in that zone is picked.
+// Function returns the preferred datastores along with the picked zone, or all shared
+// datastores and an empty zone if no preferred datastore is found.
+func getZonePreferredDatastores(cfg *config.Config, topologyRequirement *csi.TopologyRequirement,
+	sharedDatastores []*cnsvsphere.DatastoreInfo, datastoreTopologyMap map[string][]map[string]string) ([]*cnsvsphere.DatastoreInfo, string) {
+	if len(cfg.ZoneDatastores) == 0 || topologyRequirement == nil {
+		return sharedDatastores, ""
+	}
+	var topologies []*csi.Topology
+	topologies = append(topologies, topologyRequirement.GetPreferred()...)
+	topologies = append(topologies, topologyRequirement.GetRequisite()...)
+	for _, topology := range topologies {
+		zone := topology.GetSegments()[csitypes.GetTopologyKeys(cfg).Zone]
+		var preferredDatastores []*cnsvsphere.DatastoreInfo
+		for _, datastoreURL := range cfg.GetZoneDatastoreURLs(zone) {
+			for _, datastore := range sharedDatastores {
+				if datastore.Info.Url == datastoreURL && isDatastoreAccessibleInZone(datastoreTopologyMap[datastoreURL], csitypes.GetTopologyKeys(cfg).Zone, zone) {
+					preferredDatastores = append(preferredDatastores, datastore)
+					break
+				}
+			}
+		}
+		if len(preferredDatastores) > 0 {
+			klog.V(4).Infof("Using datastores %+v preferred for zone %q", preferredDatastores, zone)
+			return preferredDatastores, zone
+		}
+	}
+	return sharedDatastores, ""
+}
+
+// isDatastoreAccessibleInZone returns true if any of the accessible topologies of a datastore is in the zone
+func isDatastoreAccessibleInZone(accessibleTopologies []map[string]string, zoneKey string, zone string) bool {
+	for _, accessibleTopology := range accessibleTopologies {
+		if accessibleTopology[zoneKey] == zone {
+			return true
+		}
+	}
+	return false
+}
+
+// filterDatastoresWithFreeSpace returns the datastores which keep the free space
+// configured in [Global] after a volume of the given size is created on them, so
+// datastores are not filled up to the point VM swap files and snapshots fail.
+func filterDatastoresWithFreeSpace(ctx context.Context, cfg *config.Config, datastores []*cnsvsphere.DatastoreInfo,
+	volSizeMB int64) ([]*cnsvsphere.DatastoreInfo, error) {
+	if cfg.Global.DatastoreMinFreeSpacePercent <= 0 && cfg.Global.DatastoreMinFreeSpaceMB <= 0 {
+		return datastores, nil
+	}
+	summaries, err := cnsvsphere.GetDatastoreSummaries(ctx, datastores)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []*cnsvsphere.DatastoreInfo
+	for _, datastore := range datastores {
+		summary, found := summaries[datastore.Info.Url]
+		if !found {
+			continue
+		}
+		minFreeSpace := cfg.Global.DatastoreMinFreeSpaceMB * common.MbInBytes
+		if percent := summary.Capacity * int64(cfg.Global.DatastoreMinFreeSpacePercent) / 100; percent > minFreeSpace {
+			minFreeSpace = percent
+		}
+		freeSpaceAfter := summary.FreeSpace - volSizeMB*common.MbInBytes
+		if freeSpaceAfter < minFreeSpace {
+			klog.V(2).Infof("Datastore %q is excluded from placement: %d of its %d bytes would be free after creating the volume, "+
+				"below the minimum of %d", datastore.Info.Url, freeSpaceAfter, summary.Capacity, minFreeSpace)
+			continue
+		}
+		filtered = append(filtered, datastore)
+	}
+	return filtered, nil
+}
+
+// isDatastoreInList returns true if the datastore with the given URL is in the list
+func isDatastoreInList(datastores []*cnsvsphere.DatastoreInfo, datastoreURL string) bool {
+	for _, datastore := range datastores {
+		if datastore.Info.Url == datastoreURL {
+			return true
+		}
+	}
+	return false
+}
+
+// getVolumeAccessibleTopology returns the accessible topology of a volume from the
+// accessible topologies of its datastore, without duplicates. The topology of the
+// preferred zone, if any, is listed first.
+func getVolumeAccessibleTopology(datastoreAccessibleTopology []map[string]string, zoneKey string, preferredZone string) []*csi.Topology {
+	var preferred, others []*csi.Topology
+	seen := make(map[string]bool)
+	for _, accessibleTopology := range datastoreAccessibleTopology {
+		if len(accessibleTopology) == 0 {
+			continue
+		}
+		key := fmt.Sprint(accessibleTopology)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		topology := &csi.Topology{Segments: accessibleTopology}
+		if preferredZone != "" && accessibleTopology[zoneKey] == preferredZone {
+			preferred = append(preferred, topology)
+		} else {
+			others = append(others, topology)
+		}
+	}
+	return append(preferred, others...)
+}
+
+// incrementDetachFailures increments and returns the count of consecutive
+// failed detaches of the volume from the node.
+func (c *controller) incrementDetachFailures(volumeID string, nodeName string) int {
+	c.detachFailuresLock.Lock()
+	defer c.detachFailuresLock.Unlock()
+	if c.detachFailures == nil {
+		c.detachFailures = make(map[string]map[string]int)
+	}
+	if c.detachFailures[volumeID] == nil {
+		c.detachFailures[volumeID] = make(map[string]int)
+	}
+	c.detachFailures[volumeID][nodeName]++
+	return c.detachFailures[volumeID][nodeName]
+}
+
+// pruneDetachFailures clears the counts of failed detaches of the volume
+// from all nodes. It is called once the volume is detached or deleted, so
+// counts of nodes the volume is no longer attached to are not kept around.
+func (c *controller) pruneDetachFailures(volumeID string) {
+	c.detachFailuresLock.Lock()
+	defer c.detachFailuresLock.Unlock()
+	delete(c.detachFailures, volumeID)
+}
+
+// nodeDisks are the disks of a node VM.
+type nodeDisks struct {
+	// count is the number of disks, including the boot disk
+	count int
+	// volumes are the volume IDs of the attached volumes
+	volumes map[string]bool
+}
+
+// checkDiskCapacity returns a ResourceExhausted error when the node VM has
+// as many disks as configured in max-disks-per-node, so the volume can't be
+// attached to it. Volumes already attached to the node pass, their publish
+// is only repeated. The disks tracked for the node are used while they are
+// below the limit, the disks are listed on the VM when they aren't known or
+// reach the limit, as they drift e.g. when disks are attached outside of
+// kubernetes. The disks are counted in the given devices of the VM, if any,
+// rather than fetched from the VM.
+func (c *controller) checkDiskCapacity(ctx context.Context, nodeName string, node *cnsvsphere.VirtualMachine, volumeID string,
+	devices object.VirtualDeviceList) error {
+	maxDisks := c.manager.CnsConfig.Global.MaxDisksPerNode
+	if maxDisks <= 0 {
+		return nil
+	}
+	c.diskCountsLock.Lock()
+	disks, known := c.diskCounts[nodeName]
+	tracked := known && (disks.volumes[volumeID] || disks.count < maxDisks)
+	c.diskCountsLock.Unlock()
+	if tracked {
+		return nil
+	}
+	var count int
+	var volumeIDs []string
+	if devices != nil {
+		count, volumeIDs = cnsvsphere.GetDisksOfDevices(devices)
+	} else {
+		var err error
+		count, volumeIDs, err = node.GetDisks(ctx)
+		if err != nil {
+			// Let the attach go ahead, it fails if the VM is full
+			klog.Warningf("Failed to list the disks of node: %q. err=%v", nodeName, err)
+			return nil
+		}
+	}
+	disks = &nodeDisks{count: count, volumes: make(map[string]bool)}
+	for _, id := range volumeIDs {
+		disks.volumes[id] = true
+	}
+	c.diskCountsLock.Lock()
+	if c.diskCounts == nil {
+		c.diskCounts = make(map[string]*nodeDisks)
+	}
+	c.diskCounts[nodeName] = disks
+	c.diskCountsLock.Unlock()
+	if disks.volumes[volumeID] || count < maxDisks {
+		return nil
+	}
+	msg := fmt.Sprintf("Failed to attach volume: %q to node: %q. The node VM has %d disks, the maximum is %d",
+		volumeID, nodeName, count, maxDisks)
+	klog.Error(msg)
+	return status.Errorf(codes.ResourceExhausted, msg)
+}
+
+// recordDiskAttached counts the volume in the disks tracked for the node, if
+// known and the volume wasn't attached yet.
+func (c *controller) recordDiskAttached(nodeName string, volumeID string) {
+	c.diskCountsLock.Lock()
+	defer c.diskCountsLock.Unlock()
+	disks, known := c.diskCounts[nodeName]
+	if !known || disks.volumes[volumeID] {
+		return
+	}
+	disks.volumes[volumeID] = true
+	disks.count++
+}
+
+// recordDiskDetached removes the volume from the disks tracked for the node,
+// if known and the volume was attached.
+func (c *controller) recordDiskDetached(nodeName string, volumeID string) {
+	c.diskCountsLock.Lock()
+	defer c.diskCountsLock.Unlock()
+	disks, known := c.diskCounts[nodeName]
+	if !known || !disks.volumes[volumeID] {
+		return
+	}
+	delete(disks.volumes, volumeID)
+	disks.count--
+}
+
+// forgetDiskCount drops the disk count tracked for the node, the disks are
+// counted on the VM again on the next attach.
+func (c *controller) forgetDiskCount(nodeName string) {
+	c.diskCountsLock.Lock()
+	defer c.diskCountsLock.Unlock()
+	delete(c.diskCounts, nodeName)
+}
+
+// getNamespaceToTag returns the namespace of the PVC the volume is created
+// for, if tags are configured in [Namespace], so its tags can be attached to
+// the volume. An error is returned if the namespace can't be found, e.g.
+// while the PVC isn't in the informer cache yet, as the volume must not be
+// created without the tags of its namespace.
+func (c *controller) getNamespaceToTag(req *csi.CreateVolumeRequest) (string, error) {
+	if len(c.manager.CnsConfig.Namespace) == 0 {
+		return "", nil
+	}
+	return c.getPVCNamespace(req)
+}
+
+// tagNamespaceVolume attaches the tags configured in [Namespace] for the
+// given namespace to the disk of the volume.
+func (c *controller) tagNamespaceVolume(ctx context.Context, namespace string, volumeID string) error {
+	if namespace == "" {
+		return nil
+	}
+	tags := c.manager.CnsConfig.GetNamespaceTags(namespace)
+	if len(tags) == 0 {
+		return nil
+	}
+	vc, err := common.GetVCenter(ctx, c.manager)
+	if err != nil {
+		klog.Errorf("Failed to get vCenter. Error: %v", err)
+		return err
+	}
+	for _, tag := range tags {
+		if err := cnsvolume.TagVolume(ctx, vc, volumeID, tag.Category, tag.Name); err != nil {
+			return err
+		}
+	}
+	klog.V(2).Infof("Attached tags %+v of namespace %q to volume %q", tags, namespace, volumeID)
+	return nil
+}
+
+// getPVCNamespace returns the namespace of the PVC a volume is created for.
+// It is passed by external-provisioner when run with --extra-create-metadata,
+// otherwise it is taken from the PVC whose UID is in the volume name, which
+// may not be in the informer cache yet.
+func (c *controller) getPVCNamespace(req *csi.CreateVolumeRequest) (string, error) {
+	for paramName, value := range req.Parameters {
+		if strings.ToLower(paramName) == common.AttributePVCNamespace && value != "" {
+			return value, nil
+		}
+	}
+	if c.informMgr == nil {
+		return "", fmt.Errorf("no PVC informer to find the PVC of volume %q", req.Name)
+	}
+	if !strings.HasPrefix(req.Name, "pvc-") {
+		return "", fmt.Errorf("volume name %q has no PVC UID to find the PVC namespace, which is required while a [Namespace] "+
+			"section is configured, run external-provisioner with --extra-create-metadata", req.Name)
+	}
+	pvc, err := c.informMgr.GetPVCByUID(strings.TrimPrefix(req.Name, "pvc-"))
+	if err != nil {
+		return "", err
+	}
+	if pvc == nil {
+		return "", fmt.Errorf("couldn't find the PVC of volume %q in the informer cache", req.Name)
+	}
+	return pvc.Namespace, nil
+}
+
+// getPVByVolumeHandle returns the PV of the volume with the given ID, or nil
+// if there is none. external-provisioner deletes the PV only after the volume
+// is deleted, so the PV of a volume being deleted is still found.
+func (c *controller) getPVByVolumeHandle(volumeID string) (*v1.PersistentVolume, error) {
+	if c.informMgr == nil {
+		return nil, nil
+	}
+	pvs, err := c.informMgr.GetPVsByVolumeHandle(volumeID)
+	if err != nil {
+		klog.Errorf("Failed to find the PV of volume %q. err=%v", volumeID, err)
+		return nil, err
+	}
+	for _, pv := range pvs {
+		if pv.Spec.CSI.Driver == csitypes.DriverName {
+			return pv, nil
+		}
+	}
+	return nil, nil
+}
+
+// getPVsByVolumeHandle returns the PVs of the volumes of the driver, by
+// volume ID. No PVs are returned if the informers are not set up.
+func (c *controller) getPVsByVolumeHandle() (map[string]*v1.PersistentVolume, error) {
+	pvsByVolumeHandle := make(map[string]*v1.PersistentVolume)
+	if c.informMgr == nil {
+		return pvsByVolumeHandle, nil
+	}
+	pvs, err := c.informMgr.GetPVLister().List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list PVs. err=%v", err)
+		return nil, err
+	}
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == csitypes.DriverName {
+			pvsByVolumeHandle[pv.Spec.CSI.VolumeHandle] = pv
+		}
+	}
+	return pvsByVolumeHandle, nil
+}
+
+// recordNodeEvent emits an event on the kubernetes node with the given name.
+func (c *controller) recordNodeEvent(nodeName string, eventType string, reason string, message string) {
+	if c.eventRecorder == nil {
+		return
+	}
+	nodeRef := &v1.ObjectReference{
+		Kind: "Node",
+		Name: nodeName,
+		UID:  types.UID(nodeName),
+	}
+	c.eventRecorder.Event(nodeRef, eventType, reason, message)
+}
+
+// deleteVolume deletes the volume, keeping the disk if requested in the
+// DeleteVolume secrets or the annotation of the PV, and soft deleting it if
+// soft deletion is enabled
+func (c *controller) deleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) error {
+	if common.IsRetainDiskSet(req.GetSecrets()[common.SecretRetainDisk]) {
+		klog.V(2).Infof("DeleteVolume: keeping disk of volume %q as requested by secret %q", req.VolumeId, common.SecretRetainDisk)
+		return common.DeleteVolumeUtil(ctx, c.manager, req.VolumeId, false)
+	}
+	pv, err := c.getPVByVolumeHandle(req.VolumeId)
+	if err != nil {
+		return err
+	}
+	if pv != nil && common.IsRetainDiskSet(pv.Annotations[common.AnnRetainDisk]) {
+		klog.V(2).Infof("DeleteVolume: keeping disk of volume %q as requested by annotation %q on PV %q",
+			req.VolumeId, common.AnnRetainDisk, pv.Name)
+		return common.DeleteVolumeUtil(ctx, c.manager, req.VolumeId, false)
+	}
+	if c.softDelete != nil {
+		return c.softDelete.softDeleteVolume(ctx, req.VolumeId)
+	}
+	return common.DeleteVolumeUtil(ctx, c.manager, req.VolumeId, true)
+}
+
+// handleDeleteAttachedVolume is called when a volume can not be deleted as it
+// is in use. It returns the name of the node the volume is attached to, if
+// any. If the attachment is orphaned, i.e. kubernetes has no VolumeAttachment
+// for it, and detach-orphaned-before-delete is set, the volume is detached
+// and deleted again.
+func (c *controller) handleDeleteAttachedVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (string, error) {
+	nodeName, err := c.getVolumeAttachmentNode(req.VolumeId)
+	if err != nil {
+		return "", err
+	}
+	if nodeName != "" {
+		return nodeName, fmt.Errorf("volume %q is attached to node %q and in use by kubernetes: %w", req.VolumeId, nodeName, cnsvolume.ErrVolumeInUse)
+	}
+	nodeName, vm, err := c.findVolumeAttachedNode(ctx, req.VolumeId)
+	if err != nil {
+		return "", err
+	}
+	if nodeName == "" {
+		return "", fmt.Errorf("volume %q is in use but not attached to any node: %w", req.VolumeId, cnsvolume.ErrVolumeInUse)
+	}
+	if !c.manager.CnsConfig.Global.DetachOrphanedBeforeDelete {
+		return nodeName, fmt.Errorf("volume %q is attached to node %q: %w", req.VolumeId, nodeName, cnsvolume.ErrVolumeInUse)
+	}
+	klog.Infof("DeleteVolume: detaching orphaned attachment of volume %q from node %q", req.VolumeId, nodeName)
+	if err = common.DetachVolumeUtil(ctx, c.manager, vm, req.VolumeId); err != nil {
+		return nodeName, err
+	}
+	c.recordNodeEvent(nodeName, v1.EventTypeWarning, "DetachedOrphanedVolume",
+		fmt.Sprintf("Volume %s was detached from the node before deletion as kubernetes had no VolumeAttachment for it", req.VolumeId))
+	return "", c.deleteVolume(ctx, req)
+}
+
+// getVolumeAttachmentNode returns the name of the node kubernetes attached
+// the volume to, as recorded in its VolumeAttachment. An empty node name is
+// returned if kubernetes has no VolumeAttachment of the volume.
+func (c *controller) getVolumeAttachmentNode(volumeID string) (string, error) {
+	if c.informMgr == nil {
+		return "", nil
+	}
+	pv, err := c.getPVByVolumeHandle(volumeID)
+	if err != nil || pv == nil {
+		return "", err
+	}
+	attachments, err := c.informMgr.GetVolumeAttachmentLister().List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list VolumeAttachments. err=%v", err)
+		return "", err
+	}
+	for _, attachment := range attachments {
+		if attachment.Spec.Attacher == csitypes.DriverName && attachment.Spec.Source.PersistentVolumeName != nil &&
+			*attachment.Spec.Source.PersistentVolumeName == pv.Name {
+			return attachment.Spec.NodeName, nil
+		}
+	}
+	return "", nil
+}
+
+// findVolumeAttachedNode returns the name and VM of the node the volume is
+// attached to without kubernetes knowing, e.g. after a failed detach. Only
+// the node VMs with files on the datastore of the volume, as reported by CNS,
+// are checked. An empty node name is returned if the volume is not attached
+// to any node.
+func (c *controller) findVolumeAttachedNode(ctx context.Context, volumeID string) (string, *cnsvsphere.VirtualMachine, error) {
+	queryResult, err := c.manager.VolumeManager.QueryVolume(ctx, cnstypes.CnsQueryFilter{
+		VolumeIds: []cnstypes.CnsVolumeId{{Id: volumeID}},
+	})
+	if err != nil {
+		klog.Errorf("Failed to query volume %q. err=%v", volumeID, err)
+		return "", nil, err
+	}
+	if len(queryResult.Volumes) == 0 {
+		return "", nil, nil
+	}
+	datastoreURL := queryResult.Volumes[0].DatastoreUrl
+	vc, err := common.GetVCenter(ctx, c.manager)
+	if err != nil {
+		return "", nil, err
+	}
+	datacenters, err := vc.GetDatacenters(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	datastoreVMs := make(map[string]bool)
+	for _, dc := range datacenters {
+		ds, err := dc.GetDatastoreByURL(ctx, datastoreURL)
+		if err != nil {
+			if errors.Is(err, cnsvsphere.ErrDatastoreNotFound) {
+				continue
+			}
+			return "", nil, err
+		}
+		vmRefs, err := ds.GetVirtualMachineRefs(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, vmRef := range vmRefs {
+			datastoreVMs[vmRef.Value] = true
+		}
+		break
+	}
+	for _, nodeName := range c.nodeMgr.GetAllNodeNames() {
+		vm, err := c.nodeMgr.GetNodeByName(nodeName)
+		if err != nil {
+			klog.Warningf("Failed to get VM for node %q. err=%v", nodeName, err)
+			continue
+		}
+		if !datastoreVMs[vm.Reference().Value] {
+			continue
+		}
+		diskUUID, err := cnsvolume.GetDiskAttachedToVM(ctx, vm, volumeID)
+		if err != nil {
+			klog.Warningf("Failed to check if volume %q is attached to node %q. err=%v", volumeID, nodeName, err)
+			continue
+		}
+		if diskUUID != "" {
+			return nodeName, vm, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// isChangeBlockTrackingRequested returns true if change block tracking is
+// enabled for the volume, either in the Storage Class, which is reflected in
+// the volume context, or by the AnnChangeBlockTracking annotation on its PVC.
+// The PVC is only looked up if the Storage Class opts in to the annotation,
+// so publishing the volumes of other classes doesn't reach the API server.
+func (c *controller) isChangeBlockTrackingRequested(req *csi.ControllerPublishVolumeRequest) (bool, error) {
+	value := strings.ToLower(req.VolumeContext[common.AttributeChangeBlockTracking])
+	if value != common.ChangeBlockTrackingPerClaim {
+		enabled, _ := strconv.ParseBool(value)
+		return enabled, nil
+	}
+	if c.k8sclient == nil {
+		return false, nil
+	}
+	pv, err := c.getPVByVolumeHandle(req.VolumeId)
+	if err != nil {
+		return false, err
+	}
+	if pv == nil || pv.Spec.ClaimRef == nil {
+		klog.V(4).Infof("No PVC found for volume %q", req.VolumeId)
+		return false, nil
+	}
+	pvc, err := c.k8sclient.CoreV1().PersistentVolumeClaims(pv.Spec.ClaimRef.Namespace).Get(pv.Spec.ClaimRef.Name, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("Failed to get PVC %s/%s. err=%v", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name, err)
+		return false, err
+	}
+	enabled, _ := strconv.ParseBool(pvc.Annotations[common.AnnChangeBlockTracking])
+	return enabled, nil
+}
+
+// queryVolume returns the CNS volume with the given ID, or nil if CNS doesn't know the volume
+func (c *controller) queryVolume(ctx context.Context, volumeID string) (*cnstypes.CnsVolume, error) {
+	queryResult, err := c.manager.VolumeManager.QueryVolume(ctx, cnstypes.CnsQueryFilter{
+		VolumeIds: []cnstypes.CnsVolumeId{{Id: volumeID}},
+	})
+	if err != nil {
+		klog.Errorf("Failed to query volume %q. err=%v", volumeID, err)
+		return nil, err
+	}
+	if len(queryResult.Volumes) == 0 {
+		return nil, nil
+	}
+	return &queryResult.Volumes[0], nil
+}
+
+// enableVolumeChangeTracking enables change block tracking on the disk of
+// the given CNS volume, after checking that the node VM can track changed blocks.
+func (c *controller) enableVolumeChangeTracking(ctx context.Context, volumeID string, volume *cnstypes.CnsVolume, node *cnsvsphere.VirtualMachine) error {
+	if err := node.ValidateChangeTrackingSupported(ctx); err != nil {
+		return err
+	}
+	if volume == nil {
+		return fmt.Errorf("volume %q not found", volumeID)
+	}
+	vc, err := common.GetVCenter(ctx, c.manager)
+	if err != nil {
+		return err
+	}
+	return cnsvolume.EnableVolumeChangeTracking(ctx, vc, volumeID, volume.DatastoreUrl)
+}
+
+// maxAccessibleNodesReported bounds the number of nodes named in the error
+// returned when a volume is not accessible from the node it is published to
+const maxAccessibleNodesReported = 10
+
+// maxNodesScanned bounds the number of nodes whose datastores are looked up
+// to find where a volume not accessible from its node could be attached, as
+// each node costs round trips to vCenter and the attach is retried
+const maxNodesScanned = 20
+
+// checkVolumeAccessibleFromNode returns an error naming the zones and nodes
+// the volume is accessible from, if the datastore of the volume is not
+// mounted on the host of the node VM. Attaching the volume would otherwise
+// fail only once the attach times out. Failing to find the datastore of the
+// volume or of the node is not an error, the attach is attempted anyway.
+// Only up to maxNodesScanned nodes are checked, their zones are taken from
+// the topology labels of the kubernetes nodes.
+func (c *controller) checkVolumeAccessibleFromNode(ctx context.Context, volume *cnstypes.CnsVolume, nodeName string, node *cnsvsphere.VirtualMachine) error {
+	if volume == nil || volume.DatastoreUrl == "" {
+		klog.V(4).Infof("Datastore of the volume is unknown, skipping accessibility check")
+		return nil
+	}
+	datastoreURL := volume.DatastoreUrl
+	accessible, err := isDatastoreAccessibleFromVM(ctx, node, datastoreURL)
+	if err != nil {
+		klog.V(4).Infof("Failed to get datastores accessible from node %s, skipping accessibility check. err=%v", nodeName, err)
+		return nil
+	}
+	if accessible {
+		return nil
+	}
+
+	// Find where the volume could be attached, to point at the misconfigured affinity
+	var accessibleNodes []string
+	zones := make(map[string]bool)
+	zoneKey := csitypes.GetTopologyKeys(c.manager.CnsConfig).Zone
+	scanned, truncated := 0, false
+	for _, name := range c.nodeMgr.GetAllNodeNames() {
+		if name == nodeName {
+			continue
+		}
+		if scanned == maxNodesScanned || len(accessibleNodes) == maxAccessibleNodesReported {
+			truncated = true
+			break
+		}
+		scanned++
+		vm, err := c.nodeMgr.GetNodeByName(name)
+		if err != nil {
+			continue
+		}
+		if accessible, err := isDatastoreAccessibleFromVM(ctx, vm, datastoreURL); err != nil || !accessible {
+			continue
+		}
+		accessibleNodes = append(accessibleNodes, name)
+		if c.informMgr == nil {
+			continue
+		}
+		if k8sNode, err := c.informMgr.GetNodeLister().Get(name); err == nil && k8sNode.Labels[zoneKey] != "" {
+			zones[k8sNode.Labels[zoneKey]] = true
+		}
+	}
+	msg := fmt.Sprintf("datastore %s of the volume is not accessible from node %s", datastoreURL, nodeName)
+	if len(zones) > 0 {
+		var zoneNames []string
+		for zone := range zones {
+			zoneNames = append(zoneNames, zone)
+		}
+		sort.Strings(zoneNames)
+		msg += fmt.Sprintf(", the volume is accessible from zones %v", zoneNames)
+	}
+	if len(accessibleNodes) > 0 {
+		msg += fmt.Sprintf(", the volume is accessible from nodes %v", accessibleNodes)
+	} else if truncated {
+		msg += fmt.Sprintf(", the volume is not accessible from any of %d other nodes checked", scanned)
+	} else {
+		msg += ", the volume is not accessible from any node"
+	}
+	return errors.New(msg)
+}
+
+// isDatastoreAccessibleFromVM returns whether the datastore with the given URL is mounted on the host of the VM
+func isDatastoreAccessibleFromVM(ctx context.Context, vm *cnsvsphere.VirtualMachine, datastoreURL string) (bool, error) {
+	datastores, err := vm.GetAllAccessibleDatastores(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, datastore := range datastores {
+		if datastore.Info.Url == datastoreURL {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setEffectiveStoragePolicy records the ID and the name of the storage policy
+// applied to a volume in its attributes. Failing to resolve the name of the
+// policy is not an error, only the ID is recorded.
+func (c *controller) setEffectiveStoragePolicy(ctx context.Context, attributes map[string]string, storagePolicyID string) {
+	if storagePolicyID == "" {
+		return
+	}
+	attributes[common.AttributeEffectiveStoragePolicyID] = storagePolicyID
+	vc, err := common.GetVCenter(ctx, c.manager)
+	if err != nil {
+		klog.Warningf("Failed to get vCenter to resolve the name of storage policy %s. err=%v", storagePolicyID, err)
+		return
+	}
+	if err := vc.ConnectPbm(ctx); err != nil {
+		klog.Warningf("Failed to connect to PBM to resolve the name of storage policy %s. err=%v", storagePolicyID, err)
+		return
+	}
+	storagePolicyName, err := vc.GetStoragePolicyNameByID(ctx, storagePolicyID)
+	if err != nil {
+		klog.Warningf("Failed to resolve the name of storage policy %s. err=%v", storagePolicyID, err)
+		return
+	}
+	attributes[common.AttributeEffectiveStoragePolicyName] = storagePolicyName
+}
+
+// setVmdkPath records the path of the VMDK backing a volume in its attributes.
+// Failing to find the path is not an error, the attribute is left unset.
+func (c *controller) setVmdkPath(ctx context.Context, attributes map[string]string, volumeID string, datastoreURL string) {
+	vc, err := common.GetVCenter(ctx, c.manager)
+	if err != nil {
+		klog.Warningf("Failed to get vCenter to find the VMDK path of volume %s. err=%v", volumeID, err)
+		return
+	}
+	vmdkPath, err := cnsvolume.GetVolumeBackingPath(ctx, vc, volumeID, datastoreURL)
+	if err != nil {
+		klog.Warningf("Failed to find the VMDK path of volume %s. err=%v", volumeID, err)
+		return
+	}
+	attributes[common.AttributeVmdkPath] = vmdkPath
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
+)
+
+func TestPruneDetachFailures(t *testing.T) {
+	c := &controller{}
+	for i := 1; i <= 2; i++ {
+		if failures := c.incrementDetachFailures("vol-1", "node-1"); failures != i {
+			t.Fatalf("incrementDetachFailures returned %d, expected %d", failures, i)
+		}
+	}
+	c.incrementDetachFailures("vol-1", "node-2")
+	c.incrementDetachFailures("vol-2", "node-1")
+
+	c.pruneDetachFailures("vol-1")
+	if _, ok := c.detachFailures["vol-1"]; ok {
+		t.Errorf("detach failures of vol-1 were not pruned: %v", c.detachFailures)
+	}
+	if failures := c.detachFailures["vol-2"]["node-1"]; failures != 1 {
+		t.Errorf("detach failures of vol-2 on node-1 are %d, expected 1", failures)
+	}
+	if failures := c.incrementDetachFailures("vol-1", "node-1"); failures != 1 {
+		t.Errorf("incrementDetachFailures returned %d after pruning, expected 1", failures)
+	}
+	// Pruning a volume without failures is a no-op
+	c.pruneDetachFailures("vol-3")
+	if len(c.detachFailures) != 2 {
+		t.Errorf("expected detach failures of 2 volumes, got %v", c.detachFailures)
+	}
+}
+
+func newCSIPV(name string, driver string, volumeID string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: volumeID},
+			},
+		},
+	}
+}
+
+func TestGetPVByVolumeHandle(t *testing.T) {
+	c := &controller{}
+	if pv, err := c.getPVByVolumeHandle("vol-1"); pv != nil || err != nil {
+		t.Fatalf("Expected no PV without informers, got %v, %v", pv, err)
+	}
+	k8sclient := testclient.NewSimpleClientset(
+		newCSIPV("pv-other-driver", "other.csi.example.com", "vol-1"),
+		newCSIPV("pv-1", csitypes.DriverName, "vol-1"),
+		&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-in-tree"}},
+	)
+	c.informMgr = k8s.NewInformer(k8sclient)
+	defer c.informMgr.Release()
+	if err := c.informMgr.IndexPVsByVolumeHandle(); err != nil {
+		t.Fatal(err)
+	}
+	c.informMgr.Listen()
+	if !c.informMgr.WaitForCacheSync() {
+		t.Fatalf("Failed to sync the PV informer")
+	}
+	pv, err := c.getPVByVolumeHandle("vol-1")
+	if err != nil || pv == nil || pv.Name != "pv-1" {
+		t.Errorf("Expected pv-1, got %v, %v", pv, err)
+	}
+	if pv, err = c.getPVByVolumeHandle("vol-2"); pv != nil || err != nil {
+		t.Errorf("Expected no PV for vol-2, got %v, %v", pv, err)
+	}
+	pvs, err := c.getPVsByVolumeHandle()
+	if err != nil || len(pvs) != 1 || pvs["vol-1"] == nil || pvs["vol-1"].Name != "pv-1" {
+		t.Errorf("Expected only pv-1 by volume handle, got %v, %v", pvs, err)
+	}
+}
+
+func newVolumeAttachment(name string, attacher string, pvName string, nodeName string) *storagev1.VolumeAttachment {
+	return &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: attacher,
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &pvName},
+			NodeName: nodeName,
+		},
+	}
+}
+
+func TestGetVolumeAttachmentNode(t *testing.T) {
+	k8sclient := testclient.NewSimpleClientset(
+		newCSIPV("pv-1", csitypes.DriverName, "vol-1"),
+		newCSIPV("pv-2", csitypes.DriverName, "vol-2"),
+		newVolumeAttachment("va-other-driver", "other.csi.example.com", "pv-1", "node-2"),
+		newVolumeAttachment("va-1", csitypes.DriverName, "pv-1", "node-1"),
+	)
+	c := &controller{informMgr: k8s.NewInformer(k8sclient)}
+	defer c.informMgr.Release()
+	c.informMgr.GetPVLister()
+	c.informMgr.GetVolumeAttachmentLister()
+	c.informMgr.Listen()
+	if !c.informMgr.WaitForCacheSync() {
+		t.Fatalf("Failed to sync the PV and VolumeAttachment informers")
+	}
+	tests := map[string]string{
+		"vol-1": "node-1",
+		// Volumes without VolumeAttachment or PV
+		"vol-2": "",
+		"vol-3": "",
+	}
+	for volumeID, expected := range tests {
+		nodeName, err := c.getVolumeAttachmentNode(volumeID)
+		if err != nil || nodeName != expected {
+			t.Errorf("getVolumeAttachmentNode(%q) returned %q, %v, expected %q", volumeID, nodeName, err, expected)
+		}
+	}
+}
+
+func TestIsChangeBlockTrackingRequested(t *testing.T) {
+	claimedPV := func(name string, volumeID string, pvcName string) *v1.PersistentVolume {
+		pv := newCSIPV(name, csitypes.DriverName, volumeID)
+		pv.Spec.ClaimRef = &v1.ObjectReference{Namespace: "default", Name: pvcName}
+		return pv
+	}
+	k8sclient := testclient.NewSimpleClientset(
+		claimedPV("pv-1", "vol-1", "pvc-1"),
+		claimedPV("pv-2", "vol-2", "pvc-2"),
+		&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "pvc-1",
+			Annotations: map[string]string{common.AnnChangeBlockTracking: "true"},
+		}},
+		&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pvc-2"}},
+	)
+	c := &controller{k8sclient: k8sclient, informMgr: k8s.NewInformer(k8sclient)}
+	defer c.informMgr.Release()
+	c.informMgr.GetPVLister()
+	c.informMgr.Listen()
+	if !c.informMgr.WaitForCacheSync() {
+		t.Fatalf("Failed to sync the PV informer")
+	}
+	tests := []struct {
+		volumeID  string
+		attribute string
+		expected  bool
+		lookup    bool
+	}{
+		{"vol-1", "true", true, false},
+		{"vol-1", "", false, false},
+		{"vol-1", "false", false, false},
+		{"vol-1", common.ChangeBlockTrackingPerClaim, true, true},
+		{"vol-2", common.ChangeBlockTrackingPerClaim, false, true},
+		// Volumes without PV
+		{"vol-3", common.ChangeBlockTrackingPerClaim, false, false},
+	}
+	for _, test := range tests {
+		k8sclient.ClearActions()
+		req := &csi.ControllerPublishVolumeRequest{
+			VolumeId:      test.volumeID,
+			VolumeContext: map[string]string{common.AttributeChangeBlockTracking: test.attribute},
+		}
+		enabled, err := c.isChangeBlockTrackingRequested(req)
+		if err != nil || enabled != test.expected {
+			t.Errorf("isChangeBlockTrackingRequested(%q, %q) returned %t, %v, expected %t", test.volumeID, test.attribute, enabled, err, test.expected)
+		}
+		if lookup := len(k8sclient.Actions()) > 0; lookup != test.lookup {
+			t.Errorf("isChangeBlockTrackingRequested(%q, %q) looked up the PVC: %t, expected %t", test.volumeID, test.attribute, lookup, test.lookup)
+		}
+	}
+}
+
+func TestRecordDiskAttachedOnce(t *testing.T) {
+	c := &controller{diskCounts: map[string]*nodeDisks{
+		"node-1": {count: 2, volumes: map[string]bool{"vol-1": true}},
+	}}
+	// Publishing an attached volume again doesn't count it twice
+	c.recordDiskAttached("node-1", "vol-1")
+	c.recordDiskAttached("node-1", "vol-2")
+	c.recordDiskAttached("node-1", "vol-2")
+	if count := c.diskCounts["node-1"].count; count != 3 {
+		t.Errorf("expected 3 disks after attaching vol-2, got %d", count)
+	}
+	// Unpublishing a detached volume again doesn't uncount it twice
+	c.recordDiskDetached("node-1", "vol-1")
+	c.recordDiskDetached("node-1", "vol-1")
+	c.recordDiskDetached("node-1", "vol-3")
+	if count := c.diskCounts["node-1"].count; count != 2 {
+		t.Errorf("expected 2 disks after detaching vol-1, got %d", count)
+	}
+	// Nodes whose disks are not known yet are listed on the VM on next attach
+	c.recordDiskAttached("node-2", "vol-1")
+	if _, known := c.diskCounts["node-2"]; known {
+		t.Errorf("expected the disks of node-2 to stay unknown")
+	}
+}
@@ -41,6 +41,7 @@ import (
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
 	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
 )
 
@@ -130,7 +131,12 @@ func (f *FakeNodeManager) GetNodeByName(nodeName string) (*cnsvsphere.VirtualMac
 	return vm, nil
 }
 
-func (f *FakeNodeManager) GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, zoneKey string, regionKey string) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error) {
+func (f *FakeNodeManager) GetAllNodeNames() []string {
+	return nil
+}
+
+func (f *FakeNodeManager) GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, zoneCategoryName string, regionCategoryName string,
+	topologyKeys csitypes.TopologyKeys) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error) {
 	return nil, nil, nil
 }
 
@@ -145,7 +151,7 @@ var (
 	onceForControllerTest  sync.Once
 )
 
-func getControllerTest(t *testing.T) *controllerTest {
+func getControllerTest(t testing.TB) *controllerTest {
 	onceForControllerTest.Do(func() {
 		config, _ := config.FromEnvOrSim()
 
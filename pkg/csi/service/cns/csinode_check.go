@@ -0,0 +1,230 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1beta1"
+	"k8s.io/klog"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+)
+
+const (
+	// csiNodeCheckInterval is the interval between two checks of the CSINodes
+	// of all the nodes. Nodes created less than an interval ago are not
+	// checked, their node plugin may still be registering.
+	csiNodeCheckInterval = 5 * time.Minute
+	// Reasons of the events emitted on the nodes when their CSINode drifts
+	// or is back to the expected registration
+	eventReasonCSINodeDrift    = "CSINodeRegistrationDrift"
+	eventReasonCSINodeRestored = "CSINodeRegistrationRestored"
+
+	// Drift reasons, also used as label of the drift metric
+	csiNodeDriftMissing       = "csinode_missing"
+	csiNodeDriftNotRegistered = "driver_not_registered"
+	csiNodeDriftNodeID        = "node_id_mismatch"
+	csiNodeDriftTopologyKeys  = "topology_keys_mismatch"
+)
+
+// csiNodeDriftReasons lists all the drift reasons, so that the metric is
+// reset to 0 for the reasons no node drifted for
+var csiNodeDriftReasons = []string{csiNodeDriftMissing, csiNodeDriftNotRegistered, csiNodeDriftNodeID, csiNodeDriftTopologyKeys}
+
+// csiNodeChecker verifies that every node has a CSINode on which the node
+// plugin of the driver is registered with the node name as node ID and the
+// topology keys of the configuration. Nodes drift from the expected
+// registration e.g. after a node image change leaves out the node plugin or
+// after the topology keys are changed, and volumes then can't be provisioned
+// or attached there. A warning event is emitted on the nodes that drift and
+// the number of such nodes is exposed as a metric.
+type csiNodeChecker struct {
+	nodeLister    corelisters.NodeLister
+	csiNodeLister storagelisters.CSINodeLister
+	controller    *controller
+	// lock guards drift
+	lock sync.Mutex
+	// drift maps the names of the nodes which drifted to the reason
+	drift map[string]string
+}
+
+// newCSINodeChecker returns a CSINode checker for the controller, using the
+// informers of its node manager
+func newCSINodeChecker(c *controller) *csiNodeChecker {
+	informMgr := c.informMgr
+	checker := &csiNodeChecker{
+		nodeLister:    informMgr.GetNodeLister(),
+		csiNodeLister: informMgr.GetCSINodeLister(),
+		controller:    c,
+		drift:         make(map[string]string),
+	}
+	informMgr.AddCSINodeListener(checker.csiNodeChanged, func(oldObj, newObj interface{}) {
+		checker.csiNodeChanged(newObj)
+	}, checker.csiNodeChanged)
+	informMgr.Listen()
+	return checker
+}
+
+// run checks the CSINodes of all the nodes periodically. It never returns.
+func (checker *csiNodeChecker) run() {
+	ticker := time.NewTicker(csiNodeCheckInterval)
+	for range ticker.C {
+		checker.checkAll()
+	}
+}
+
+// csiNodeChanged checks the node of a CSINode which was added, updated or deleted
+func (checker *csiNodeChecker) csiNodeChanged(obj interface{}) {
+	csiNode, ok := obj.(*storagev1beta1.CSINode)
+	if !ok || csiNode == nil {
+		return
+	}
+	node, err := checker.nodeLister.Get(csiNode.Name)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.Warningf("Failed to get node %q of CSINode. err=%v", csiNode.Name, err)
+		}
+		return
+	}
+	checker.lock.Lock()
+	defer checker.lock.Unlock()
+	checker.check(node)
+	checker.updateMetric()
+}
+
+// checkAll checks the CSINodes of all the nodes
+func (checker *csiNodeChecker) checkAll() {
+	nodes, err := checker.nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list nodes to check their CSINode. err=%v", err)
+		return
+	}
+	checker.lock.Lock()
+	defer checker.lock.Unlock()
+	existing := make(map[string]bool)
+	for _, node := range nodes {
+		existing[node.Name] = true
+		checker.check(node)
+	}
+	for nodeName := range checker.drift {
+		if !existing[nodeName] {
+			delete(checker.drift, nodeName)
+		}
+	}
+	checker.updateMetric()
+}
+
+// check checks the CSINode of a node and emits an event when its drift
+// changed. The lock must be held.
+func (checker *csiNodeChecker) check(node *v1.Node) {
+	if time.Since(node.CreationTimestamp.Time) < csiNodeCheckInterval {
+		return
+	}
+	csiNode, err := checker.csiNodeLister.Get(node.Name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.Warningf("Failed to get CSINode of node %q. err=%v", node.Name, err)
+		return
+	}
+	reason, message := getCSINodeDrift(node.Name, csiNode, checker.controller.manager.CnsConfig)
+	previous := checker.drift[node.Name]
+	if reason == previous {
+		return
+	}
+	if reason == "" {
+		delete(checker.drift, node.Name)
+		klog.Infof("CSINode registration of node %q is back to the expected one", node.Name)
+		checker.recordEvent(node, v1.EventTypeNormal, eventReasonCSINodeRestored,
+			fmt.Sprintf("CSINode registration of driver %s is back to the expected one", csitypes.DriverName))
+		return
+	}
+	checker.drift[node.Name] = reason
+	klog.Warningf("CSINode registration of node %q drifted: %s", node.Name, message)
+	checker.recordEvent(node, v1.EventTypeWarning, eventReasonCSINodeDrift, message)
+}
+
+// recordEvent emits an event on a node
+func (checker *csiNodeChecker) recordEvent(node *v1.Node, eventType string, reason string, message string) {
+	if checker.controller.eventRecorder == nil {
+		return
+	}
+	nodeRef := &v1.ObjectReference{
+		Kind: "Node",
+		Name: node.Name,
+		UID:  node.UID,
+	}
+	checker.controller.eventRecorder.Event(nodeRef, eventType, reason, message)
+}
+
+// updateMetric sets the drift metric from the drift of the nodes. The lock
+// must be held.
+func (checker *csiNodeChecker) updateMetric() {
+	counts := make(map[string]int)
+	for _, reason := range checker.drift {
+		counts[reason]++
+	}
+	for _, reason := range csiNodeDriftReasons {
+		prometheus.CSINodeDrift.WithLabelValues(reason).Set(float64(counts[reason]))
+	}
+}
+
+// getCSINodeDrift returns the reason and a description of the drift of the
+// CSINode of a node from the expected registration of the driver, or an
+// empty reason when there's no drift. csiNode is nil when the node has no
+// CSINode.
+func getCSINodeDrift(nodeName string, csiNode *storagev1beta1.CSINode, cfg *cnsconfig.Config) (string, string) {
+	if csiNode == nil {
+		return csiNodeDriftMissing, fmt.Sprintf("node %s has no CSINode, the node plugins are not registered with the kubelet", nodeName)
+	}
+	var driver *storagev1beta1.CSINodeDriver
+	for i := range csiNode.Spec.Drivers {
+		if csiNode.Spec.Drivers[i].Name == csitypes.DriverName {
+			driver = &csiNode.Spec.Drivers[i]
+			break
+		}
+	}
+	if driver == nil {
+		return csiNodeDriftNotRegistered, fmt.Sprintf("driver %s is not registered in the CSINode of node %s", csitypes.DriverName, nodeName)
+	}
+	if driver.NodeID != nodeName {
+		return csiNodeDriftNodeID, fmt.Sprintf("driver %s is registered with node ID %s instead of %s", csitypes.DriverName, driver.NodeID, nodeName)
+	}
+	var expectedKeys []string
+	if cfg != nil && cfg.Labels.Zone != "" && cfg.Labels.Region != "" {
+		topologyKeys := csitypes.GetTopologyKeys(cfg)
+		expectedKeys = []string{topologyKeys.Region, topologyKeys.Zone}
+	}
+	keys := append([]string(nil), driver.TopologyKeys...)
+	sort.Strings(expectedKeys)
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != strings.Join(expectedKeys, ",") {
+		return csiNodeDriftTopologyKeys, fmt.Sprintf("driver %s is registered with topology keys [%s] instead of [%s]",
+			csitypes.DriverName, strings.Join(keys, ", "), strings.Join(expectedKeys, ", "))
+	}
+	return "", ""
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"testing"
+
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+)
+
+func TestGetCSINodeDrift(t *testing.T) {
+	cfg := &cnsconfig.Config{}
+	cfg.Labels.Zone = "k8s-zone"
+	cfg.Labels.Region = "k8s-region"
+	csiNode := func(drivers ...storagev1beta1.CSINodeDriver) *storagev1beta1.CSINode {
+		return &storagev1beta1.CSINode{Spec: storagev1beta1.CSINodeSpec{Drivers: drivers}}
+	}
+	topologyKeys := []string{csitypes.LabelZoneFailureDomain, csitypes.LabelRegionFailureDomain}
+	tests := []struct {
+		name    string
+		csiNode *storagev1beta1.CSINode
+		cfg     *cnsconfig.Config
+		reason  string
+	}{
+		{"missing CSINode", nil, cfg, csiNodeDriftMissing},
+		{"other driver only", csiNode(storagev1beta1.CSINodeDriver{Name: "other", NodeID: "node1"}), cfg, csiNodeDriftNotRegistered},
+		{"wrong node ID", csiNode(storagev1beta1.CSINodeDriver{Name: csitypes.DriverName, NodeID: "node2", TopologyKeys: topologyKeys}), cfg, csiNodeDriftNodeID},
+		{"missing topology keys", csiNode(storagev1beta1.CSINodeDriver{Name: csitypes.DriverName, NodeID: "node1"}), cfg, csiNodeDriftTopologyKeys},
+		{"stale topology keys", csiNode(storagev1beta1.CSINodeDriver{Name: csitypes.DriverName, NodeID: "node1", TopologyKeys: topologyKeys}), &cnsconfig.Config{}, csiNodeDriftTopologyKeys},
+		{"expected registration", csiNode(storagev1beta1.CSINodeDriver{Name: csitypes.DriverName, NodeID: "node1", TopologyKeys: topologyKeys}), cfg, ""},
+		{"expected registration without topology", csiNode(storagev1beta1.CSINodeDriver{Name: csitypes.DriverName, NodeID: "node1"}), &cnsconfig.Config{}, ""},
+	}
+	for _, test := range tests {
+		if reason, message := getCSINodeDrift("node1", test.csiNode, test.cfg); reason != test.reason {
+			t.Errorf("%s: expected reason %q, got %q (%s)", test.name, test.reason, reason, message)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+
+	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+const (
+	// datastoreHealthInterval is the interval between two checks of the accessibility of the datastores
+	datastoreHealthInterval = time.Minute
+	// Reasons of the events emitted on the PVs of a datastore when its accessibility changes
+	eventReasonDatastoreInaccessible = "DatastoreInaccessible"
+	eventReasonDatastoreAccessible   = "DatastoreAccessible"
+)
+
+// datastoreHealthMonitor periodically checks the accessibility of the
+// datastores shared by the node VMs. Inaccessible datastores, e.g. in an all
+// paths down or permanent device loss condition, are avoided for provisioning
+// and attaching volumes until they are accessible again.
+type datastoreHealthMonitor struct {
+	controller *controller
+	lock       sync.RWMutex
+	// unhealthy maps the URLs of inaccessible datastores to the reason
+	unhealthy map[string]string
+}
+
+// newDatastoreHealthMonitor returns a datastore health monitor for the controller
+func newDatastoreHealthMonitor(c *controller) *datastoreHealthMonitor {
+	return &datastoreHealthMonitor{
+		controller: c,
+		unhealthy:  make(map[string]string),
+	}
+}
+
+// run checks the datastores periodically. It never returns.
+func (m *datastoreHealthMonitor) run() {
+	ticker := time.NewTicker(datastoreHealthInterval)
+	for range ticker.C {
+		m.check()
+	}
+}
+
+// check refreshes the accessibility of the datastores shared by the node VMs
+func (m *datastoreHealthMonitor) check() {
+	ctx, cancel := context.WithCancel(cnsvolume.WithOpID(context.Background(), "csi-datastorehealth"))
+	defer cancel()
+	datastores, err := m.controller.nodeMgr.GetSharedDatastoresInK8SCluster(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get shared datastores to check their accessibility. err=%v", err)
+		return
+	}
+	seen := make(map[string]bool)
+	for _, datastore := range datastores {
+		seen[datastore.Info.Url] = true
+		accessible, reason, err := datastore.GetAccessibility(ctx)
+		if err != nil {
+			continue
+		}
+		url := datastore.Info.Url
+		m.lock.Lock()
+		previousReason, wasUnhealthy := m.unhealthy[url]
+		if accessible {
+			delete(m.unhealthy, url)
+		} else {
+			m.unhealthy[url] = reason
+		}
+		m.lock.Unlock()
+		switch {
+		case !accessible && !wasUnhealthy:
+			klog.Warningf("Datastore %s is inaccessible: %s. Avoiding it for provisioning and attaching volumes", url, reason)
+			m.recordVolumeEvents(ctx, datastore, v1.EventTypeWarning, eventReasonDatastoreInaccessible,
+				fmt.Sprintf("Datastore %s of the volume is inaccessible: %s", url, reason))
+		case accessible && wasUnhealthy:
+			klog.Infof("Datastore %s is accessible again after %s", url, previousReason)
+			m.recordVolumeEvents(ctx, datastore, v1.EventTypeNormal, eventReasonDatastoreAccessible,
+				fmt.Sprintf("Datastore %s of the volume is accessible again", url))
+		}
+	}
+	// Forget datastores which are no longer shared by the node VMs
+	m.lock.Lock()
+	for url := range m.unhealthy {
+		if !seen[url] {
+			delete(m.unhealthy, url)
+		}
+	}
+	m.lock.Unlock()
+}
+
+// recordVolumeEvents emits an event on the PVs of the volumes on the datastore
+func (m *datastoreHealthMonitor) recordVolumeEvents(ctx context.Context, datastore *cnsvsphere.DatastoreInfo, eventType string, reason string, message string) {
+	if m.controller.eventRecorder == nil {
+		return
+	}
+	queryFilter := cnstypes.CnsQueryFilter{
+		Datastores: []vimtypes.ManagedObjectReference{datastore.Reference()},
+	}
+	queryResult, err := m.controller.manager.VolumeManager.QueryVolume(ctx, queryFilter)
+	if err != nil {
+		klog.Errorf("Failed to query volumes on datastore %s. err=%v", datastore.Info.Url, err)
+		return
+	}
+	pvs, err := m.controller.getPVsByVolumeHandle()
+	if err != nil {
+		return
+	}
+	for _, volume := range queryResult.Volumes {
+		pv, found := pvs[volume.VolumeId.Id]
+		if !found {
+			klog.V(4).Infof("No PV found for volume %s on datastore %s, skipping event", volume.VolumeId.Id, datastore.Info.Url)
+			continue
+		}
+		m.controller.eventRecorder.Event(pv, eventType, reason, message)
+	}
+}
+
+// getUnhealthyReason returns why the datastore with the given URL is
+// inaccessible, or false if it is not known to be inaccessible.
+func (m *datastoreHealthMonitor) getUnhealthyReason(url string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	reason, unhealthy := m.unhealthy[url]
+	return reason, unhealthy
+}
+
+// hasUnhealthy returns whether any datastore is known to be inaccessible
+func (m *datastoreHealthMonitor) hasUnhealthy() bool {
+	if m == nil {
+		return false
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return len(m.unhealthy) > 0
+}
+
+// filterHealthy returns the datastores which are not known to be inaccessible
+func (m *datastoreHealthMonitor) filterHealthy(datastores []*cnsvsphere.DatastoreInfo) []*cnsvsphere.DatastoreInfo {
+	if !m.hasUnhealthy() {
+		return datastores
+	}
+	var healthy []*cnsvsphere.DatastoreInfo
+	for _, datastore := range datastores {
+		if reason, unhealthy := m.getUnhealthyReason(datastore.Info.Url); unhealthy {
+			klog.V(3).Infof("Skipping inaccessible datastore %s: %s", datastore.Info.Url, reason)
+			continue
+		}
+		healthy = append(healthy, datastore)
+	}
+	return healthy
+}
+
+// checkVolumeDatastore returns an error if the given CNS volume is on a
+// datastore known to be inaccessible. An unknown volume is not an error,
+// the operation on the volume is attempted anyway.
+func (m *datastoreHealthMonitor) checkVolumeDatastore(volume *cnstypes.CnsVolume) error {
+	if volume == nil || !m.hasUnhealthy() {
+		return nil
+	}
+	url := volume.DatastoreUrl
+	if reason, unhealthy := m.getUnhealthyReason(url); unhealthy {
+		return fmt.Errorf("datastore %s of the volume is inaccessible: %s", url, reason)
+	}
+	return nil
+}
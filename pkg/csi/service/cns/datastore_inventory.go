@@ -0,0 +1,172 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+)
+
+const (
+	// datastoreInventoryCheckDelay is the minimum interval between two checks of
+	// the shared datastores, so the updates of a change on several hosts, e.g. a
+	// datastore being mounted on all of them, are handled by a single check
+	datastoreInventoryCheckDelay = 10 * time.Second
+	// datastoreInventoryRetryInterval is the interval before watching the
+	// datastore inventory again after watching it failed
+	datastoreInventoryRetryInterval = time.Minute
+	// Reason of the events emitted on the storage classes when shared datastores are added or removed
+	eventReasonSharedDatastoresChanged = "SharedDatastoresChanged"
+)
+
+// datastoreInventoryWatcher watches the datastore inventory of the vCenter
+// and, when it changes, lists the datastores shared by the node VMs and
+// compares them with the previous list. When datastores are
+// added or removed, e.g. an admin mounts a new datastore on all hosts, the
+// cached datastore lookups are invalidated so provisioning considers the new
+// datastores right away, and an event is emitted on the storage classes of
+// the driver.
+type datastoreInventoryWatcher struct {
+	controller *controller
+	// datastores maps the URLs of the shared datastores to their name, nil
+	// until the first check
+	datastores map[string]string
+}
+
+// newDatastoreInventoryWatcher returns a datastore inventory watcher for the controller
+func newDatastoreInventoryWatcher(c *controller) *datastoreInventoryWatcher {
+	return &datastoreInventoryWatcher{controller: c}
+}
+
+// run checks the shared datastores whenever the datastore inventory changes.
+// It never returns.
+func (w *datastoreInventoryWatcher) run() {
+	changes := make(chan struct{}, 1)
+	go w.watch(changes)
+	for range changes {
+		w.check()
+		time.Sleep(datastoreInventoryCheckDelay)
+	}
+}
+
+// watch signals the changes of the datastore inventory on the changes
+// channel, including the initial inventory. It watches the inventory again
+// after a failure and never returns.
+func (w *datastoreInventoryWatcher) watch(changes chan<- struct{}) {
+	onChange := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+			// A check is already pending
+		}
+	}
+	for {
+		ctx, cancel := context.WithCancel(cnsvolume.WithOpID(context.Background(), "csi-datastoreinventory"))
+		vc, err := common.GetVCenter(ctx, w.controller.manager)
+		if err == nil {
+			err = vc.WatchDatastores(ctx, onChange)
+		}
+		cancel()
+		klog.Errorf("Failed to watch the datastore inventory, retrying in %v. err=%v", datastoreInventoryRetryInterval, err)
+		time.Sleep(datastoreInventoryRetryInterval)
+	}
+}
+
+// check lists the shared datastores and handles the ones added or removed
+// since the previous check
+func (w *datastoreInventoryWatcher) check() {
+	ctx, cancel := context.WithCancel(cnsvolume.WithOpID(context.Background(), "csi-datastoreinventory"))
+	defer cancel()
+	datastores, err := w.controller.nodeMgr.GetSharedDatastoresInK8SCluster(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get shared datastores to check for added or removed datastores. err=%v", err)
+		return
+	}
+	current := make(map[string]string)
+	for _, datastore := range datastores {
+		current[datastore.Info.Url] = datastore.Info.Name
+	}
+	previous := w.datastores
+	w.datastores = current
+	if previous == nil {
+		return
+	}
+	added := diffDatastores(current, previous)
+	removed := diffDatastores(previous, current)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	klog.Infof("Shared datastores changed, added: %v, removed: %v", added, removed)
+	cnsvsphere.InvalidateDatastoreURLCache()
+	var changes []string
+	if len(added) > 0 {
+		changes = append(changes, fmt.Sprintf("added %s", strings.Join(added, ", ")))
+	}
+	if len(removed) > 0 {
+		changes = append(changes, fmt.Sprintf("removed %s", strings.Join(removed, ", ")))
+	}
+	w.recordStorageClassEvents(fmt.Sprintf("Datastores shared by the nodes changed: %s. %d shared datastores are available for provisioning",
+		strings.Join(changes, "; "), len(current)))
+}
+
+// diffDatastores returns the names and URLs of the datastores in a which are not in b, sorted
+func diffDatastores(a map[string]string, b map[string]string) []string {
+	var diff []string
+	for url, name := range a {
+		if _, ok := b[url]; !ok {
+			diff = append(diff, fmt.Sprintf("%s (%s)", name, url))
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// recordStorageClassEvents emits an event on the storage classes provisioned by the driver
+func (w *datastoreInventoryWatcher) recordStorageClassEvents(message string) {
+	if w.controller.eventRecorder == nil || w.controller.k8sclient == nil {
+		return
+	}
+	storageClasses, err := w.controller.k8sclient.StorageV1().StorageClasses().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list storage classes to record shared datastore changes. err=%v", err)
+		return
+	}
+	for i := range storageClasses.Items {
+		sc := &storageClasses.Items[i]
+		if sc.Provisioner != csitypes.DriverName {
+			continue
+		}
+		scRef := &v1.ObjectReference{
+			Kind: "StorageClass",
+			Name: sc.Name,
+			UID:  sc.UID,
+		}
+		w.controller.eventRecorder.Event(scRef, v1.EventTypeNormal, eventReasonSharedDatastoresChanged, message)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// operationJanitorInterval is the interval between two runs of the operation janitor
+const operationJanitorInterval = time.Minute
+
+// inflightOperation is a controller operation in progress
+type inflightOperation struct {
+	name     string
+	volumeID string
+	opID     string
+	started  time.Time
+	cancel   context.CancelFunc
+}
+
+// operationJanitor tracks in-flight controller operations. Operations
+// running longer than the hard timeout are cancelled, so abandoned calls to
+// vCenter do not leak goroutines. The janitor also keeps the vCenter session
+// alive, so an expired session is replaced before the next operation needs it.
+type operationJanitor struct {
+	manager     *common.Manager
+	hardTimeout time.Duration
+	lock        sync.Mutex
+	nextID      uint64
+	operations  map[uint64]*inflightOperation
+}
+
+// newOperationJanitor returns a janitor for the operation hard timeout configured for the manager
+func newOperationJanitor(manager *common.Manager) *operationJanitor {
+	return &operationJanitor{
+		manager:     manager,
+		hardTimeout: time.Duration(manager.CnsConfig.Global.OperationHardTimeoutMinutes) * time.Minute,
+		operations:  make(map[uint64]*inflightOperation),
+	}
+}
+
+// track registers an operation on the given volume and returns the context
+// to run it with. The returned function must be called once the operation is done.
+// Operations are not tracked when the janitor is nil.
+func (j *operationJanitor) track(ctx context.Context, name string, volumeID string) (context.Context, func()) {
+	if j == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.nextID++
+	id := j.nextID
+	j.operations[id] = &inflightOperation{
+		name:     name,
+		volumeID: volumeID,
+		opID:     cnsvolume.GetOpID(ctx),
+		started:  time.Now(),
+		cancel:   cancel,
+	}
+	prometheus.InflightOperations.WithLabelValues(name).Inc()
+	return ctx, func() {
+		j.lock.Lock()
+		defer j.lock.Unlock()
+		if _, found := j.operations[id]; found {
+			delete(j.operations, id)
+			prometheus.InflightOperations.WithLabelValues(name).Dec()
+		}
+		cancel()
+	}
+}
+
+// run cancels expired operations and refreshes the vCenter session periodically.
+// It never returns.
+func (j *operationJanitor) run() {
+	ticker := time.NewTicker(operationJanitorInterval)
+	for range ticker.C {
+		j.cancelExpiredOperations()
+		j.refreshSession()
+	}
+}
+
+// cancelExpiredOperations cancels the operations running longer than the hard timeout.
+// The goroutine stacks are logged once per run to help find where they are stuck.
+func (j *operationJanitor) cancelExpiredOperations() {
+	j.lock.Lock()
+	var expired []*inflightOperation
+	for id, operation := range j.operations {
+		if time.Since(operation.started) < j.hardTimeout {
+			continue
+		}
+		expired = append(expired, operation)
+		delete(j.operations, id)
+		prometheus.InflightOperations.WithLabelValues(operation.name).Dec()
+	}
+	j.lock.Unlock()
+	if len(expired) == 0 {
+		return
+	}
+	// Log the stacks before cancelling, while the operations are still stuck
+	var stacks bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&stacks, 1); err != nil {
+		klog.Errorf("Failed to get goroutine stacks. err=%v", err)
+	} else {
+		klog.Warningf("Goroutine stacks before cancelling expired operations:\n%s", stacks.String())
+	}
+	for _, operation := range expired {
+		klog.Warningf("Cancelling %s of volume %q, opId: %q, running since %v which exceeds the hard timeout of %v",
+			operation.name, operation.volumeID, operation.opID, operation.started, j.hardTimeout)
+		operation.cancel()
+		prometheus.CancelledOperations.WithLabelValues(operation.name).Inc()
+	}
+}
+
+// refreshSession reconnects to vCenter if the session has expired
+func (j *operationJanitor) refreshSession() {
+	ctx, cancel := context.WithTimeout(context.Background(), operationJanitorInterval)
+	defer cancel()
+	if _, err := common.GetVCenter(ctx, j.manager); err != nil {
+		klog.Warningf("Failed to refresh vCenter session. err=%v", err)
+	}
+}
@@ -18,14 +18,20 @@ package cns
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"reflect"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 
 	cnsnode "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/node"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
 	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
 	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
@@ -47,24 +53,104 @@ func (nodes *Nodes) Initialize() error {
 		return err
 	}
 	nodes.cnsNodeManager.SetKubernetesClient(k8sclient)
+	// Discover the existing nodes in one pass, the node add events sent by
+	// the informer for them then don't search vCenter again
+	nodes.registerExistingNodes(k8sclient)
 	nodes.informMgr = k8s.NewInformer(k8sclient)
-	nodes.informMgr.AddNodeListener(nodes.nodeAdd, nil, nodes.nodeDelete)
+	nodes.informMgr.AddNodeListener(nodes.nodeAdd, nodes.nodeUpdate, nodes.nodeDelete)
 	nodes.informMgr.Listen()
+	prometheus.HandleDebug("nodes", http.HandlerFunc(nodes.serveNodeMetadata))
 	return nil
 }
 
+// registerExistingNodes registers all the nodes of the cluster with the node
+// manager at once. Failures are only logged, the nodes are then registered
+// one by one by nodeAdd.
+func (nodes *Nodes) registerExistingNodes(k8sclient clientset.Interface) {
+	nodeList, err := k8sclient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Failed to list nodes, they will be registered one by one. Err: %v", err)
+		return
+	}
+	var registrations []cnsnode.Registration
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		registrations = append(registrations, cnsnode.Registration{
+			UUID:     common.GetUUIDFromProviderID(node.Spec.ProviderID),
+			Name:     node.Name,
+			Metadata: cnsnode.GetMetadata(node),
+		})
+	}
+	if err := nodes.cnsNodeManager.RegisterNodes(registrations); err != nil {
+		klog.Warningf("Failed to register %d nodes at once, they will be registered one by one. Err: %v", len(registrations), err)
+	}
+}
+
 func (nodes *Nodes) nodeAdd(obj interface{}) {
 	node, ok := obj.(*v1.Node)
 	if node == nil || !ok {
 		klog.Warningf("nodeAdd: unrecognized object %+v", obj)
 		return
 	}
-	err := nodes.cnsNodeManager.RegisterNode(common.GetUUIDFromProviderID(node.Spec.ProviderID), node.Name)
+	err := nodes.cnsNodeManager.RegisterNode(common.GetUUIDFromProviderID(node.Spec.ProviderID), node.Name, cnsnode.GetMetadata(node))
 	if err != nil {
 		klog.Warningf("Failed to register node:%q. err=%v", node.Name, err)
 	}
 }
 
+func (nodes *Nodes) nodeUpdate(oldObj interface{}, newObj interface{}) {
+	oldNode, ok := oldObj.(*v1.Node)
+	if oldNode == nil || !ok {
+		klog.Warningf("nodeUpdate: unrecognized old object %+v", oldObj)
+		return
+	}
+	newNode, ok := newObj.(*v1.Node)
+	if newNode == nil || !ok {
+		klog.Warningf("nodeUpdate: unrecognized new object %+v", newObj)
+		return
+	}
+	metadata := cnsnode.GetMetadata(newNode)
+	// The node was replaced by another VM, or its providerId was only set now
+	oldUUID := common.GetUUIDFromProviderID(oldNode.Spec.ProviderID)
+	newUUID := common.GetUUIDFromProviderID(newNode.Spec.ProviderID)
+	if oldUUID != newUUID {
+		klog.V(2).Infof("nodeUUID of node %q changed from %q to %q, registering it again", newNode.Name, oldUUID, newUUID)
+		if err := nodes.cnsNodeManager.UnregisterNode(oldNode.Name); err != nil {
+			klog.Warningf("Failed to unregister node:%q. err=%v", oldNode.Name, err)
+		}
+		if err := nodes.cnsNodeManager.RegisterNode(newUUID, newNode.Name, metadata); err != nil {
+			klog.Warningf("Failed to register node:%q. err=%v", newNode.Name, err)
+		}
+		return
+	}
+	// Keep the metadata current, e.g. after a kernel or node plugin upgrade
+	if reflect.DeepEqual(cnsnode.GetMetadata(oldNode), metadata) {
+		return
+	}
+	if err := nodes.cnsNodeManager.UpdateNodeMetadata(newNode.Name, metadata); err != nil {
+		klog.Warningf("Failed to update metadata of node:%q. err=%v", newNode.Name, err)
+	}
+}
+
+// serveNodeMetadata serves the metadata of the registered nodes as JSON, keyed on the node name
+func (nodes *Nodes) serveNodeMetadata(w http.ResponseWriter, r *http.Request) {
+	nodeMetadata := make(map[string]*cnsnode.Metadata)
+	for _, nodeName := range nodes.cnsNodeManager.GetAllNodeNames() {
+		if metadata, err := nodes.cnsNodeManager.GetNodeMetadata(nodeName); err == nil {
+			nodeMetadata[nodeName] = metadata
+		}
+	}
+	data, err := json.Marshal(nodeMetadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(data); err != nil {
+		klog.Errorf("Failed to write node metadata. Err: %v", err)
+	}
+}
+
 func (nodes *Nodes) nodeDelete(obj interface{}) {
 	node, ok := obj.(*v1.Node)
 	if node == nil || !ok {
@@ -83,6 +169,11 @@ func (nodes *Nodes) GetNodeByName(nodeName string) (*cnsvsphere.VirtualMachine,
 	return nodes.cnsNodeManager.GetNodeByName(nodeName)
 }
 
+// GetAllNodeNames returns the names of all nodes registered with the node manager
+func (nodes *Nodes) GetAllNodeNames() []string {
+	return nodes.cnsNodeManager.GetAllNodeNames()
+}
+
 // GetSharedDatastoresInTopology returns shared accessible datastores for specified topologyRequirement along with the map of
 // datastore URL and array of accessibleTopology map for each datastore returned from this function.
 // Here in this function, argument topologyRequirement can be passed in following form
@@ -103,7 +194,8 @@ func (nodes *Nodes) GetNodeByName(nodeName string) (*cnsvsphere.VirtualMachine,
 //      ds:///vmfs/volumes/vsan:524fae1aaca129a5-1ee55a87f26ae626/:
 //         [map[failure-domain.beta.kubernetes.io/region:k8s-region-us failure-domain.beta.kubernetes.io/zone:k8s-zone-us-west]
 //         map[failure-domain.beta.kubernetes.io/region:k8s-region-us failure-domain.beta.kubernetes.io/zone:k8s-zone-us-east]]]]
-func (nodes *Nodes) GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, zoneCategoryName string, regionCategoryName string) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error) {
+func (nodes *Nodes) GetSharedDatastoresInTopology(ctx context.Context, topologyRequirement *csi.TopologyRequirement, zoneCategoryName string, regionCategoryName string,
+	topologyKeys csitypes.TopologyKeys) ([]*cnsvsphere.DatastoreInfo, map[string][]map[string]string, error) {
 	klog.V(4).Infof("GetSharedDatastoresInTopology: called with topologyRequirement: %+v, zoneCategoryName: %s, regionCategoryName: %s", topologyRequirement, zoneCategoryName, regionCategoryName)
 	allNodes, err := nodes.cnsNodeManager.GetAllNodes()
 	if err != nil {
@@ -141,8 +233,8 @@ func (nodes *Nodes) GetSharedDatastoresInTopology(ctx context.Context, topologyR
 		datastoreTopologyMap := make(map[string][]map[string]string)
 		for _, topology := range topologyArr {
 			segments := topology.GetSegments()
-			zone := segments[csitypes.LabelZoneFailureDomain]
-			region := segments[csitypes.LabelRegionFailureDomain]
+			zone := segments[topologyKeys.Zone]
+			region := segments[topologyKeys.Region]
 			klog.V(4).Infof("Getting list of nodeVMs for zone [%s] and region [%s]", zone, region)
 			nodeVMsInZoneRegion, err := getNodesInZoneRegion(zone, region)
 			if err != nil {
@@ -159,10 +251,10 @@ func (nodes *Nodes) GetSharedDatastoresInTopology(ctx context.Context, topologyR
 			for _, datastore := range sharedDatastoresInZoneRegion {
 				accessibleTopology := make(map[string]string)
 				if zone != "" {
-					accessibleTopology[csitypes.LabelZoneFailureDomain] = zone
+					accessibleTopology[topologyKeys.Zone] = zone
 				}
 				if region != "" {
-					accessibleTopology[csitypes.LabelRegionFailureDomain] = region
+					accessibleTopology[topologyKeys.Region] = region
 				}
 				datastoreTopologyMap[datastore.Info.Url] = append(datastoreTopologyMap[datastore.Info.Url], accessibleTopology)
 			}
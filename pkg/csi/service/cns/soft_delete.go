@@ -0,0 +1,223 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"k8s.io/klog"
+
+	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+// maxPurgeInterval is the longest interval between two purge runs
+const maxPurgeInterval = 10 * time.Minute
+
+// softDeleteJanitor permanently deletes soft deleted volumes once their
+// retention period has passed. A volume is pending purge as long as its disk
+// carries the soft delete tag, so removing the tag on vCenter rescues it.
+// The time a volume became pending is recorded in a tag on its disk, so it
+// survives restarts. Disks tagged by hand, without the time tag, get a full
+// retention period from the time they are first seen.
+type softDeleteJanitor struct {
+	manager   *common.Manager
+	retention time.Duration
+	// firstSeen maps the ID of volumes pending purge without a time tag to
+	// the time the volume was first found pending purge
+	firstSeen map[string]time.Time
+	// datastores maps the ID of volumes soft deleted by this janitor to the
+	// URL of their datastore, so the disk is purged without a search
+	datastores map[string]string
+	lock       sync.Mutex
+}
+
+// newSoftDeleteJanitor returns a janitor for the soft delete configuration of the manager
+func newSoftDeleteJanitor(manager *common.Manager) *softDeleteJanitor {
+	return &softDeleteJanitor{
+		manager:    manager,
+		retention:  time.Duration(manager.CnsConfig.SoftDelete.RetentionMinutes) * time.Minute,
+		firstSeen:  make(map[string]time.Time),
+		datastores: make(map[string]string),
+	}
+}
+
+// softDeleteVolume tags the disk of the volume as pending purge and removes
+// the volume from CNS, keeping the disk
+func (j *softDeleteJanitor) softDeleteVolume(ctx context.Context, volumeID string) error {
+	vc, err := common.GetVCenter(ctx, j.manager)
+	if err != nil {
+		klog.Errorf("Failed to get vcenter. err=%v", err)
+		return err
+	}
+	// The datastore is only known while CNS manages the volume
+	datastoreURL := ""
+	queryResult, err := j.manager.VolumeManager.QueryVolume(ctx, cnstypes.CnsQueryFilter{
+		VolumeIds: []cnstypes.CnsVolumeId{{Id: volumeID}},
+	})
+	if err != nil {
+		klog.Warningf("Failed to query the datastore of volume %s. err=%v", volumeID, err)
+	} else if len(queryResult.Volumes) > 0 {
+		datastoreURL = queryResult.Volumes[0].DatastoreUrl
+	}
+	// Tag the disk first, so it is never left behind untracked
+	err = cnsvolume.TagVolumeForPurge(ctx, vc, volumeID, j.manager.CnsConfig.SoftDelete.TagCategory,
+		j.manager.CnsConfig.SoftDelete.Tag, time.Now())
+	if err != nil {
+		return err
+	}
+	err = common.DeleteVolumeUtil(ctx, j.manager, volumeID, false)
+	if err != nil {
+		// The volume is still managed by CNS, it must not be purged
+		if untagErr := cnsvolume.UntagVolumeForPurge(ctx, vc, volumeID, j.manager.CnsConfig.SoftDelete.TagCategory,
+			j.manager.CnsConfig.SoftDelete.Tag); untagErr != nil {
+			klog.Errorf("Failed to remove pending purge tag from volume %s after failed delete. err=%v", volumeID, untagErr)
+		}
+		return err
+	}
+	if datastoreURL != "" {
+		j.lock.Lock()
+		j.datastores[volumeID] = datastoreURL
+		j.lock.Unlock()
+	}
+	klog.V(2).Infof("Volume %s is soft deleted and will be purged after %v", volumeID, j.retention)
+	return nil
+}
+
+// run purges expired volumes periodically. It never returns.
+func (j *softDeleteJanitor) run() {
+	interval := j.retention
+	if interval > maxPurgeInterval {
+		interval = maxPurgeInterval
+	}
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		j.purgeExpiredVolumes()
+	}
+}
+
+// purgeExpiredVolumes deletes the disks which have been pending purge for longer than the retention period
+func (j *softDeleteJanitor) purgeExpiredVolumes() {
+	ctx := cnsvolume.WithPriority(context.Background(), cnsvolume.PriorityBackground)
+	ctx, cancel := context.WithCancel(cnsvolume.WithOpID(ctx, "csi-purge"))
+	defer cancel()
+	vc, err := common.GetVCenter(ctx, j.manager)
+	if err != nil {
+		klog.Errorf("Failed to get vcenter. err=%v", err)
+		return
+	}
+	category := j.manager.CnsConfig.SoftDelete.TagCategory
+	tag := j.manager.CnsConfig.SoftDelete.Tag
+	volumeIDs, err := cnsvolume.ListVolumesPendingPurge(ctx, vc, category, tag)
+	if err != nil {
+		return
+	}
+	pendingSince := make(map[string]time.Time)
+	for _, volumeID := range volumeIDs {
+		since, found, err := cnsvolume.GetVolumePendingPurgeSince(ctx, vc, volumeID, category, tag)
+		if err != nil {
+			continue
+		}
+		if found {
+			pendingSince[volumeID] = since
+		}
+	}
+	expired := j.getExpiredVolumes(volumeIDs, pendingSince, time.Now())
+	if len(expired) == 0 {
+		return
+	}
+	// Never purge a disk which is still managed by CNS
+	managed, err := j.getCnsManagedVolumes(ctx, expired)
+	if err != nil {
+		return
+	}
+	for _, volumeID := range expired {
+		if managed[volumeID] {
+			klog.Warningf("Not purging volume %s as it is still managed by CNS", volumeID)
+			continue
+		}
+		j.lock.Lock()
+		datastoreURL := j.datastores[volumeID]
+		j.lock.Unlock()
+		if err := cnsvolume.PurgeVolume(ctx, vc, volumeID, datastoreURL); err != nil {
+			klog.Errorf("Failed to purge soft deleted volume %s. err=%v", volumeID, err)
+			continue
+		}
+		j.lock.Lock()
+		delete(j.firstSeen, volumeID)
+		delete(j.datastores, volumeID)
+		j.lock.Unlock()
+		if since, found := pendingSince[volumeID]; found {
+			sinceTag := cnsvolume.PendingPurgeSinceTagName(tag, since)
+			if err := cnsvolume.DeleteTagIfUnused(ctx, vc, category, sinceTag); err != nil {
+				klog.Warningf("Failed to delete pending purge time tag %s. err=%v", sinceTag, err)
+			}
+		}
+	}
+}
+
+// getExpiredVolumes returns the volumes pending purge whose retention period
+// has passed at the given time. pendingSince holds the times recorded by the
+// time tags of the volumes, volumes without one are timed from when they are
+// first seen. Volumes which are no longer pending purge are forgotten, as they
+// were rescued or purged out of band.
+func (j *softDeleteJanitor) getExpiredVolumes(volumeIDs []string, pendingSince map[string]time.Time, now time.Time) []string {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	var expired []string
+	firstSeen := make(map[string]time.Time)
+	datastores := make(map[string]string)
+	for _, volumeID := range volumeIDs {
+		if datastoreURL, found := j.datastores[volumeID]; found {
+			datastores[volumeID] = datastoreURL
+		}
+		since, found := pendingSince[volumeID]
+		if !found {
+			if since, found = j.firstSeen[volumeID]; !found {
+				since = now
+			}
+			firstSeen[volumeID] = since
+		}
+		if now.Sub(since) >= j.retention {
+			expired = append(expired, volumeID)
+		}
+	}
+	j.firstSeen = firstSeen
+	j.datastores = datastores
+	return expired
+}
+
+// getCnsManagedVolumes returns the set of the given volumes which are known to CNS
+func (j *softDeleteJanitor) getCnsManagedVolumes(ctx context.Context, volumeIDs []string) (map[string]bool, error) {
+	queryFilter := cnstypes.CnsQueryFilter{}
+	for _, volumeID := range volumeIDs {
+		queryFilter.VolumeIds = append(queryFilter.VolumeIds, cnstypes.CnsVolumeId{Id: volumeID})
+	}
+	queryResult, err := j.manager.VolumeManager.QueryVolume(ctx, queryFilter)
+	if err != nil {
+		klog.Errorf("Failed to query CNS for volumes pending purge. err=%v", err)
+		return nil, err
+	}
+	managed := make(map[string]bool)
+	for _, volume := range queryResult.Volumes {
+		managed[volume.VolumeId.Id] = true
+	}
+	return managed, nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cns
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+)
+
+func TestGetExpiredVolumes(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.SoftDelete.RetentionMinutes = 60
+	j := newSoftDeleteJanitor(&common.Manager{CnsConfig: cfg})
+	now := time.Now()
+	j.datastores["vol-tagged"] = "ds:///ds-1/"
+	j.datastores["vol-rescued"] = "ds:///ds-1/"
+
+	// The time tags decide the expiry, also for volumes soft deleted before a restart
+	pendingSince := map[string]time.Time{
+		"vol-tagged":  now.Add(-2 * time.Hour),
+		"vol-pending": now.Add(-30 * time.Minute),
+	}
+	expired := j.getExpiredVolumes([]string{"vol-tagged", "vol-pending", "vol-by-hand"}, pendingSince, now)
+	if !reflect.DeepEqual(expired, []string{"vol-tagged"}) {
+		t.Errorf("Expected vol-tagged to expire, got %v", expired)
+	}
+	if _, found := j.datastores["vol-rescued"]; found {
+		t.Errorf("Expected the datastore of a volume no longer pending purge to be forgotten")
+	}
+	if j.datastores["vol-tagged"] != "ds:///ds-1/" {
+		t.Errorf("Expected the datastore of vol-tagged to be kept, got %v", j.datastores)
+	}
+
+	// Volumes without a time tag get a full retention period from when they are first seen
+	expired = j.getExpiredVolumes([]string{"vol-by-hand"}, nil, now.Add(59*time.Minute))
+	if len(expired) != 0 {
+		t.Errorf("Expected no volume to expire, got %v", expired)
+	}
+	expired = j.getExpiredVolumes([]string{"vol-by-hand"}, nil, now.Add(60*time.Minute))
+	if !reflect.DeepEqual(expired, []string{"vol-by-hand"}) {
+		t.Errorf("Expected vol-by-hand to expire, got %v", expired)
+	}
+	// A volume tagged by hand again after a rescue gets a new retention period
+	j.getExpiredVolumes(nil, nil, now.Add(61*time.Minute))
+	expired = j.getExpiredVolumes([]string{"vol-by-hand"}, nil, now.Add(62*time.Minute))
+	if len(expired) != 0 {
+		t.Errorf("Expected no volume to expire after a rescue, got %v", expired)
+	}
+}
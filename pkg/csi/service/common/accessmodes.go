@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Kubernetes entity types of the CNS volume metadata, as the strings stored in
+// CnsKubernetesEntityMetadata.EntityType
+const (
+	EntityTypePV  = string(cnstypes.CnsKubernetesEntityTypePV)
+	EntityTypePVC = string(cnstypes.CnsKubernetesEntityTypePVC)
+	EntityTypePod = string(cnstypes.CnsKubernetesEntityTypePOD)
+)
+
+// GetEntityType returns the Kubernetes entity type of a CNS entity metadata,
+// or false if it is not Kubernetes entity metadata
+func GetEntityType(metadata cnstypes.BaseCnsEntityMetadata) (string, bool) {
+	k8sMetadata, ok := metadata.(*cnstypes.CnsKubernetesEntityMetadata)
+	if !ok {
+		return "", false
+	}
+	return k8sMetadata.EntityType, true
+}
+
+// IsEntityType returns true if the CNS entity metadata is Kubernetes entity
+// metadata of the given entity type
+func IsEntityType(metadata cnstypes.BaseCnsEntityMetadata, entityType string) bool {
+	metadataEntityType, ok := GetEntityType(metadata)
+	return ok && metadataEntityType == entityType
+}
+
+// GetAccessMode returns the Kubernetes access mode of a CSI access mode. CSI
+// access modes without a Kubernetes equivalent map to the closest one, e.g.
+// SINGLE_NODE_READER_ONLY to ReadWriteOnce, as attaching to a single node is
+// what matters to the driver. An error is returned for UNKNOWN and modes
+// unknown to this version of the CSI spec.
+func GetAccessMode(mode csi.VolumeCapability_AccessMode_Mode) (v1.PersistentVolumeAccessMode, error) {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:
+		return v1.ReadWriteOnce, nil
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return v1.ReadOnlyMany, nil
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return v1.ReadWriteMany, nil
+	}
+	return "", fmt.Errorf("unsupported volume access mode %s", mode)
+}
+
+// IsReadOnlyAccessMode returns true if volumes with the CSI access mode are
+// mounted read only
+func IsReadOnlyAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
+
+// IsMultiNodeAccessMode returns true if volumes with the CSI access mode may be
+// published to several nodes at once
+func IsMultiNodeAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return true
+	}
+	return false
+}
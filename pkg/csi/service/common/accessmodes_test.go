@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	v1 "k8s.io/api/core/v1"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func TestGetAccessMode(t *testing.T) {
+	expected := map[csi.VolumeCapability_AccessMode_Mode]v1.PersistentVolumeAccessMode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER:       v1.ReadWriteOnce,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:  v1.ReadWriteOnce,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:   v1.ReadOnlyMany,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER: v1.ReadWriteMany,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:  v1.ReadWriteMany,
+	}
+	// Every mode of the CSI spec must be mapped, except UNKNOWN
+	for value, name := range csi.VolumeCapability_AccessMode_Mode_name {
+		mode := csi.VolumeCapability_AccessMode_Mode(value)
+		accessMode, err := GetAccessMode(mode)
+		if mode == csi.VolumeCapability_AccessMode_UNKNOWN {
+			if err == nil {
+				t.Errorf("expected an error for access mode %s", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("access mode %s is not mapped: %v", name, err)
+			continue
+		}
+		if accessMode != expected[mode] {
+			t.Errorf("access mode %s: expected %s, got %s", name, expected[mode], accessMode)
+		}
+		if IsMultiNodeAccessMode(mode) != (accessMode != v1.ReadWriteOnce) {
+			t.Errorf("access mode %s: IsMultiNodeAccessMode is inconsistent with %s", name, accessMode)
+		}
+	}
+	if !IsReadOnlyAccessMode(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY) ||
+		IsReadOnlyAccessMode(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER) {
+		t.Errorf("IsReadOnlyAccessMode is wrong")
+	}
+}
+
+func TestEntityType(t *testing.T) {
+	pvMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData("pv", nil, false, EntityTypePV, "")
+	if entityType, ok := GetEntityType(pvMetadata); !ok || entityType != EntityTypePV {
+		t.Errorf("expected entity type %s, got %q", EntityTypePV, entityType)
+	}
+	if !IsEntityType(pvMetadata, EntityTypePV) || IsEntityType(pvMetadata, EntityTypePod) {
+		t.Errorf("IsEntityType is wrong for entity type %s", EntityTypePV)
+	}
+	if _, ok := GetEntityType(&cnstypes.CnsEntityMetadata{EntityName: "other"}); ok {
+		t.Errorf("expected no entity type for metadata which is not kubernetes entity metadata")
+	}
+}
@@ -49,6 +49,49 @@ func ValidateCreateVolumeRequest(req *csi.CreateVolumeRequest) error {
 	return nil
 }
 
+// GetVolumeSizeMB returns the size in MB of the volume to create for the
+// given capacity range, defaultBytes if no size is required. The size is
+// rounded up to a MB, and must not exceed the limit of the range once rounded.
+// If only a limit below defaultBytes is set, the volume is created with the
+// limit rounded down to a MB. Sizes below minBytes are raised to minBytes.
+// Function returns an OutOfRange error if the range can not be satisfied, and
+// an InvalidArgument error if minBytes exceeds the limit of the range.
+func GetVolumeSizeMB(capacityRange *csi.CapacityRange, defaultBytes int64, minBytes int64) (int64, error) {
+	volSizeBytes := defaultBytes
+	limitBytes := capacityRange.GetLimitBytes()
+	if requiredBytes := capacityRange.GetRequiredBytes(); requiredBytes != 0 {
+		volSizeBytes = requiredBytes
+	} else if limitBytes != 0 && limitBytes < volSizeBytes {
+		volSizeBytes = limitBytes / MbInBytes * MbInBytes
+	}
+	if limitBytes != 0 && volSizeBytes > limitBytes {
+		msg := fmt.Sprintf("required size %d bytes exceeds the limit of %d bytes", volSizeBytes, limitBytes)
+		klog.Error(msg)
+		return 0, status.Error(codes.OutOfRange, msg)
+	}
+	volSizeMB := RoundUpSize(volSizeBytes, MbInBytes)
+	if minMB := RoundUpSize(minBytes, MbInBytes); volSizeMB < minMB {
+		if limitBytes != 0 && minMB*MbInBytes > limitBytes {
+			msg := fmt.Sprintf("minimum volume size of %d MB exceeds the limit of %d bytes", minMB, limitBytes)
+			klog.Error(msg)
+			return 0, status.Error(codes.InvalidArgument, msg)
+		}
+		klog.V(4).Infof("Raising volume size of %d MB to the minimum volume size of %d MB", volSizeMB, minMB)
+		volSizeMB = minMB
+	}
+	if volSizeMB == 0 {
+		msg := fmt.Sprintf("limit of %d bytes is below the volume size granularity of 1 MB", limitBytes)
+		klog.Error(msg)
+		return 0, status.Error(codes.OutOfRange, msg)
+	}
+	if limitBytes != 0 && volSizeMB*MbInBytes > limitBytes {
+		msg := fmt.Sprintf("size %d bytes rounded up to %d MB exceeds the limit of %d bytes", volSizeBytes, volSizeMB, limitBytes)
+		klog.Error(msg)
+		return 0, status.Error(codes.OutOfRange, msg)
+	}
+	return volSizeMB, nil
+}
+
 // ValidateDeleteVolumeRequest is the helper function to validate
 // DeleteVolumeRequest for all block controllers.
 // Function returns error if validation fails otherwise returns nil.
@@ -0,0 +1,56 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetVolumeSizeMB(t *testing.T) {
+	defaultBytes := DefaultGbDiskSize * GbInBytes
+	tests := []struct {
+		capacityRange *csi.CapacityRange
+		sizeMB        int64
+		code          codes.Code
+	}{
+		{capacityRange: nil, sizeMB: DefaultGbDiskSize * 1024, code: codes.OK},
+		{capacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes}, sizeMB: 1024, code: codes.OK},
+		{capacityRange: &csi.CapacityRange{RequiredBytes: MbInBytes + 1}, sizeMB: 2, code: codes.OK},
+		{capacityRange: &csi.CapacityRange{RequiredBytes: MbInBytes + 1, LimitBytes: 2 * MbInBytes}, sizeMB: 2, code: codes.OK},
+		{capacityRange: &csi.CapacityRange{RequiredBytes: MbInBytes + 1, LimitBytes: MbInBytes + 10}, code: codes.OutOfRange},
+		{capacityRange: &csi.CapacityRange{RequiredBytes: 2 * GbInBytes, LimitBytes: GbInBytes}, code: codes.OutOfRange},
+		{capacityRange: &csi.CapacityRange{LimitBytes: GbInBytes}, sizeMB: 1024, code: codes.OK},
+		{capacityRange: &csi.CapacityRange{LimitBytes: MbInBytes + MbInBytes/2}, sizeMB: 1, code: codes.OK},
+		{capacityRange: &csi.CapacityRange{LimitBytes: 2 * defaultBytes}, sizeMB: DefaultGbDiskSize * 1024, code: codes.OK},
+		{capacityRange: &csi.CapacityRange{RequiredBytes: 1024}, sizeMB: 1, code: codes.OK},
+		{capacityRange: &csi.CapacityRange{LimitBytes: 1024}, code: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		sizeMB, err := GetVolumeSizeMB(tt.capacityRange, defaultBytes, MbInBytes)
+		if code := status.Code(err); code != tt.code {
+			t.Errorf("GetVolumeSizeMB(%v) returned error %v, expected code %v", tt.capacityRange, err, tt.code)
+			continue
+		}
+		if err == nil && sizeMB != tt.sizeMB {
+			t.Errorf("GetVolumeSizeMB(%v) = %d, expected %d", tt.capacityRange, sizeMB, tt.sizeMB)
+		}
+	}
+}
@@ -44,10 +44,45 @@ const (
 	// For Example: StoragePolicyId: "251bce41-cb24-41df-b46b-7c75aed3c4ee"
 	AttributeStoragePolicyID = "storagepolicyid"
 
+	// AttributeEffectiveStoragePolicyID is the ID of the Storage Policy CNS applied
+	// to the volume, recorded in the PersistentVolume's attributes. It is set even
+	// when the Storage Class has no Storage Policy and the datastore default is used
+	AttributeEffectiveStoragePolicyID = "effectivestoragepolicyid"
+
+	// AttributeEffectiveStoragePolicyName is the name of the Storage Policy CNS
+	// applied to the volume, recorded in the PersistentVolume's attributes
+	AttributeEffectiveStoragePolicyName = "effectivestoragepolicyname"
+
+	// AttributeVmdkPath is the path of the VMDK backing the volume when it was
+	// created, recorded in the PersistentVolume's attributes for backup and DR
+	// tools. The volume ID is the ID of the FCD. The syncer records the path
+	// after the volume is relocated in the AnnVmdkPath annotation of the PV
+	// For Example: VmdkPath: "[vsanDatastore] fcd/0b3d5e5f1fbc4e0f8a5e9d1b7c7d4a3e.vmdk"
+	AttributeVmdkPath = "vmdkpath"
+
 	// AttributeFsType represents filesystem type in the Storage Classs
 	// For Example: FsType: "ext4"
 	AttributeFsType = "fstype"
 
+	// AttributeChangeBlockTracking enables change block tracking on the disks of
+	// the volumes of the Storage Class when set to true, or on the disks of the
+	// volumes whose PVC has the AnnChangeBlockTracking annotation when set to
+	// ChangeBlockTrackingPerClaim
+	// For Example: ChangeBlockTracking: "true"
+	AttributeChangeBlockTracking = "changeblocktracking"
+
+	// ChangeBlockTrackingPerClaim is the value of AttributeChangeBlockTracking
+	// which leaves enabling change block tracking to the PVC annotation
+	ChangeBlockTrackingPerClaim = "perclaim"
+
+	// CSIParameterPrefix is the prefix of the parameters external-provisioner
+	// adds to CreateVolume requests, e.g. when run with --extra-create-metadata
+	CSIParameterPrefix = "csi.storage.k8s.io/"
+
+	// AttributePVCNamespace is the namespace of the PVC a volume is created for,
+	// passed by external-provisioner when run with --extra-create-metadata
+	AttributePVCNamespace = "csi.storage.k8s.io/pvc/namespace"
+
 	// DefaultFsType represents the default filesystem type which will be used to format the volume
 	// during mount if user does not specify the filesystem type in the Storage Class
 	DefaultFsType = "ext4"
@@ -62,6 +97,23 @@ const (
 	// BlockVolumeType is the VolumeType for CNS Volume
 	BlockVolumeType = "BLOCK"
 
+	// AnnRetainDisk is the PV annotation which, when set to true, keeps the disk
+	// when the volume is removed from CNS on deletion of the PV
+	AnnRetainDisk = "cns.vmware.com/retain-disk"
+
+	// AnnVmdkPath is the PV annotation holding the path of the VMDK backing the
+	// volume, set by the syncer when the volume is relocated to another datastore
+	AnnVmdkPath = "cns.vmware.com/vmdk-path"
+
+	// AnnChangeBlockTracking is the PVC annotation which, when set to true, enables
+	// change block tracking on the disk of the volume. It is only honored if the
+	// Storage Class sets AttributeChangeBlockTracking to ChangeBlockTrackingPerClaim
+	AnnChangeBlockTracking = "cns.vmware.com/change-block-tracking"
+
+	// SecretRetainDisk is the DeleteVolume secret which, when set to true, keeps
+	// the disk when the volume is removed from CNS
+	SecretRetainDisk = "retain-disk"
+
 	// MinSupportedVCenterMajor is the minimum, major version of vCenter
 	// on which CNS is supported.
 	MinSupportedVCenterMajor int = 6
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"k8s.io/klog"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+)
+
+// datastoreLimiter bounds the number of volumes created at once on each
+// datastore, so bursts of provisioning, e.g. a StatefulSet with many
+// replicas, are queued in the driver instead of overloading the datastore
+// with simultaneous create tasks.
+type datastoreLimiter struct {
+	lock sync.Mutex
+	// slots holds a semaphore per datastore URL, sized on first use
+	slots map[string]chan struct{}
+}
+
+var createLimiter = &datastoreLimiter{slots: make(map[string]chan struct{})}
+
+// acquire waits for a create slot on each of the datastores with the given
+// URLs which have a limit in cfg, and returns the function releasing them.
+// When CNS chooses among several datastores a slot is taken on each of them,
+// as any of them may receive the volume. Slots are taken in URL order so
+// concurrent callers can't deadlock. It returns an error if ctx is done while
+// waiting.
+func (l *datastoreLimiter) acquire(ctx context.Context, cfg *cnsconfig.Config, datastoreURLs []string) (func(), error) {
+	if cfg == nil {
+		return func() {}, nil
+	}
+	urls := make([]string, 0, len(datastoreURLs))
+	seen := make(map[string]bool)
+	for _, url := range datastoreURLs {
+		if !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	var acquired []chan struct{}
+	release := func() {
+		for _, slots := range acquired {
+			<-slots
+		}
+	}
+	for _, url := range urls {
+		slots := l.getSlots(url, cfg.GetMaxConcurrentCreates(url))
+		if slots == nil {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			klog.V(2).Infof("Waiting for one of the %d create slots of datastore %q", cap(slots), url)
+			prometheus.DatastoreCreateQueueLength.WithLabelValues(url).Inc()
+			select {
+			case slots <- struct{}{}:
+				prometheus.DatastoreCreateQueueLength.WithLabelValues(url).Dec()
+			case <-ctx.Done():
+				prometheus.DatastoreCreateQueueLength.WithLabelValues(url).Dec()
+				release()
+				klog.Errorf("Gave up waiting for a create slot of datastore %q. Err: %v", url, ctx.Err())
+				return nil, ctx.Err()
+			}
+		}
+		acquired = append(acquired, slots)
+	}
+	return release, nil
+}
+
+// getSlots returns the semaphore of the datastore, or nil if creates on it are not limited
+func (l *datastoreLimiter) getSlots(url string, limit int) chan struct{} {
+	if limit <= 0 {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	slots, found := l.slots[url]
+	if !found {
+		slots = make(chan struct{}, limit)
+		l.slots[url] = slots
+	}
+	return slots
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+func TestDatastoreLimiter(t *testing.T) {
+	cfg := &cnsconfig.Config{}
+	cfg.Global.MaxConcurrentCreatesPerDatastore = 1
+	cfg.DatastoreConcurrency = map[string]*cnsconfig.DatastoreConcurrencyConfig{
+		"ds:///unlimited/": {MaxConcurrentCreates: 0},
+	}
+	limiter := &datastoreLimiter{slots: make(map[string]chan struct{})}
+	ctx := context.Background()
+
+	release, err := limiter.acquire(ctx, cfg, []string{"ds:///a/", "ds:///b/"})
+	if err != nil {
+		t.Fatalf("Failed to acquire the slots of free datastores. Err: %v", err)
+	}
+	// Datastores without a limit never wait
+	releaseUnlimited, err := limiter.acquire(ctx, cfg, []string{"ds:///unlimited/"})
+	if err != nil {
+		t.Fatalf("Failed to acquire a slot of an unlimited datastore. Err: %v", err)
+	}
+	releaseUnlimited()
+
+	// The slot of ds:///b/ is taken, so this waits until the context expires
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.acquire(timeoutCtx, cfg, []string{"ds:///b/", "ds:///0/"}); err == nil {
+		t.Fatalf("Acquired the slot of a busy datastore")
+	}
+	// The slot of ds:///0/, taken before waiting for ds:///b/, must have been given back
+	releaseZero, err := limiter.acquire(ctx, cfg, []string{"ds:///0/"})
+	if err != nil {
+		t.Fatalf("Failed to acquire the slot of a free datastore. Err: %v", err)
+	}
+	releaseZero()
+
+	release()
+	releaseB, err := limiter.acquire(ctx, cfg, []string{"ds:///b/"})
+	if err != nil {
+		t.Fatalf("Failed to acquire a released slot. Err: %v", err)
+	}
+	releaseB()
+}
@@ -18,12 +18,16 @@ package common
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	csictx "github.com/rexray/gocsi/context"
 	"github.com/vmware/govmomi/vim25/types"
 	"k8s.io/klog"
 
+	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 )
 
@@ -44,9 +48,22 @@ func GetVCenter(ctx context.Context, manager *Manager) (*cnsvsphere.VirtualCente
 	return vcenter, nil
 }
 
-// GetUUIDFromProviderID Returns VM UUID from Node's providerID
+// GetUUIDFromProviderID Returns VM UUID from Node's providerID in its
+// canonical form. The "vsphere://" prefix is matched case insensitively and
+// extra slashes after it are dropped. If the remainder isn't a valid UUID
+// it's returned lower cased, so nodes with an unexpected providerID are
+// still handled consistently.
 func GetUUIDFromProviderID(providerID string) string {
-	return strings.TrimPrefix(providerID, ProviderPrefix)
+	uuid := strings.TrimSpace(providerID)
+	if len(uuid) >= len(ProviderPrefix) && strings.EqualFold(uuid[:len(ProviderPrefix)], ProviderPrefix) {
+		uuid = strings.TrimLeft(uuid[len(ProviderPrefix):], "/")
+	}
+	normalized, err := cnsvsphere.NormalizeUUID(uuid)
+	if err != nil {
+		klog.Warningf("Unexpected UUID in providerID %q. Err: %v", providerID, err)
+		return strings.ToLower(uuid)
+	}
+	return normalized
 }
 
 // FormatDiskUUID removes any spaces and hyphens in UUID
@@ -76,6 +93,20 @@ func GetLabelsMapFromKeyValue(labels []types.KeyValue) map[string]string {
 	return labelsMap
 }
 
+// IsRetainDiskSet returns true if the given retain-disk annotation or secret
+// value asks for the disk to be kept
+func IsRetainDiskSet(value string) bool {
+	if value == "" {
+		return false
+	}
+	retainDisk, err := strconv.ParseBool(value)
+	if err != nil {
+		klog.Warningf("Ignoring invalid retain-disk value %q. Err: %v", value, err)
+		return false
+	}
+	return retainDisk
+}
+
 // IsValidVolumeCapabilities is the helper function to validate capabilities of volume.
 func IsValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
 	hasSupport := func(cap *csi.VolumeCapability) bool {
@@ -94,3 +125,16 @@ func IsValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
 	}
 	return foundAll
 }
+
+// WithRequestOpID returns a context carrying the vCenter operation ID used for
+// all vCenter calls made while serving the given CSI RPC. The ID embeds the
+// gocsi request ID when one is present, so a failed CSI call can be matched
+// with the vpxd and vsan-health logs. ctx is returned unchanged if it already
+// carries an operation ID.
+func WithRequestOpID(ctx context.Context, rpc string) context.Context {
+	prefix := "csi-" + rpc
+	if reqID, ok := csictx.GetRequestID(ctx); ok {
+		prefix = fmt.Sprintf("%s-%d", prefix, reqID)
+	}
+	return cnsvolume.WithOpID(ctx, prefix)
+}
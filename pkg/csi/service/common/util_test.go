@@ -0,0 +1,43 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"testing"
+)
+
+func TestGetUUIDFromProviderID(t *testing.T) {
+	const uuid = "42208c6b-d10d-37d0-156f-435f999d94c1"
+	tests := []struct {
+		providerID string
+		expected   string
+	}{
+		{"vsphere://" + uuid, uuid},
+		{"vsphere://42208C6B-D10D-37D0-156F-435F999D94C1", uuid},
+		{"VSphere://" + uuid, uuid},
+		{"vsphere:///" + uuid, uuid},
+		{" vsphere://42208c6bd10d37d0156f435f999d94c1 ", uuid},
+		{uuid, uuid},
+		{"vsphere://Not-A-UUID", "not-a-uuid"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if actual := GetUUIDFromProviderID(test.providerID); actual != test.expected {
+			t.Errorf("GetUUIDFromProviderID(%q) = %q, expected %q", test.providerID, actual, test.expected)
+		}
+	}
+}
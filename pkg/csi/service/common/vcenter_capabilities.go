@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// VCenterFeature is a vSphere feature whose availability depends on the
+// version of the connected vCenter.
+type VCenterFeature string
+
+const (
+	// VCenterFeatureSnapshots is creating and deleting volume snapshots.
+	VCenterFeatureSnapshots VCenterFeature = "snapshots"
+)
+
+// VCenterVersion represents a vCenter API version as major.minor.update.
+// For Example: vCenter 6.7 Update 3 is 6.7.3, vCenter 7.0 Update 2 is 7.0.2
+type VCenterVersion struct {
+	Major  int
+	Minor  int
+	Update int
+}
+
+func (v VCenterVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Update)
+}
+
+// AtLeast returns true if v is equal to or higher than other.
+func (v VCenterVersion) AtLeast(other VCenterVersion) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor > other.Minor
+	}
+	return v.Update >= other.Update
+}
+
+// vCenterFeatureMinVersions is the capability matrix mapping each feature
+// to the minimum vCenter version supporting it.
+var vCenterFeatureMinVersions = map[VCenterFeature]VCenterVersion{
+	VCenterFeatureSnapshots: {Major: 7, Minor: 0, Update: 3},
+}
+
+// ParseVCenterVersion parses vCenter API version strings like "6.7.3" or "7.0.1.0".
+// The update version is optional and defaults to 0.
+func ParseVCenterVersion(version string) (VCenterVersion, error) {
+	items := strings.Split(version, ".")
+	if len(items) < 2 {
+		return VCenterVersion{}, fmt.Errorf("Invalid API Version format")
+	}
+	major, err := strconv.Atoi(items[0])
+	if err != nil {
+		return VCenterVersion{}, fmt.Errorf("Invalid Major Version value")
+	}
+	minor, err := strconv.Atoi(items[1])
+	if err != nil {
+		return VCenterVersion{}, fmt.Errorf("Invalid Minor Version value")
+	}
+	vcVersion := VCenterVersion{Major: major, Minor: minor}
+	if len(items) >= 3 {
+		vcVersion.Update, err = strconv.Atoi(items[2])
+		if err != nil {
+			return VCenterVersion{}, fmt.Errorf("Invalid patch version value")
+		}
+	}
+	return vcVersion, nil
+}
+
+// IsVCenterFeatureSupported returns true if the feature is supported by
+// a vCenter with the specified API version.
+func IsVCenterFeatureSupported(apiVersion string, feature VCenterFeature) bool {
+	minVersion, ok := vCenterFeatureMinVersions[feature]
+	if !ok {
+		return false
+	}
+	vcVersion, err := ParseVCenterVersion(apiVersion)
+	if err != nil {
+		return false
+	}
+	return vcVersion.AtLeast(minVersion)
+}
+
+// CheckVCenterFeatureSupported returns an Unimplemented error mentioning the
+// required vCenter version if the feature is not supported by a vCenter with
+// the specified API version, otherwise returns nil.
+func CheckVCenterFeatureSupported(apiVersion string, feature VCenterFeature) error {
+	if IsVCenterFeatureSupported(apiVersion, feature) {
+		return nil
+	}
+	msg := fmt.Sprintf("%s is not supported on vCenter API version %q", feature, apiVersion)
+	if minVersion, ok := vCenterFeatureMinVersions[feature]; ok {
+		msg = fmt.Sprintf("%s requires vCenter %s or later, connected vCenter API version is %q",
+			feature, minVersion, apiVersion)
+	}
+	return status.Error(codes.Unimplemented, msg)
+}
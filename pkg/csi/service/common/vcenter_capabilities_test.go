@@ -0,0 +1,55 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsVCenterFeatureSupported(t *testing.T) {
+	tests := []struct {
+		apiVersion string
+		feature    VCenterFeature
+		supported  bool
+	}{
+		{apiVersion: "6.7.3", feature: VCenterFeatureSnapshots, supported: false},
+		{apiVersion: "7.0.2.0", feature: VCenterFeatureSnapshots, supported: false},
+		{apiVersion: "7.0.3.0", feature: VCenterFeatureSnapshots, supported: true},
+		{apiVersion: "7.1", feature: VCenterFeatureSnapshots, supported: true},
+		{apiVersion: "8.0", feature: VCenterFeatureSnapshots, supported: true},
+		{apiVersion: "7", feature: VCenterFeatureSnapshots, supported: false},
+		{apiVersion: "7.0.0.0", feature: VCenterFeature("unknown"), supported: false},
+	}
+	for _, tt := range tests {
+		if supported := IsVCenterFeatureSupported(tt.apiVersion, tt.feature); supported != tt.supported {
+			t.Errorf("IsVCenterFeatureSupported(%q, %q) = %v, expected %v",
+				tt.apiVersion, tt.feature, supported, tt.supported)
+		}
+		err := CheckVCenterFeatureSupported(tt.apiVersion, tt.feature)
+		if tt.supported && err != nil {
+			t.Errorf("CheckVCenterFeatureSupported(%q, %q) returned unexpected error: %v",
+				tt.apiVersion, tt.feature, err)
+		}
+		if !tt.supported && status.Code(err) != codes.Unimplemented {
+			t.Errorf("CheckVCenterFeatureSupported(%q, %q) = %v, expected Unimplemented error",
+				tt.apiVersion, tt.feature, err)
+		}
+	}
+}
@@ -21,10 +21,10 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/davecgh/go-spew/spew"
 	cnstypes "github.com/vmware/govmomi/cns/types"
 	vim25types "github.com/vmware/govmomi/vim25/types"
 	"k8s.io/klog"
+	cnsvolume "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 )
 
@@ -49,9 +49,13 @@ func CreateVolumeUtil(ctx context.Context, manager *Manager, spec *CreateVolumeS
 		}
 	}
 	var datastores []vim25types.ManagedObjectReference
+	var datastoreURLs []string
 	if spec.DatastoreURL == "" {
 		//  If DatastoreURL is not specified in StorageClass, get all shared datastores
 		datastores = getDatastoreMoRefs(sharedDatastores)
+		for _, datastore := range sharedDatastores {
+			datastoreURLs = append(datastoreURLs, datastore.Info.Url)
+		}
 	} else {
 		// Check datastore specified in the StorageClass should be shared datastore across all nodes.
 
@@ -69,6 +73,10 @@ func CreateVolumeUtil(ctx context.Context, manager *Manager, spec *CreateVolumeS
 		for _, datacenter := range datacenters {
 			datastoreObj, err = datacenter.GetDatastoreByURL(ctx, spec.DatastoreURL)
 			if err != nil {
+				if !errors.Is(err, vsphere.ErrDatastoreNotFound) {
+					klog.Errorf("Failed to get datastore with URL %q in datacenter %q from VC %q, Error: %+v", spec.DatastoreURL, datacenter.InventoryPath, vc.Config.Host, err)
+					return "", err
+				}
 				klog.Warningf("Failed to find datastore with URL %q in datacenter %q from VC %q, Error: %+v", spec.DatastoreURL, datacenter.InventoryPath, vc.Config.Host, err)
 				continue
 			}
@@ -89,6 +97,7 @@ func CreateVolumeUtil(ctx context.Context, manager *Manager, spec *CreateVolumeS
 		}
 		if isSharedDatastoreURL {
 			datastores = append(datastores, datastoreObj.Reference())
+			datastoreURLs = append(datastoreURLs, spec.DatastoreURL)
 		} else {
 			errMsg := fmt.Sprintf("Datastore: %s specified in the storage class is not accessible to all nodes.", spec.DatastoreURL)
 			klog.Errorf(errMsg)
@@ -103,7 +112,7 @@ func CreateVolumeUtil(ctx context.Context, manager *Manager, spec *CreateVolumeS
 			CapacityInMb: spec.CapacityMB,
 		},
 		Metadata: cnstypes.CnsVolumeMetadata{
-			ContainerCluster: vsphere.GetContainerCluster(manager.CnsConfig.Global.ClusterID, manager.CnsConfig.VirtualCenter[vc.Config.Host].User),
+			ContainerCluster: vc.GetContainerCluster(manager.CnsConfig.Global.ClusterID),
 		},
 	}
 	if spec.StoragePolicyID != "" {
@@ -112,8 +121,14 @@ func CreateVolumeUtil(ctx context.Context, manager *Manager, spec *CreateVolumeS
 		}
 		createSpec.Profile = append(createSpec.Profile, profileSpec)
 	}
-	klog.V(4).Infof("vSphere CNS driver creating volume %s with create spec %+v", spec.Name, spew.Sdump(createSpec))
-	volumeID, err := manager.VolumeManager.CreateVolume(createSpec)
+	release, err := createLimiter.acquire(ctx, manager.CnsConfig, datastoreURLs)
+	if err != nil {
+		klog.Errorf("Failed to create disk %s while waiting for the datastores to accept it with error %+v", spec.Name, err)
+		return "", err
+	}
+	defer release()
+	klog.V(4).Infof("vSphere CNS driver creating volume %s with create spec %v", spec.Name, cnsvolume.Dump(createSpec))
+	volumeID, err := manager.VolumeManager.CreateVolume(ctx, createSpec)
 	if err != nil {
 		klog.Errorf("Failed to create disk %s with error %+v", spec.Name, err)
 		return "", err
@@ -126,7 +141,7 @@ func AttachVolumeUtil(ctx context.Context, manager *Manager,
 	vm *vsphere.VirtualMachine,
 	volumeID string) (string, error) {
 	klog.V(4).Infof("vSphere CNS driver is attaching volume: %s to node vm: %s", volumeID, vm.InventoryPath)
-	diskUUID, err := manager.VolumeManager.AttachVolume(vm, volumeID)
+	diskUUID, err := manager.VolumeManager.AttachVolume(ctx, vm, volumeID)
 	if err != nil {
 		klog.Errorf("Failed to attach disk %s with err %+v", volumeID, err)
 		return "", err
@@ -140,7 +155,7 @@ func DetachVolumeUtil(ctx context.Context, manager *Manager,
 	vm *vsphere.VirtualMachine,
 	volumeID string) error {
 	klog.V(4).Infof("vSphere CNS driver is detaching volume: %s from node vm: %s", volumeID, vm.InventoryPath)
-	err := manager.VolumeManager.DetachVolume(vm, volumeID)
+	err := manager.VolumeManager.DetachVolume(ctx, vm, volumeID)
 	if err != nil {
 		klog.Errorf("Failed to detach disk %s with err %+v", volumeID, err)
 		return err
@@ -149,11 +164,26 @@ func DetachVolumeUtil(ctx context.Context, manager *Manager,
 	return nil
 }
 
+// ForceDetachVolumeUtil is the helper function to remove the disk backing
+// the CNS volume from specified vm without going through CNS
+func ForceDetachVolumeUtil(ctx context.Context, manager *Manager,
+	vm *vsphere.VirtualMachine,
+	volumeID string) error {
+	klog.V(4).Infof("vSphere CNS driver is force detaching volume: %s from node vm: %s", volumeID, vm.InventoryPath)
+	err := cnsvolume.RemoveDiskFromVM(ctx, vm, volumeID)
+	if err != nil {
+		klog.Errorf("Failed to force detach disk %s with err %+v", volumeID, err)
+		return err
+	}
+	klog.V(4).Infof("Successfully force detached disk %s from VM %v.", volumeID, vm)
+	return nil
+}
+
 // DeleteVolumeUtil is the helper function to delete CNS volume for given volumeId
 func DeleteVolumeUtil(ctx context.Context, manager *Manager, volumeID string, deleteDisk bool) error {
 	var err error
 	klog.V(4).Infof("vSphere Cloud Provider deleting volume: %s", volumeID)
-	err = manager.VolumeManager.DeleteVolume(volumeID, deleteDisk)
+	err = manager.VolumeManager.DeleteVolume(ctx, volumeID, deleteDisk)
 	if err != nil {
 		klog.Errorf("Failed to delete disk %s with error %+v", volumeID, err)
 		return err
@@ -18,12 +18,46 @@ package service
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
 )
 
 // set via ldflags
-var version string
+var (
+	version   string
+	gitCommit string
+	buildDate string
+)
+
+// Keys of the build manifest reported by GetPluginInfo
+const (
+	ManifestGitCommit       = "git-commit"
+	ManifestBuildDate       = "build-date"
+	ManifestVSphereAPILevel = "vsphere-api-level"
+)
+
+// GetVersion returns the version of the driver
+func GetVersion() string {
+	return version
+}
+
+// GetBuildManifest returns the git commit, build date and targeted vSphere
+// API level of the driver
+func GetBuildManifest() map[string]string {
+	return map[string]string{
+		ManifestGitCommit:       gitCommit,
+		ManifestBuildDate:       buildDate,
+		ManifestVSphereAPILevel: getVSphereAPILevel(),
+	}
+}
+
+// getVSphereAPILevel returns the minimum vSphere version targeted by the driver
+func getVSphereAPILevel() string {
+	return fmt.Sprintf("%d.%d.%d", common.MinSupportedVCenterMajor, common.MinSupportedVCenterMinor, common.MinSupportedVCenterPatch)
+}
 
 func (s *service) Probe(
 	ctx context.Context,
@@ -41,6 +75,7 @@ func (s *service) GetPluginInfo(
 	return &csi.GetPluginInfoResponse{
 		Name:          Name,
 		VendorVersion: version,
+		Manifest:      GetBuildManifest(),
 	}, nil
 }
 
@@ -49,23 +84,7 @@ func (s *service) GetPluginCapabilities(
 	req *csi.GetPluginCapabilitiesRequest) (
 	*csi.GetPluginCapabilitiesResponse, error) {
 
-	rep := &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-					},
-				},
-			},
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
-					},
-				},
-			},
-		},
-	}
-	return rep, nil
+	return &csi.GetPluginCapabilitiesResponse{
+		Capabilities: getPluginCapabilities(s.mode, s.cfg),
+	}, nil
 }
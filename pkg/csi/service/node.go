@@ -91,12 +91,7 @@ func (s *service) NodeStageVolume(
 		return nil, err
 	}
 
-	accMode := volCap.GetAccessMode().GetMode()
-	ro := false
-	if accMode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
-		accMode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
-		ro = true
-	}
+	ro := common.IsReadOnlyAccessMode(volCap.GetAccessMode().GetMode())
 
 	// Get mounts to check if already staged
 	mnts, err := gofsutil.GetDevMounts(context.Background(), dev.RealDev)
@@ -402,19 +397,9 @@ func (s *service) NodeGetInfo(
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
 		klog.V(4).Infof("Successfully retrieved uuid:%s  from the node: %s", uuid, nodeID)
-		nodeVM, err := cnsvsphere.GetVirtualMachineByUUID(uuid, false)
-		if err != nil || nodeVM == nil {
-			klog.Errorf("Failed to get nodeVM for uuid: %s. err: %+v", uuid, err)
-			uuid, err = convertUUID(uuid)
-			if err != nil {
-				klog.Errorf("convertUUID failed with error: %v", err)
-				return nil, status.Errorf(codes.Internal, err.Error())
-			}
-			nodeVM, err = cnsvsphere.GetVirtualMachineByUUID(uuid, false)
-			if err != nil || nodeVM == nil {
-				klog.Errorf("Failed to get nodeVM for uuid: %s. err: %+v", uuid, err)
-				return nil, status.Errorf(codes.Internal, err.Error())
-			}
+		nodeVM, err := getNodeVMByUUID(uuid)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, err.Error())
 		}
 		zone, region, err := nodeVM.GetZoneRegion(ctx, cfg.Labels.Zone, cfg.Labels.Region)
 		if err != nil {
@@ -424,16 +409,25 @@ func (s *service) NodeGetInfo(
 		klog.V(4).Infof("zone: [%s], region: [%s], Node VM: [%s]", zone, region, nodeID)
 		if zone != "" && region != "" {
 			accessibleTopology = make(map[string]string)
-			accessibleTopology[csitypes.LabelRegionFailureDomain] = region
-			accessibleTopology[csitypes.LabelZoneFailureDomain] = zone
+			topologyKeys := csitypes.GetTopologyKeys(cfg)
+			accessibleTopology[topologyKeys.Region] = region
+			accessibleTopology[topologyKeys.Zone] = zone
 		}
 	}
 	if len(accessibleTopology) > 0 {
 		topology.Segments = accessibleTopology
 	}
 
+	// Advertise the number of volumes the node VM can have besides its boot
+	// disk when max-disks-per-node is set, for the scheduler to not place
+	// more volumes on the node. Left unlimited otherwise.
+	var maxVolumesPerNode int64
+	if cfg.Global.MaxDisksPerNode > 1 {
+		maxVolumesPerNode = int64(cfg.Global.MaxDisksPerNode - 1)
+	}
 	return &csi.NodeGetInfoResponse{
 		NodeId:             nodeID,
+		MaxVolumesPerNode:  maxVolumesPerNode,
 		AccessibleTopology: topology,
 	}, nil
 }
@@ -648,11 +642,25 @@ func contains(list []string, item string) bool {
 func verifyVolumeAttached(diskID string) (string, error) {
 
 	// Check that volume is attached
-	volPath, err := getDiskPath(diskID, nil)
+	disks, err := nodeRescanCoordinator.listDisks()
 	if err != nil {
 		return "", status.Errorf(codes.Internal,
 			"Error trying to read attached disks: %v", err)
 	}
+	volPath, _ := getDiskPath(diskID, disks)
+	if volPath == "" {
+		// The disk may have been attached after the last scan of the SCSI bus
+		klog.V(2).Infof("disk: %s not found, rescanning SCSI hosts", diskID)
+		if err := nodeRescanCoordinator.rescan(); err != nil {
+			klog.Warningf("Failed to rescan SCSI hosts. Error: %v", err)
+		}
+		disks, err = nodeRescanCoordinator.listDisks()
+		if err != nil {
+			return "", status.Errorf(codes.Internal,
+				"Error trying to read attached disks: %v", err)
+		}
+		volPath, _ = getDiskPath(diskID, disks)
+	}
 	if volPath == "" {
 		return "", status.Errorf(codes.NotFound,
 			"disk: %s not attached to node", diskID)
@@ -784,20 +792,33 @@ func getSystemUUID() (string, error) {
 	return strings.ToLower(id), nil
 }
 
-// convertUUID helps convert UUID to vSphere format
-//input uuid:    6B8C2042-0DD1-D037-156F-435F999D94C1
-//returned uuid: 42208c6b-d10d-37d0-156f-435f999d94c1
-func convertUUID(uuid string) (string, error) {
-	if len(uuid) != 36 {
-		return "", errors.New("uuid length should be 36")
-	}
-	convertedUUID := fmt.Sprintf("%s%s%s%s-%s%s-%s%s-%s-%s",
-		uuid[6:8], uuid[4:6], uuid[2:4], uuid[0:2],
-		uuid[11:13], uuid[9:11],
-		uuid[16:18], uuid[14:16],
-		uuid[19:23],
-		uuid[24:36])
-	return strings.ToLower(convertedUUID), nil
+// getNodeVMByUUID returns the VM of the node with the given system UUID on
+// the registered vCenter. The system UUID is tried in both byte orders, as
+// the byte order reported by the guest depends on the VM hardware version.
+func getNodeVMByUUID(uuid string) (*cnsvsphere.VirtualMachine, error) {
+	nodeVM, err := cnsvsphere.GetVirtualMachineByUUID(uuid, false)
+	if err != nil && !errors.Is(err, cnsvsphere.ErrVMNotFound) {
+		klog.Errorf("Failed to get nodeVM for uuid: %s. err: %+v", uuid, err)
+		return nil, err
+	}
+	if err == nil && nodeVM != nil {
+		return nodeVM, nil
+	}
+	klog.Errorf("Failed to get nodeVM for uuid: %s. err: %+v", uuid, err)
+	uuid, err = cnsvsphere.SwapUUIDByteOrder(uuid)
+	if err != nil {
+		klog.Errorf("Failed to convert uuid to vSphere format. err: %v", err)
+		return nil, err
+	}
+	nodeVM, err = cnsvsphere.GetVirtualMachineByUUID(uuid, false)
+	if err == nil && nodeVM == nil {
+		err = fmt.Errorf("couldn't find the node VM with uuid %s: %w", uuid, cnsvsphere.ErrVMNotFound)
+	}
+	if err != nil {
+		klog.Errorf("Failed to get nodeVM for uuid: %s. err: %+v", uuid, err)
+		return nil, err
+	}
+	return nodeVM, nil
 }
 
 func getDiskID(volID string, pubCtx map[string]string) (string, error) {
@@ -0,0 +1,226 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+
+	cnsnode "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/node"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
+)
+
+// nodeReadinessInterval is the interval between two checks of the node prerequisites
+const nodeReadinessInterval = 10 * time.Second
+
+// removeStartupTaintWhenReady waits until the node meets the prerequisites
+// for attaching vSphere volumes and then removes the startup taint from the
+// node, so pods with volumes are only scheduled once the node can serve them.
+// It returns once the taint is removed, or if the driver is not allowed to update the node.
+func removeStartupTaintWhenReady(nodeName string) {
+	k8sclient, err := k8s.NewClient()
+	if err != nil {
+		klog.Warningf("Failed to create kubernetes client, not managing taint %q on node %q. Error: %v",
+			csitypes.NodeStartupTaintKey, nodeName, err)
+		return
+	}
+	annotateNodeDriverVersion(k8sclient, nodeName)
+	checkNodeVM := getNodeVMCheck(nodeName)
+	ticker := time.NewTicker(nodeReadinessInterval)
+	defer ticker.Stop()
+	for {
+		if err := checkNodePrerequisites(k8sclient, nodeName, devDiskID, scsiHostDir, checkNodeVM); err != nil {
+			klog.Warningf("Node %q is not ready for vSphere volumes. Error: %v", nodeName, err)
+		} else if err := removeNodeTaint(k8sclient, nodeName, csitypes.NodeStartupTaintKey); err == nil || apierrors.IsForbidden(err) {
+			return
+		}
+		<-ticker.C
+	}
+}
+
+// checkNodePrerequisites verifies that volumes can be attached to the node
+// and found on it. Attached disks must be listed in diskDir, at least one
+// SCSI controller must be present, and the node VM UUID and the kubernetes
+// node must be readable. No disk is required to be present yet, e.g. the
+// boot disk of a node may be an NVMe disk, which is not listed with a UUID.
+// The node VM, looked up by its UUID, is checked with checkNodeVM unless it
+// is nil.
+func checkNodePrerequisites(k8sclient clientset.Interface, nodeName string, diskDir string, hostDir string,
+	checkNodeVM func(uuid string) error) error {
+	if _, err := ioutil.ReadDir(diskDir); err != nil {
+		return fmt.Errorf("failed to list disks in %s: %v", diskDir, err)
+	}
+	hosts, err := filepath.Glob(filepath.Join(hostDir, "host*"))
+	if err != nil {
+		return err
+	}
+	if len(hosts) == 0 {
+		return fmt.Errorf("no SCSI controller found in %s", hostDir)
+	}
+	uuid, err := getSystemUUID()
+	if err != nil {
+		return fmt.Errorf("failed to get the UUID of the node VM: %v", err)
+	}
+	if checkNodeVM != nil {
+		if err := checkNodeVM(uuid); err != nil {
+			return err
+		}
+	}
+	if _, err := k8sclient.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{}); err != nil {
+		return fmt.Errorf("failed to get node %q: %v", nodeName, err)
+	}
+	return nil
+}
+
+// getNodeVMCheck returns the check of the node VM on vCenter, which
+// requires disk.EnableUUID to be set on the VM. nil is returned if the
+// node plugin is not given the vCenter configuration, so the VM can't be
+// checked.
+func getNodeVMCheck(nodeName string) func(uuid string) error {
+	cfgPath := os.Getenv(cnsconfig.EnvCloudConfig)
+	if cfgPath == "" {
+		cfgPath = cnsconfig.DefaultCloudConfigPath
+	}
+	cfg, err := cnsconfig.GetCnsconfig(cfgPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			klog.Warningf("Config file not provided to node daemonset, not checking disk.EnableUUID on the VM of node %q", nodeName)
+			return nil
+		}
+		klog.Errorf("Failed to read cnsconfig. Error: %v", err)
+		return func(string) error {
+			return fmt.Errorf("failed to read the config to check the node VM: %v", err)
+		}
+	}
+	return func(uuid string) error {
+		return checkNodeVMDiskUUID(cfg, uuid)
+	}
+}
+
+// checkNodeVMDiskUUID returns an error unless disk.EnableUUID is TRUE on the
+// VM with the given system UUID. Without it, volumes attached to the node
+// can't be found by UUID, so every NodeStageVolume would fail.
+func checkNodeVMDiskUUID(cfg *cnsconfig.Config, uuid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), nodeReadinessInterval)
+	defer cancel()
+	vcenterconfig, err := cnsvsphere.GetVirtualCenterConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to get VirtualCenterConfig from cns config: %v", err)
+	}
+	vcManager := cnsvsphere.GetVirtualCenterManager()
+	vcenter, err := vcManager.RegisterVirtualCenter(vcenterconfig)
+	if err != nil {
+		return fmt.Errorf("failed to register vcenter %s: %v", vcenterconfig.Host, err)
+	}
+	defer vcManager.UnregisterVirtualCenter(vcenterconfig.Host)
+	if err := vcenter.Connect(ctx); err != nil {
+		return fmt.Errorf("failed to connect to vcenter %s: %v", vcenterconfig.Host, err)
+	}
+	nodeVM, err := getNodeVMByUUID(uuid)
+	if err != nil {
+		return fmt.Errorf("failed to get the node VM with uuid %s: %v", uuid, err)
+	}
+	return nodeVM.ValidateDiskUUIDEnabled(ctx)
+}
+
+// annotateNodeDriverVersion records the version of the node plugin on the
+// node, so the controller knows which node plugin version each node runs
+func annotateNodeDriverVersion(k8sclient clientset.Interface, nodeName string) {
+	node, err := k8sclient.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("Failed to get node %q, not annotating it with the driver version. Error: %v", nodeName, err)
+		return
+	}
+	if node.Annotations[cnsnode.DriverVersionAnnotation] == version {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{cnsnode.DriverVersionAnnotation: version},
+		},
+	})
+	if err != nil {
+		klog.Warningf("Failed to create the driver version patch of node %q. Error: %v", nodeName, err)
+		return
+	}
+	if _, err := k8sclient.CoreV1().Nodes().Patch(nodeName, types.MergePatchType, patch); err != nil {
+		klog.Warningf("Failed to annotate node %q with driver version %q. Error: %v", nodeName, version, err)
+		return
+	}
+	klog.V(2).Infof("Annotated node %q with driver version %q", nodeName, version)
+}
+
+// removeNodeTaint removes all taints with the given key from the node. The
+// taints are patched with a test of the taints read, so taints changed in the
+// meantime are never overwritten; the patch fails and is retried instead.
+func removeNodeTaint(k8sclient clientset.Interface, nodeName string, taintKey string) error {
+	node, err := k8sclient.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("Failed to get node %q. Error: %v", nodeName, err)
+		return err
+	}
+	var taints []v1.Taint
+	for _, taint := range node.Spec.Taints {
+		if taint.Key != taintKey {
+			taints = append(taints, taint)
+		}
+	}
+	if len(taints) == len(node.Spec.Taints) {
+		klog.V(2).Infof("Node %q does not have taint %q", nodeName, taintKey)
+		return nil
+	}
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "test", "path": "/spec/taints", "value": node.Spec.Taints},
+		{"op": "replace", "path": "/spec/taints", "value": taints},
+	})
+	if err != nil {
+		klog.Errorf("Failed to create the taint patch of node %q. Error: %v", nodeName, err)
+		return err
+	}
+	if _, err := k8sclient.CoreV1().Nodes().Patch(nodeName, types.JSONPatchType, patch); err != nil {
+		klog.Errorf("Failed to remove taint %q from node %q. Error: %v", taintKey, nodeName, err)
+		return err
+	}
+	klog.Infof("Removed taint %q from node %q", taintKey, nodeName)
+	return nil
+}
+
+// startNodeReadiness starts removing the startup taint in the background
+// if the node name is known
+func startNodeReadiness() {
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		klog.Warningf("ENV NODE_NAME is not set, not managing taint %q", csitypes.NodeStartupTaintKey)
+		return
+	}
+	go removeStartupTaintWhenReady(nodeName)
+}
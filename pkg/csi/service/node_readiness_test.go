@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+
+	cnsnode "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/node"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+)
+
+func TestRemoveNodeTaint(t *testing.T) {
+	otherTaint := v1.Taint{Key: "example.com/other", Effect: v1.TaintEffectNoExecute}
+	k8sclient := testclient.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec: v1.NodeSpec{Taints: []v1.Taint{
+			{Key: csitypes.NodeStartupTaintKey, Effect: v1.TaintEffectNoSchedule},
+			otherTaint,
+		}},
+	})
+	for i := 0; i < 2; i++ {
+		// Removing the taint again is a no-op
+		if err := removeNodeTaint(k8sclient, "node-1", csitypes.NodeStartupTaintKey); err != nil {
+			t.Fatalf("Failed to remove the taint: %v", err)
+		}
+	}
+	node, _ := k8sclient.CoreV1().Nodes().Get("node-1", metav1.GetOptions{})
+	if len(node.Spec.Taints) != 1 || node.Spec.Taints[0].Key != otherTaint.Key {
+		t.Errorf("Expected only taint %q to be left, got %v", otherTaint.Key, node.Spec.Taints)
+	}
+	for _, action := range k8sclient.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("Expected the node to be patched, not updated")
+		}
+	}
+	if err := removeNodeTaint(k8sclient, "node-2", csitypes.NodeStartupTaintKey); err == nil {
+		t.Errorf("Expected an error for a missing node")
+	}
+}
+
+func TestAnnotateNodeDriverVersion(t *testing.T) {
+	savedVersion := version
+	defer func() { version = savedVersion }()
+	version = "v1.0.1"
+	k8sclient := testclient.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: map[string]string{"example.com/owner": "infra"}},
+	})
+	annotateNodeDriverVersion(k8sclient, "node-1")
+	node, _ := k8sclient.CoreV1().Nodes().Get("node-1", metav1.GetOptions{})
+	if node.Annotations[cnsnode.DriverVersionAnnotation] != version || node.Annotations["example.com/owner"] != "infra" {
+		t.Errorf("Unexpected annotations on the node: %v", node.Annotations)
+	}
+	// The node is not patched again for the same version
+	k8sclient.ClearActions()
+	annotateNodeDriverVersion(k8sclient, "node-1")
+	for _, action := range k8sclient.Actions() {
+		if action.GetVerb() == "patch" {
+			t.Errorf("Unexpected patch of a node already annotated with version %q", version)
+		}
+	}
+}
+
+func TestCheckNodePrerequisites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-readiness")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// An empty disk directory is enough, no disk needs to be present
+	diskDir := filepath.Join(dir, "by-id")
+	hostDir := filepath.Join(dir, "scsi_host")
+	for _, d := range []string{diskDir, hostDir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", d, err)
+		}
+	}
+	k8sclient := testclient.NewSimpleClientset()
+
+	err = checkNodePrerequisites(k8sclient, "node-1", filepath.Join(dir, "missing"), hostDir, nil)
+	if err == nil || !strings.Contains(err.Error(), "failed to list disks") {
+		t.Errorf("Expected a missing disk directory to fail the check, got %v", err)
+	}
+	err = checkNodePrerequisites(k8sclient, "node-1", diskDir, hostDir, nil)
+	if err == nil || !strings.Contains(err.Error(), "no SCSI controller") {
+		t.Errorf("Expected a node without SCSI controller to fail the check, got %v", err)
+	}
+}
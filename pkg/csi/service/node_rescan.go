@@ -0,0 +1,122 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	scsiHostDir = "/sys/class/scsi_host"
+	// diskListCacheTTL is the duration a listing of attached disks is reused
+	// by concurrent volume operations on the node
+	diskListCacheTTL = 2 * time.Second
+)
+
+// rescanCoordinator lists the disks attached to the node and rescans the
+// SCSI hosts on behalf of concurrent volume operations. After a failover many
+// volumes are staged on a node at once, and sharing the disk listing and
+// rescans between them avoids one SCSI bus rescan per volume.
+type rescanCoordinator struct {
+	diskDir     string
+	scsiHostDir string
+
+	lock sync.Mutex
+	// disks is the last listing of diskDir, read at disksReadAt
+	disks       []os.FileInfo
+	disksReadAt time.Time
+	// rescanDone is closed when the rescan in progress finishes, nil when no rescan is in progress
+	rescanDone chan struct{}
+	rescanErr  error
+}
+
+var nodeRescanCoordinator = newRescanCoordinator(devDiskID, scsiHostDir)
+
+func newRescanCoordinator(diskDir string, scsiHostDir string) *rescanCoordinator {
+	return &rescanCoordinator{
+		diskDir:     diskDir,
+		scsiHostDir: scsiHostDir,
+	}
+}
+
+// listDisks returns the entries of the disk directory. A listing read
+// within diskListCacheTTL is reused.
+func (rc *rescanCoordinator) listDisks() ([]os.FileInfo, error) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if rc.disks != nil && time.Since(rc.disksReadAt) < diskListCacheTTL {
+		return rc.disks, nil
+	}
+	disks, err := ioutil.ReadDir(rc.diskDir)
+	if err != nil {
+		return nil, err
+	}
+	rc.disks = disks
+	rc.disksReadAt = time.Now()
+	return disks, nil
+}
+
+// rescan rescans all SCSI hosts of the node and drops the cached disk
+// listing. Callers arriving while a rescan is in progress wait for it to
+// finish instead of starting another one.
+func (rc *rescanCoordinator) rescan() error {
+	rc.lock.Lock()
+	if rc.rescanDone != nil {
+		done := rc.rescanDone
+		rc.lock.Unlock()
+		<-done
+		rc.lock.Lock()
+		defer rc.lock.Unlock()
+		return rc.rescanErr
+	}
+	done := make(chan struct{})
+	rc.rescanDone = done
+	rc.lock.Unlock()
+
+	err := rc.rescanSCSIHosts()
+
+	rc.lock.Lock()
+	rc.rescanErr = err
+	rc.rescanDone = nil
+	rc.disks = nil
+	rc.lock.Unlock()
+	close(done)
+	return err
+}
+
+// rescanSCSIHosts asks every SCSI host of the node to scan for new devices
+func (rc *rescanCoordinator) rescanSCSIHosts() error {
+	hosts, err := filepath.Glob(filepath.Join(rc.scsiHostDir, "host*"))
+	if err != nil {
+		return err
+	}
+	klog.V(4).Infof("Rescanning SCSI hosts: %v", hosts)
+	var scanErr error
+	for _, host := range hosts {
+		if err := ioutil.WriteFile(filepath.Join(host, "scan"), []byte("- - -"), 0200); err != nil {
+			klog.Errorf("Failed to rescan SCSI host %s. Error: %v", host, err)
+			scanErr = err
+		}
+	}
+	return scanErr
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package service
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -70,6 +71,49 @@ func TestGetDisk(t *testing.T) {
 	}
 }
 
+func TestRescanCoordinator(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "rescan")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	diskDir := filepath.Join(tmpDir, "by-id")
+	hostDir := filepath.Join(tmpDir, "scsi_host", "host0")
+	for _, dir := range []string{diskDir, hostDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	for _, file := range []string{filepath.Join(diskDir, blockPrefix+"702438570234875"), filepath.Join(hostDir, "scan")} {
+		if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	rc := newRescanCoordinator(diskDir, filepath.Join(tmpDir, "scsi_host"))
+
+	disks, err := rc.listDisks()
+	if err != nil || len(disks) != 1 {
+		t.Fatalf("Expected 1 disk, got: %v, err: %v", disks, err)
+	}
+	// A disk showing up within the cache TTL is not listed until a rescan
+	if err := ioutil.WriteFile(filepath.Join(diskDir, blockPrefix+"702345804753484"), nil, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if disks, _ = rc.listDisks(); len(disks) != 1 {
+		t.Errorf("Expected cached listing with 1 disk, got: %v", disks)
+	}
+	if err := rc.rescan(); err != nil {
+		t.Errorf("Unexpected rescan error: %v", err)
+	}
+	scan, err := ioutil.ReadFile(filepath.Join(hostDir, "scan"))
+	if err != nil || string(scan) != "- - -" {
+		t.Errorf("Expected SCSI host to be rescanned, got: %q, err: %v", scan, err)
+	}
+	if disks, _ = rc.listDisks(); len(disks) != 2 {
+		t.Errorf("Expected 2 disks after rescan, got: %v", disks)
+	}
+}
+
 type FakeFileInfo struct {
 	name string
 }
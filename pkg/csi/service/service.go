@@ -28,13 +28,15 @@ import (
 	"k8s.io/klog"
 
 	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/logging"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/cns"
 	vTypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
 )
 
 const (
 	// Name is the name of this CSI SP.
-	Name = "csi.vsphere.vmware.com"
+	Name = vTypes.DriverName
 
 	// UnixSocketPrefix is the prefix before the path on disk
 	UnixSocketPrefix = "unix://"
@@ -55,6 +57,8 @@ type Service interface {
 type service struct {
 	mode string
 	cs   vTypes.Controller
+	// cfg is the driver configuration, nil if it could not be read
+	cfg *cnsconfig.Config
 }
 
 // This works around a bug that if k8s node dies, this will clean up the sock file
@@ -91,22 +95,54 @@ func (s *service) BeforeServe(
 	// Get the SP's operating mode.
 	s.mode = csictx.Getenv(ctx, gocsi.EnvVarMode)
 
-	if !strings.EqualFold(s.mode, "node") {
-		// Controller service is needed
-		var cfg *cnsconfig.Config
-		cfgPath = csictx.Getenv(ctx, cnsconfig.EnvCloudConfig)
-		if cfgPath == "" {
-			cfgPath = cnsconfig.DefaultCloudConfigPath
+	// Expose the build of the driver
+	prometheus.CsiInfo.WithLabelValues(version, gitCommit, buildDate, getVSphereAPILevel(), s.mode).Set(1)
+	prometheus.HandleDebug("loglevel", logging.LevelHandler())
+	prometheus.StartMetricsServer(prometheus.DefaultCsiMetricsAddress)
+
+	cfgPath = csictx.Getenv(ctx, cnsconfig.EnvCloudConfig)
+	if cfgPath == "" {
+		cfgPath = cnsconfig.DefaultCloudConfigPath
+	}
+	if !strings.EqualFold(s.mode, "controller") {
+		// Node service is needed
+		startNodeReadiness()
+	}
+	if strings.EqualFold(s.mode, "node") {
+		// The node service reads the configuration when it needs it, it is
+		// only used here to compute the plugin capabilities
+		cfg, err := cnsconfig.GetCnsconfig(cfgPath)
+		if err != nil {
+			klog.V(2).Infof("Failed to read cnsconfig, advertising default plugin capabilities. Error: %v", err)
+		} else {
+			s.cfg = cfg
+			if err := logging.Configure(cfg.Logging.Format, cfg.Logging.Level); err != nil {
+				klog.Errorf("Failed to configure logging. Error: %v", err)
+			}
 		}
+	} else {
+		// Controller service is needed
 		cfg, err := cnsconfig.GetCnsconfig(cfgPath)
 		if err != nil {
 			klog.Errorf("Failed to read cnsconfig. Error: %v", err)
 			return err
 		}
+		s.cfg = cfg
+		if err := logging.Configure(cfg.Logging.Format, cfg.Logging.Level); err != nil {
+			klog.Errorf("Failed to configure logging. Error: %v", err)
+			return err
+		}
 		if err := s.cs.Init(cfg); err != nil {
 			klog.Errorf("Failed to init controller. Error: %v", err)
 			return err
 		}
+		auditInterceptor, err := newAuditInterceptor(cfg)
+		if err != nil {
+			return err
+		}
+		if auditInterceptor != nil {
+			sp.Interceptors = append(sp.Interceptors, auditInterceptor)
+		}
 	}
 	return nil
 }
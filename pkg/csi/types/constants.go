@@ -17,8 +17,18 @@ limitations under the License.
 package types
 
 const (
+	// DriverName is the name of the vSphere CSI driver
+	DriverName = "csi.vsphere.vmware.com"
 	// LabelRegionFailureDomain is label placed on nodes and PV containing region detail
 	LabelRegionFailureDomain = "failure-domain.beta.kubernetes.io/region"
 	// LabelZoneFailureDomain is label placed on nodes and PV containing zone detail
 	LabelZoneFailureDomain = "failure-domain.beta.kubernetes.io/zone"
+	// LabelTopologyRegion is the GA label placed on nodes containing region detail
+	LabelTopologyRegion = "topology.kubernetes.io/region"
+	// LabelTopologyZone is the GA label placed on nodes containing zone detail
+	LabelTopologyZone = "topology.kubernetes.io/zone"
+	// NodeStartupTaintKey is the key of the taint removed by the node plugin
+	// once the node is ready for vSphere volumes. Nodes can be registered
+	// with this taint to keep pods with volumes off them until then.
+	NodeStartupTaintKey = "node.vsphere.csi.vmware.com/agent-not-ready"
 )
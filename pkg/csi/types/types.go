@@ -27,3 +27,33 @@ type Controller interface {
 	csi.ControllerServer
 	Init(config *config.Config) error
 }
+
+// TopologyKeys are the keys the zone and region of nodes and volumes are
+// reported under in CSI topologies and PV node affinities
+type TopologyKeys struct {
+	Zone   string
+	Region string
+}
+
+// GetTopologyKeys returns the topology keys of the configuration, the
+// failure-domain.beta.kubernetes.io labels unless configured otherwise
+func GetTopologyKeys(cfg *config.Config) TopologyKeys {
+	keys := TopologyKeys{Zone: LabelZoneFailureDomain, Region: LabelRegionFailureDomain}
+	if cfg == nil {
+		return keys
+	}
+	if cfg.Labels.ZoneTopologyKey != "" {
+		keys.Zone = cfg.Labels.ZoneTopologyKey
+	}
+	if cfg.Labels.RegionTopologyKey != "" {
+		keys.Region = cfg.Labels.RegionTopologyKey
+	}
+	return keys
+}
+
+// IsZone returns true if key is a zone topology key: the configured one or
+// one of the well known labels, so node affinities set before the topology
+// keys were changed are still recognized
+func (keys TopologyKeys) IsZone(key string) bool {
+	return key == keys.Zone || key == LabelZoneFailureDomain || key == LabelTopologyZone
+}
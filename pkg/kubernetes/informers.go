@@ -17,12 +17,19 @@ limitations under the License.
 package kubernetes
 
 import (
+	"fmt"
+	"sync"
 	"time"
 
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/informers"
 	clientset "k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	storagev1listers "k8s.io/client-go/listers/storage/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1beta1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
 	"k8s.io/sample-controller/pkg/signals"
 )
 
@@ -30,22 +37,76 @@ func noResyncPeriodFunc() time.Duration {
 	return 0
 }
 
-// NewInformer creates a new K8S client based on a service account
+var (
+	// sharedInformerManagers are the informer managers shared by the components
+	// of the process, by the client they were created with
+	sharedInformerManagers    = make(map[clientset.Interface]*InformerManager)
+	sharedInformerManagerLock sync.Mutex
+	// signalStopCh is closed on SIGTERM or SIGINT. The signal handler can only be set up once.
+	signalStopCh     <-chan struct{}
+	signalStopChOnce sync.Once
+)
+
+// NewInformer returns the informer manager shared by all components running
+// in the process with the given client, creating it on first use. Components
+// running in the same process would use a single watch per resource on the
+// API server, as NewClient returns the same client to all of them. The
+// controller and the syncer are still built as separate binaries, so they
+// don't share informers yet. Each call takes a reference on the manager,
+// which is dropped by calling Release.
 func NewInformer(client clientset.Interface) *InformerManager {
-	return &InformerManager{
+	sharedInformerManagerLock.Lock()
+	defer sharedInformerManagerLock.Unlock()
+	if im, found := sharedInformerManagers[client]; found {
+		im.refCount++
+		klog.V(4).Infof("Sharing informer manager, %d references", im.refCount)
+		return im
+	}
+	signalStopChOnce.Do(func() {
+		signalStopCh = signals.SetupSignalHandler()
+	})
+	stopCh := make(chan struct{})
+	var stopOnce sync.Once
+	im := &InformerManager{
 		client:          client,
-		stopCh:          signals.SetupSignalHandler(),
+		stopCh:          stopCh,
+		stop:            func() { stopOnce.Do(func() { close(stopCh) }) },
 		informerFactory: informers.NewSharedInformerFactory(client, noResyncPeriodFunc()),
+		refCount:        1,
 	}
+	go func() {
+		select {
+		case <-signalStopCh:
+			im.stop()
+		case <-stopCh:
+		}
+	}()
+	sharedInformerManagers[client] = im
+	return im
 }
 
-// AddNodeListener hooks up add, update, delete callbacks
-func (im *InformerManager) AddNodeListener(add func(obj interface{}), update func(oldObj, newObj interface{}), remove func(obj interface{})) {
-	if im.nodeInformer == nil {
-		im.nodeInformer = im.informerFactory.Core().V1().Nodes().Informer()
+// Release drops a reference to the informer manager. The informers are
+// stopped once the last reference is dropped, and the next call to
+// NewInformer creates a new informer manager. Components which run for the
+// life of the process, e.g. the controller, never release their reference.
+func (im *InformerManager) Release() {
+	sharedInformerManagerLock.Lock()
+	defer sharedInformerManagerLock.Unlock()
+	im.refCount--
+	if im.refCount > 0 {
+		return
 	}
+	klog.V(2).Infof("Stopping informers, no references left")
+	im.stop()
+	if sharedInformerManagers[im.client] == im {
+		delete(sharedInformerManagers, im.client)
+	}
+}
 
-	im.nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+// AddNodeListener hooks up add, update, delete callbacks
+func (im *InformerManager) AddNodeListener(add func(obj interface{}), update func(oldObj, newObj interface{}), remove func(obj interface{})) {
+	informer := im.informerFactory.Core().V1().Nodes().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    add,
 		UpdateFunc: update,
 		DeleteFunc: remove,
@@ -54,11 +115,8 @@ func (im *InformerManager) AddNodeListener(add func(obj interface{}), update fun
 
 // AddPVCListener hooks up add, update, delete callbacks
 func (im *InformerManager) AddPVCListener(add func(obj interface{}), update func(oldObj, newObj interface{}), remove func(obj interface{})) {
-	if im.pvcInformer == nil {
-		im.pvcInformer = im.informerFactory.Core().V1().PersistentVolumeClaims().Informer()
-	}
-
-	im.pvcInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	informer := im.informerFactory.Core().V1().PersistentVolumeClaims().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    add,
 		UpdateFunc: update,
 		DeleteFunc: remove,
@@ -67,11 +125,8 @@ func (im *InformerManager) AddPVCListener(add func(obj interface{}), update func
 
 // AddPVListener hooks up add, update, delete callbacks
 func (im *InformerManager) AddPVListener(add func(obj interface{}), update func(oldObj, newObj interface{}), remove func(obj interface{})) {
-	if im.pvInformer == nil {
-		im.pvInformer = im.informerFactory.Core().V1().PersistentVolumes().Informer()
-	}
-
-	im.pvInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	informer := im.informerFactory.Core().V1().PersistentVolumes().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    add,
 		UpdateFunc: update,
 		DeleteFunc: remove,
@@ -80,17 +135,39 @@ func (im *InformerManager) AddPVListener(add func(obj interface{}), update func(
 
 // AddPodListener hooks up add, update, delete callbacks
 func (im *InformerManager) AddPodListener(add func(obj interface{}), update func(oldObj, newObj interface{}), remove func(obj interface{})) {
-	if im.podInformer == nil {
-		im.podInformer = im.informerFactory.Core().V1().Pods().Informer()
-	}
+	informer := im.informerFactory.Core().V1().Pods().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    add,
+		UpdateFunc: update,
+		DeleteFunc: remove,
+	})
+}
 
-	im.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+// AddCSINodeListener hooks up add, update, delete callbacks
+func (im *InformerManager) AddCSINodeListener(add func(obj interface{}), update func(oldObj, newObj interface{}), remove func(obj interface{})) {
+	informer := im.informerFactory.Storage().V1beta1().CSINodes().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    add,
 		UpdateFunc: update,
 		DeleteFunc: remove,
 	})
 }
 
+// GetNodeLister returns Node Lister for the calling informer manager
+func (im *InformerManager) GetNodeLister() corelisters.NodeLister {
+	return im.informerFactory.Core().V1().Nodes().Lister()
+}
+
+// GetCSINodeLister returns CSINode Lister for the calling informer manager
+func (im *InformerManager) GetCSINodeLister() storagelisters.CSINodeLister {
+	return im.informerFactory.Storage().V1beta1().CSINodes().Lister()
+}
+
+// GetVolumeAttachmentLister returns VolumeAttachment Lister for the calling informer manager
+func (im *InformerManager) GetVolumeAttachmentLister() storagev1listers.VolumeAttachmentLister {
+	return im.informerFactory.Storage().V1().VolumeAttachments().Lister()
+}
+
 // GetPVLister returns Persistent Volume Lister for the calling informer manager
 func (im *InformerManager) GetPVLister() corelisters.PersistentVolumeLister {
 	return im.informerFactory.Core().V1().PersistentVolumes().Lister()
@@ -101,8 +178,124 @@ func (im *InformerManager) GetPVCLister() corelisters.PersistentVolumeClaimListe
 	return im.informerFactory.Core().V1().PersistentVolumeClaims().Lister()
 }
 
-// Listen starts the Informers
+// IndexPVCsByUID indexes the PVCs of the PVC informer by UID, for
+// GetPVCByUID. Indexes can only be added before the informer is started by
+// Listen.
+func (im *InformerManager) IndexPVCsByUID() error {
+	informer := im.informerFactory.Core().V1().PersistentVolumeClaims().Informer()
+	if _, found := informer.GetIndexer().GetIndexers()[pvcUIDIndex]; found {
+		return nil
+	}
+	return informer.AddIndexers(cache.Indexers{
+		pvcUIDIndex: func(obj interface{}) ([]string, error) {
+			pvc, ok := obj.(*v1.PersistentVolumeClaim)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object %T in the PVC informer", obj)
+			}
+			return []string{string(pvc.UID)}, nil
+		},
+	})
+}
+
+// GetPVCByUID returns the PVC with the given UID from the informer cache, nil
+// if there is none. All PVCs are listed if they couldn't be indexed by UID,
+// e.g. when another component started the PVC informer first.
+func (im *InformerManager) GetPVCByUID(uid string) (*v1.PersistentVolumeClaim, error) {
+	informer := im.informerFactory.Core().V1().PersistentVolumeClaims().Informer()
+	if _, found := informer.GetIndexer().GetIndexers()[pvcUIDIndex]; found {
+		objs, err := informer.GetIndexer().ByIndex(pvcUIDIndex, uid)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			if pvc, ok := obj.(*v1.PersistentVolumeClaim); ok {
+				return pvc, nil
+			}
+		}
+		return nil, nil
+	}
+	pvcs, err := im.GetPVCLister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, pvc := range pvcs {
+		if string(pvc.UID) == uid {
+			return pvc, nil
+		}
+	}
+	return nil, nil
+}
+
+// IndexPVsByVolumeHandle indexes the CSI PVs of the PV informer by volume
+// handle, for GetPVsByVolumeHandle. Indexes can only be added before the
+// informer is started by Listen.
+func (im *InformerManager) IndexPVsByVolumeHandle() error {
+	informer := im.informerFactory.Core().V1().PersistentVolumes().Informer()
+	if _, found := informer.GetIndexer().GetIndexers()[pvVolumeHandleIndex]; found {
+		return nil
+	}
+	return informer.AddIndexers(cache.Indexers{
+		pvVolumeHandleIndex: func(obj interface{}) ([]string, error) {
+			pv, ok := obj.(*v1.PersistentVolume)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object %T in the PV informer", obj)
+			}
+			if pv.Spec.CSI == nil {
+				return nil, nil
+			}
+			return []string{pv.Spec.CSI.VolumeHandle}, nil
+		},
+	})
+}
+
+// GetPVsByVolumeHandle returns the CSI PVs with the given volume handle from
+// the informer cache, of any driver. All PVs are listed if they couldn't be
+// indexed by volume handle, e.g. when another component started the PV
+// informer first.
+func (im *InformerManager) GetPVsByVolumeHandle(volumeHandle string) ([]*v1.PersistentVolume, error) {
+	var found []*v1.PersistentVolume
+	informer := im.informerFactory.Core().V1().PersistentVolumes().Informer()
+	if _, indexed := informer.GetIndexer().GetIndexers()[pvVolumeHandleIndex]; indexed {
+		objs, err := informer.GetIndexer().ByIndex(pvVolumeHandleIndex, volumeHandle)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			if pv, ok := obj.(*v1.PersistentVolume); ok {
+				found = append(found, pv)
+			}
+		}
+		return found, nil
+	}
+	pvs, err := im.GetPVLister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.VolumeHandle == volumeHandle {
+			found = append(found, pv)
+		}
+	}
+	return found, nil
+}
+
+// Listen starts the Informers. Informers added after a previous call are
+// started as well, so every component sharing the manager calls Listen once
+// it has added its listeners.
 func (im *InformerManager) Listen() (stopCh <-chan struct{}) {
-	go im.informerFactory.Start(im.stopCh)
+	// Start does not block, it runs the informers in their own goroutines
+	im.informerFactory.Start(im.stopCh)
 	return im.stopCh
 }
+
+// WaitForCacheSync waits for the caches of the started informers to sync. It
+// returns false if the informers were stopped before all caches synced.
+func (im *InformerManager) WaitForCacheSync() bool {
+	for informerType, synced := range im.informerFactory.WaitForCacheSync(im.stopCh) {
+		if !synced {
+			klog.Errorf("Failed to sync the informer cache of %v", informerType)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewInformerSharing(t *testing.T) {
+	client := testclient.NewSimpleClientset()
+	first := NewInformer(client)
+	second := NewInformer(client)
+	if first != second {
+		t.Fatalf("Expected callers with the same client to share the informer manager")
+	}
+	other := NewInformer(testclient.NewSimpleClientset())
+	defer other.Release()
+	if other == first {
+		t.Errorf("Expected a separate informer manager for another client")
+	}
+
+	first.Release()
+	select {
+	case <-first.stopCh:
+		t.Fatalf("Informers stopped while a reference is left")
+	default:
+	}
+	second.Release()
+	select {
+	case <-first.stopCh:
+	default:
+		t.Fatalf("Informers not stopped after the last reference was dropped")
+	}
+	if third := NewInformer(client); third == first {
+		t.Errorf("Expected a new informer manager after the last reference was dropped")
+	} else {
+		third.Release()
+	}
+}
+
+func TestGetPVCByUID(t *testing.T) {
+	newPVC := func(namespace string, name string, uid string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(uid)}}
+	}
+	im := NewInformer(testclient.NewSimpleClientset(newPVC("ns-1", "pvc-1", "uid-1"), newPVC("ns-2", "pvc-2", "uid-2")))
+	defer im.Release()
+	if err := im.IndexPVCsByUID(); err != nil {
+		t.Fatal(err)
+	}
+	// Indexing again, e.g. by another component sharing the manager, is a no-op
+	if err := im.IndexPVCsByUID(); err != nil {
+		t.Fatal(err)
+	}
+	im.Listen()
+	if !im.WaitForCacheSync() {
+		t.Fatal("informer caches didn't sync")
+	}
+	pvc, err := im.GetPVCByUID("uid-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pvc == nil || pvc.Namespace != "ns-2" || pvc.Name != "pvc-2" {
+		t.Errorf("expected PVC ns-2/pvc-2, got %v", pvc)
+	}
+	if pvc, err = im.GetPVCByUID("uid-3"); err != nil || pvc != nil {
+		t.Errorf("expected no PVC with an unknown UID, got %v, err %v", pvc, err)
+	}
+}
+
+func TestGetPVsByVolumeHandle(t *testing.T) {
+	newPV := func(name string, driver string, volumeHandle string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: volumeHandle},
+				},
+			},
+		}
+	}
+	for _, indexed := range []bool{true, false} {
+		im := NewInformer(testclient.NewSimpleClientset(
+			newPV("pv-1", "driver-1", "vol-1"),
+			newPV("pv-2", "driver-2", "vol-1"),
+			newPV("pv-3", "driver-1", "vol-3"),
+			&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-in-tree"}},
+		))
+		if indexed {
+			if err := im.IndexPVsByVolumeHandle(); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			im.GetPVLister()
+		}
+		im.Listen()
+		if !im.WaitForCacheSync() {
+			t.Fatal("informer caches didn't sync")
+		}
+		pvs, err := im.GetPVsByVolumeHandle("vol-1")
+		if err != nil || len(pvs) != 2 {
+			t.Errorf("indexed %t: expected pv-1 and pv-2, got %v, err %v", indexed, pvs, err)
+		}
+		if pvs, err = im.GetPVsByVolumeHandle("vol-2"); err != nil || len(pvs) != 0 {
+			t.Errorf("indexed %t: expected no PV with an unknown volume handle, got %v, err %v", indexed, pvs, err)
+		}
+		im.Release()
+	}
+}
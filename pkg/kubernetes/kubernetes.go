@@ -17,6 +17,8 @@ limitations under the License.
 package kubernetes
 
 import (
+	"sync"
+
 	"k8s.io/klog"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -27,9 +29,21 @@ import (
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
 )
 
-// NewClient creates a newk8s client based on a service account
-func NewClient() (clientset.Interface, error) {
+var (
+	// sharedClient is the client returned by NewClient, so co-located
+	// components share it and with it their informer manager
+	sharedClient     clientset.Interface
+	sharedClientLock sync.Mutex
+)
 
+// NewClient returns the k8s client of the process based on a service account,
+// creating it on first use
+func NewClient() (clientset.Interface, error) {
+	sharedClientLock.Lock()
+	defer sharedClientLock.Unlock()
+	if sharedClient != nil {
+		return sharedClient, nil
+	}
 	var config *restclient.Config
 	var err error
 	klog.V(2).Info("k8s client using in-cluster config")
@@ -38,8 +52,12 @@ func NewClient() (clientset.Interface, error) {
 		klog.Errorf("InClusterConfig failed %q", err)
 		return nil, err
 	}
-
-	return clientset.NewForConfig(config)
+	client, err := clientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	sharedClient = client
+	return client, nil
 }
 
 // CreateKubernetesClientFromConfig creaates a newk8s client from given kubeConfig file
@@ -19,11 +19,19 @@ package kubernetes
 import (
 	"k8s.io/client-go/informers"
 	clientset "k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// pvcUIDIndex is the name of the index of the PVCs by UID
+	pvcUIDIndex = "uid"
+	// pvVolumeHandleIndex is the name of the index of the CSI PVs by volume handle
+	pvVolumeHandleIndex = "volumeHandle"
 )
 
 // InformerManager is a service that notifies subscribers about changes
-// to well-defined information in the Kubernetes API server.
+// to well-defined information in the Kubernetes API server. The informer
+// factory returns the same informer to every caller, so listeners of a
+// resource share its watch and cache.
 type InformerManager struct {
 	// k8s client
 	client clientset.Interface
@@ -31,16 +39,8 @@ type InformerManager struct {
 	informerFactory informers.SharedInformerFactory
 	// main signal
 	stopCh (<-chan struct{})
-
-	// node informer
-	nodeInformer cache.SharedInformer
-
-	// PV informer
-	pvInformer cache.SharedInformer
-
-	// PVC informer
-	pvcInformer cache.SharedInformer
-
-	// Pod informer
-	podInformer cache.SharedInformer
+	// stop closes stopCh
+	stop func()
+	// number of components using the informer manager, guarded by sharedInformerManagerLock
+	refCount int
 }
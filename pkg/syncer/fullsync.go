@@ -17,10 +17,15 @@ limitations under the License.
 package syncer
 
 import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
 	"sync"
 
-	"github.com/davecgh/go-spew/spew"
 	cnstypes "github.com/vmware/govmomi/cns/types"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
@@ -37,16 +42,22 @@ import (
 func triggerFullSync(k8sclient clientset.Interface, metadataSyncer *MetadataSyncInformer) {
 	klog.V(2).Infof("FullSync: start")
 
+	result := newFullSyncResult(startFullSyncCycle())
+	defer publishFullSyncResult(k8sclient, result)
+
 	// Get K8s PVs in State "Bound", "Available" or "Released"
 	k8sPVs, err := getPVsInBoundAvailableOrReleased(k8sclient)
 	if err != nil {
 		klog.Warningf("FullSync: Failed to get PVs from kubernetes. Err: %v", err)
+		result.addError("failed to get PVs: %v", err)
 		return
 	}
+	result.PVs = len(k8sPVs)
 
 	// pvToPVCMap maps pv name to corresponding PVC
 	// pvcToPodMap maps pvc to the mounted Pod
-	pvToPVCMap, pvcToPodMap := buildPVCMapPodMap(k8sclient, k8sPVs)
+	pvToPVCMap, pvcToPodMap, skippedPVs := buildPVCMapPodMap(k8sclient, k8sPVs, metadataSyncer)
+	result.setSkipped(skippedPVs)
 	klog.V(4).Infof("FullSync: pvToPVCMap %v", pvToPVCMap)
 	klog.V(4).Infof("FullSync: pvcToPodMap %v", pvcToPodMap)
 
@@ -57,43 +68,83 @@ func triggerFullSync(k8sclient clientset.Interface, metadataSyncer *MetadataSync
 		},
 	}
 	querySelection := cnstypes.CnsQuerySelection{}
-	queryAllResult, err := volumes.GetManager(metadataSyncer.vcenter).QueryAllVolume(queryFilter, querySelection)
+	if err := checkVirtualCenterHealth(context.Background(), metadataSyncer); err != nil {
+		klog.Warningf("FullSync: skipping cycle as vCenter is not healthy. Err: %v", err)
+		result.addError("vCenter is not healthy: %v", err)
+		fullSyncQueryFailed = true
+		return
+	}
+	queryAllResult, err := volumes.GetManager(metadataSyncer.vcenter).QueryAllVolume(fullSyncContext(), queryFilter, querySelection)
 	if err != nil {
 		klog.Warningf("FullSync: failed to queryAllVolume with err %v", err)
+		result.addError("failed to query CNS volumes: %v", err)
+		fullSyncQueryFailed = true
 		return
 	}
-	cnsVolumeArray := queryAllResult.Volumes
+	result.CnsVolumes = len(queryAllResult.Volumes)
+
+	// Skip creating and deleting volumes if the volumes returned by CNS may be incomplete
+	suspectReason := checkFullSyncData(queryAllResult, fullSyncQueryFailed, fullSyncCnsVolumeCount)
+	if suspectReason != "" {
+		klog.Warningf("FullSync: cycle %d is suspect, volumes will not be created or deleted: %s", fullSyncCycle, suspectReason)
+		result.Suspect = suspectReason
+	}
+	fullSyncQueryFailed = false
+	fullSyncCnsVolumeCount = len(queryAllResult.Volumes)
 
-	// Initialize CNS volume maps
-	cnsVolumeToPodMap = make(map[string]string)
-	cnsVolumeToPvcMap = make(map[string]string)
-	cnsVolumeToEntityNamespaceMap = make(map[string]string)
+	// Only the datastore of the CNS volumes is needed from here on, release
+	// the volumes and their metadata before comparing them with K8s
+	cnsVolumes := getCnsVolumeDatastores(queryAllResult.Volumes)
+	queryAllResult = nil
+
+	// Detect volumes relocated to another datastore outside of kubernetes
+	syncVolumeDatastores(k8sclient, k8sPVs, cnsVolumes, metadataSyncer)
+
+	// Reflect tags attached to the disks on vCenter as PV labels
+	syncVolumeTagsToPVLabels(k8sclient, k8sPVs, cnsVolumes, metadataSyncer)
 
 	// Map K8s PV's to the operation that needs to be performed on them
-	k8sPVsMap := buildVolumeMap(k8sPVs, cnsVolumeArray, pvToPVCMap, pvcToPodMap, metadataSyncer)
-	klog.V(4).Infof("FullSync: k8sPVMap %v", k8sPVsMap)
+	creationMap := make(map[string]bool)
+	for volID := range cnsCreationMap {
+		creationMap[volID] = true
+	}
+	k8sPVsMap := buildVolumeMap(k8sPVs, cnsVolumes, pvToPVCMap, pvcToPodMap, metadataSyncer)
 
 	// Identify volumes to be created, updated and deleted
 	volToBeCreated, volToBeUpdated, volWithPvcEntryToBeDeleted, volWithPodEntryToBeDeleted := identifyVolumesToBeCreatedUpdated(k8sPVs, k8sPVsMap)
-	volToBeDeleted := identifyVolumesToBeDeleted(cnsVolumeArray, k8sPVsMap)
+	// PVC and pod entries missing for PVs whose PVC or pods could not be
+	// fetched may still exist, keep their metadata on CNS until the next cycle
+	volWithPvcEntryToBeDeleted = excludeSkippedPVs(volWithPvcEntryToBeDeleted, skippedPVs)
+	volWithPodEntryToBeDeleted = excludeSkippedPVs(volWithPodEntryToBeDeleted, skippedPVs)
+	var volToBeDeleted []cnstypes.CnsVolumeId
+	if suspectReason == "" {
+		volToBeDeleted = identifyVolumesToBeDeleted(cnsVolumes, k8sPVsMap)
+	} else {
+		// Volumes missing from suspect data must not count towards their creation
+		cnsCreationMap = creationMap
+		volToBeCreated = nil
+	}
 
 	// Construct the cns spec for create and update operations
 	createSpecArray := constructCnsCreateSpec(volToBeCreated, pvToPVCMap, pvcToPodMap, metadataSyncer)
 	updateSpecArray := constructCnsUpdateSpec(volToBeUpdated, pvToPVCMap, pvcToPodMap, metadataSyncer)
-	updateSpecArray = append(updateSpecArray, constructCnsUpdateSpecWithPVCToBeDeleted(volWithPvcEntryToBeDeleted, metadataSyncer)...)
-	updateSpecArray = append(updateSpecArray, constructCnsUpdateSpecWithPodToBeDeleted(volWithPodEntryToBeDeleted, metadataSyncer)...)
+	updateSpecArray = append(updateSpecArray, constructCnsUpdateSpecWithPVCToBeDeleted(volWithPvcEntryToBeDeleted, k8sPVsMap, metadataSyncer)...)
+	updateSpecArray = append(updateSpecArray, constructCnsUpdateSpecWithPodToBeDeleted(volWithPodEntryToBeDeleted, k8sPVsMap, metadataSyncer)...)
 
 	wg := sync.WaitGroup{}
 	wg.Add(3)
 	// Perform operations
-	go fullSyncCreateVolumes(createSpecArray, metadataSyncer, k8sclient, &wg)
-	go fullSyncDeleteVolumes(volToBeDeleted, metadataSyncer, k8sclient, &wg)
-	go fullSyncUpdateVolumes(updateSpecArray, metadataSyncer, &wg)
+	go fullSyncCreateVolumes(createSpecArray, metadataSyncer, k8sclient, &wg, result)
+	go fullSyncDeleteVolumes(volToBeDeleted, metadataSyncer, k8sclient, &wg, result)
+	go fullSyncUpdateVolumes(updateSpecArray, metadataSyncer, &wg, result)
 	wg.Wait()
 
 	cleanupCnsMaps(k8sPVsMap)
 	klog.V(4).Infof("FullSync: cnsDeletionMap at end of cycle: %v", cnsDeletionMap)
 	klog.V(4).Infof("FullSync: cnsCreationMap at end of cycle: %v", cnsCreationMap)
+	result.lock.Lock()
+	result.Completed = true
+	result.lock.Unlock()
 	klog.V(2).Infof("FullSync: end")
 }
 
@@ -117,44 +168,77 @@ func getPVsInBoundAvailableOrReleased(k8sclient clientset.Interface) ([]*v1.Pers
 }
 
 // fullSyncCreateVolumes create volumes with given array of createSpec
+// and sets node affinity on the PVs of the created volumes
+func fullSyncCreateVolumes(createSpecArray []cnstypes.CnsVolumeCreateSpec, metadataSyncer *MetadataSyncInformer, k8sclient clientset.Interface, wg *sync.WaitGroup, syncResult *fullSyncResult) {
+	defer wg.Done()
+	createdPVs := createVolumesForFullSync(createSpecArray, metadataSyncer, k8sclient, syncResult)
+	// Node affinity is set without holding volumeOperationsLock, as it looks up
+	// the datastores accessible from every node
+	setStaticPVNodeAffinities(context.Background(), k8sclient, createdPVs, metadataSyncer)
+}
+
+// createVolumesForFullSync create volumes with given array of createSpec and returns the PVs of the created volumes
 // Before creating a volume, all current K8s volumes are retrieved
 // If the volume is successfully created, it is removed from cnsCreationMap
-func fullSyncCreateVolumes(createSpecArray []cnstypes.CnsVolumeCreateSpec, metadataSyncer *MetadataSyncInformer, k8sclient clientset.Interface, wg *sync.WaitGroup) {
-	defer wg.Done()
-	currentK8sPVMap := make(map[string]bool)
+func createVolumesForFullSync(createSpecArray []cnstypes.CnsVolumeCreateSpec, metadataSyncer *MetadataSyncInformer, k8sclient clientset.Interface, syncResult *fullSyncResult) []*v1.PersistentVolume {
+	currentK8sPVMap := make(map[string]*v1.PersistentVolume)
 	volumeOperationsLock.Lock()
 	defer volumeOperationsLock.Unlock()
 	// Get all K8s PVs
 	currentK8sPV, err := getPVsInBoundAvailableOrReleased(k8sclient)
 	if err != nil {
 		klog.Errorf("FullSync: fullSyncCreateVolumes failed to get PVs from kubernetes. Err: %v", err)
-		return
+		syncResult.addError("failed to get PVs before creating volumes: %v", err)
+		return nil
 	}
 	// Create map for easy lookup
 	for _, pv := range currentK8sPV {
-		currentK8sPVMap[pv.Spec.CSI.VolumeHandle] = true
+		currentK8sPVMap[pv.Spec.CSI.VolumeHandle] = pv
 	}
+	var createSpecs []cnstypes.CnsVolumeCreateSpec
+	var createPVs []*v1.PersistentVolume
 	for _, createSpec := range createSpecArray {
 		// Create volume if present in currentK8sPVMap
 		if createSpec.BackingObjectDetails.(*cnstypes.CnsBlockBackingDetails) == nil {
 			continue
 		}
-		if _, existsInK8s := currentK8sPVMap[createSpec.BackingObjectDetails.(*cnstypes.CnsBlockBackingDetails).BackingDiskId]; existsInK8s {
-			klog.V(4).Infof("FullSync: Calling CreateVolume for volume %s with id %s and create spec %+v", createSpec.Name, createSpec.BackingObjectDetails.(*cnstypes.CnsBlockBackingDetails).BackingDiskId, spew.Sdump(createSpec))
-			_, err := volumes.GetManager(metadataSyncer.vcenter).CreateVolume(&createSpec)
-			if err != nil {
-				klog.Warningf("FullSync: Failed to create disk %s with id %s. Err: %+v", createSpec.Name, createSpec.BackingObjectDetails.(*cnstypes.CnsBlockBackingDetails).BackingDiskId, err)
-				continue
-			}
+		volumeID := createSpec.BackingObjectDetails.(*cnstypes.CnsBlockBackingDetails).BackingDiskId
+		if pv, existsInK8s := currentK8sPVMap[volumeID]; existsInK8s {
+			klog.V(4).Infof("FullSync: Creating volume %s with id %s and create spec %v", createSpec.Name, volumeID, volumes.Dump(createSpec))
+			createSpecs = append(createSpecs, createSpec)
+			createPVs = append(createPVs, pv)
+			continue
+		}
+		delete(cnsCreationMap, volumeID)
+	}
+	if len(createSpecs) == 0 {
+		return nil
+	}
+	results, err := volumes.GetManager(metadataSyncer.vcenter).CreateVolumes(fullSyncContext(), createSpecs)
+	if err != nil {
+		klog.Warningf("FullSync: Failed to create %d volumes. Err: %+v", len(createSpecs), err)
+		syncResult.addError("failed to create %d volumes: %v", len(createSpecs), err)
+		return nil
+	}
+	var createdPVs []*v1.PersistentVolume
+	for i, result := range results {
+		volumeID := createSpecs[i].BackingObjectDetails.(*cnstypes.CnsBlockBackingDetails).BackingDiskId
+		if result.Err != nil {
+			klog.Warningf("FullSync: Failed to create disk %s with id %s. Err: %+v", createSpecs[i].Name, volumeID, result.Err)
+			syncResult.addError("failed to create volume %s: %v", volumeID, result.Err)
+			continue
 		}
-		delete(cnsCreationMap, (createSpec.BackingObjectDetails).(*cnstypes.CnsBlockBackingDetails).BackingDiskId)
+		syncResult.addCreated(volumeID)
+		createdPVs = append(createdPVs, createPVs[i])
+		delete(cnsCreationMap, volumeID)
 	}
+	return createdPVs
 }
 
 // fullSyncDeleteVolumes delete volumes with given array of volumeId
 // Before deleting a volume, all current K8s volumes are retrieved
 // If the volume is successfully deleted, it is removed from cnsDeletionMap
-func fullSyncDeleteVolumes(volumeIDDeleteArray []cnstypes.CnsVolumeId, metadataSyncer *MetadataSyncInformer, k8sclient clientset.Interface, wg *sync.WaitGroup) {
+func fullSyncDeleteVolumes(volumeIDDeleteArray []cnstypes.CnsVolumeId, metadataSyncer *MetadataSyncInformer, k8sclient clientset.Interface, wg *sync.WaitGroup, syncResult *fullSyncResult) {
 	defer wg.Done()
 	deleteDisk := false
 	currentK8sPVMap := make(map[string]bool)
@@ -164,33 +248,74 @@ func fullSyncDeleteVolumes(volumeIDDeleteArray []cnstypes.CnsVolumeId, metadataS
 	currentK8sPV, err := getPVsInBoundAvailableOrReleased(k8sclient)
 	if err != nil {
 		klog.Errorf("FullSync: fullSyncDeleteVolumes failed to get PVs from kubernetes. Err: %v", err)
+		syncResult.addError("failed to get PVs before deleting volumes: %v", err)
 		return
 	}
 	// Create map for easy lookup
 	for _, pv := range currentK8sPV {
 		currentK8sPVMap[pv.Spec.CSI.VolumeHandle] = true
 	}
+	var volumeIDs []string
 	for _, volID := range volumeIDDeleteArray {
 		// Delete volume if not present in currentK8sPVMap
 		if _, existsInK8s := currentK8sPVMap[volID.Id]; !existsInK8s {
-			klog.V(4).Infof("FullSync: Calling DeleteVolume for volume %v with delete disk %v", volID, deleteDisk)
-			err := volumes.GetManager(metadataSyncer.vcenter).DeleteVolume(volID.Id, deleteDisk)
-			if err != nil {
-				klog.Warningf("FullSync: Failed to delete volume %s with error %+v", volID, err)
-				continue
-			}
+			volumeIDs = append(volumeIDs, volID.Id)
+			continue
 		}
 		delete(cnsDeletionMap, volID.Id)
 	}
+	if len(volumeIDs) == 0 {
+		return
+	}
+	klog.V(4).Infof("FullSync: Calling DeleteVolumes for volumes %v with delete disk %v", volumeIDs, deleteDisk)
+	results, err := volumes.GetManager(metadataSyncer.vcenter).DeleteVolumes(fullSyncContext(), volumeIDs, deleteDisk)
+	if err != nil {
+		klog.Warningf("FullSync: Failed to delete %d volumes with error %+v", len(volumeIDs), err)
+		syncResult.addError("failed to delete %d volumes: %v", len(volumeIDs), err)
+		return
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			klog.Warningf("FullSync: Failed to delete volume %s with error %+v", result.VolumeID, result.Err)
+			syncResult.addError("failed to delete volume %s: %v", result.VolumeID, result.Err)
+			continue
+		}
+		syncResult.addDeleted(result.VolumeID)
+		delete(cnsDeletionMap, result.VolumeID)
+	}
 }
 
 // fullSyncUpdateVolumes update metadata for volumes with given array of createSpec
-func fullSyncUpdateVolumes(updateSpecArray []cnstypes.CnsVolumeMetadataUpdateSpec, metadataSyncer *MetadataSyncInformer, wg *sync.WaitGroup) {
+func fullSyncUpdateVolumes(updateSpecArray []cnstypes.CnsVolumeMetadataUpdateSpec, metadataSyncer *MetadataSyncInformer, wg *sync.WaitGroup, syncResult *fullSyncResult) {
 	defer wg.Done()
+	if len(updateSpecArray) == 0 {
+		return
+	}
 	for _, updateSpec := range updateSpecArray {
-		klog.V(4).Infof("FullSync: Calling UpdateVolumeMetadata for volume %s with updateSpec: %+v", updateSpec.VolumeId.Id, spew.Sdump(updateSpec))
-		if err := volumes.GetManager(metadataSyncer.vcenter).UpdateVolumeMetadata(&updateSpec); err != nil {
-			klog.Warningf("FullSync:UpdateVolumeMetadata failed with err %v", err)
+		klog.V(4).Infof("FullSync: Updating metadata of volume %s with updateSpec: %v", updateSpec.VolumeId.Id, volumes.Dump(updateSpec))
+	}
+	results, err := volumes.GetManager(metadataSyncer.vcenter).UpdateVolumeMetadataBatch(fullSyncContext(), updateSpecArray)
+	if err != nil {
+		klog.Warningf("FullSync:UpdateVolumeMetadataBatch failed for %d volumes with err %v", len(updateSpecArray), err)
+		syncResult.addError("failed to update %d volumes: %v", len(updateSpecArray), err)
+		return
+	}
+	failedVolumes := make(map[string]bool)
+	for _, result := range results {
+		if result.Err != nil {
+			klog.Warningf("FullSync:UpdateVolumeMetadata failed for volume %s with err %v", result.VolumeID, result.Err)
+			syncResult.addError("failed to update volume %s: %v", result.VolumeID, result.Err)
+			failedVolumes[result.VolumeID] = true
+			continue
+		}
+		syncResult.addUpdated(result.VolumeID)
+	}
+	if len(metadataSyncer.externalBackends) == 0 {
+		return
+	}
+	for i := range updateSpecArray {
+		if !failedVolumes[updateSpecArray[i].VolumeId.Id] {
+			metadataSyncer.pushToExternalBackends(context.Background(), &updateSpecArray[i])
 		}
 	}
 }
@@ -201,84 +326,248 @@ func buildCnsUpdateMetadataList(pv *v1.PersistentVolume, pvToPVCMap pvcMap, pvcT
 	var metadataList []cnstypes.BaseCnsEntityMetadata
 
 	// get pv metadata
-	pvMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pv.Name, pv.GetLabels(), false, string(cnstypes.CnsKubernetesEntityTypePV), pv.Namespace)
+	pvMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pv.Name, pv.GetLabels(), false, common.EntityTypePV, pv.Namespace)
 	metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(pvMetadata))
 	if pvc, ok := pvToPVCMap[pv.Name]; ok {
 		// get pvc metadata
-		pvcMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pvc.Name, pvc.GetLabels(), false, string(cnstypes.CnsKubernetesEntityTypePVC), pvc.Namespace)
+		pvcMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pvc.Name, pvc.GetLabels(), false, common.EntityTypePVC, pvc.Namespace)
 		metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(pvcMetadata))
 
 		key := pvc.Namespace + "/" + pvc.Name
 		if pod, ok := pvcToPodMap[key]; ok {
 			// get pod metadata
-			podMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pod.Name, nil, false, string(cnstypes.CnsKubernetesEntityTypePOD), pod.Namespace)
+			podMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pod.Name, nil, false, common.EntityTypePod, pod.Namespace)
 			metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(podMetadata))
 		}
 	}
-	klog.V(4).Infof("FullSync: buildMetadataList=%+v \n", spew.Sdump(metadataList))
+	klog.V(4).Infof("FullSync: buildMetadataList=%v \n", volumes.Dump(metadataList))
 	return metadataList
 }
 
-// buildVolumeMap build k8sPVMap which maps volume id to a string "Create"/"Update" to indicate the PV need to be
-// created/updated in CNS cache
-// A volume mapped to an empty string implies either no operation has to be performed or that the volume will be
-// deleted
-func buildVolumeMap(pvList []*v1.PersistentVolume, cnsVolumeList []cnstypes.CnsVolume, pvToPVCMap pvcMap, pvcToPodMap podMap, metadataSyncer *MetadataSyncInformer) map[string]string {
-	k8sPVMap := make(map[string]string)
-	cnsVolumeMap := make(map[string]bool)
+// startFullSyncCycle increments fullSyncCycle and returns the new cycle
+// Every getFullSyncCompleteScanCycles() cycles, cnsSyncedMetadataMap is cleared so that
+// metadata of all volumes is compared, to detect changes made to volume metadata directly on CNS
+func startFullSyncCycle() int {
+	fullSyncCycle++
+	if isCompleteScanCycle(fullSyncCycle) {
+		klog.V(2).Infof("FullSync: comparing metadata of all volumes in cycle %d", fullSyncCycle)
+		cnsSyncedMetadataMap = make(map[string]uint64)
+	}
+	return fullSyncCycle
+}
 
+// isCompleteScanCycle returns true if the metadata of all volumes is compared in the given cycle
+func isCompleteScanCycle(cycle int) bool {
+	return cycle%getFullSyncCompleteScanCycles() == 0
+}
+
+// getCnsVolumeDatastores returns the datastore URL of the given CNS volumes, keyed by volume ID
+func getCnsVolumeDatastores(cnsVolumeList []cnstypes.CnsVolume) map[string]string {
+	cnsVolumes := make(map[string]string, len(cnsVolumeList))
 	for _, vol := range cnsVolumeList {
-		cnsVolumeMap[vol.VolumeId.Id] = true
+		cnsVolumes[vol.VolumeId.Id] = vol.DatastoreUrl
 	}
+	return cnsVolumes
+}
+
+// buildVolumeMap build k8sPVMap which maps volume id to the state of the PV in the cycle, whose operation
+// is "Create"/"Update" to indicate the PV need to be created/updated in CNS cache
+// A volume with an empty operation implies either no operation has to be performed or that the volume will be
+// deleted
+// Volumes whose K8s metadata is unchanged since it was last found in sync with CNS
+// are not queried from CNS. The queried volumes are compared by the metadata
+// checksum stored on CNS, except in complete scan cycles where all entity
+// metadata is compared.
+func buildVolumeMap(pvList []*v1.PersistentVolume, cnsVolumes map[string]string, pvToPVCMap pvcMap, pvcToPodMap podMap, metadataSyncer *MetadataSyncInformer) map[string]*fullSyncVolume {
+	k8sPVMap := make(map[string]*fullSyncVolume, len(pvList))
+	completeScan := isCompleteScanCycle(fullSyncCycle)
+
+	var pvsToCompare []*v1.PersistentVolume
 	for _, pv := range pvList {
-		k8sPVMap[pv.Spec.CSI.VolumeHandle] = ""
-		if cnsVolumeMap[pv.Spec.CSI.VolumeHandle] {
+		volume := &fullSyncVolume{}
+		k8sPVMap[pv.Spec.CSI.VolumeHandle] = volume
+		if _, existsInCns := cnsVolumes[pv.Spec.CSI.VolumeHandle]; existsInCns {
 			// PV exist in both K8S and CNS cache, check metadata has been changed or not
-			queryFilter := cnstypes.CnsQueryFilter{
-				VolumeIds: []cnstypes.CnsVolumeId{
-					{
-						Id: pv.Spec.CSI.VolumeHandle,
-					},
-				},
-			}
-
-			queryResult, err := volumes.GetManager(metadataSyncer.vcenter).QueryVolume(queryFilter)
-			if err == nil && queryResult != nil && len(queryResult.Volumes) > 0 {
-				if &queryResult.Volumes[0].Metadata != nil {
-					cnsMetadata := queryResult.Volumes[0].Metadata.EntityMetadata
-					metadataList := buildCnsUpdateMetadataList(pv, pvToPVCMap, pvcToPodMap)
-					k8sPVMap[pv.Spec.CSI.VolumeHandle] = getCnsUpdateOperationType(metadataList, cnsMetadata, pv.Name)
-				} else {
-					// metadata does not exist in CNS cache even the volume has an entry in CNS cache
-					klog.Warningf("FullSync: No metadata found for volume %v", pv.Spec.CSI.VolumeHandle)
-					k8sPVMap[pv.Spec.CSI.VolumeHandle] = updateVolumeOperation
-				}
+			if hash, ok := cnsSyncedMetadataMap[pv.Spec.CSI.VolumeHandle]; ok &&
+				hash == getMetadataHash(buildCnsUpdateMetadataList(pv, pvToPVCMap, pvcToPodMap)) {
+				klog.V(4).Infof("FullSync: metadata for volume %s is unchanged since last cycle", pv.Spec.CSI.VolumeHandle)
+				continue
 			}
+			pvsToCompare = append(pvsToCompare, pv)
 		} else {
 			// PV exist in K8S but not in CNS cache, need to create
 			if _, existsInCnsCreationMap := cnsCreationMap[pv.Spec.CSI.VolumeHandle]; existsInCnsCreationMap {
-				k8sPVMap[pv.Spec.CSI.VolumeHandle] = createVolumeOperation
+				volume.operation = createVolumeOperation
 			} else {
 				cnsCreationMap[pv.Spec.CSI.VolumeHandle] = true
 			}
 		}
 	}
 
+	// Compare the metadata one batch of volumes at a time, so only the
+	// metadata of the current batch is held in memory
+	queryVolumesForFullSync(pvsToCompare, metadataSyncer, func(pv *v1.PersistentVolume, cnsVolume *cnstypes.CnsVolume) {
+		volumeID := pv.Spec.CSI.VolumeHandle
+		volume := k8sPVMap[volumeID]
+		metadataList := buildCnsUpdateMetadataList(pv, pvToPVCMap, pvcToPodMap)
+		volume.operation = getVolumeMetadataOperation(volumeID, metadataList, cnsVolume.Metadata.EntityMetadata, volume, completeScan)
+		if volume.operation == "" {
+			cnsSyncedMetadataMap[volumeID] = getMetadataHash(metadataList)
+		} else {
+			delete(cnsSyncedMetadataMap, volumeID)
+		}
+	})
 	return k8sPVMap
 }
 
+// queryVolumesForFullSync queries CNS for the given PVs and calls compare with each PV and its CnsVolume
+// in batches of queryVolumeBatchSize
+// PVs whose volume could not be queried are skipped
+func queryVolumesForFullSync(pvList []*v1.PersistentVolume, metadataSyncer *MetadataSyncInformer,
+	compare func(pv *v1.PersistentVolume, cnsVolume *cnstypes.CnsVolume)) {
+	for start := 0; start < len(pvList); start += queryVolumeBatchSize {
+		end := start + queryVolumeBatchSize
+		if end > len(pvList) {
+			end = len(pvList)
+		}
+		var volumeIds []cnstypes.CnsVolumeId
+		for _, pv := range pvList[start:end] {
+			volumeIds = append(volumeIds, cnstypes.CnsVolumeId{Id: pv.Spec.CSI.VolumeHandle})
+		}
+		queryFilter := cnstypes.CnsQueryFilter{
+			VolumeIds: volumeIds,
+		}
+		queryResult, err := volumes.GetManager(metadataSyncer.vcenter).QueryVolume(fullSyncContext(), queryFilter)
+		if err != nil || queryResult == nil {
+			klog.Warningf("FullSync: QueryVolume failed for volumes %v. Err: %v", volumeIds, err)
+			continue
+		}
+		cnsVolumes := make(map[string]*cnstypes.CnsVolume, len(queryResult.Volumes))
+		for index := range queryResult.Volumes {
+			cnsVolumes[queryResult.Volumes[index].VolumeId.Id] = &queryResult.Volumes[index]
+		}
+		for _, pv := range pvList[start:end] {
+			if cnsVolume, ok := cnsVolumes[pv.Spec.CSI.VolumeHandle]; ok {
+				compare(pv, cnsVolume)
+			}
+		}
+	}
+}
+
+// getVolumeMetadataOperation returns the operation needed to bring the metadata of
+// the volume on CNS in sync with the given K8s metadata list. Outside complete
+// scan cycles the volume is in sync if the checksum stored on CNS matches the
+// checksum of the K8s metadata. All entity metadata is compared if it doesn't,
+// e.g. as the informer handlers wrote the PV entity without checksum, and in
+// complete scan cycles, which detect changes made directly on CNS.
+func getVolumeMetadataOperation(volumeID string, metadataList []cnstypes.BaseCnsEntityMetadata,
+	cnsMetadataList []cnstypes.BaseCnsEntityMetadata, volume *fullSyncVolume, completeScan bool) string {
+	checksumMatches := getCnsMetadataChecksum(cnsMetadataList) == getMetadataChecksum(metadataList)
+	if checksumMatches && !completeScan {
+		return ""
+	}
+	operation := getCnsUpdateOperationType(metadataList, withoutMetadataChecksum(cnsMetadataList), volume)
+	if operation != "" && checksumMatches {
+		klog.V(2).Infof("FullSync: metadata of volume %s was changed directly on CNS", volumeID)
+	}
+	return operation
+}
+
+// getMetadataHash returns a hash of the given entity metadata list
+// The hash does not depend on the order of entities or labels in the list
+func getMetadataHash(metadataList []cnstypes.BaseCnsEntityMetadata) uint64 {
+	var entities []string
+	for _, metadata := range metadataList {
+		entityMetadata, ok := metadata.(*cnstypes.CnsKubernetesEntityMetadata)
+		if !ok {
+			continue
+		}
+		var labels []string
+		for _, label := range entityMetadata.Labels {
+			labels = append(labels, label.Key+"="+label.Value)
+		}
+		sort.Strings(labels)
+		entities = append(entities, fmt.Sprintf("%s/%s/%s/%t/%s", entityMetadata.EntityType, entityMetadata.Namespace,
+			entityMetadata.EntityName, entityMetadata.Delete, strings.Join(labels, ",")))
+	}
+	sort.Strings(entities)
+	hash := fnv.New64a()
+	hash.Write([]byte(strings.Join(entities, ";")))
+	return hash.Sum64()
+}
+
+// getMetadataChecksum returns the checksum of the given K8s metadata list, stored on CNS as labelMetadataChecksum
+func getMetadataChecksum(metadataList []cnstypes.BaseCnsEntityMetadata) string {
+	return fmt.Sprintf("%016x", getMetadataHash(metadataList))
+}
+
+// getCnsMetadataChecksum returns the metadata checksum stored on the PV entity
+// of the given CNS metadata list, or an empty string if there is none
+func getCnsMetadataChecksum(cnsMetadataList []cnstypes.BaseCnsEntityMetadata) string {
+	for _, metadata := range cnsMetadataList {
+		if !common.IsEntityType(metadata, common.EntityTypePV) {
+			continue
+		}
+		for _, label := range metadata.GetCnsEntityMetadata().Labels {
+			if label.Key == labelMetadataChecksum {
+				return label.Value
+			}
+		}
+	}
+	return ""
+}
+
+// withMetadataChecksum returns the given K8s metadata list with the checksum
+// of the list added to the labels of the PV entity
+func withMetadataChecksum(metadataList []cnstypes.BaseCnsEntityMetadata) []cnstypes.BaseCnsEntityMetadata {
+	checksum := getMetadataChecksum(metadataList)
+	for _, metadata := range metadataList {
+		entityMetadata, ok := metadata.(*cnstypes.CnsKubernetesEntityMetadata)
+		if !ok || entityMetadata.EntityType != common.EntityTypePV {
+			continue
+		}
+		entityMetadata.Labels = append(entityMetadata.Labels, vimtypes.KeyValue{Key: labelMetadataChecksum, Value: checksum})
+	}
+	return metadataList
+}
+
+// withoutMetadataChecksum returns a copy of the given CNS metadata list without the metadata checksum label
+func withoutMetadataChecksum(cnsMetadataList []cnstypes.BaseCnsEntityMetadata) []cnstypes.BaseCnsEntityMetadata {
+	var metadataList []cnstypes.BaseCnsEntityMetadata
+	for _, metadata := range cnsMetadataList {
+		entityMetadata, ok := metadata.(*cnstypes.CnsKubernetesEntityMetadata)
+		if !ok {
+			metadataList = append(metadataList, metadata)
+			continue
+		}
+		newEntityMetadata := *entityMetadata
+		newEntityMetadata.Labels = nil
+		for _, label := range entityMetadata.Labels {
+			if label.Key != labelMetadataChecksum {
+				newEntityMetadata.Labels = append(newEntityMetadata.Labels, label)
+			}
+		}
+		metadataList = append(metadataList, &newEntityMetadata)
+	}
+	return metadataList
+}
+
 // identifyVolumesToBeCreatedUpdated return list of PV need to be created and updated
 // volumes to be updated can be of three types -
 // 	1. volumes whose existing metadata needs to be updated/created
 //  2. volumes whose existing PVC and Pod metadata needs to be deleted
 // 	3. volumes whose existing Pod metadata needs to be deleted
-func identifyVolumesToBeCreatedUpdated(pvList []*v1.PersistentVolume, k8sPVMap map[string]string) ([]*v1.PersistentVolume, []*v1.PersistentVolume, []*v1.PersistentVolume, []*v1.PersistentVolume) {
+func identifyVolumesToBeCreatedUpdated(pvList []*v1.PersistentVolume, k8sPVMap map[string]*fullSyncVolume) ([]*v1.PersistentVolume, []*v1.PersistentVolume, []*v1.PersistentVolume, []*v1.PersistentVolume) {
 	pvToBeCreated := []*v1.PersistentVolume{}
 	pvToBeUpdated := []*v1.PersistentVolume{}
 	pvcToBeDeleted := []*v1.PersistentVolume{}
 	podToBeDeleted := []*v1.PersistentVolume{}
 	for _, pv := range pvList {
-		switch k8sPVMap[pv.Spec.CSI.VolumeHandle] {
+		volume, ok := k8sPVMap[pv.Spec.CSI.VolumeHandle]
+		if !ok {
+			continue
+		}
+		switch volume.operation {
 		case createVolumeOperation:
 			klog.V(4).Infof("FullSync: Volume with id %s added to volume create list as it was present in cnsCreationMap across two fullsync cycles", pv.Spec.CSI.VolumeHandle)
 			pvToBeCreated = append(pvToBeCreated, pv)
@@ -286,10 +575,10 @@ func identifyVolumesToBeCreatedUpdated(pvList []*v1.PersistentVolume, k8sPVMap m
 			klog.V(4).Infof("FullSync: Volume with id %s added to volume update list", pv.Spec.CSI.VolumeHandle)
 			pvToBeUpdated = append(pvToBeUpdated, pv)
 		case updateVolumeWithDeleteClaimOperation:
-			klog.V(4).Infof("FullSync: Volume with id %s and claim %s added to volume claim delete list", pv.Spec.CSI.VolumeHandle, cnsVolumeToPvcMap[pv.Name])
+			klog.V(4).Infof("FullSync: Volume with id %s and claim %s added to volume claim delete list", pv.Spec.CSI.VolumeHandle, volume.cnsPVCName)
 			pvcToBeDeleted = append(pvcToBeDeleted, pv)
 		case updateVolumeWithDeletePodOperation:
-			klog.V(4).Infof("FullSync: Volume with id %s and pod name %s added to volume pod delete list", pv.Spec.CSI.VolumeHandle, cnsVolumeToPodMap[pv.Name])
+			klog.V(4).Infof("FullSync: Volume with id %s and pod name %s added to volume pod delete list", pv.Spec.CSI.VolumeHandle, volume.cnsPodName)
 			podToBeDeleted = append(podToBeDeleted, pv)
 		}
 	}
@@ -299,18 +588,18 @@ func identifyVolumesToBeCreatedUpdated(pvList []*v1.PersistentVolume, k8sPVMap m
 // identifyVolumesToBeDeleted return list of volumeId's that need to be deleted
 // A volumeId is added to this list only if it was present in cnsDeletionMap across two
 // cycles of full sync
-func identifyVolumesToBeDeleted(cnsVolumeList []cnstypes.CnsVolume, k8sPVMap map[string]string) []cnstypes.CnsVolumeId {
+func identifyVolumesToBeDeleted(cnsVolumes map[string]string, k8sPVMap map[string]*fullSyncVolume) []cnstypes.CnsVolumeId {
 	var volToBeDeleted []cnstypes.CnsVolumeId
-	for _, vol := range cnsVolumeList {
-		if _, existsInK8s := k8sPVMap[vol.VolumeId.Id]; !existsInK8s {
-			if _, existsInCnsDeletionMap := cnsDeletionMap[vol.VolumeId.Id]; existsInCnsDeletionMap {
+	for volID := range cnsVolumes {
+		if _, existsInK8s := k8sPVMap[volID]; !existsInK8s {
+			if _, existsInCnsDeletionMap := cnsDeletionMap[volID]; existsInCnsDeletionMap {
 				// Volume does not exist in K8s across two fullsync cycles - add to delete list
-				klog.V(4).Infof("FullSync: Volume with id %s added to delete list as it was present in cnsDeletionMap across two fullsync cycles", vol.VolumeId.Id)
-				volToBeDeleted = append(volToBeDeleted, vol.VolumeId)
+				klog.V(4).Infof("FullSync: Volume with id %s added to delete list as it was present in cnsDeletionMap across two fullsync cycles", volID)
+				volToBeDeleted = append(volToBeDeleted, cnstypes.CnsVolumeId{Id: volID})
 			} else {
 				// Add to cnsDeletionMap
-				klog.V(4).Infof("Volume with id %s added to cnsDeletionMap", vol.VolumeId.Id)
-				cnsDeletionMap[vol.VolumeId.Id] = true
+				klog.V(4).Infof("Volume with id %s added to cnsDeletionMap", volID)
+				cnsDeletionMap[volID] = true
 			}
 		}
 	}
@@ -328,7 +617,7 @@ func constructCnsCreateSpec(pvList []*v1.PersistentVolume, pvToPVCMap pvcMap, pv
 			Name:       pv.Name,
 			VolumeType: common.BlockVolumeType,
 			Metadata: cnstypes.CnsVolumeMetadata{
-				ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
+				ContainerCluster: metadataSyncer.vcenter.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID),
 				EntityMetadata:   metadataList,
 			},
 			BackingObjectDetails: &cnstypes.CnsBlockBackingDetails{
@@ -347,14 +636,14 @@ func constructCnsUpdateSpec(pvUpdateList []*v1.PersistentVolume, pvToPVCMap pvcM
 	var updateSpecArray []cnstypes.CnsVolumeMetadataUpdateSpec
 	for _, pv := range pvUpdateList {
 		// Create new metadata spec with delete flag false
-		metadataList := buildCnsUpdateMetadataList(pv, pvToPVCMap, pvcToPodMap)
+		metadataList := withMetadataChecksum(buildCnsUpdateMetadataList(pv, pvToPVCMap, pvcToPodMap))
 		// volume exist in K8S and CNS cache, but metadata is different, need to update this volume
 		updateSpec := cnstypes.CnsVolumeMetadataUpdateSpec{
 			VolumeId: cnstypes.CnsVolumeId{
 				Id: pv.Spec.CSI.VolumeHandle,
 			},
 			Metadata: cnstypes.CnsVolumeMetadata{
-				ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
+				ContainerCluster: metadataSyncer.vcenter.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID),
 				EntityMetadata:   metadataList,
 			},
 		}
@@ -368,14 +657,14 @@ func constructCnsUpdateSpec(pvUpdateList []*v1.PersistentVolume, pvToPVCMap pvcM
 
 // constructCnsUpdateSpecWithPVCToBeDeleted constructs CnsVolumeMetadataUpdateSpec for given list of PVs
 // List of PVs have PVC and/or Pod entries in CNS that need to be deleted
-func constructCnsUpdateSpecWithPVCToBeDeleted(pvUpdateList []*v1.PersistentVolume, metadataSyncer *MetadataSyncInformer) []cnstypes.CnsVolumeMetadataUpdateSpec {
+func constructCnsUpdateSpecWithPVCToBeDeleted(pvUpdateList []*v1.PersistentVolume, k8sPVMap map[string]*fullSyncVolume, metadataSyncer *MetadataSyncInformer) []cnstypes.CnsVolumeMetadataUpdateSpec {
 	var updateSpecArray []cnstypes.CnsVolumeMetadataUpdateSpec
 
 	for _, pv := range pvUpdateList {
-		updateSpec := buildCnsMetadataSpecMarkedForDelete(pv, updateVolumeWithDeleteClaimOperation)
+		updateSpec := buildCnsMetadataSpecMarkedForDelete(pv, k8sPVMap[pv.Spec.CSI.VolumeHandle], updateVolumeWithDeleteClaimOperation)
 		// volume exist in K8S and CNS cache, but PVC metadata does not exist in K8S
 		// need to delete PVC entries for this volume
-		updateSpec.Metadata.ContainerCluster = cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User)
+		updateSpec.Metadata.ContainerCluster = metadataSyncer.vcenter.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID)
 		updateSpecArray = append(updateSpecArray, updateSpec)
 		klog.V(4).Infof("FullSync: constructCnsUpdateSpecWithPVCToBeDeleted to update metadata for volume %s with delete flag true", pv.Spec.CSI.VolumeHandle)
 	}
@@ -384,14 +673,14 @@ func constructCnsUpdateSpecWithPVCToBeDeleted(pvUpdateList []*v1.PersistentVolum
 
 // constructCnsUpdateSpecWithPodToBeDeleted constructs CnsVolumeMetadataUpdateSpec for given list of PVs
 // List of PVs have Pod entries in CNS that need to be deleted
-func constructCnsUpdateSpecWithPodToBeDeleted(pvUpdateList []*v1.PersistentVolume, metadataSyncer *MetadataSyncInformer) []cnstypes.CnsVolumeMetadataUpdateSpec {
+func constructCnsUpdateSpecWithPodToBeDeleted(pvUpdateList []*v1.PersistentVolume, k8sPVMap map[string]*fullSyncVolume, metadataSyncer *MetadataSyncInformer) []cnstypes.CnsVolumeMetadataUpdateSpec {
 	var updateSpecArray []cnstypes.CnsVolumeMetadataUpdateSpec
 
 	for _, pv := range pvUpdateList {
-		updateSpec := buildCnsMetadataSpecMarkedForDelete(pv, updateVolumeWithDeletePodOperation)
+		updateSpec := buildCnsMetadataSpecMarkedForDelete(pv, k8sPVMap[pv.Spec.CSI.VolumeHandle], updateVolumeWithDeletePodOperation)
 		// volume exist in K8S and CNS cache, but Pod metadata does not exist in K8S
 		// need to delete Pod entries for this volume
-		updateSpec.Metadata.ContainerCluster = cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User)
+		updateSpec.Metadata.ContainerCluster = metadataSyncer.vcenter.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID)
 		updateSpecArray = append(updateSpecArray, updateSpec)
 		klog.V(4).Infof("FullSync: constructCnsUpdateSpecWithPodToBeDeleted to update metadata for volume %s with delete flag true", pv.Spec.CSI.VolumeHandle)
 	}
@@ -404,49 +693,99 @@ func constructCnsUpdateSpecWithPodToBeDeleted(pvUpdateList []*v1.PersistentVolum
 //  2. find POD mounted to given PVC
 // pvToPVCMap maps PV name to corresponding PVC, key is pv name
 // pvcToPodMap maps PVC to the POD attached to the PVC, key is "pvc.Namespace/pvc.Name"
-func buildPVCMapPodMap(k8sclient clientset.Interface, pvList []*v1.PersistentVolume) (pvcMap, podMap) {
+// The names of the PVs whose PVC or pods could not be fetched are returned as
+// well. Their PVC and pod entries are missing from the maps, which must not be
+// taken as the PVC or pod being deleted.
+func buildPVCMapPodMap(k8sclient clientset.Interface, pvList []*v1.PersistentVolume, metadataSyncer *MetadataSyncInformer) (pvcMap, podMap, map[string]bool) {
 	pvToPVCMap := make(pvcMap)
 	pvcToPodMap := make(podMap)
+	skippedPVs := make(map[string]bool)
+	// Running pods are listed once per namespace, keyed on the PVC they mount
+	podsByNamespace := make(map[string]map[string]*v1.Pod)
 	for _, pv := range pvList {
 		if pv.Spec.ClaimRef != nil && pv.Status.Phase == v1.VolumeBound {
-			pvc, err := k8sclient.CoreV1().PersistentVolumeClaims(pv.Spec.ClaimRef.Namespace).Get(pv.Spec.ClaimRef.Name, metav1.GetOptions{})
+			pvc, err := getFullSyncPVC(k8sclient, pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name, metadataSyncer)
 			if err != nil {
 				klog.Warningf("FullSync: Failed to get pvc for namespace %v and name %v. err=%v", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name, err)
+				skippedPVs[pv.Name] = true
 				continue
 			}
 			pvToPVCMap[pv.Name] = pvc
 			klog.V(4).Infof("FullSync: pvc %v is backed by pv %v", pvc.Name, pv.Name)
-			pods, err := k8sclient.CoreV1().Pods(pvc.Namespace).List(metav1.ListOptions{
-				FieldSelector: fields.AndSelectors(fields.SelectorFromSet(fields.Set{"status.phase": string(v1.PodRunning)})).String(),
-			})
-			if err != nil {
-				klog.Warningf("FullSync: Failed to get pods for namespace %v. err=%v", pvc.Namespace, err)
-				continue
-			}
-			for index, pod := range pods.Items {
-				if pod.Spec.Volumes != nil {
-					for _, volume := range pod.Spec.Volumes {
-						pvClaim := volume.VolumeSource.PersistentVolumeClaim
-						if pvClaim != nil && pvClaim.ClaimName == pvc.Name {
-							key := pod.Namespace + "/" + pvClaim.ClaimName
-							pvcToPodMap[key] = &pods.Items[index]
-							klog.V(4).Infof("FullSync: pvc %v is mounted by pod %v", key, pod.Name)
-							break
-						}
-					}
+			podsByClaim, listed := podsByNamespace[pvc.Namespace]
+			if !listed {
+				podsByClaim, err = listRunningPodsByClaim(k8sclient, pvc.Namespace)
+				if err != nil {
+					klog.Warningf("FullSync: Failed to get pods for namespace %v. err=%v", pvc.Namespace, err)
+					skippedPVs[pv.Name] = true
+					continue
 				}
+				podsByNamespace[pvc.Namespace] = podsByClaim
+			}
+			if pod, ok := podsByClaim[pvc.Name]; ok {
+				key := pod.Namespace + "/" + pvc.Name
+				pvcToPodMap[key] = pod
+				klog.V(4).Infof("FullSync: pvc %v is mounted by pod %v", key, pod.Name)
+			}
+		}
+	}
+	return pvToPVCMap, pvcToPodMap, skippedPVs
+}
+
+// getFullSyncPVC returns the PVC with the given namespace and name, from the
+// PVC lister when the syncer has one, falling back to the API server
+func getFullSyncPVC(k8sclient clientset.Interface, namespace string, name string, metadataSyncer *MetadataSyncInformer) (*v1.PersistentVolumeClaim, error) {
+	if metadataSyncer != nil && metadataSyncer.pvcLister != nil {
+		return getPVC(namespace, name, metadataSyncer)
+	}
+	return k8sclient.CoreV1().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
+}
+
+// listRunningPodsByClaim returns the running pods of the namespace keyed on
+// the name of the PVCs they mount. Pods are read from the API server rather
+// than a lister, as a pod missing from a stale cache would have its metadata
+// deleted from CNS.
+func listRunningPodsByClaim(k8sclient clientset.Interface, namespace string) (map[string]*v1.Pod, error) {
+	pods, err := k8sclient.CoreV1().Pods(namespace).List(metav1.ListOptions{
+		FieldSelector: fields.AndSelectors(fields.SelectorFromSet(fields.Set{"status.phase": string(v1.PodRunning)})).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	podsByClaim := make(map[string]*v1.Pod)
+	for index, pod := range pods.Items {
+		for _, volume := range pod.Spec.Volumes {
+			pvClaim := volume.VolumeSource.PersistentVolumeClaim
+			if pvClaim != nil {
+				podsByClaim[pvClaim.ClaimName] = &pods.Items[index]
 			}
+		}
+	}
+	return podsByClaim, nil
+}
 
+// excludeSkippedPVs returns the PVs which are not in skippedPVs
+func excludeSkippedPVs(pvList []*v1.PersistentVolume, skippedPVs map[string]bool) []*v1.PersistentVolume {
+	if len(skippedPVs) == 0 {
+		return pvList
+	}
+	var pvs []*v1.PersistentVolume
+	for _, pv := range pvList {
+		if skippedPVs[pv.Name] {
+			klog.Warningf("FullSync: not deleting PVC or pod metadata of volume %s as its PVC or pods could not be fetched", pv.Spec.CSI.VolumeHandle)
+			continue
 		}
+		pvs = append(pvs, pv)
 	}
-	return pvToPVCMap, pvcToPodMap
+	return pvs
 }
 
 // getCnsUpdateOperationType compares the input metadata list from K8S and metadata list from CNS
 // Returns the update operation type that needs to be performed on CNS
 // Empty string returned implies either no operation needs to be performed or
 // volume needs to be deleted from CNS
-func getCnsUpdateOperationType(pvMetadataList []cnstypes.BaseCnsEntityMetadata, cnsMetadataList []cnstypes.BaseCnsEntityMetadata, pvName string) string {
+// The PVC and Pod entries on CNS are recorded in volume when they need to be deleted
+func getCnsUpdateOperationType(pvMetadataList []cnstypes.BaseCnsEntityMetadata, cnsMetadataList []cnstypes.BaseCnsEntityMetadata, volume *fullSyncVolume) string {
 	// K8s resource metadata contains more entries than CNS - need to update
 	if len(pvMetadataList) > len(cnsMetadataList) {
 		return updateVolumeOperation
@@ -455,17 +794,16 @@ func getCnsUpdateOperationType(pvMetadataList []cnstypes.BaseCnsEntityMetadata,
 	// K8s resource metadata contains lesser entries than CNS - need to delete
 	// some entries from CNS
 	if len(pvMetadataList) < len(cnsMetadataList) {
-		// Construct CNS volume mappings
+		// Record the PVC and Pod of the volume on CNS. Both belong to the same namespace
 		for _, cnsMetadata := range cnsMetadataList {
-			// Construct CNS volume to Pod name mapping
-			if cnsMetadata.(*cnstypes.CnsKubernetesEntityMetadata).EntityType == string(cnstypes.CnsKubernetesEntityTypePOD) {
-				cnsVolumeToPodMap[pvName] = cnsMetadata.GetCnsEntityMetadata().EntityName
-				cnsVolumeToEntityNamespaceMap[pvName] = cnsMetadata.(*cnstypes.CnsKubernetesEntityMetadata).Namespace
-			}
-			// Construct CNS volume to Pvc name mapping
-			if cnsMetadata.(*cnstypes.CnsKubernetesEntityMetadata).EntityType == string(cnstypes.CnsKubernetesEntityTypePVC) {
-				cnsVolumeToPvcMap[pvName] = cnsMetadata.GetCnsEntityMetadata().EntityName
-				cnsVolumeToEntityNamespaceMap[pvName] = cnsMetadata.(*cnstypes.CnsKubernetesEntityMetadata).Namespace
+			entityType, _ := common.GetEntityType(cnsMetadata)
+			switch entityType {
+			case common.EntityTypePod:
+				volume.cnsPodName = cnsMetadata.GetCnsEntityMetadata().EntityName
+				volume.cnsEntityNamespace = cnsMetadata.(*cnstypes.CnsKubernetesEntityMetadata).Namespace
+			case common.EntityTypePVC:
+				volume.cnsPVCName = cnsMetadata.GetCnsEntityMetadata().EntityName
+				volume.cnsEntityNamespace = cnsMetadata.(*cnstypes.CnsKubernetesEntityMetadata).Namespace
 			}
 		}
 		// PVC and Pod entries need to be deleted from CNS
@@ -499,15 +837,15 @@ func getCnsUpdateOperationType(pvMetadataList []cnstypes.BaseCnsEntityMetadata,
 // buildCnsMetadataSpecMarkedForDelete builds metadata list for a volume
 // where PVC and/or Pod entries need to be deleted from CNS
 // and returns the update spec to be passed to CNS
-func buildCnsMetadataSpecMarkedForDelete(pv *v1.PersistentVolume, operationType string) cnstypes.CnsVolumeMetadataUpdateSpec {
+func buildCnsMetadataSpecMarkedForDelete(pv *v1.PersistentVolume, volume *fullSyncVolume, operationType string) cnstypes.CnsVolumeMetadataUpdateSpec {
 	// Create new metadata spec with delete flag true
 	var metadataList []cnstypes.BaseCnsEntityMetadata
-	if _, ok := cnsVolumeToPvcMap[pv.Name]; ok && operationType == updateVolumeWithDeleteClaimOperation {
-		pvcMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(cnsVolumeToPvcMap[pv.Name], nil, true, string(cnstypes.CnsKubernetesEntityTypePVC), cnsVolumeToEntityNamespaceMap[pv.Name])
+	if volume != nil && volume.cnsPVCName != "" && operationType == updateVolumeWithDeleteClaimOperation {
+		pvcMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(volume.cnsPVCName, nil, true, common.EntityTypePVC, volume.cnsEntityNamespace)
 		metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(pvcMetadata))
 	}
-	if _, ok := cnsVolumeToPodMap[pv.Name]; ok {
-		podMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(cnsVolumeToPodMap[pv.Name], nil, true, string(cnstypes.CnsKubernetesEntityTypePOD), cnsVolumeToEntityNamespaceMap[pv.Name])
+	if volume != nil && volume.cnsPodName != "" {
+		podMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(volume.cnsPodName, nil, true, common.EntityTypePod, volume.cnsEntityNamespace)
 		metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(podMetadata))
 	}
 
@@ -522,13 +860,13 @@ func buildCnsMetadataSpecMarkedForDelete(pv *v1.PersistentVolume, operationType
 	return updateSpec
 }
 
-// cleanupCnsMaps performs cleanup on cnsCreationMap and cnsDeletionMap
-// Removes volume entries from cnsCreationMap that do not exist in K8s
+// cleanupCnsMaps performs cleanup on cnsCreationMap, cnsDeletionMap, cnsSyncedMetadataMap and cnsVolumeDatastoreMap
+// Removes volume entries from cnsCreationMap, cnsSyncedMetadataMap and cnsVolumeDatastoreMap that do not exist in K8s
 // and volume entries from cnsDeletionMap that exist in K8s
 // An entry could have been added to cnsCreationMap (or cnsDeletionMap)
 // because full sync was triggered in between the delete (or create)
 // operation of a volume
-func cleanupCnsMaps(k8sPVs map[string]string) {
+func cleanupCnsMaps(k8sPVs map[string]*fullSyncVolume) {
 	// Cleanup cnsCreationMap
 	for volID := range cnsCreationMap {
 		if _, existsInK8s := k8sPVs[volID]; !existsInK8s {
@@ -541,4 +879,16 @@ func cleanupCnsMaps(k8sPVs map[string]string) {
 			delete(cnsDeletionMap, volID)
 		}
 	}
+	// Cleanup cnsSyncedMetadataMap
+	for volID := range cnsSyncedMetadataMap {
+		if _, existsInK8s := k8sPVs[volID]; !existsInK8s {
+			delete(cnsSyncedMetadataMap, volID)
+		}
+	}
+	// Cleanup cnsVolumeDatastoreMap
+	for volID := range cnsVolumeDatastoreMap {
+		if _, existsInK8s := k8sPVs[volID]; !existsInK8s {
+			delete(cnsVolumeDatastoreMap, volID)
+		}
+	}
 }
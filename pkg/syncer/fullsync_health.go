@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"k8s.io/klog"
+)
+
+// checkVirtualCenterHealth verifies the syncer holds a valid session on
+// vCenter before full sync queries CNS
+func checkVirtualCenterHealth(ctx context.Context, metadataSyncer *MetadataSyncInformer) error {
+	if metadataSyncer.vcenter == nil || metadataSyncer.vcenter.Client == nil {
+		return fmt.Errorf("not connected to vCenter")
+	}
+	session, err := metadataSyncer.vcenter.Client.SessionManager.UserSession(ctx)
+	if err != nil {
+		klog.Errorf("FullSync: Failed to get user session from vCenter %q. Err: %v", metadataSyncer.vcenter.Config.Host, err)
+		return err
+	}
+	if session == nil {
+		return fmt.Errorf("no valid session on vCenter %q", metadataSyncer.vcenter.Config.Host)
+	}
+	return nil
+}
+
+// checkFullSyncData returns why the volumes returned by CNS in a full sync
+// cycle may be incomplete, or an empty string if they can be trusted.
+// Creating and deleting volumes based on incomplete data would register
+// volumes again or delete volumes still in use, so such cycles are suspect.
+// The data is suspect when:
+//  1. CNS reports more volumes than it returned
+//  2. the previous cycle failed to query CNS, as vsan-health may be flapping
+//  3. the number of volumes dropped below suspectVolumeDropRatio of the
+//     previous cycle, which had at least suspectVolumeDropMinVolumes volumes
+func checkFullSyncData(result *cnstypes.CnsQueryResult, previousCycleFailed bool, previousVolumeCount int) string {
+	count := len(result.Volumes)
+	if result.Cursor.TotalRecords > int64(count) {
+		return fmt.Sprintf("CNS returned %d of %d volumes", count, result.Cursor.TotalRecords)
+	}
+	if previousCycleFailed {
+		return "CNS could not be queried in the previous cycle"
+	}
+	if previousVolumeCount >= suspectVolumeDropMinVolumes && float64(count) < float64(previousVolumeCount)*suspectVolumeDropRatio {
+		return fmt.Sprintf("CNS returned %d volumes, down from %d in the previous cycle", count, previousVolumeCount)
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"fmt"
+	"testing"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+)
+
+func TestCheckFullSyncData(t *testing.T) {
+	newResult := func(count int, totalRecords int64) *cnstypes.CnsQueryResult {
+		result := &cnstypes.CnsQueryResult{Cursor: cnstypes.CnsCursor{TotalRecords: totalRecords}}
+		for i := 0; i < count; i++ {
+			result.Volumes = append(result.Volumes, cnstypes.CnsVolume{VolumeId: cnstypes.CnsVolumeId{Id: fmt.Sprintf("volume-%d", i)}})
+		}
+		return result
+	}
+	tests := []struct {
+		name                string
+		result              *cnstypes.CnsQueryResult
+		previousCycleFailed bool
+		previousVolumeCount int
+		suspect             bool
+	}{
+		{"complete", newResult(20, 20), false, 20, false},
+		{"no total records", newResult(20, 0), false, 18, false},
+		{"incomplete", newResult(20, 25), false, 20, true},
+		{"previous cycle failed", newResult(20, 20), true, 20, true},
+		{"volume count dropped", newResult(4, 4), false, 20, true},
+		{"few volumes dropped", newResult(1, 1), false, 5, false},
+	}
+	for _, test := range tests {
+		reason := checkFullSyncData(test.result, test.previousCycleFailed, test.previousVolumeCount)
+		if (reason != "") != test.suspect {
+			t.Errorf("%s: expected suspect %t, got reason %q", test.name, test.suspect, reason)
+		}
+	}
+}
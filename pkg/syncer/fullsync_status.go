@@ -0,0 +1,170 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+)
+
+// fullSyncResult is the result of a full sync cycle, published in the full
+// sync status ConfigMap so operators and tests can follow full sync.
+type fullSyncResult struct {
+	lock sync.Mutex
+
+	// Generation increases by one with each completed cycle, starting at 1
+	Generation int64 `json:"generation"`
+	// Completed is set if the cycle ran to the end. Cycles which returned
+	// early, e.g. as CNS couldn't be queried, keep the generation of the last
+	// completed cycle.
+	Completed bool      `json:"completed"`
+	Cycle     int       `json:"cycle"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	// DurationSeconds is the time the cycle took
+	DurationSeconds float64 `json:"durationSeconds"`
+	// PVs and CnsVolumes are the numbers of PVs and CNS volumes compared
+	PVs        int `json:"pvs"`
+	CnsVolumes int `json:"cnsVolumes"`
+	// Suspect is why creation and deletion of volumes were skipped, if they were
+	Suspect string `json:"suspect,omitempty"`
+	// Volumes created, deleted and updated on CNS
+	CreatedVolumes []string `json:"createdVolumes,omitempty"`
+	DeletedVolumes []string `json:"deletedVolumes,omitempty"`
+	UpdatedVolumes []string `json:"updatedVolumes,omitempty"`
+	// SkippedPVs are the PVs whose PVC or pods could not be fetched, their
+	// PVC and pod metadata was not deleted from CNS
+	SkippedPVs []string `json:"skippedPVs,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// newFullSyncResult returns the result of the given full sync cycle starting now
+func newFullSyncResult(cycle int) *fullSyncResult {
+	return &fullSyncResult{Cycle: cycle, StartTime: time.Now()}
+}
+
+func (r *fullSyncResult) addCreated(volumeID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.CreatedVolumes = append(r.CreatedVolumes, volumeID)
+}
+
+func (r *fullSyncResult) addDeleted(volumeID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.DeletedVolumes = append(r.DeletedVolumes, volumeID)
+}
+
+func (r *fullSyncResult) addUpdated(volumeID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.UpdatedVolumes = append(r.UpdatedVolumes, volumeID)
+}
+
+func (r *fullSyncResult) setSkipped(skippedPVs map[string]bool) {
+	prometheus.FullSyncSkippedVolumes.Set(float64(len(skippedPVs)))
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.SkippedPVs = nil
+	for pvName := range skippedPVs {
+		r.SkippedPVs = append(r.SkippedPVs, pvName)
+	}
+	sort.Strings(r.SkippedPVs)
+}
+
+func (r *fullSyncResult) addError(format string, args ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// getFullSyncStatusConfigMapNamespace returns the namespace of the full sync status ConfigMap
+func getFullSyncStatusConfigMapNamespace() string {
+	if v := os.Getenv(envFullSyncStatusConfigMapNamespace); v != "" {
+		return v
+	}
+	return defaultFullSyncStatusConfigMapNamespace
+}
+
+// publishFullSyncResult completes the result, bumps the full sync generation
+// if the cycle completed and writes the result to the full sync status
+// ConfigMap, creating the ConfigMap if needed. Failures are logged only.
+func publishFullSyncResult(k8sclient clientset.Interface, result *fullSyncResult) {
+	result.lock.Lock()
+	if result.Completed {
+		fullSyncGeneration++
+		prometheus.FullSyncGeneration.Set(float64(fullSyncGeneration))
+	}
+	result.Generation = fullSyncGeneration
+	result.EndTime = time.Now()
+	result.DurationSeconds = result.EndTime.Sub(result.StartTime).Seconds()
+	data, err := json.Marshal(result)
+	result.lock.Unlock()
+	if err != nil {
+		klog.Errorf("FullSync: Failed to marshal result of cycle %d. Err: %v", result.Cycle, err)
+		return
+	}
+	klog.V(2).Infof("FullSync: result of cycle %d: %s", result.Cycle, data)
+	if k8sclient == nil {
+		return
+	}
+	namespace := getFullSyncStatusConfigMapNamespace()
+	configMaps := k8sclient.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMaps.Get(fullSyncStatusConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fullSyncStatusConfigMapName,
+				Namespace: namespace,
+			},
+			Data: map[string]string{
+				fullSyncStatusGenerationKey: strconv.FormatInt(result.Generation, 10),
+				fullSyncStatusResultKey:     string(data),
+			},
+		}
+		if _, err = configMaps.Create(configMap); err != nil {
+			klog.Errorf("FullSync: Failed to create status ConfigMap %s/%s. Err: %v", namespace, fullSyncStatusConfigMapName, err)
+		}
+		return
+	}
+	if err != nil {
+		klog.Errorf("FullSync: Failed to get status ConfigMap %s/%s. Err: %v", namespace, fullSyncStatusConfigMapName, err)
+		return
+	}
+	configMap = configMap.DeepCopy()
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[fullSyncStatusGenerationKey] = strconv.FormatInt(result.Generation, 10)
+	configMap.Data[fullSyncStatusResultKey] = string(data)
+	if _, err = configMaps.Update(configMap); err != nil {
+		klog.Errorf("FullSync: Failed to update status ConfigMap %s/%s. Err: %v", namespace, fullSyncStatusConfigMapName, err)
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"testing"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	v1 "k8s.io/api/core/v1"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func TestMetadataHashIgnoresOrder(t *testing.T) {
+	pvMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(testVolumeName,
+		map[string]string{testPVLabelName: testPVLabelValue, "app": "db"}, false, string(cnstypes.CnsKubernetesEntityTypePV), "")
+	pvcMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(testPVCName,
+		map[string]string{testPVCLabelName: testPVCLabelValue}, false, string(cnstypes.CnsKubernetesEntityTypePVC), testNamespace)
+	hash := getMetadataHash([]cnstypes.BaseCnsEntityMetadata{pvMetadata, pvcMetadata})
+	if reordered := getMetadataHash([]cnstypes.BaseCnsEntityMetadata{pvcMetadata, pvMetadata}); reordered != hash {
+		t.Errorf("Expected hash %d for reordered metadata, got %d", hash, reordered)
+	}
+	pvMetadata.Labels[0], pvMetadata.Labels[1] = pvMetadata.Labels[1], pvMetadata.Labels[0]
+	if reordered := getMetadataHash([]cnstypes.BaseCnsEntityMetadata{pvMetadata, pvcMetadata}); reordered != hash {
+		t.Errorf("Expected hash %d for reordered labels, got %d", hash, reordered)
+	}
+	pvcMetadata.Labels[0].Value = newTestPVCLabelValue
+	if changed := getMetadataHash([]cnstypes.BaseCnsEntityMetadata{pvMetadata, pvcMetadata}); changed == hash {
+		t.Errorf("Expected hash to change with PVC labels")
+	}
+}
+
+func TestBuildVolumeMapSkipsUnchangedVolumes(t *testing.T) {
+	cnsCreationMap = make(map[string]bool)
+	cnsSyncedMetadataMap = make(map[string]uint64)
+	unchangedPV := getPersistentVolumeSpec("volume-unchanged", v1.PersistentVolumeReclaimRetain,
+		map[string]string{testPVLabelName: testPVLabelValue}, v1.VolumeBound, "")
+	newPV := getPersistentVolumeSpec("volume-new", v1.PersistentVolumeReclaimRetain, nil, v1.VolumeAvailable, "")
+	cnsSyncedMetadataMap["volume-unchanged"] = getMetadataHash(buildCnsUpdateMetadataList(unchangedPV, pvcMap{}, podMap{}))
+
+	// Only the unchanged volume exists on CNS, so no volume needs to be queried from CNS
+	// and metadataSyncer isn't used
+	k8sPVMap := buildVolumeMap([]*v1.PersistentVolume{unchangedPV, newPV},
+		map[string]string{"volume-unchanged": ""}, pvcMap{}, podMap{}, nil)
+	if op := k8sPVMap["volume-unchanged"].operation; op != "" {
+		t.Errorf("Expected no operation for unchanged volume, got %q", op)
+	}
+	if op := k8sPVMap["volume-new"].operation; op != "" || !cnsCreationMap["volume-new"] {
+		t.Errorf("Expected volume-new to be marked for creation in the next cycle, got operation %q", op)
+	}
+	if _, ok := cnsSyncedMetadataMap["volume-unchanged"]; !ok {
+		t.Errorf("Expected the synced metadata of the unchanged volume to be kept")
+	}
+}
+
+func TestStartFullSyncCycle(t *testing.T) {
+	fullSyncCycle = 0
+	cnsSyncedMetadataMap = map[string]uint64{"volume-1": 1}
+	for cycle := 1; cycle < defaultFullSyncCompleteScanCycles; cycle++ {
+		if started := startFullSyncCycle(); started != cycle {
+			t.Fatalf("Expected cycle %d, got %d", cycle, started)
+		}
+		if len(cnsSyncedMetadataMap) != 1 {
+			t.Fatalf("Expected synced metadata to be kept in cycle %d", cycle)
+		}
+	}
+	startFullSyncCycle()
+	if len(cnsSyncedMetadataMap) != 0 {
+		t.Errorf("Expected synced metadata to be cleared in cycle %d", defaultFullSyncCompleteScanCycles)
+	}
+}
+
+func TestPublishFullSyncResultCountsCompletedCycles(t *testing.T) {
+	fullSyncGeneration = 0
+	// A cycle which returned early, e.g. as CNS couldn't be queried
+	failed := newFullSyncResult(1)
+	publishFullSyncResult(nil, failed)
+	if fullSyncGeneration != 0 || failed.Generation != 0 {
+		t.Fatalf("Expected an incomplete cycle to keep generation 0, got %d", failed.Generation)
+	}
+	completed := newFullSyncResult(2)
+	completed.Completed = true
+	publishFullSyncResult(nil, completed)
+	if fullSyncGeneration != 1 || completed.Generation != 1 {
+		t.Errorf("Expected a completed cycle to bump the generation to 1, got %d", completed.Generation)
+	}
+}
+
+func TestMetadataChecksum(t *testing.T) {
+	newMetadataList := func() []cnstypes.BaseCnsEntityMetadata {
+		return []cnstypes.BaseCnsEntityMetadata{
+			cnsvsphere.GetCnsKubernetesEntityMetaData(testVolumeName, map[string]string{testPVLabelName: testPVLabelValue},
+				false, string(cnstypes.CnsKubernetesEntityTypePV), ""),
+			cnsvsphere.GetCnsKubernetesEntityMetaData(testPVCName, map[string]string{testPVCLabelName: testPVCLabelValue},
+				false, string(cnstypes.CnsKubernetesEntityTypePVC), testNamespace),
+		}
+	}
+	cnsMetadataList := withMetadataChecksum(newMetadataList())
+	if checksum := getCnsMetadataChecksum(cnsMetadataList); checksum != getMetadataChecksum(newMetadataList()) {
+		t.Errorf("Expected checksum %s, got %s", getMetadataChecksum(newMetadataList()), checksum)
+	}
+	if op := getCnsUpdateOperationType(newMetadataList(), withoutMetadataChecksum(cnsMetadataList), &fullSyncVolume{}); op != "" {
+		t.Errorf("Expected metadata without checksum to match, got operation %q", op)
+	}
+	if getCnsMetadataChecksum(newMetadataList()) != "" {
+		t.Errorf("Expected no checksum in metadata list without checksum")
+	}
+	changedMetadataList := newMetadataList()
+	changedMetadataList[1].(*cnstypes.CnsKubernetesEntityMetadata).Labels[0].Value = newTestPVCLabelValue
+	if getCnsMetadataChecksum(cnsMetadataList) == getMetadataChecksum(changedMetadataList) {
+		t.Errorf("Expected checksum to change with PVC labels")
+	}
+}
+
+func TestGetVolumeMetadataOperation(t *testing.T) {
+	newMetadataList := func() []cnstypes.BaseCnsEntityMetadata {
+		return []cnstypes.BaseCnsEntityMetadata{
+			cnsvsphere.GetCnsKubernetesEntityMetaData(testVolumeName, map[string]string{testPVLabelName: testPVLabelValue},
+				false, string(cnstypes.CnsKubernetesEntityTypePV), ""),
+			cnsvsphere.GetCnsKubernetesEntityMetaData(testPVCName, map[string]string{testPVCLabelName: testPVCLabelValue},
+				false, string(cnstypes.CnsKubernetesEntityTypePVC), testNamespace),
+		}
+	}
+	changedPVCMetadataList := func() []cnstypes.BaseCnsEntityMetadata {
+		metadataList := newMetadataList()
+		metadataList[1].(*cnstypes.CnsKubernetesEntityMetadata).Labels[0].Value = newTestPVCLabelValue
+		return metadataList
+	}
+	// PVC labels changed directly on CNS, after full sync stored the checksum
+	changedOnCnsMetadataList := func() []cnstypes.BaseCnsEntityMetadata {
+		metadataList := withMetadataChecksum(newMetadataList())
+		metadataList[1].(*cnstypes.CnsKubernetesEntityMetadata).Labels[0].Value = newTestPVCLabelValue
+		return metadataList
+	}
+	tests := []struct {
+		name            string
+		metadataList    []cnstypes.BaseCnsEntityMetadata
+		cnsMetadataList []cnstypes.BaseCnsEntityMetadata
+		completeScan    bool
+		expected        string
+	}{
+		// Metadata written by the informer handlers has no checksum, all entity metadata is compared
+		{"in sync without checksum", newMetadataList(), newMetadataList(), false, ""},
+		{"PVC labels changed in K8s without checksum", changedPVCMetadataList(), newMetadataList(), false, updateVolumeOperation},
+		{"stale checksum", changedPVCMetadataList(), withMetadataChecksum(newMetadataList()), false, updateVolumeOperation},
+		{"matching checksum", newMetadataList(), withMetadataChecksum(newMetadataList()), false, ""},
+		{"matching checksum in a complete scan", newMetadataList(), withMetadataChecksum(newMetadataList()), true, ""},
+		// Changes made directly on CNS leave the checksum alone, they are only found in complete scans
+		{"PVC labels changed on CNS", newMetadataList(), changedOnCnsMetadataList(), false, ""},
+		{"PVC labels changed on CNS in a complete scan", newMetadataList(), changedOnCnsMetadataList(), true, updateVolumeOperation},
+		{"PVC entity removed on CNS in a complete scan", newMetadataList(), withMetadataChecksum(newMetadataList())[:1], true, updateVolumeOperation},
+	}
+	for _, test := range tests {
+		op := getVolumeMetadataOperation("volume-1", test.metadataList, test.cnsMetadataList, &fullSyncVolume{}, test.completeScan)
+		if op != test.expected {
+			t.Errorf("%s: expected operation %q, got %q", test.name, test.expected, op)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"strings"
+
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+
+	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+)
+
+// getLabelSyncTagCategories returns the tag categories configured for reverse label sync
+func getLabelSyncTagCategories(metadataSyncer *MetadataSyncInformer) []string {
+	var categories []string
+	for _, category := range strings.Split(metadataSyncer.cfg.LabelSync.TagCategories, ",") {
+		category = strings.TrimSpace(category)
+		if category == "" {
+			continue
+		}
+		if errs := validation.IsQualifiedName(labelSyncPrefix + category); len(errs) > 0 {
+			klog.Warningf("FullSync: Ignoring tag category %q for label sync as it is not a valid label name: %v", category, errs)
+			continue
+		}
+		categories = append(categories, category)
+	}
+	return categories
+}
+
+// syncVolumeTagsToPVLabels reflects the tags attached to the disks of volumes
+// on vCenter as labels on their PVs. For each configured tag category the
+// PV gets the label labelSyncPrefix+category, set to the name of the tag of
+// that category attached to the disk. The label is removed once the disk no
+// longer carries a tag of the category. Other labels are left untouched.
+func syncVolumeTagsToPVLabels(k8sclient clientset.Interface, pvList []*v1.PersistentVolume, cnsVolumes map[string]string, metadataSyncer *MetadataSyncInformer) {
+	categories := getLabelSyncTagCategories(metadataSyncer)
+	if len(categories) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(volumes.WithOpID(context.Background(), "syncer-labelsync"))
+	defer cancel()
+	volumeTags, err := volumes.ListVolumeTagsByCategory(ctx, metadataSyncer.vcenter, categories)
+	if err != nil {
+		// Retry on the next full sync cycle
+		return
+	}
+	for _, pv := range pvList {
+		volumeID := pv.Spec.CSI.VolumeHandle
+		if _, found := cnsVolumes[volumeID]; !found {
+			continue
+		}
+		labels, changed := buildPVTagLabels(pv.Labels, volumeTags[volumeID], categories)
+		if !changed {
+			continue
+		}
+		// Failures are retried on the next full sync cycle
+		_ = setPVTagLabels(k8sclient, pv.Name, labels, categories)
+	}
+}
+
+// buildPVTagLabels returns the labels reflecting the given tags for the given
+// tag categories, and whether they differ from the ones in pvLabels
+func buildPVTagLabels(pvLabels map[string]string, tags []vimtypes.VslmTagEntry, categories []string) (map[string]string, bool) {
+	labels := make(map[string]string)
+	for _, category := range categories {
+		for _, tag := range tags {
+			if tag.ParentCategoryName != category {
+				continue
+			}
+			if errs := validation.IsValidLabelValue(tag.TagName); len(errs) > 0 {
+				klog.Warningf("FullSync: Ignoring tag %q of category %q as it is not a valid label value: %v", tag.TagName, category, errs)
+				continue
+			}
+			labels[labelSyncPrefix+category] = tag.TagName
+			break
+		}
+	}
+	changed := false
+	for _, category := range categories {
+		key := labelSyncPrefix + category
+		value, found := pvLabels[key]
+		newValue, newFound := labels[key]
+		if found != newFound || value != newValue {
+			changed = true
+			break
+		}
+	}
+	return labels, changed
+}
+
+// setPVTagLabels sets the labels of the configured tag categories on the latest
+// version of the PV, removing the labels of categories missing in labels
+func setPVTagLabels(k8sclient clientset.Interface, pvName string, labels map[string]string, categories []string) error {
+	pv, err := k8sclient.CoreV1().PersistentVolumes().Get(pvName, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("FullSync: Failed to get PV %s. Err: %v", pvName, err)
+		return err
+	}
+	newPv := pv.DeepCopy()
+	if newPv.Labels == nil {
+		newPv.Labels = make(map[string]string)
+	}
+	for _, category := range categories {
+		key := labelSyncPrefix + category
+		if value, found := labels[key]; found {
+			newPv.Labels[key] = value
+		} else {
+			delete(newPv.Labels, key)
+		}
+	}
+	if _, err := k8sclient.CoreV1().PersistentVolumes().Update(newPv); err != nil {
+		klog.Errorf("FullSync: Failed to update tag labels on PV %s. Err: %v", pvName, err)
+		return err
+	}
+	klog.V(2).Infof("FullSync: Updated tag labels on PV %s to %v", pvName, labels)
+	return nil
+}
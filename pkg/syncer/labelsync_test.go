@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"testing"
+
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+)
+
+func TestBuildPVTagLabels(t *testing.T) {
+	categories := []string{"backup-tier", "owner"}
+	tags := []vimtypes.VslmTagEntry{
+		{ParentCategoryName: "backup-tier", TagName: "gold"},
+		{ParentCategoryName: "unsynced", TagName: "value"},
+		{ParentCategoryName: "owner", TagName: "not a valid label value"},
+	}
+	pvLabels := map[string]string{
+		testPVLabelName:              testPVLabelValue,
+		labelSyncPrefix + "owner":    "team-a",
+		labelSyncPrefix + "unsynced": "value",
+	}
+	labels, changed := buildPVTagLabels(pvLabels, tags, categories)
+	if !changed {
+		t.Errorf("Expected labels %v to differ from PV labels %v", labels, pvLabels)
+	}
+	expected := map[string]string{labelSyncPrefix + "backup-tier": "gold"}
+	if len(labels) != len(expected) || labels[labelSyncPrefix+"backup-tier"] != "gold" {
+		t.Errorf("Expected labels %v, got %v", expected, labels)
+	}
+	pvLabels = map[string]string{
+		testPVLabelName:                 testPVLabelValue,
+		labelSyncPrefix + "backup-tier": "gold",
+	}
+	if _, changed = buildPVTagLabels(pvLabels, tags, categories); changed {
+		t.Errorf("Expected labels to match PV labels %v", pvLabels)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"k8s.io/klog"
+
+	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/webhook"
+)
+
+// metadataBackend receives the PV, PVC and Pod metadata of volumes pushed by the syncer
+type metadataBackend interface {
+	// Name identifies the backend in logs
+	Name() string
+	// UpdateVolumeMetadata pushes the metadata in updateSpec for the volume
+	UpdateVolumeMetadata(ctx context.Context, updateSpec *cnstypes.CnsVolumeMetadataUpdateSpec) error
+}
+
+// cnsMetadataBackend pushes metadata to CNS
+type cnsMetadataBackend struct {
+	vcenter *cnsvsphere.VirtualCenter
+}
+
+func (b *cnsMetadataBackend) Name() string {
+	return "cns"
+}
+
+func (b *cnsMetadataBackend) UpdateVolumeMetadata(ctx context.Context, updateSpec *cnstypes.CnsVolumeMetadataUpdateSpec) error {
+	return volumes.GetManager(b.vcenter).UpdateVolumeMetadata(ctx, updateSpec)
+}
+
+// volumeMetadataEvent is the document posted by the webhook backend for each metadata update
+type volumeMetadataEvent struct {
+	Time      time.Time        `json:"time"`
+	VolumeID  string           `json:"volumeId"`
+	ClusterID string           `json:"clusterId"`
+	Entities  []entityMetadata `json:"entities"`
+}
+
+// entityMetadata is the metadata of a PV, PVC or Pod using the volume
+type entityMetadata struct {
+	Type      string            `json:"type"`
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	// Deleted is set when the entity no longer uses the volume
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// newVolumeMetadataEvent converts updateSpec to the document posted by the webhook backend
+func newVolumeMetadataEvent(updateSpec *cnstypes.CnsVolumeMetadataUpdateSpec) volumeMetadataEvent {
+	event := volumeMetadataEvent{
+		Time:      time.Now(),
+		VolumeID:  updateSpec.VolumeId.Id,
+		ClusterID: updateSpec.Metadata.ContainerCluster.ClusterId,
+	}
+	for _, metadata := range updateSpec.Metadata.EntityMetadata {
+		k8sMetadata, ok := metadata.(*cnstypes.CnsKubernetesEntityMetadata)
+		if !ok {
+			continue
+		}
+		entity := entityMetadata{
+			Type:      k8sMetadata.EntityType,
+			Name:      k8sMetadata.EntityName,
+			Namespace: k8sMetadata.Namespace,
+			Deleted:   k8sMetadata.Delete,
+		}
+		if len(k8sMetadata.Labels) > 0 {
+			entity.Labels = make(map[string]string, len(k8sMetadata.Labels))
+			for _, label := range k8sMetadata.Labels {
+				entity.Labels[label.Key] = label.Value
+			}
+		}
+		event.Entities = append(event.Entities, entity)
+	}
+	return event
+}
+
+// webhookMetadataBackend posts metadata updates as JSON to a URL. Updates
+// are posted in the background so a slow webhook does not hold up the
+// informer handlers; updates are dropped if the webhook can not keep up.
+type webhookMetadataBackend struct {
+	client *webhook.Client
+	events *webhook.Queue
+}
+
+// newWebhookMetadataBackend returns a backend posting metadata updates to url
+func newWebhookMetadataBackend(url string) *webhookMetadataBackend {
+	b := &webhookMetadataBackend{client: webhook.NewClient(url, metadataWebhookTimeout)}
+	b.events = webhook.NewQueue(metadataWebhookQueueSize, func(item interface{}) {
+		event := item.(volumeMetadataEvent)
+		if err := b.client.Post(event); err != nil {
+			klog.Errorf("Failed to post metadata of volume %s to %s. Err: %v", event.VolumeID, b.Name(), err)
+		}
+	})
+	return b
+}
+
+func (b *webhookMetadataBackend) Name() string {
+	return "webhook " + b.client.URL()
+}
+
+func (b *webhookMetadataBackend) UpdateVolumeMetadata(ctx context.Context, updateSpec *cnstypes.CnsVolumeMetadataUpdateSpec) error {
+	if !b.events.Add(newVolumeMetadataEvent(updateSpec)) {
+		return fmt.Errorf("queue of %s is full, dropping metadata update of volume %s", b.Name(), updateSpec.VolumeId.Id)
+	}
+	return nil
+}
+
+// initMetadataBackends sets up CNS and the external metadata backends configured in cfg
+func (metadataSyncer *MetadataSyncInformer) initMetadataBackends(cfg *cnsconfig.Config) {
+	metadataSyncer.cnsBackend = &cnsMetadataBackend{vcenter: metadataSyncer.vcenter}
+	metadataSyncer.externalBackends = nil
+	if cfg.MetadataBackend.WebhookURL != "" {
+		klog.Infof("Pushing volume metadata to webhook %q", cfg.MetadataBackend.WebhookURL)
+		metadataSyncer.externalBackends = append(metadataSyncer.externalBackends, newWebhookMetadataBackend(cfg.MetadataBackend.WebhookURL))
+	}
+}
+
+// updateVolumeMetadata pushes updateSpec to CNS and then to the external
+// metadata backends. Only CNS failures are returned, the update is then
+// retried in the background; the external backends are best effort and
+// their failures are logged.
+func (metadataSyncer *MetadataSyncInformer) updateVolumeMetadata(ctx context.Context, updateSpec *cnstypes.CnsVolumeMetadataUpdateSpec) error {
+	if err := metadataSyncer.cnsBackend.UpdateVolumeMetadata(ctx, updateSpec); err != nil {
+		metadataSyncer.metadataRetries.add(updateSpec, err)
+		return err
+	}
+	metadataSyncer.metadataRetries.forget(updateSpec)
+	metadataSyncer.pushToExternalBackends(ctx, updateSpec)
+	return nil
+}
+
+// pushToExternalBackends pushes updateSpec, already applied on CNS, to the external metadata backends
+func (metadataSyncer *MetadataSyncInformer) pushToExternalBackends(ctx context.Context, updateSpec *cnstypes.CnsVolumeMetadataUpdateSpec) {
+	for _, backend := range metadataSyncer.externalBackends {
+		if err := backend.UpdateVolumeMetadata(ctx, updateSpec); err != nil {
+			klog.Errorf("Failed to push metadata of volume %s to %s. Err: %v", updateSpec.VolumeId.Id, backend.Name(), err)
+		}
+	}
+}
@@ -25,15 +25,22 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	csictx "github.com/rexray/gocsi/context"
 	cnstypes "github.com/vmware/govmomi/cns/types"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 
 	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/logging"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service"
 	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
 	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
@@ -67,12 +74,27 @@ func getFullSyncIntervalInMin() int {
 	return fullSyncIntervalInMin
 }
 
-// Init initializes the Metadata Sync Informer
-func (metadataSyncer *MetadataSyncInformer) Init() error {
-	var err error
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// getFullSyncCompleteScanCycles return the number of fullsync cycles after which
+// metadata of all volumes is compared with CNS, even if unchanged in K8s.
+// If enviroment variable FULL_SYNC_COMPLETE_SCAN_CYCLES is set and valid,
+// return the value read from enviroment variable
+// otherwise, use the default value 4
+func getFullSyncCompleteScanCycles() int {
+	completeScanCycles := defaultFullSyncCompleteScanCycles
+	if v := os.Getenv(envFullSyncCompleteScanCycles); v != "" {
+		if value, err := strconv.Atoi(v); err == nil && value > 0 {
+			completeScanCycles = value
+			klog.V(2).Infof("FullSync: complete scan is set to every %d cycles", completeScanCycles)
+		} else {
+			klog.Warningf("FullSync: FULL_SYNC_COMPLETE_SCAN_CYCLES %s is invalid, will use the default value", v)
+		}
+	}
+	return completeScanCycles
+}
 
+// connectVirtualCenter reads the config and connects to the vCenter it configures
+func (metadataSyncer *MetadataSyncInformer) connectVirtualCenter(ctx context.Context) error {
+	var err error
 	cfgPath := csictx.Getenv(ctx, cnsconfig.EnvCloudConfig)
 	if cfgPath == "" {
 		cfgPath = cnsconfig.DefaultCloudConfigPath
@@ -105,17 +127,55 @@ func (metadataSyncer *MetadataSyncInformer) Init() error {
 		klog.Errorf("Failed to connect to VirtualCenter host: %q. err=%v", metadataSyncer.vcconfig.Host, err)
 		return err
 	}
+	return nil
+}
+
+// Init initializes the Metadata Sync Informer
+func (metadataSyncer *MetadataSyncInformer) Init() error {
+	var err error
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Expose the build of the syncer
+	manifest := service.GetBuildManifest()
+	prometheus.SyncerInfo.WithLabelValues(service.GetVersion(), manifest[service.ManifestGitCommit], manifest[service.ManifestBuildDate], manifest[service.ManifestVSphereAPILevel]).Set(1)
+	prometheus.HandleDebug("loglevel", logging.LevelHandler())
+	prometheus.StartMetricsServer(prometheus.DefaultSyncerMetricsAddress)
+
+	if err = metadataSyncer.connectVirtualCenter(ctx); err != nil {
+		return err
+	}
+	if err = logging.Configure(metadataSyncer.cfg.Logging.Format, metadataSyncer.cfg.Logging.Level); err != nil {
+		klog.Errorf("Failed to configure logging. Err: %v", err)
+		return err
+	}
+	metadataSyncer.initMetadataBackends(metadataSyncer.cfg)
+	metadataSyncer.metadataRetries = newMetadataRetryQueue(metadataSyncer.cnsBackend.UpdateVolumeMetadata)
+	metadataSyncer.metadataRetries.pushed = metadataSyncer.pushToExternalBackends
+	go metadataSyncer.metadataRetries.run()
+	prometheus.HandleDebug("metadata-dead-letters", metadataSyncer.metadataRetries)
+	volumes.ConfigureScheduler(metadataSyncer.cfg.Global.ForegroundLoadThreshold, metadataSyncer.cfg.Global.MaxBackgroundOperations)
+	volumes.ConfigureVSphereUserPolicy(metadataSyncer.cfg.Global.VSphereUserPolicy)
+	go watchControllerLoad(getControllerMetricsURL())
 	// Create the kubernetes client from config
 	k8sclient, err := k8s.NewClient()
 	if err != nil {
 		klog.Errorf("Creating Kubernetes client failed. Err: %v", err)
 		return err
 	}
+	metadataSyncer.k8sclient = k8sclient
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8sclient.CoreV1().Events("")})
+	metadataSyncer.eventRecorder = eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventSourceComponent})
 
 	// Initialize cnsDeletionMap used by Full Sync
 	cnsDeletionMap = make(map[string]bool)
 	// Initialize cnsCreationMap used by Full Sync
 	cnsCreationMap = make(map[string]bool)
+	// Initialize cnsSyncedMetadataMap used by incremental Full Sync
+	cnsSyncedMetadataMap = make(map[string]uint64)
+	// Initialize cnsVolumeDatastoreMap used by Full Sync to detect relocated volumes
+	cnsVolumeDatastoreMap = make(map[string]string)
 
 	ticker := time.NewTicker(time.Duration(getFullSyncIntervalInMin()) * time.Minute)
 	// Trigger full sync
@@ -131,7 +191,9 @@ func (metadataSyncer *MetadataSyncInformer) Init() error {
 	// Set up kubernetes resource listeners for metadata syncer
 	metadataSyncer.k8sInformerManager = k8s.NewInformer(k8sclient)
 	metadataSyncer.k8sInformerManager.AddPVCListener(
-		nil, // Add
+		func(obj interface{}) { // Add
+			pvcAdded(obj, metadataSyncer)
+		},
 		func(oldObj interface{}, newObj interface{}) { // Update
 			pvcUpdated(oldObj, newObj, metadataSyncer)
 		},
@@ -147,7 +209,9 @@ func (metadataSyncer *MetadataSyncInformer) Init() error {
 			pvDeleted(obj, metadataSyncer)
 		})
 	metadataSyncer.k8sInformerManager.AddPodListener(
-		nil, // Add
+		func(obj interface{}) { // Add
+			podAdded(obj, metadataSyncer)
+		},
 		func(oldObj interface{}, newObj interface{}) { // Update
 			podUpdated(oldObj, newObj, metadataSyncer)
 		},
@@ -156,13 +220,48 @@ func (metadataSyncer *MetadataSyncInformer) Init() error {
 		})
 	metadataSyncer.pvLister = metadataSyncer.k8sInformerManager.GetPVLister()
 	metadataSyncer.pvcLister = metadataSyncer.k8sInformerManager.GetPVCLister()
+	if metadataSyncer.cfg.Telemetry.Endpoint != "" {
+		go newTelemetryReporter(metadataSyncer).run()
+	}
 	klog.V(2).Infof("Initialized metadata syncer")
 	stopCh := metadataSyncer.k8sInformerManager.Listen()
+	defer metadataSyncer.k8sInformerManager.Release()
 	<-(stopCh)
 	<-(stopFullSync)
 	return nil
 }
 
+// pvcAdded pushes the metadata of a bound persistent volume claim to VC, so a
+// PVC binding a retained PV is reflected right away rather than at the next full sync.
+// The informer adds every bound PVC when the syncer starts, which full sync
+// reconciles anyway, so the updates run in the background and are throttled
+// while CSI operations are waiting.
+func pvcAdded(obj interface{}, metadataSyncer *MetadataSyncInformer) {
+	pvc, ok := obj.(*v1.PersistentVolumeClaim)
+	if pvc == nil || !ok {
+		klog.Warningf("PVCAdded: unrecognized object %+v", obj)
+		return
+	}
+	if pvc.Status.Phase != v1.ClaimBound {
+		klog.V(3).Infof("PVCAdded: PVC %s/%s not in Bound phase", pvc.Namespace, pvc.Name)
+		return
+	}
+	pv, err := getPVForPVC(pvc, metadataSyncer)
+	if err != nil {
+		klog.Errorf("PVCAdded: Error getting Persistent Volume for pvc %s in namespace %s with err: %v", pvc.Name, pvc.Namespace, err)
+		return
+	}
+	// Verify if pv is vsphere csi volume
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != service.Name {
+		klog.V(3).Infof("PVCAdded: Not a Vsphere CSI Volume")
+		return
+	}
+	ctx := volumes.WithPriority(context.Background(), volumes.PriorityBackground)
+	if err := updatePVCMetadata(ctx, pvc, pv, metadataSyncer, false); err != nil {
+		klog.Errorf("PVCAdded: UpdateVolumeMetadata failed with err %v", err)
+	}
+}
+
 // pvcUpdated updates persistent volume claim metadata on VC when pvc labels on K8S cluster have been updated
 func pvcUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer) {
 	// Get old and new pvc objects
@@ -181,8 +280,8 @@ func pvcUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer
 	}
 
 	// Get pv object attached to pvc
-	pv, err := metadataSyncer.pvLister.Get(newPvc.Spec.VolumeName)
-	if pv == nil || err != nil {
+	pv, err := getPVForPVC(newPvc, metadataSyncer)
+	if err != nil {
 		klog.Errorf("PVCUpdated: Error getting Persistent Volume for pvc %s in namespace %s with err: %v", newPvc.Name, newPvc.Namespace, err)
 		return
 	}
@@ -199,29 +298,17 @@ func pvcUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer
 		return
 	}
 
-	// Create updateSpec
-	var metadataList []cnstypes.BaseCnsEntityMetadata
-	pvcMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(newPvc.Name, newPvc.Labels, false, string(cnstypes.CnsKubernetesEntityTypePVC), newPvc.Namespace)
-	metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(pvcMetadata))
-
-	updateSpec := &cnstypes.CnsVolumeMetadataUpdateSpec{
-		VolumeId: cnstypes.CnsVolumeId{
-			Id: pv.Spec.CSI.VolumeHandle,
-		},
-		Metadata: cnstypes.CnsVolumeMetadata{
-			ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
-			EntityMetadata:   metadataList,
-		},
-	}
-
-	klog.V(4).Infof("PVCUpdated: Calling UpdateVolumeMetadata with updateSpec: %+v", spew.Sdump(updateSpec))
-	if err := volumes.GetManager(metadataSyncer.vcenter).UpdateVolumeMetadata(updateSpec); err != nil {
+	if err := updatePVCMetadata(context.Background(), newPvc, pv, metadataSyncer, false); err != nil {
 		klog.Errorf("PVCUpdated: UpdateVolumeMetadata failed with err %v", err)
 	}
 }
 
 // pvDeleted deletes pvc metadata on VC when pvc has been deleted on K8s cluster
 func pvcDeleted(obj interface{}, metadataSyncer *MetadataSyncInformer) {
+	// The informer may have missed the deletion, then obj is the last known state of the PVC
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
 	pvc, ok := obj.(*v1.PersistentVolumeClaim)
 	if pvc == nil || !ok {
 		klog.Warningf("PVCDeleted: unrecognized object %+v", obj)
@@ -232,8 +319,8 @@ func pvcDeleted(obj interface{}, metadataSyncer *MetadataSyncInformer) {
 		return
 	}
 	// Get pv object attached to pvc
-	pv, err := metadataSyncer.pvLister.Get(pvc.Spec.VolumeName)
-	if pv == nil || err != nil {
+	pv, err := getPVForPVC(pvc, metadataSyncer)
+	if err != nil {
 		klog.Errorf("PVCDeleted: Error getting Persistent Volume for pvc %s in namespace %s with err: %v", pvc.Name, pvc.Namespace, err)
 		return
 	}
@@ -250,9 +337,52 @@ func pvcDeleted(obj interface{}, metadataSyncer *MetadataSyncInformer) {
 		return
 	}
 
-	// If the PV reclaim policy is retain we need to delete PVC labels
+	// If the PV reclaim policy is retain we need to delete PVC labels. Only the
+	// entity of the deleted PVC is removed, the PV may already be bound to a new PVC.
+	if err := updatePVCMetadata(context.Background(), pvc, pv, metadataSyncer, true); err != nil {
+		klog.Errorf("PVCDeleted: UpdateVolumeMetadata failed with err %v", err)
+	}
+}
+
+// getPVForPVC returns the PV bound to the PVC. The PV is read from the API
+// server when the lister does not have it yet, as the PVC and PV informers
+// are not synchronized.
+func getPVForPVC(pvc *v1.PersistentVolumeClaim, metadataSyncer *MetadataSyncInformer) (*v1.PersistentVolume, error) {
+	pv, err := metadataSyncer.pvLister.Get(pvc.Spec.VolumeName)
+	if err == nil {
+		return pv, nil
+	}
+	if !apierrors.IsNotFound(err) || metadataSyncer.k8sclient == nil {
+		return nil, err
+	}
+	klog.V(4).Infof("PV %s of PVC %s/%s not found in the lister, getting it from the API server", pvc.Spec.VolumeName, pvc.Namespace, pvc.Name)
+	return metadataSyncer.k8sclient.CoreV1().PersistentVolumes().Get(pvc.Spec.VolumeName, metav1.GetOptions{})
+}
+
+// getPVC returns the PVC with the given namespace and name. The PVC is read
+// from the API server when the lister does not have it yet, e.g. for pods
+// seen before the PVC informer has synced.
+func getPVC(namespace string, name string, metadataSyncer *MetadataSyncInformer) (*v1.PersistentVolumeClaim, error) {
+	pvc, err := metadataSyncer.pvcLister.PersistentVolumeClaims(namespace).Get(name)
+	if err == nil {
+		return pvc, nil
+	}
+	if !apierrors.IsNotFound(err) || metadataSyncer.k8sclient == nil {
+		return nil, err
+	}
+	klog.V(4).Infof("PVC %s/%s not found in the lister, getting it from the API server", namespace, name)
+	return metadataSyncer.k8sclient.CoreV1().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
+}
+
+// updatePVCMetadata pushes the metadata of the PVC bound to the PV to VC, or
+// marks the PVC entity for deletion when deleteFlag is set
+func updatePVCMetadata(ctx context.Context, pvc *v1.PersistentVolumeClaim, pv *v1.PersistentVolume, metadataSyncer *MetadataSyncInformer, deleteFlag bool) error {
+	var labels map[string]string
+	if !deleteFlag {
+		labels = pvc.Labels
+	}
 	var metadataList []cnstypes.BaseCnsEntityMetadata
-	pvcMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pvc.Name, nil, true, string(cnstypes.CnsKubernetesEntityTypePVC), pvc.Namespace)
+	pvcMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pvc.Name, labels, deleteFlag, common.EntityTypePVC, pvc.Namespace)
 	metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(pvcMetadata))
 
 	updateSpec := &cnstypes.CnsVolumeMetadataUpdateSpec{
@@ -260,15 +390,13 @@ func pvcDeleted(obj interface{}, metadataSyncer *MetadataSyncInformer) {
 			Id: pv.Spec.CSI.VolumeHandle,
 		},
 		Metadata: cnstypes.CnsVolumeMetadata{
-			ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
+			ContainerCluster: metadataSyncer.vcenter.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID),
 			EntityMetadata:   metadataList,
 		},
 	}
 
-	klog.V(4).Infof("PVCDeleted: Calling UpdateVolumeMetadata for volume %s with updateSpec: %+v", updateSpec.VolumeId.Id, spew.Sdump(updateSpec))
-	if err := volumes.GetManager(metadataSyncer.vcenter).UpdateVolumeMetadata(updateSpec); err != nil {
-		klog.Errorf("PVCDeleted: UpdateVolumeMetadata failed with err %v", err)
-	}
+	klog.V(4).Infof("Calling UpdateVolumeMetadata for volume %s with updateSpec: %v", updateSpec.VolumeId.Id, volumes.Dump(updateSpec))
+	return metadataSyncer.updateVolumeMetadata(ctx, updateSpec)
 }
 
 // pvUpdated updates volume metadata on VC when volume labels on K8S cluster have been updated
@@ -312,7 +440,7 @@ func pvUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer)
 	}
 
 	var metadataList []cnstypes.BaseCnsEntityMetadata
-	pvMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(newPv.Name, newPv.GetLabels(), false, string(cnstypes.CnsKubernetesEntityTypePV), newPv.Namespace)
+	pvMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(newPv.Name, newPv.GetLabels(), false, common.EntityTypePV, newPv.Namespace)
 	metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(pvMetadata))
 
 	if oldPv.Status.Phase == v1.VolumeAvailable || newPv.Spec.StorageClassName != "" {
@@ -321,13 +449,13 @@ func pvUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer)
 				Id: newPv.Spec.CSI.VolumeHandle,
 			},
 			Metadata: cnstypes.CnsVolumeMetadata{
-				ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
+				ContainerCluster: metadataSyncer.vcenter.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID),
 				EntityMetadata:   metadataList,
 			},
 		}
 
-		klog.V(4).Infof("PVUpdated: Calling UpdateVolumeMetadata for volume %s with updateSpec: %+v", updateSpec.VolumeId.Id, spew.Sdump(updateSpec))
-		if err := volumes.GetManager(metadataSyncer.vcenter).UpdateVolumeMetadata(updateSpec); err != nil {
+		klog.V(4).Infof("PVUpdated: Calling UpdateVolumeMetadata for volume %s with updateSpec: %v", updateSpec.VolumeId.Id, volumes.Dump(updateSpec))
+		if err := metadataSyncer.updateVolumeMetadata(context.Background(), updateSpec); err != nil {
 			klog.Errorf("PVUpdated: UpdateVolumeMetadata failed with err %v", err)
 		}
 	} else {
@@ -335,7 +463,7 @@ func pvUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer)
 			Name:       oldPv.Name,
 			VolumeType: common.BlockVolumeType,
 			Metadata: cnstypes.CnsVolumeMetadata{
-				ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
+				ContainerCluster: metadataSyncer.vcenter.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID),
 				EntityMetadata:   metadataList,
 			},
 			BackingObjectDetails: &cnstypes.CnsBlockBackingDetails{
@@ -344,12 +472,16 @@ func pvUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer)
 			},
 		}
 		volumeOperationsLock.Lock()
-		defer volumeOperationsLock.Unlock()
-		klog.V(4).Infof("PVUpdated: vSphere provisioner creating volume %s with create spec %+v", oldPv.Name, spew.Sdump(createSpec))
-		_, err := volumes.GetManager(metadataSyncer.vcenter).CreateVolume(createSpec)
+		klog.V(4).Infof("PVUpdated: vSphere provisioner creating volume %s with create spec %v", oldPv.Name, volumes.Dump(createSpec))
+		_, err := volumes.GetManager(metadataSyncer.vcenter).CreateVolume(context.Background(), createSpec)
+		volumeOperationsLock.Unlock()
 
 		if err != nil {
 			klog.Errorf("PVUpdated: Failed to create disk %s with error %+v", oldPv.Name, err)
+			return
+		}
+		if metadataSyncer.k8sclient != nil {
+			setStaticPVNodeAffinities(context.Background(), metadataSyncer.k8sclient, []*v1.PersistentVolume{newPv}, metadataSyncer)
 		}
 	}
 }
@@ -377,6 +509,9 @@ func pvDeleted(obj interface{}, metadataSyncer *MetadataSyncInformer) {
 	if pv.Spec.ClaimRef == nil || (pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete) {
 		klog.V(4).Infof("PVDeleted: Setting DeleteDisk to false")
 		deleteDisk = false
+	} else if common.IsRetainDiskSet(pv.Annotations[common.AnnRetainDisk]) {
+		klog.V(4).Infof("PVDeleted: Setting DeleteDisk to false as PV %s has annotation %s", pv.Name, common.AnnRetainDisk)
+		deleteDisk = false
 	} else {
 		// We set delete disk=true for the case where PV status is failed after deletion of pvc
 		// In this case, metadatasyncer will remove the volume
@@ -386,12 +521,33 @@ func pvDeleted(obj interface{}, metadataSyncer *MetadataSyncInformer) {
 	volumeOperationsLock.Lock()
 	defer volumeOperationsLock.Unlock()
 	klog.V(4).Infof("PVDeleted: vSphere provisioner deleting volume %v with delete disk %v", pv, deleteDisk)
-	if err := volumes.GetManager(metadataSyncer.vcenter).DeleteVolume(pv.Spec.CSI.VolumeHandle, deleteDisk); err != nil {
+	if err := volumes.GetManager(metadataSyncer.vcenter).DeleteVolume(context.Background(), pv.Spec.CSI.VolumeHandle, deleteDisk); err != nil {
 		klog.Errorf("PVDeleted: Failed to delete disk %s with error %+v", pv.Spec.CSI.VolumeHandle, err)
 		return
 	}
 }
 
+// podAdded updates pod metadata on VC for pods which are already running when
+// the informer sees them, e.g. when the syncer restarts, as podUpdated only
+// handles pods moving from pending to running
+func podAdded(obj interface{}, metadataSyncer *MetadataSyncInformer) {
+	pod, ok := obj.(*v1.Pod)
+	if pod == nil || !ok {
+		klog.Warningf("PodAdded: unrecognized object %+v", obj)
+		return
+	}
+	if pod.Status.Phase != v1.PodRunning {
+		return
+	}
+	klog.V(3).Infof("PodAdded: Pod %s calling updatePodMetadata", pod.Name)
+	if errorList := updatePodMetadata(pod, metadataSyncer, false); len(errorList) > 0 {
+		klog.Errorf("PodAdded: updatePodMetadata failed for pod %s with errors: ", pod.Name)
+		for _, err := range errorList {
+			klog.Errorf("PodAdded: %v", err)
+		}
+	}
+}
+
 // podUpdated updates pod metadata on VC when pod labels have been updated on K8s cluster
 func podUpdated(oldObj, newObj interface{}, metadataSyncer *MetadataSyncInformer) {
 	// Get old and new pod objects
@@ -448,7 +604,7 @@ func updatePodMetadata(pod *v1.Pod, metadataSyncer *MetadataSyncInformer, delete
 		if volume.PersistentVolumeClaim != nil {
 			pvcName := volume.PersistentVolumeClaim.ClaimName
 			// Get pvc attached to pod
-			pvc, err := metadataSyncer.pvcLister.PersistentVolumeClaims(pod.Namespace).Get(pvcName)
+			pvc, err := getPVC(pod.Namespace, pvcName, metadataSyncer)
 			if err != nil {
 				msg := fmt.Sprintf("Error getting Persistent Volume Claim for volume %s with err: %v", volume.Name, err)
 				errorList = append(errorList, errors.New(msg))
@@ -456,7 +612,7 @@ func updatePodMetadata(pod *v1.Pod, metadataSyncer *MetadataSyncInformer, delete
 			}
 
 			// Get pv object attached to pvc
-			pv, err := metadataSyncer.pvLister.Get(pvc.Spec.VolumeName)
+			pv, err := getPVForPVC(pvc, metadataSyncer)
 			if err != nil {
 				msg := fmt.Sprintf("Error getting Persistent Volume for PVC %s in volume %s with err: %v", pvc.Name, volume.Name, err)
 				errorList = append(errorList, errors.New(msg))
@@ -469,20 +625,20 @@ func updatePodMetadata(pod *v1.Pod, metadataSyncer *MetadataSyncInformer, delete
 				continue
 			}
 			var metadataList []cnstypes.BaseCnsEntityMetadata
-			podMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pod.Name, nil, deleteFlag, string(cnstypes.CnsKubernetesEntityTypePOD), pod.Namespace)
+			podMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(pod.Name, nil, deleteFlag, common.EntityTypePod, pod.Namespace)
 			metadataList = append(metadataList, cnstypes.BaseCnsEntityMetadata(podMetadata))
 			updateSpec := &cnstypes.CnsVolumeMetadataUpdateSpec{
 				VolumeId: cnstypes.CnsVolumeId{
 					Id: pv.Spec.CSI.VolumeHandle,
 				},
 				Metadata: cnstypes.CnsVolumeMetadata{
-					ContainerCluster: cnsvsphere.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID, metadataSyncer.cfg.VirtualCenter[metadataSyncer.vcenter.Config.Host].User),
+					ContainerCluster: metadataSyncer.vcenter.GetContainerCluster(metadataSyncer.cfg.Global.ClusterID),
 					EntityMetadata:   metadataList,
 				},
 			}
 
-			klog.V(4).Infof("Calling UpdateVolumeMetadata for volume %s with updateSpec: %+v", updateSpec.VolumeId.Id, spew.Sdump(updateSpec))
-			if err := volumes.GetManager(metadataSyncer.vcenter).UpdateVolumeMetadata(updateSpec); err != nil {
+			klog.V(4).Infof("Calling UpdateVolumeMetadata for volume %s with updateSpec: %v", updateSpec.VolumeId.Id, volumes.Dump(updateSpec))
+			if err := metadataSyncer.updateVolumeMetadata(context.Background(), updateSpec); err != nil {
 				msg := fmt.Sprintf("UpdateVolumeMetadata failed for volume %s with err: %v", volume.Name, err)
 				errorList = append(errorList, errors.New(msg))
 			}
@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"k8s.io/klog"
+
+	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+)
+
+// MigrateClusterID moves the CNS metadata of all volumes of the cluster with
+// ID oldClusterID to the cluster ID in the config, e.g. after the cluster ID
+// had to be changed. Volumes are migrated in batches. The metadata of a volume
+// is first written for the new cluster ID and only then removed for the old
+// one, so a volume never lacks metadata, and the migration can be run again
+// after a failure. With dryRun set, the volumes are only listed.
+func (metadataSyncer *MetadataSyncInformer) MigrateClusterID(oldClusterID string, dryRun bool) error {
+	ctx, cancel := context.WithCancel(volumes.WithOpID(context.Background(), "syncer-migrateclusterid"))
+	defer cancel()
+	if err := metadataSyncer.connectVirtualCenter(ctx); err != nil {
+		return err
+	}
+	newClusterID := metadataSyncer.cfg.Global.ClusterID
+	if oldClusterID == "" || oldClusterID == newClusterID {
+		return fmt.Errorf("cluster ID to migrate from %q must be set and differ from the configured cluster ID %q", oldClusterID, newClusterID)
+	}
+	return migrateClusterID(ctx, volumes.GetManager(metadataSyncer.vcenter), metadataSyncer.vcenter.GetContainerCluster, oldClusterID, newClusterID, dryRun)
+}
+
+// migrateClusterID migrates the CNS metadata of the volumes of the cluster
+// with ID oldClusterID to the cluster with ID newClusterID, as described in
+// MigrateClusterID. containerCluster returns the container cluster with the
+// given ID for the vSphere user in use.
+func migrateClusterID(ctx context.Context, volumeManager volumes.Manager, containerCluster func(clusterID string) cnstypes.CnsContainerCluster,
+	oldClusterID string, newClusterID string, dryRun bool) error {
+	queryAllResult, err := volumeManager.QueryAllVolume(ctx, cnstypes.CnsQueryFilter{ContainerClusterIds: []string{oldClusterID}}, cnstypes.CnsQuerySelection{})
+	if err != nil {
+		klog.Errorf("Migration: Failed to query volumes of cluster %q. Err: %v", oldClusterID, err)
+		return err
+	}
+	total := len(queryAllResult.Volumes)
+	klog.Infof("Migration: Found %d volumes of cluster %q to migrate to cluster %q", total, oldClusterID, newClusterID)
+	migrated, failed := 0, 0
+	for start := 0; start < total; start += queryVolumeBatchSize {
+		end := start + queryVolumeBatchSize
+		if end > total {
+			end = total
+		}
+		var volumeIds []cnstypes.CnsVolumeId
+		for _, volume := range queryAllResult.Volumes[start:end] {
+			volumeIds = append(volumeIds, volume.VolumeId)
+		}
+		if dryRun {
+			for _, volumeID := range volumeIds {
+				klog.Infof("Migration: Would migrate volume %s", volumeID.Id)
+			}
+			continue
+		}
+		// Get the metadata of the volumes for the old cluster
+		queryResult, err := volumeManager.QueryVolume(ctx, cnstypes.CnsQueryFilter{VolumeIds: volumeIds, ContainerClusterIds: []string{oldClusterID}})
+		if err != nil {
+			klog.Errorf("Migration: Failed to query volumes %v. Err: %v", volumeIds, err)
+			failed += len(volumeIds)
+			continue
+		}
+		var newSpecs, oldSpecs []cnstypes.CnsVolumeMetadataUpdateSpec
+		for _, volume := range queryResult.Volumes {
+			newSpecs = append(newSpecs, buildMigrationSpec(volume, containerCluster(newClusterID), false))
+			oldSpecs = append(oldSpecs, buildMigrationSpec(volume, containerCluster(oldClusterID), true))
+		}
+		failed += len(volumeIds) - len(newSpecs)
+		// Write the metadata for the new cluster first
+		results, err := volumeManager.UpdateVolumeMetadataBatch(ctx, newSpecs)
+		if err != nil {
+			failed += len(newSpecs)
+			continue
+		}
+		var removeSpecs []cnstypes.CnsVolumeMetadataUpdateSpec
+		for i, result := range results {
+			if result.Err != nil {
+				klog.Errorf("Migration: Failed to set metadata of volume %s for cluster %q. Err: %v", result.VolumeID, newClusterID, result.Err)
+				failed++
+				continue
+			}
+			removeSpecs = append(removeSpecs, oldSpecs[i])
+		}
+		// Remove the metadata for the old cluster
+		results, err = volumeManager.UpdateVolumeMetadataBatch(ctx, removeSpecs)
+		if err != nil {
+			failed += len(removeSpecs)
+			continue
+		}
+		for _, result := range results {
+			if result.Err != nil {
+				klog.Errorf("Migration: Failed to remove metadata of volume %s for cluster %q. Err: %v", result.VolumeID, oldClusterID, result.Err)
+				failed++
+				continue
+			}
+			migrated++
+		}
+		klog.Infof("Migration: %d of %d volumes migrated, %d failed", migrated, total, failed)
+	}
+	if dryRun {
+		klog.Infof("Migration: Dry run done, %d volumes would be migrated", total)
+		return nil
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to migrate %d of %d volumes from cluster %q to cluster %q, run the migration again to retry", failed, total, oldClusterID, newClusterID)
+	}
+	klog.Infof("Migration: All %d volumes migrated from cluster %q to cluster %q", total, oldClusterID, newClusterID)
+	return nil
+}
+
+// buildMigrationSpec returns the spec setting the entity metadata of the
+// volume for the given container cluster, or deleting it if deleteFlag is set
+func buildMigrationSpec(volume cnstypes.CnsVolume, containerCluster cnstypes.CnsContainerCluster, deleteFlag bool) cnstypes.CnsVolumeMetadataUpdateSpec {
+	var metadataList []cnstypes.BaseCnsEntityMetadata
+	for _, metadata := range volume.Metadata.EntityMetadata {
+		entityMetadata, ok := metadata.(*cnstypes.CnsKubernetesEntityMetadata)
+		if !ok {
+			continue
+		}
+		newEntityMetadata := *entityMetadata
+		newEntityMetadata.Delete = deleteFlag
+		metadataList = append(metadataList, &newEntityMetadata)
+	}
+	return cnstypes.CnsVolumeMetadataUpdateSpec{
+		VolumeId: volume.VolumeId,
+		Metadata: cnstypes.CnsVolumeMetadata{
+			ContainerCluster: containerCluster,
+			EntityMetadata:   metadataList,
+		},
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+
+	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+// migrationVolumeManager is a volumes.Manager serving the volumes of a
+// cluster and recording the metadata updates of a migration
+type migrationVolumeManager struct {
+	volumes.Manager
+	volumes []cnstypes.CnsVolume
+	// failures are the volume IDs whose update fails, by cluster ID
+	failures map[string]map[string]bool
+	// updates are the batches of metadata updates, in order
+	updates [][]cnstypes.CnsVolumeMetadataUpdateSpec
+}
+
+func (m *migrationVolumeManager) QueryAllVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter, querySelection cnstypes.CnsQuerySelection) (*cnstypes.CnsQueryResult, error) {
+	return &cnstypes.CnsQueryResult{Volumes: m.volumes}, nil
+}
+
+func (m *migrationVolumeManager) QueryVolume(ctx context.Context, queryFilter cnstypes.CnsQueryFilter) (*cnstypes.CnsQueryResult, error) {
+	result := &cnstypes.CnsQueryResult{}
+	for _, volumeID := range queryFilter.VolumeIds {
+		for _, volume := range m.volumes {
+			if volume.VolumeId == volumeID {
+				result.Volumes = append(result.Volumes, volume)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (m *migrationVolumeManager) UpdateVolumeMetadataBatch(ctx context.Context, specs []cnstypes.CnsVolumeMetadataUpdateSpec) ([]volumes.BatchResult, error) {
+	m.updates = append(m.updates, specs)
+	var results []volumes.BatchResult
+	for _, spec := range specs {
+		result := volumes.BatchResult{VolumeID: spec.VolumeId.Id}
+		if m.failures[spec.Metadata.ContainerCluster.ClusterId][spec.VolumeId.Id] {
+			result.Err = fmt.Errorf("failed to update volume %s", spec.VolumeId.Id)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func newMigrationVolume(volumeID string) cnstypes.CnsVolume {
+	return cnstypes.CnsVolume{
+		VolumeId: cnstypes.CnsVolumeId{Id: volumeID},
+		Metadata: cnstypes.CnsVolumeMetadata{
+			EntityMetadata: []cnstypes.BaseCnsEntityMetadata{
+				cnsvsphere.GetCnsKubernetesEntityMetaData("pv-"+volumeID, nil, false, string(cnstypes.CnsKubernetesEntityTypePV), ""),
+			},
+		},
+	}
+}
+
+func checkMigrationUpdate(t *testing.T, update []cnstypes.CnsVolumeMetadataUpdateSpec, clusterID string, deleteFlag bool, volumeIDs ...string) {
+	if len(update) != len(volumeIDs) {
+		t.Fatalf("expected an update of %v, got %d specs", volumeIDs, len(update))
+	}
+	for i, spec := range update {
+		if spec.VolumeId.Id != volumeIDs[i] || spec.Metadata.ContainerCluster.ClusterId != clusterID ||
+			spec.Metadata.ContainerCluster.VSphereUser != "user@vsphere.local" {
+			t.Errorf("expected an update of volume %s for cluster %q, got %+v", volumeIDs[i], clusterID, spec)
+		}
+		for _, metadata := range spec.Metadata.EntityMetadata {
+			if metadata.(*cnstypes.CnsKubernetesEntityMetadata).Delete != deleteFlag {
+				t.Errorf("expected the metadata of volume %s to have delete set to %t", spec.VolumeId.Id, deleteFlag)
+			}
+		}
+	}
+}
+
+func containerClusterForTest(clusterID string) cnstypes.CnsContainerCluster {
+	return cnsvsphere.GetContainerCluster(clusterID, "user@vsphere.local")
+}
+
+func TestMigrateClusterID(t *testing.T) {
+	manager := &migrationVolumeManager{
+		volumes: []cnstypes.CnsVolume{newMigrationVolume("vol-1"), newMigrationVolume("vol-2")},
+	}
+	if err := migrateClusterID(context.Background(), manager, containerClusterForTest, "old-cluster", "new-cluster", false); err != nil {
+		t.Fatalf("migrateClusterID failed: %v", err)
+	}
+	// The metadata is written for the new cluster before it is removed for the old one
+	if len(manager.updates) != 2 {
+		t.Fatalf("expected 2 metadata updates, got %d", len(manager.updates))
+	}
+	checkMigrationUpdate(t, manager.updates[0], "new-cluster", false, "vol-1", "vol-2")
+	checkMigrationUpdate(t, manager.updates[1], "old-cluster", true, "vol-1", "vol-2")
+}
+
+func TestMigrateClusterIDPartialFailure(t *testing.T) {
+	manager := &migrationVolumeManager{
+		volumes: []cnstypes.CnsVolume{newMigrationVolume("vol-1"), newMigrationVolume("vol-2"), newMigrationVolume("vol-3")},
+		failures: map[string]map[string]bool{
+			"new-cluster": {"vol-2": true},
+			"old-cluster": {"vol-3": true},
+		},
+	}
+	if err := migrateClusterID(context.Background(), manager, containerClusterForTest, "old-cluster", "new-cluster", false); err == nil {
+		t.Fatalf("expected migrateClusterID to fail")
+	}
+	if len(manager.updates) != 2 {
+		t.Fatalf("expected 2 metadata updates, got %d", len(manager.updates))
+	}
+	checkMigrationUpdate(t, manager.updates[0], "new-cluster", false, "vol-1", "vol-2", "vol-3")
+	// The metadata of vol-2 is kept for the old cluster as it couldn't be written for the new one
+	checkMigrationUpdate(t, manager.updates[1], "old-cluster", true, "vol-1", "vol-3")
+}
+
+func TestMigrateClusterIDDryRun(t *testing.T) {
+	manager := &migrationVolumeManager{
+		volumes: []cnstypes.CnsVolume{newMigrationVolume("vol-1")},
+	}
+	if err := migrateClusterID(context.Background(), manager, containerClusterForTest, "old-cluster", "new-cluster", true); err != nil {
+		t.Fatalf("migrateClusterID failed: %v", err)
+	}
+	if len(manager.updates) != 0 {
+		t.Errorf("expected no metadata updates on a dry run, got %d", len(manager.updates))
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+
+	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+)
+
+// syncVolumeDatastores detects volumes which were relocated to another
+// datastore outside of kubernetes, e.g. by storage vMotion, by comparing the
+// datastore reported by CNS with the last known datastore of the volume.
+// For relocated volumes the cached datastore lookups are invalidated, the
+// datastore and VMDK path annotations on the PV are updated and an event is
+// recorded on the PV.
+// PVs without the datastore annotation are annotated with their current
+// datastore when first seen, so relocations which happen while the syncer is
+// not running are detected after a restart.
+func syncVolumeDatastores(k8sclient clientset.Interface, pvList []*v1.PersistentVolume, cnsVolumeDatastores map[string]string, metadataSyncer *MetadataSyncInformer) {
+	invalidateCache := false
+	for _, pv := range pvList {
+		volumeID := pv.Spec.CSI.VolumeHandle
+		datastoreURL := cnsVolumeDatastores[volumeID]
+		if datastoreURL == "" {
+			continue
+		}
+		lastKnownURL, found := cnsVolumeDatastoreMap[volumeID]
+		if !found {
+			lastKnownURL = pv.Annotations[annDatastoreURL]
+		}
+		cnsVolumeDatastoreMap[volumeID] = datastoreURL
+		if lastKnownURL == datastoreURL {
+			continue
+		}
+		if lastKnownURL == "" {
+			if err := setPVDatastoreAnnotation(k8sclient, pv.Name, datastoreURL, ""); err != nil {
+				// Retry on the next full sync cycle
+				delete(cnsVolumeDatastoreMap, volumeID)
+			}
+			continue
+		}
+		klog.Infof("FullSync: volume %q of PV %q was relocated from datastore %q to %q", volumeID, pv.Name, lastKnownURL, datastoreURL)
+		invalidateCache = true
+		recordVolumeRelocatedEvent(k8sclient, pv, lastKnownURL, datastoreURL, metadataSyncer)
+		vmdkPath, err := volumes.GetVolumeBackingPath(fullSyncContext(), metadataSyncer.vcenter, volumeID, datastoreURL)
+		if err != nil {
+			klog.Warningf("FullSync: Failed to find the VMDK path of relocated volume %q. Err: %v", volumeID, err)
+		}
+		if err := setPVDatastoreAnnotation(k8sclient, pv.Name, datastoreURL, vmdkPath); err != nil {
+			// Retry on the next full sync cycle
+			cnsVolumeDatastoreMap[volumeID] = lastKnownURL
+		}
+	}
+	if invalidateCache {
+		cnsvsphere.InvalidateDatastoreURLCache()
+	}
+}
+
+// setPVDatastoreAnnotation records the given datastore URL in the datastore
+// annotation of the PV, and the VMDK path in its VMDK path annotation if set.
+// The volume attributes of a PV can not be changed, so the VMDK path recorded
+// in them at creation is superseded by the annotation.
+// The PV is read again before the update, as the PV from the full sync
+// listing may be outdated.
+func setPVDatastoreAnnotation(k8sclient clientset.Interface, pvName string, datastoreURL string, vmdkPath string) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pv, err := k8sclient.CoreV1().PersistentVolumes().Get(pvName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pv.Annotations == nil {
+			pv.Annotations = make(map[string]string)
+		}
+		pv.Annotations[annDatastoreURL] = datastoreURL
+		if vmdkPath != "" {
+			pv.Annotations[common.AnnVmdkPath] = vmdkPath
+		}
+		_, err = k8sclient.CoreV1().PersistentVolumes().Update(pv)
+		return err
+	})
+	if err != nil {
+		klog.Errorf("FullSync: Failed to set datastore annotation on PV %s. Err: %v", pvName, err)
+		return err
+	}
+	klog.V(4).Infof("FullSync: Set datastore annotation %q on PV %s", datastoreURL, pvName)
+	return nil
+}
+
+// recordVolumeRelocatedEvent records an event on the PV of a relocated volume.
+// If the node affinity of the PV does not include any zone which can access
+// the new datastore, a warning event is recorded instead, as node affinity
+// can not be changed once the PV is created.
+func recordVolumeRelocatedEvent(k8sclient clientset.Interface, pv *v1.PersistentVolume, oldURL string, newURL string, metadataSyncer *MetadataSyncInformer) {
+	if metadataSyncer.eventRecorder == nil {
+		return
+	}
+	message := fmt.Sprintf("Volume %s was relocated from datastore %s to %s", pv.Spec.CSI.VolumeHandle, oldURL, newURL)
+	if isPVNodeAffinityStale(k8sclient, pv, newURL, metadataSyncer) {
+		metadataSyncer.eventRecorder.Event(pv, v1.EventTypeWarning, eventReasonVolumeRelocated,
+			message+". The new datastore is not accessible from any zone in the node affinity of the PV")
+		return
+	}
+	metadataSyncer.eventRecorder.Event(pv, v1.EventTypeNormal, eventReasonVolumeRelocated, message)
+}
+
+// isPVNodeAffinityStale returns true if the PV has zone node affinity and
+// none of its zones can access the datastore the volume now resides on
+func isPVNodeAffinityStale(k8sclient clientset.Interface, pv *v1.PersistentVolume, datastoreURL string, metadataSyncer *MetadataSyncInformer) bool {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return false
+	}
+	topologyKeys := csitypes.GetTopologyKeys(metadataSyncer.cfg)
+	affinityZones := make(map[string]bool)
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expression := range term.MatchExpressions {
+			if !topologyKeys.IsZone(expression.Key) {
+				continue
+			}
+			for _, zone := range expression.Values {
+				affinityZones[zone] = true
+			}
+		}
+	}
+	if len(affinityZones) == 0 {
+		return false
+	}
+	topologies, err := getDatastoreTopologies(context.Background(), k8sclient, metadataSyncer)
+	if err != nil {
+		klog.Warningf("FullSync: Failed to get accessible topology for volume %s. Err: %v", pv.Spec.CSI.VolumeHandle, err)
+		return false
+	}
+	for _, topology := range topologies[datastoreURL] {
+		if affinityZones[topology[topologyKeys.Zone]] {
+			return false
+		}
+	}
+	return true
+}
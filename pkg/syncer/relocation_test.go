@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+)
+
+func newRelocationTestPV(name string, volumeID string, annotations map[string]string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: csitypes.DriverName, VolumeHandle: volumeID},
+			},
+		},
+	}
+}
+
+func TestSyncVolumeDatastoresAnnotatesNewVolumes(t *testing.T) {
+	savedMap := cnsVolumeDatastoreMap
+	defer func() { cnsVolumeDatastoreMap = savedMap }()
+	cnsVolumeDatastoreMap = make(map[string]string)
+
+	pvs := []*v1.PersistentVolume{
+		newRelocationTestPV("pv-1", "vol-1", nil),
+		newRelocationTestPV("pv-2", "vol-2", map[string]string{annDatastoreURL: "ds:///ds-2/"}),
+	}
+	k8sclient := testclient.NewSimpleClientset(pvs[0], pvs[1])
+	cnsVolumeDatastores := map[string]string{"vol-1": "ds:///ds-1/", "vol-2": "ds:///ds-2/"}
+
+	syncVolumeDatastores(k8sclient, pvs, cnsVolumeDatastores, &MetadataSyncInformer{})
+	pv, _ := k8sclient.CoreV1().PersistentVolumes().Get("pv-1", metav1.GetOptions{})
+	if url := pv.Annotations[annDatastoreURL]; url != "ds:///ds-1/" {
+		t.Errorf("Expected pv-1 to be annotated with its datastore when first seen, got %q", url)
+	}
+	if url := cnsVolumeDatastoreMap["vol-2"]; url != "ds:///ds-2/" {
+		t.Errorf("Expected the datastore of vol-2 to be remembered, got %q", url)
+	}
+
+	// Volumes on their last known datastore are not updated again
+	k8sclient.ClearActions()
+	syncVolumeDatastores(k8sclient, pvs, cnsVolumeDatastores, &MetadataSyncInformer{})
+	for _, action := range k8sclient.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("Unexpected update of %v", action.GetResource())
+		}
+	}
+}
+
+func TestSetPVDatastoreAnnotation(t *testing.T) {
+	pv := newRelocationTestPV("pv-1", "vol-1", map[string]string{annDatastoreURL: "ds:///ds-1/"})
+	k8sclient := testclient.NewSimpleClientset(pv)
+	// The PV is changed after it was listed by full sync
+	current := pv.DeepCopy()
+	current.Labels = map[string]string{"app": "db"}
+	if _, err := k8sclient.CoreV1().PersistentVolumes().Update(current); err != nil {
+		t.Fatalf("Failed to update PV: %v", err)
+	}
+
+	if err := setPVDatastoreAnnotation(k8sclient, pv.Name, "ds:///ds-2/", "[ds-2] fcd/vol-1.vmdk"); err != nil {
+		t.Fatalf("Failed to set datastore annotation: %v", err)
+	}
+	updated, _ := k8sclient.CoreV1().PersistentVolumes().Get(pv.Name, metav1.GetOptions{})
+	if updated.Annotations[annDatastoreURL] != "ds:///ds-2/" || updated.Annotations[common.AnnVmdkPath] != "[ds-2] fcd/vol-1.vmdk" {
+		t.Errorf("Unexpected annotations on the PV: %v", updated.Annotations)
+	}
+	if updated.Labels["app"] != "db" {
+		t.Errorf("Expected changes made after the PV was listed to be kept, got labels %v", updated.Labels)
+	}
+	if err := setPVDatastoreAnnotation(k8sclient, "pv-missing", "ds:///ds-2/", ""); err == nil {
+		t.Errorf("Expected an error for a missing PV")
+	}
+}
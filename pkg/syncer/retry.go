@@ -0,0 +1,212 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	"k8s.io/klog"
+
+	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/prometheus"
+)
+
+// metadataRetry is a failed metadata update waiting to be retried
+type metadataRetry struct {
+	spec        *cnstypes.CnsVolumeMetadataUpdateSpec
+	attempts    int
+	nextAttempt time.Time
+	lastError   string
+}
+
+// deadLetter is a metadata update given up on after metadataRetryMaxAttempts attempts
+type deadLetter struct {
+	VolumeID  string    `json:"volumeId"`
+	Entities  []string  `json:"entities"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError"`
+	Time      time.Time `json:"time"`
+}
+
+// metadataRetryQueue retries failed metadata updates with exponential
+// backoff, so a transient vCenter outage does not lose them until the next
+// full sync. Updates are keyed on the volume and the entities they carry; a
+// newer update for the same key replaces the pending one. Updates still
+// failing after metadataRetryMaxAttempts attempts, or not fitting in the
+// queue, are moved to a bounded dead-letter list.
+type metadataRetryQueue struct {
+	lock        sync.Mutex
+	pending     map[string]*metadataRetry
+	deadLetters []deadLetter
+	// push applies an update, it is the CNS metadata backend in the syncer
+	push func(ctx context.Context, spec *cnstypes.CnsVolumeMetadataUpdateSpec) error
+	// pushed is called once a retried update has been applied
+	pushed func(ctx context.Context, spec *cnstypes.CnsVolumeMetadataUpdateSpec)
+}
+
+// newMetadataRetryQueue returns a retry queue applying updates with push
+func newMetadataRetryQueue(push func(ctx context.Context, spec *cnstypes.CnsVolumeMetadataUpdateSpec) error) *metadataRetryQueue {
+	return &metadataRetryQueue{
+		pending: make(map[string]*metadataRetry),
+		push:    push,
+	}
+}
+
+// metadataRetryKey returns the key of the update: its volume and the entities it carries
+func metadataRetryKey(spec *cnstypes.CnsVolumeMetadataUpdateSpec) string {
+	return spec.VolumeId.Id + "/" + strings.Join(metadataEntities(spec), ",")
+}
+
+// metadataEntities returns the entities of the update as type:namespace/name
+func metadataEntities(spec *cnstypes.CnsVolumeMetadataUpdateSpec) []string {
+	var entities []string
+	for _, metadata := range spec.Metadata.EntityMetadata {
+		if k8sMetadata, ok := metadata.(*cnstypes.CnsKubernetesEntityMetadata); ok {
+			entities = append(entities, k8sMetadata.EntityType+":"+k8sMetadata.Namespace+"/"+k8sMetadata.EntityName)
+		}
+	}
+	sort.Strings(entities)
+	return entities
+}
+
+// add queues the failed update for a retry after the initial backoff
+func (q *metadataRetryQueue) add(spec *cnstypes.CnsVolumeMetadataUpdateSpec, err error) {
+	if q == nil {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	key := metadataRetryKey(spec)
+	if _, found := q.pending[key]; !found && len(q.pending) >= metadataRetryQueueSize {
+		klog.Errorf("Metadata retry queue is full, giving up on metadata update of volume %s", spec.VolumeId.Id)
+		q.addDeadLetter(&metadataRetry{spec: spec, lastError: err.Error()})
+		return
+	}
+	q.pending[key] = &metadataRetry{
+		spec:        spec,
+		nextAttempt: time.Now().Add(metadataRetryInitialBackoff),
+		lastError:   err.Error(),
+	}
+	prometheus.MetadataRetryQueueLength.Set(float64(len(q.pending)))
+}
+
+// forget drops the pending retry superseded by the given successful update
+func (q *metadataRetryQueue) forget(spec *cnstypes.CnsVolumeMetadataUpdateSpec) {
+	if q == nil {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.pending, metadataRetryKey(spec))
+	prometheus.MetadataRetryQueueLength.Set(float64(len(q.pending)))
+}
+
+// addDeadLetter records the update given up on. The lock must be held.
+func (q *metadataRetryQueue) addDeadLetter(retry *metadataRetry) {
+	q.deadLetters = append(q.deadLetters, deadLetter{
+		VolumeID:  retry.spec.VolumeId.Id,
+		Entities:  metadataEntities(retry.spec),
+		Attempts:  retry.attempts,
+		LastError: retry.lastError,
+		Time:      time.Now(),
+	})
+	if len(q.deadLetters) > metadataDeadLettersSize {
+		q.deadLetters = q.deadLetters[len(q.deadLetters)-metadataDeadLettersSize:]
+	}
+	prometheus.MetadataDeadLetters.Inc()
+}
+
+// run retries the due updates periodically. It never returns.
+func (q *metadataRetryQueue) run() {
+	ticker := time.NewTicker(metadataRetryInterval)
+	for range ticker.C {
+		q.retryDue(time.Now())
+	}
+}
+
+// retryDue retries the updates due at the given time
+func (q *metadataRetryQueue) retryDue(now time.Time) {
+	q.lock.Lock()
+	due := make(map[string]*metadataRetry)
+	for key, retry := range q.pending {
+		if !retry.nextAttempt.After(now) {
+			due[key] = retry
+		}
+	}
+	q.lock.Unlock()
+
+	for key, retry := range due {
+		ctx := volumes.WithPriority(context.Background(), volumes.PriorityBackground)
+		err := q.push(ctx, retry.spec)
+		prometheus.MetadataRetries.Inc()
+		q.lock.Lock()
+		// A newer update may have replaced or superseded this one meanwhile
+		if q.pending[key] != retry {
+			q.lock.Unlock()
+			continue
+		}
+		if err == nil {
+			delete(q.pending, key)
+			q.lock.Unlock()
+			klog.V(2).Infof("Retried metadata update of volume %s succeeded after %d attempts", retry.spec.VolumeId.Id, retry.attempts+1)
+			if q.pushed != nil {
+				q.pushed(ctx, retry.spec)
+			}
+			continue
+		}
+		retry.attempts++
+		retry.lastError = err.Error()
+		if retry.attempts >= metadataRetryMaxAttempts {
+			klog.Errorf("Giving up on metadata update of volume %s after %d attempts. Err: %v", retry.spec.VolumeId.Id, retry.attempts, err)
+			delete(q.pending, key)
+			q.addDeadLetter(retry)
+		} else {
+			backoff := metadataRetryInitialBackoff << uint(retry.attempts)
+			if backoff > metadataRetryMaxBackoff {
+				backoff = metadataRetryMaxBackoff
+			}
+			retry.nextAttempt = now.Add(backoff)
+			klog.Warningf("Retry %d of metadata update of volume %s failed, next retry in %v. Err: %v", retry.attempts, retry.spec.VolumeId.Id, backoff, err)
+		}
+		q.lock.Unlock()
+	}
+	q.lock.Lock()
+	prometheus.MetadataRetryQueueLength.Set(float64(len(q.pending)))
+	q.lock.Unlock()
+}
+
+// ServeHTTP serves the dead-letter list as JSON
+func (q *metadataRetryQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q.lock.Lock()
+	data, err := json.Marshal(q.deadLetters)
+	q.lock.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(data); err != nil {
+		klog.Errorf("Failed to write metadata dead letters. Err: %v", err)
+	}
+}
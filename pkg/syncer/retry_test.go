@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+)
+
+func TestMetadataRetryQueue(t *testing.T) {
+	// Number of times pushing the update of each volume fails
+	failures := map[string]int{"volume-1": 1, "volume-2": metadataRetryMaxAttempts}
+	attempts := make(map[string]int)
+	queue := newMetadataRetryQueue(func(ctx context.Context, spec *cnstypes.CnsVolumeMetadataUpdateSpec) error {
+		attempts[spec.VolumeId.Id]++
+		if attempts[spec.VolumeId.Id] <= failures[spec.VolumeId.Id] {
+			return fmt.Errorf("vCenter unavailable")
+		}
+		return nil
+	})
+	newSpec := func(volumeID string) *cnstypes.CnsVolumeMetadataUpdateSpec {
+		pvcMetadata := cnsvsphere.GetCnsKubernetesEntityMetaData(testPVCName, nil, false, string(cnstypes.CnsKubernetesEntityTypePVC), "default")
+		return &cnstypes.CnsVolumeMetadataUpdateSpec{
+			VolumeId: cnstypes.CnsVolumeId{Id: volumeID},
+			Metadata: cnstypes.CnsVolumeMetadata{
+				EntityMetadata: []cnstypes.BaseCnsEntityMetadata{pvcMetadata},
+			},
+		}
+	}
+	for _, volumeID := range []string{"volume-1", "volume-2", "volume-3"} {
+		queue.add(newSpec(volumeID), fmt.Errorf("vCenter unavailable"))
+	}
+	// A newer update of volume-3 succeeded
+	queue.forget(newSpec("volume-3"))
+
+	now := time.Now()
+	for i := 0; i < metadataRetryMaxAttempts; i++ {
+		now = now.Add(metadataRetryMaxBackoff)
+		queue.retryDue(now)
+	}
+	if len(queue.pending) != 0 {
+		t.Errorf("Expected no pending retries, got %d", len(queue.pending))
+	}
+	if attempts["volume-1"] != 2 || attempts["volume-3"] != 0 {
+		t.Errorf("Unexpected attempts %v", attempts)
+	}
+	if len(queue.deadLetters) != 1 || queue.deadLetters[0].VolumeID != "volume-2" || queue.deadLetters[0].Attempts != metadataRetryMaxAttempts {
+		t.Errorf("Expected the update of volume-2 in the dead letters, got %+v", queue.deadLetters)
+	}
+}
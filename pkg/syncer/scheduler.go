@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+
+	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+)
+
+// fullSyncContext returns the context of the CNS operations of full sync,
+// which run in the background so they do not slow down CSI operations
+func fullSyncContext() context.Context {
+	return volumes.WithPriority(context.Background(), volumes.PriorityBackground)
+}
+
+// getControllerMetricsURL returns the URL of the metrics of the CSI controller
+func getControllerMetricsURL() string {
+	if v := os.Getenv(envControllerMetricsURL); v != "" {
+		return v
+	}
+	return defaultControllerMetricsURL
+}
+
+// watchControllerLoad reports the number of operations in progress in the
+// CSI controller as the foreground load of the volume operation scheduler,
+// so full sync yields to CSI operations. It never returns.
+func watchControllerLoad(url string) {
+	client := &http.Client{Timeout: controllerLoadInterval}
+	ticker := time.NewTicker(controllerLoadInterval)
+	for range ticker.C {
+		load, err := getControllerLoad(client, url)
+		if err != nil {
+			klog.V(4).Infof("Failed to get the load of the CSI controller from %s. Err: %v", url, err)
+			load = 0
+		}
+		volumes.SetExternalForegroundLoad(load)
+	}
+}
+
+// getControllerLoad returns the sum of the in-flight operations metric
+// served by the CSI controller at url
+func getControllerLoad(client *http.Client, url string) (int, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s returned status %s", url, resp.Status)
+	}
+	load := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, inflightOperationsMetric+"{") && !strings.HasPrefix(line, inflightOperationsMetric+" ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		value, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid sample %q: %v", line, err)
+		}
+		load += int(value)
+	}
+	return load, scanner.Err()
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	v1 "k8s.io/api/core/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	k8s "sigs.k8s.io/vsphere-csi-driver/pkg/kubernetes"
+)
+
+const (
+	// Env variable for the number of volumes the full sync benchmark runs with
+	envBenchmarkVolumes = "SYNCER_BENCHMARK_VOLUMES"
+	// default number of volumes the full sync benchmark runs with
+	defaultBenchmarkVolumes = 1000
+)
+
+// getBenchmarkVolumes returns the number of volumes the full sync benchmark runs with
+func getBenchmarkVolumes(b *testing.B) int {
+	if v := os.Getenv(envBenchmarkVolumes); v != "" {
+		volumes, err := strconv.Atoi(v)
+		if err != nil || volumes <= 0 {
+			b.Fatalf("Invalid %s %q", envBenchmarkVolumes, v)
+		}
+		return volumes
+	}
+	return defaultBenchmarkVolumes
+}
+
+// setupFullSyncBenchmark connects the syncer to vCenter, or to the simulator,
+// and creates the given number of volumes in CNS with a bound PV and PVC each.
+// The volume manager is bound to the first vCenter it is used with, so the
+// benchmark can not run in the same process as TestSyncerWorkflows; run it
+// with -run=^$.
+func setupFullSyncBenchmark(b *testing.B, volumes int) func() {
+	if metadataSyncer != nil {
+		b.Skip("The volume manager is bound to the vCenter of TestSyncerWorkflows, run benchmarks with -run=^$")
+	}
+	var cleanup func()
+	config, cleanup = cnsconfig.FromEnvOrSim()
+	config.Global.ClusterID = testClusterName
+	ctx = context.Background()
+
+	cnsVCenterConfig, err = cnsvsphere.GetVirtualCenterConfig(config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	virtualCenterManager = cnsvsphere.GetVirtualCenterManager()
+	virtualCenter, err = virtualCenterManager.RegisterVirtualCenter(cnsVCenterConfig)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err = virtualCenter.ConnectCNS(ctx); err != nil {
+		b.Fatal(err)
+	}
+	volumeManager = volume.GetManager(virtualCenter)
+
+	metadataSyncer = &MetadataSyncInformer{
+		cfg:                  config,
+		vcconfig:             cnsVCenterConfig,
+		virtualcentermanager: virtualCenterManager,
+		vcenter:              virtualCenter,
+	}
+	metadataSyncer.initMetadataBackends(config)
+	k8sclient = testclient.NewSimpleClientset()
+	metadataSyncer.k8sInformerManager = k8s.NewInformer(k8sclient)
+	metadataSyncer.pvLister = metadataSyncer.k8sInformerManager.GetPVLister()
+	metadataSyncer.pvcLister = metadataSyncer.k8sInformerManager.GetPVCLister()
+
+	cnsCreationMap = make(map[string]bool)
+	cnsDeletionMap = make(map[string]bool)
+	cnsSyncedMetadataMap = make(map[string]uint64)
+	cnsVolumeDatastoreMap = make(map[string]string)
+
+	createSpec, err := getCnsCreateSpec(b)
+	if err != nil {
+		b.Fatal(err)
+	}
+	labels := map[string]string{testPVLabelName: testPVLabelValue}
+	var volumeIds []cnstypes.CnsVolumeId
+	for i := 0; i < volumes; i++ {
+		createSpec.Name = fmt.Sprintf("%s-%d", testVolumeName, i)
+		volumeID, err := volumeManager.CreateVolume(ctx, &createSpec)
+		if err != nil {
+			b.Fatal(err)
+		}
+		volumeIds = append(volumeIds, *volumeID)
+	}
+	// PVs are created with the datastore annotation full sync sets on first
+	// sight, as a burst of updates overflows the watch channel of the fake client
+	queryResult, err := volumeManager.QueryVolume(ctx, cnstypes.CnsQueryFilter{VolumeIds: volumeIds})
+	if err != nil {
+		b.Fatal(err)
+	}
+	volumeDatastores := getCnsVolumeDatastores(queryResult.Volumes)
+	for i, volumeID := range volumeIds {
+		createSpec.Name = fmt.Sprintf("%s-%d", testVolumeName, i)
+		pvc := getPersistentVolumeClaimSpec(testNamespace, labels, createSpec.Name)
+		pvc.Name = fmt.Sprintf("%s-%d", testPVCName, i)
+		if _, err := k8sclient.CoreV1().PersistentVolumeClaims(testNamespace).Create(pvc); err != nil {
+			b.Fatal(err)
+		}
+		pv := getPersistentVolumeSpec(volumeID.Id, v1.PersistentVolumeReclaimDelete, labels, v1.VolumeBound, pvc.Name)
+		pv.Name = createSpec.Name
+		pv.Annotations = map[string]string{annDatastoreURL: volumeDatastores[volumeID.Id]}
+		if _, err := k8sclient.CoreV1().PersistentVolumes().Create(pv); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// The informers are started once the objects exist, so they are listed
+	// rather than watched
+	metadataSyncer.k8sInformerManager.Listen()
+	return func() {
+		virtualCenter.DisconnectCNS(ctx)
+		cleanup()
+	}
+}
+
+// BenchmarkFullSync measures a full sync cycle once CNS is in sync with
+// kubernetes, the heap held by the full sync maps is reported per volume.
+func BenchmarkFullSync(b *testing.B) {
+	volumes := getBenchmarkVolumes(b)
+	cleanup := setupFullSyncBenchmark(b, volumes)
+	defer cleanup()
+
+	// Push the metadata of the new volumes to CNS, so the benchmark measures steady state cycles
+	triggerFullSync(k8sclient, metadataSyncer)
+	triggerFullSync(k8sclient, metadataSyncer)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		triggerFullSync(k8sclient, metadataSyncer)
+	}
+	b.StopTimer()
+
+	var withMaps, withoutMaps runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&withMaps)
+	cnsCreationMap = make(map[string]bool)
+	cnsDeletionMap = make(map[string]bool)
+	cnsSyncedMetadataMap = make(map[string]uint64)
+	runtime.GC()
+	runtime.ReadMemStats(&withoutMaps)
+	if withMaps.HeapAlloc > withoutMaps.HeapAlloc {
+		b.ReportMetric(float64(withMaps.HeapAlloc-withoutMaps.HeapAlloc)/float64(volumes), "map-bytes/volume")
+	}
+	b.ReportMetric(float64(volumes), "volumes")
+}
+
+// BenchmarkMetadataHash measures hashing the metadata of a volume, done for
+// every volume in each full sync cycle
+func BenchmarkMetadataHash(b *testing.B) {
+	metadataList := []cnstypes.BaseCnsEntityMetadata{
+		cnsvsphere.GetCnsKubernetesEntityMetaData(testVolumeName, map[string]string{testPVLabelName: testPVLabelValue},
+			false, string(cnstypes.CnsKubernetesEntityTypePV), ""),
+		cnsvsphere.GetCnsKubernetesEntityMetaData(testPVCName, map[string]string{testPVCLabelName: testPVCLabelValue},
+			false, string(cnstypes.CnsKubernetesEntityTypePVC), testNamespace),
+		cnsvsphere.GetCnsKubernetesEntityMetaData(testPodName, nil,
+			false, string(cnstypes.CnsKubernetesEntityTypePOD), testNamespace),
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		getMetadataHash(metadataList)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/vmware/govmomi/simulator"
@@ -30,6 +31,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
 	testclient "k8s.io/client-go/kubernetes/fake"
 
@@ -118,6 +120,7 @@ func TestSyncerWorkflows(t *testing.T) {
 		virtualcentermanager: virtualCenterManager,
 		vcenter:              virtualCenter,
 	}
+	metadataSyncer.initMetadataBackends(config)
 
 	// Create the kubernetes client
 	// Here we should use a faked client to avoid test inteference with running
@@ -125,6 +128,7 @@ func TestSyncerWorkflows(t *testing.T) {
 	k8sclient = testclient.NewSimpleClientset()
 
 	metadataSyncer.k8sInformerManager = k8s.NewInformer(k8sclient)
+	defer metadataSyncer.k8sInformerManager.Release()
 	metadataSyncer.pvLister = metadataSyncer.k8sInformerManager.GetPVLister()
 	metadataSyncer.pvcLister = metadataSyncer.k8sInformerManager.GetPVCLister()
 	metadataSyncer.k8sInformerManager.Listen()
@@ -132,6 +136,8 @@ func TestSyncerWorkflows(t *testing.T) {
 	// Initialize maps needed for full sync
 	cnsCreationMap = make(map[string]bool)
 	cnsDeletionMap = make(map[string]bool)
+	cnsSyncedMetadataMap = make(map[string]uint64)
+	cnsVolumeDatastoreMap = make(map[string]string)
 
 	runMetadataSyncerTest(t)
 	runFullSyncTest(t)
@@ -168,7 +174,7 @@ func runMetadataSyncerTest(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	volumeID, err := volumeManager.CreateVolume(&createSpec)
+	volumeID, err := volumeManager.CreateVolume(ctx, &createSpec)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -210,7 +216,7 @@ func runMetadataSyncerTest(t *testing.T) {
 	}
 
 	// Delete volume with DeleteDisk=false
-	err = volumeManager.DeleteVolume(volumeID.Id, false)
+	err = volumeManager.DeleteVolume(ctx, volumeID.Id, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -335,7 +341,7 @@ func runFullSyncTest(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	volumeID, err := volumeManager.CreateVolume(&createSpec)
+	volumeID, err := volumeManager.CreateVolume(ctx, &createSpec)
 	if err != nil {
 		t.Errorf("Failed to create volume. Error: %+v", err)
 		t.Fatal(err)
@@ -430,6 +436,16 @@ func runFullSyncTest(t *testing.T) {
 	if pvc, err = k8sclient.CoreV1().PersistentVolumeClaims(testNamespace).Update(pvc); err != nil {
 		t.Fatal(err)
 	}
+	// Full sync reads PVCs from the lister, wait for it to receive the update
+	if err = wait.PollImmediate(100*time.Millisecond, 10*time.Second, func() (bool, error) {
+		cachedPVC, err := metadataSyncer.pvcLister.PersistentVolumeClaims(testNamespace).Get(pvc.Name)
+		if err != nil {
+			return false, nil
+		}
+		return cachedPVC.Labels[testPVCLabelName] == newTestPVCLabelValue, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
 
 	triggerFullSync(k8sclient, metadataSyncer)
 
@@ -472,7 +488,7 @@ func runFullSyncTest(t *testing.T) {
 	}
 
 	// Cleanup in CNS to delete the volume
-	if err = volumeManager.DeleteVolume(volumeID.Id, true); err != nil {
+	if err = volumeManager.DeleteVolume(ctx, volumeID.Id, true); err != nil {
 		t.Logf("Failed to delete volume %v from CNS", volumeID.Id)
 	}
 	t.Log("End FullSync test")
@@ -532,7 +548,7 @@ func verifyUpdateOperation(queryResult *cnstypes.CnsQueryResult, volumeID string
 }
 
 // getCnsCreateSpec returns the spec for a create call to cns
-func getCnsCreateSpec(t *testing.T) (cnstypes.CnsVolumeCreateSpec, error) {
+func getCnsCreateSpec(t testing.TB) (cnstypes.CnsVolumeCreateSpec, error) {
 	var sharedDatastore string
 	if v := os.Getenv("VSPHERE_DATASTORE_URL"); v != "" {
 		sharedDatastore = v
@@ -576,7 +592,8 @@ func getPersistentVolumeSpec(volumeHandle string, persistentVolumeReclaimPolicy
 	var claimRef *v1.ObjectReference
 	if claimRefName != "" {
 		claimRef = &v1.ObjectReference{
-			Name: claimRefName,
+			Name:      claimRefName,
+			Namespace: testNamespace,
 		}
 	}
 	pv = &v1.PersistentVolume{
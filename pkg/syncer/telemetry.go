@@ -0,0 +1,251 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/common/webhook"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service"
+)
+
+// telemetryReport holds the aggregate usage statistics posted to the
+// telemetry endpoint. It must not hold names, labels or addresses.
+type telemetryReport struct {
+	Time time.Time `json:"time"`
+	// InstallationID is a random ID identifying the reports of a cluster,
+	// see getInstallationID. It can't be traced back to the cluster ID.
+	InstallationID    string `json:"installationId"`
+	DriverVersion     string `json:"driverVersion"`
+	VSphereVersion    string `json:"vsphereVersion,omitempty"`
+	VSphereAPIVersion string `json:"vsphereApiVersion,omitempty"`
+	Nodes             int    `json:"nodes"`
+	StorageClasses    int    `json:"storageClasses"`
+	Volumes           int    `json:"volumes"`
+	BoundVolumes      int    `json:"boundVolumes"`
+	CapacityBytes     int64  `json:"capacityBytes"`
+	// VolumeSizes counts the volumes by size range, see volumeSizeRange
+	VolumeSizes map[string]int `json:"volumeSizes"`
+	// Features lists the optional features enabled in the configuration
+	Features []string `json:"features"`
+}
+
+// telemetryReporter periodically posts a telemetryReport to the telemetry
+// endpoint. Reports are best effort, failures are logged.
+type telemetryReporter struct {
+	metadataSyncer *MetadataSyncInformer
+	endpoint       string
+	interval       time.Duration
+	client         *webhook.Client
+	// installationID is read from the telemetry ConfigMap on first report
+	installationID string
+}
+
+// newTelemetryReporter returns a reporter posting to the endpoint of the
+// syncer configuration
+func newTelemetryReporter(metadataSyncer *MetadataSyncInformer) *telemetryReporter {
+	return &telemetryReporter{
+		metadataSyncer: metadataSyncer,
+		endpoint:       metadataSyncer.cfg.Telemetry.Endpoint,
+		interval:       time.Duration(metadataSyncer.cfg.Telemetry.IntervalHours) * time.Hour,
+		client:         webhook.NewClient(metadataSyncer.cfg.Telemetry.Endpoint, telemetryTimeout),
+	}
+}
+
+// run posts a report after telemetryInitialDelay and then at every interval.
+// It never returns.
+func (r *telemetryReporter) run() {
+	klog.Infof("Telemetry is enabled, reporting usage statistics to %q every %v", r.endpoint, r.interval)
+	time.Sleep(telemetryInitialDelay)
+	r.report()
+	ticker := time.NewTicker(r.interval)
+	for range ticker.C {
+		r.report()
+	}
+}
+
+// report builds and posts a report
+func (r *telemetryReporter) report() {
+	report, err := r.build()
+	if err != nil {
+		klog.Errorf("Failed to build telemetry report. Err: %v", err)
+		return
+	}
+	if err := r.client.Post(report); err != nil {
+		klog.Errorf("Failed to post telemetry report to %q. Err: %v", r.endpoint, err)
+		return
+	}
+	klog.V(2).Infof("Posted telemetry report to %q", r.endpoint)
+}
+
+// build collects the statistics of the report
+func (r *telemetryReporter) build() (*telemetryReport, error) {
+	metadataSyncer := r.metadataSyncer
+	if r.installationID == "" {
+		installationID, err := getInstallationID(metadataSyncer.k8sclient)
+		if err != nil {
+			return nil, err
+		}
+		r.installationID = installationID
+	}
+	report := &telemetryReport{
+		Time:           time.Now(),
+		InstallationID: r.installationID,
+		DriverVersion:  service.GetVersion(),
+		VolumeSizes:    make(map[string]int),
+		Features:       getEnabledFeatures(metadataSyncer.cfg),
+	}
+	if vc := metadataSyncer.vcenter; vc != nil && vc.Client != nil {
+		report.VSphereVersion = vc.Client.ServiceContent.About.Version
+		report.VSphereAPIVersion = vc.Client.ServiceContent.About.ApiVersion
+	}
+	pvs, err := metadataSyncer.pvLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list PVs for telemetry. Err: %v", err)
+		return nil, err
+	}
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != service.Name {
+			continue
+		}
+		report.Volumes++
+		if pv.Status.Phase == v1.VolumeBound {
+			report.BoundVolumes++
+		}
+		capacity := pv.Spec.Capacity[v1.ResourceStorage]
+		report.CapacityBytes += capacity.Value()
+		report.VolumeSizes[volumeSizeRange(capacity.Value())]++
+	}
+	nodes, err := metadataSyncer.k8sclient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list nodes for telemetry. Err: %v", err)
+		return nil, err
+	}
+	report.Nodes = len(nodes.Items)
+	storageClasses, err := metadataSyncer.k8sclient.StorageV1().StorageClasses().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list storage classes for telemetry. Err: %v", err)
+		return nil, err
+	}
+	for _, sc := range storageClasses.Items {
+		if sc.Provisioner == service.Name {
+			report.StorageClasses++
+		}
+	}
+	return report, nil
+}
+
+// getInstallationID returns the ID of this installation from the telemetry
+// ConfigMap, next to the full sync status ConfigMap. A random ID is generated
+// and stored in the ConfigMap the first time, so the reports of a cluster
+// share an ID which, unlike a hash of the cluster ID, can't be matched
+// against known cluster IDs.
+func getInstallationID(k8sclient clientset.Interface) (string, error) {
+	namespace := getFullSyncStatusConfigMapNamespace()
+	configMaps := k8sclient.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMaps.Get(telemetryConfigMapName, metav1.GetOptions{})
+	if err == nil && configMap.Data[telemetryInstallationIDKey] != "" {
+		return configMap.Data[telemetryInstallationIDKey], nil
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.Errorf("Failed to get telemetry ConfigMap %s/%s. Err: %v", namespace, telemetryConfigMapName, err)
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		klog.Errorf("Failed to generate telemetry installation ID. Err: %v", err)
+		return "", err
+	}
+	installationID := hex.EncodeToString(id)
+	if err != nil {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      telemetryConfigMapName,
+				Namespace: namespace,
+			},
+			Data: map[string]string{telemetryInstallationIDKey: installationID},
+		}
+		_, err = configMaps.Create(configMap)
+	} else {
+		configMap = configMap.DeepCopy()
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data[telemetryInstallationIDKey] = installationID
+		_, err = configMaps.Update(configMap)
+	}
+	if err != nil {
+		klog.Errorf("Failed to store the installation ID in telemetry ConfigMap %s/%s. Err: %v", namespace, telemetryConfigMapName, err)
+		return "", err
+	}
+	klog.V(2).Infof("Generated telemetry installation ID %s", installationID)
+	return installationID, nil
+}
+
+// volumeSizeRanges are the upper bounds of the size ranges volumes are counted in
+var volumeSizeRanges = []struct {
+	name  string
+	bytes int64
+}{
+	{"<1Gi", 1 << 30},
+	{"1Gi-10Gi", 10 << 30},
+	{"10Gi-100Gi", 100 << 30},
+	{"100Gi-1Ti", 1 << 40},
+}
+
+// volumeSizeRange returns the name of the size range of a volume of the given size
+func volumeSizeRange(sizeBytes int64) string {
+	for _, sizeRange := range volumeSizeRanges {
+		if sizeBytes < sizeRange.bytes {
+			return sizeRange.name
+		}
+	}
+	return ">=1Ti"
+}
+
+// getEnabledFeatures returns the sorted names of the optional features enabled in cfg
+func getEnabledFeatures(cfg *cnsconfig.Config) []string {
+	enabled := map[string]bool{
+		"topology":           cfg.Labels.Zone != "" && cfg.Labels.Region != "",
+		"soft-delete":        cfg.SoftDelete.RetentionMinutes > 0,
+		"label-sync":         cfg.LabelSync.TagCategories != "",
+		"audit":              cfg.Audit.File != "" || cfg.Audit.WebhookURL != "",
+		"alarms":             cfg.Alarms.FailureThreshold > 0,
+		"metadata-webhook":   cfg.MetadataBackend.WebhookURL != "",
+		"force-detach":       cfg.Global.ForceDetachAfterFailures > 0,
+		"concurrency-limits": cfg.Global.MaxConcurrentCreatesPerDatastore > 0 || len(cfg.DatastoreConcurrency) > 0,
+	}
+	var features []string
+	for feature, isEnabled := range enabled {
+		if isEnabled {
+			features = append(features, feature)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"reflect"
+	"testing"
+
+	testclient "k8s.io/client-go/kubernetes/fake"
+
+	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
+)
+
+func TestVolumeSizeRange(t *testing.T) {
+	tests := map[int64]string{
+		0:             "<1Gi",
+		1 << 30:       "1Gi-10Gi",
+		50 << 30:      "10Gi-100Gi",
+		(1 << 40) - 1: "100Gi-1Ti",
+		2 << 40:       ">=1Ti",
+	}
+	for sizeBytes, expected := range tests {
+		if sizeRange := volumeSizeRange(sizeBytes); sizeRange != expected {
+			t.Errorf("size %d: expected range %q, got %q", sizeBytes, expected, sizeRange)
+		}
+	}
+}
+
+func TestGetEnabledFeatures(t *testing.T) {
+	cfg := &cnsconfig.Config{}
+	if features := getEnabledFeatures(cfg); len(features) != 0 {
+		t.Errorf("expected no features, got %v", features)
+	}
+	cfg.SoftDelete.RetentionMinutes = 60
+	cfg.Labels.Zone = "k8s-zone"
+	cfg.Labels.Region = "k8s-region"
+	if features := getEnabledFeatures(cfg); !reflect.DeepEqual(features, []string{"soft-delete", "topology"}) {
+		t.Errorf("unexpected features %v", features)
+	}
+}
+
+func TestGetInstallationID(t *testing.T) {
+	k8sclient := testclient.NewSimpleClientset()
+	installationID, err := getInstallationID(k8sclient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installationID) != 32 {
+		t.Errorf("expected a random 32 characters installation ID, got %q", installationID)
+	}
+	// The ID stored in the ConfigMap is kept, e.g. when the syncer restarts
+	again, err := getInstallationID(k8sclient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != installationID {
+		t.Errorf("expected installation ID %q to be kept, got %q", installationID, again)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+
+	cnstypes "github.com/vmware/govmomi/cns/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+
+	volumes "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/volume"
+	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
+	"sigs.k8s.io/vsphere-csi-driver/pkg/csi/service/common"
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+)
+
+// datastoreTopologies maps datastore URLs to the distinct zone and region of
+// the kubernetes nodes which can access the datastore
+type datastoreTopologies map[string][]map[string]string
+
+// add records that a node in the given zone and region can access the datastores
+func (topologies datastoreTopologies) add(datastoreURLs []string, zone string, region string, topologyKeys csitypes.TopologyKeys) {
+	if zone == "" || region == "" {
+		return
+	}
+	for _, datastoreURL := range datastoreURLs {
+		found := false
+		for _, topology := range topologies[datastoreURL] {
+			if topology[topologyKeys.Zone] == zone && topology[topologyKeys.Region] == region {
+				found = true
+				break
+			}
+		}
+		if !found {
+			topologies[datastoreURL] = append(topologies[datastoreURL], map[string]string{
+				topologyKeys.Zone:   zone,
+				topologyKeys.Region: region,
+			})
+		}
+	}
+}
+
+// setStaticPVNodeAffinities sets node affinity on statically provisioned PVs
+// using the zone and region of the nodes which can access the datastore
+// backing the volume, so pods using the PV are not scheduled on nodes which
+// can not reach the disk. PVs which already have node affinity are left
+// untouched, as are all PVs when zone and region categories are not configured.
+// The datastores accessible from each node are looked up once for all the PVs.
+func setStaticPVNodeAffinities(ctx context.Context, k8sclient clientset.Interface, pvs []*v1.PersistentVolume, metadataSyncer *MetadataSyncInformer) {
+	if metadataSyncer.cfg.Labels.Zone == "" || metadataSyncer.cfg.Labels.Region == "" {
+		klog.V(4).Infof("Zone/Region vsphere category names not specified in the vsphere config secret. Skipping node affinity for %d PVs", len(pvs))
+		return
+	}
+	var pvsWithoutAffinity []*v1.PersistentVolume
+	for _, pv := range pvs {
+		if pv.Spec.NodeAffinity == nil && pv.Spec.CSI != nil {
+			pvsWithoutAffinity = append(pvsWithoutAffinity, pv)
+		}
+	}
+	if len(pvsWithoutAffinity) == 0 {
+		return
+	}
+	volumeDatastores, err := getVolumeDatastores(ctx, pvsWithoutAffinity, metadataSyncer)
+	if err != nil {
+		klog.Errorf("Failed to get datastores of %d volumes. Err: %v", len(pvsWithoutAffinity), err)
+		return
+	}
+	topologies, err := getDatastoreTopologies(ctx, k8sclient, metadataSyncer)
+	if err != nil {
+		klog.Errorf("Failed to get accessible topologies of datastores. Err: %v", err)
+		return
+	}
+	topologyKeys := csitypes.GetTopologyKeys(metadataSyncer.cfg)
+	for _, pv := range pvsWithoutAffinity {
+		datastoreURL, ok := volumeDatastores[pv.Spec.CSI.VolumeHandle]
+		if !ok {
+			klog.Warningf("Volume %s not found in CNS. Skipping node affinity for PV %s", pv.Spec.CSI.VolumeHandle, pv.Name)
+			continue
+		}
+		nodeAffinity := getNodeAffinity(topologies[datastoreURL], topologyKeys)
+		if nodeAffinity == nil {
+			klog.Warningf("No node in the cluster can access volume %s. Skipping node affinity for PV %s", pv.Spec.CSI.VolumeHandle, pv.Name)
+			continue
+		}
+		if err := setPVNodeAffinity(k8sclient, pv.Name, nodeAffinity); err != nil {
+			klog.Errorf("Failed to set node affinity on PV %s. Err: %v", pv.Name, err)
+			continue
+		}
+		klog.V(2).Infof("Set node affinity %+v on PV %s", nodeAffinity.Required.NodeSelectorTerms, pv.Name)
+	}
+}
+
+// setPVNodeAffinity sets the node affinity of the latest version of the PV,
+// unless it got node affinity in the meantime
+func setPVNodeAffinity(k8sclient clientset.Interface, pvName string, nodeAffinity *v1.VolumeNodeAffinity) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pv, err := k8sclient.CoreV1().PersistentVolumes().Get(pvName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pv.Spec.NodeAffinity != nil {
+			return nil
+		}
+		pv.Spec.NodeAffinity = nodeAffinity
+		_, err = k8sclient.CoreV1().PersistentVolumes().Update(pv)
+		return err
+	})
+}
+
+// getNodeAffinity returns the node affinity selecting nodes in any of the
+// given topologies, or nil if there are none
+func getNodeAffinity(topologies []map[string]string, topologyKeys csitypes.TopologyKeys) *v1.VolumeNodeAffinity {
+	if len(topologies) == 0 {
+		return nil
+	}
+	var terms []v1.NodeSelectorTerm
+	for _, topology := range topologies {
+		terms = append(terms, v1.NodeSelectorTerm{
+			MatchExpressions: []v1.NodeSelectorRequirement{
+				{
+					Key:      topologyKeys.Zone,
+					Operator: v1.NodeSelectorOpIn,
+					Values:   []string{topology[topologyKeys.Zone]},
+				},
+				{
+					Key:      topologyKeys.Region,
+					Operator: v1.NodeSelectorOpIn,
+					Values:   []string{topology[topologyKeys.Region]},
+				},
+			},
+		})
+	}
+	return &v1.VolumeNodeAffinity{
+		Required: &v1.NodeSelector{NodeSelectorTerms: terms},
+	}
+}
+
+// getVolumeDatastores returns the datastore URL of the volumes of the given
+// PVs, keyed by volume ID. Volumes are queried from CNS in batches.
+func getVolumeDatastores(ctx context.Context, pvs []*v1.PersistentVolume, metadataSyncer *MetadataSyncInformer) (map[string]string, error) {
+	volumeDatastores := make(map[string]string)
+	for start := 0; start < len(pvs); start += queryVolumeBatchSize {
+		end := start + queryVolumeBatchSize
+		if end > len(pvs) {
+			end = len(pvs)
+		}
+		var volumeIds []cnstypes.CnsVolumeId
+		for _, pv := range pvs[start:end] {
+			volumeIds = append(volumeIds, cnstypes.CnsVolumeId{Id: pv.Spec.CSI.VolumeHandle})
+		}
+		queryResult, err := volumes.GetManager(metadataSyncer.vcenter).QueryVolume(ctx, cnstypes.CnsQueryFilter{VolumeIds: volumeIds})
+		if err != nil {
+			klog.Errorf("QueryVolume failed for volumes %v. Err: %v", volumeIds, err)
+			return nil, err
+		}
+		for _, volume := range queryResult.Volumes {
+			volumeDatastores[volume.VolumeId.Id] = volume.DatastoreUrl
+		}
+	}
+	return volumeDatastores, nil
+}
+
+// getDatastoreTopologies returns the accessible topologies of the datastores
+// of the kubernetes nodes. Nodes whose VM, datastores or zone and region
+// can't be retrieved are skipped, so a single unhealthy node doesn't prevent
+// setting node affinity for the nodes which can access the volume.
+func getDatastoreTopologies(ctx context.Context, k8sclient clientset.Interface, metadataSyncer *MetadataSyncInformer) (datastoreTopologies, error) {
+	nodes, err := k8sclient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list kubernetes nodes. Err: %v", err)
+		return nil, err
+	}
+	topologyKeys := csitypes.GetTopologyKeys(metadataSyncer.cfg)
+	topologies := make(datastoreTopologies)
+	for _, node := range nodes.Items {
+		nodeUUID := common.GetUUIDFromProviderID(node.Spec.ProviderID)
+		if nodeUUID == "" {
+			klog.V(3).Infof("Node %s has no providerID. Skipping it", node.Name)
+			continue
+		}
+		nodeVM, err := cnsvsphere.GetVirtualMachineByUUID(nodeUUID, false)
+		if err != nil {
+			klog.Warningf("Failed to get VM for node %s with uuid %s. Skipping it. Err: %v", node.Name, nodeUUID, err)
+			continue
+		}
+		accessibleDatastores, err := nodeVM.GetAllAccessibleDatastores(ctx)
+		if err != nil {
+			klog.Warningf("Failed to get accessible datastores for node %s. Skipping it. Err: %v", node.Name, err)
+			continue
+		}
+		zone, region, err := nodeVM.GetZoneRegion(ctx, metadataSyncer.cfg.Labels.Zone, metadataSyncer.cfg.Labels.Region)
+		if err != nil {
+			klog.Warningf("Failed to get zone and region for node %s. Skipping it. Err: %v", node.Name, err)
+			continue
+		}
+		var datastoreURLs []string
+		for _, datastore := range accessibleDatastores {
+			datastoreURLs = append(datastoreURLs, datastore.Info.Url)
+		}
+		topologies.add(datastoreURLs, zone, region, topologyKeys)
+	}
+	klog.V(4).Infof("Accessible topologies of datastores: %+v", topologies)
+	return topologies, nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+
+	csitypes "sigs.k8s.io/vsphere-csi-driver/pkg/csi/types"
+)
+
+var testTopologyKeys = csitypes.TopologyKeys{Zone: csitypes.LabelZoneFailureDomain, Region: csitypes.LabelRegionFailureDomain}
+
+func TestDatastoreTopologies(t *testing.T) {
+	topologies := make(datastoreTopologies)
+	topologies.add([]string{"ds:///shared/", "ds:///local-1/"}, "zone-a", "region-1", testTopologyKeys)
+	topologies.add([]string{"ds:///shared/", "ds:///local-2/"}, "zone-b", "region-1", testTopologyKeys)
+	// A second node in zone-a
+	topologies.add([]string{"ds:///shared/"}, "zone-a", "region-1", testTopologyKeys)
+	// A node without zone and region
+	topologies.add([]string{"ds:///untagged/"}, "", "", testTopologyKeys)
+
+	if n := len(topologies["ds:///shared/"]); n != 2 {
+		t.Errorf("Expected 2 distinct topologies for the shared datastore, got %d: %v", n, topologies["ds:///shared/"])
+	}
+	if local := topologies["ds:///local-2/"]; len(local) != 1 || local[0][testTopologyKeys.Zone] != "zone-b" {
+		t.Errorf("Expected zone-b for local-2, got %v", local)
+	}
+	if _, ok := topologies["ds:///untagged/"]; ok {
+		t.Errorf("Expected no topology for datastores of nodes without zone and region")
+	}
+}
+
+func TestGetNodeAffinity(t *testing.T) {
+	if nodeAffinity := getNodeAffinity(nil, testTopologyKeys); nodeAffinity != nil {
+		t.Errorf("Expected no node affinity without topologies, got %+v", nodeAffinity)
+	}
+	topologies := make(datastoreTopologies)
+	topologies.add([]string{"ds:///shared/"}, "zone-a", "region-1", testTopologyKeys)
+	topologies.add([]string{"ds:///shared/"}, "zone-b", "region-1", testTopologyKeys)
+	nodeAffinity := getNodeAffinity(topologies["ds:///shared/"], testTopologyKeys)
+	if nodeAffinity == nil || len(nodeAffinity.Required.NodeSelectorTerms) != 2 {
+		t.Fatalf("Expected a node selector term per topology, got %+v", nodeAffinity)
+	}
+	term := nodeAffinity.Required.NodeSelectorTerms[0]
+	if term.MatchExpressions[0].Key != testTopologyKeys.Zone || term.MatchExpressions[0].Values[0] != "zone-a" {
+		t.Errorf("Unexpected node selector term %+v", term)
+	}
+}
+
+func TestSetPVNodeAffinity(t *testing.T) {
+	existingAffinity := getNodeAffinity([]map[string]string{
+		{testTopologyKeys.Zone: "zone-b", testTopologyKeys.Region: "region-1"},
+	}, testTopologyKeys)
+	k8sclient := testclient.NewSimpleClientset(
+		&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}},
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-2"},
+			Spec:       v1.PersistentVolumeSpec{NodeAffinity: existingAffinity},
+		},
+	)
+	nodeAffinity := getNodeAffinity([]map[string]string{
+		{testTopologyKeys.Zone: "zone-a", testTopologyKeys.Region: "region-1"},
+	}, testTopologyKeys)
+	for _, name := range []string{"pv-1", "pv-2"} {
+		if err := setPVNodeAffinity(k8sclient, name, nodeAffinity); err != nil {
+			t.Fatalf("Failed to set node affinity on %s: %v", name, err)
+		}
+	}
+	pv, _ := k8sclient.CoreV1().PersistentVolumes().Get("pv-1", metav1.GetOptions{})
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values[0] != "zone-a" {
+		t.Errorf("Expected node affinity to be set on pv-1, got %+v", pv.Spec.NodeAffinity)
+	}
+	pv, _ = k8sclient.CoreV1().PersistentVolumes().Get("pv-2", metav1.GetOptions{})
+	if pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values[0] != "zone-b" {
+		t.Errorf("Expected node affinity of pv-2 to be kept, got %+v", pv.Spec.NodeAffinity)
+	}
+}
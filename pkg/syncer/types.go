@@ -18,9 +18,12 @@ package syncer
 
 import (
 	"sync"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 
 	cnsvsphere "sigs.k8s.io/vsphere-csi-driver/pkg/common/cns-lib/vsphere"
 	cnsconfig "sigs.k8s.io/vsphere-csi-driver/pkg/common/config"
@@ -43,22 +46,85 @@ const (
 
 	// Env variable for FullSync interval
 	envFullSyncIntervalMinutes = "FULL_SYNC_INTERVAL_MINUTES"
+
+	// Env variable for number of full sync cycles between complete metadata scans
+	envFullSyncCompleteScanCycles = "FULL_SYNC_COMPLETE_SCAN_CYCLES"
+	// default number of full sync cycles after which metadata of all volumes is compared again,
+	// used unless overridden by user in csi-controller YAML
+	defaultFullSyncCompleteScanCycles = 4
+
+	// Name of the ConfigMap the result of the last full sync cycle is published in
+	fullSyncStatusConfigMapName = "vsphere-csi-fullsync-status"
+	// Key of the full sync result in the full sync status ConfigMap
+	fullSyncStatusResultKey = "result"
+	// Key of the full sync generation in the full sync status ConfigMap
+	fullSyncStatusGenerationKey = "generation"
+	// Env variable for the namespace of the full sync status ConfigMap
+	envFullSyncStatusConfigMapNamespace = "FULL_SYNC_STATUS_CONFIGMAP_NAMESPACE"
+	// default namespace of the full sync status ConfigMap
+	defaultFullSyncStatusConfigMapNamespace = "kube-system"
+
+	// Maximum number of volume IDs passed to CNS in a single QueryVolume call
+	queryVolumeBatchSize = 100
+
+	// PV annotation holding the URL of the datastore the volume was last seen on
+	annDatastoreURL = "cns.vmware.com/datastore-url"
+	// Reason of the event recorded when a volume is found on a different datastore
+	eventReasonVolumeRelocated = "VolumeRelocated"
+	// Prefix of the PV labels reflecting the tags attached to the disk of the volume,
+	// followed by the tag category
+	labelSyncPrefix = "cns.vmware.com/"
+	// Label on the PV entity of a volume on CNS holding the checksum of the K8s
+	// metadata last written by full sync
+	labelMetadataChecksum = "cns.vmware.com/metadata-checksum"
+	// Component name of events emitted by the syncer
+	eventSourceComponent = "vsphere-csi-syncer"
+	// A full sync cycle is suspect if the number of volumes on CNS drops below
+	// this ratio of the previous cycle, when the previous cycle had at least
+	// suspectVolumeDropMinVolumes volumes
+	suspectVolumeDropRatio      = 0.5
+	suspectVolumeDropMinVolumes = 10
+
+	// Number of metadata updates buffered for the metadata webhook before updates are dropped
+	metadataWebhookQueueSize = 1000
+	// Timeout of posting a metadata update to the metadata webhook
+	metadataWebhookTimeout = 10 * time.Second
+
+	// Delay before the first telemetry report, letting the informers sync
+	telemetryInitialDelay = 10 * time.Minute
+	// Timeout of posting a telemetry report
+	telemetryTimeout = 30 * time.Second
+	// Name of the ConfigMap the telemetry installation ID is stored in
+	telemetryConfigMapName = "vsphere-csi-telemetry"
+	// Key of the installation ID in the telemetry ConfigMap
+	telemetryInstallationIDKey = "installation-id"
+
+	// Env variable for the URL of the metrics of the CSI controller, used to
+	// throttle full sync when the controller is busy
+	envControllerMetricsURL = "CONTROLLER_METRICS_URL"
+	// default URL of the metrics of the CSI controller running in the same pod
+	defaultControllerMetricsURL = "http://127.0.0.1:2112/metrics"
+	// Metric of the CSI controller counting its operations in progress
+	inflightOperationsMetric = "vsphere_csi_inflight_operations"
+	// Interval between two reads of the load of the CSI controller
+	controllerLoadInterval = 10 * time.Second
+
+	// Interval between two runs of the metadata retry queue
+	metadataRetryInterval = time.Second
+	// Backoff before the first retry of a failed metadata update, doubled
+	// after each failed retry up to metadataRetryMaxBackoff
+	metadataRetryInitialBackoff = 5 * time.Second
+	metadataRetryMaxBackoff     = 5 * time.Minute
+	// Number of retries after which a metadata update is given up on
+	metadataRetryMaxAttempts = 8
+	// Number of failed metadata updates waiting to be retried, beyond which
+	// failed updates are given up on right away
+	metadataRetryQueueSize = 10000
+	// Number of metadata updates given up on kept in the dead-letter list
+	metadataDeadLettersSize = 1000
 )
 
 var (
-	// Create a mapping of CNS volume to Pod name
-	// as this mapping does not exist in K8s
-	// in case a Pod entry needs to be deleted from CNS cache
-	cnsVolumeToPodMap map[string]string
-
-	// Create a mapping of CNS volume to Pvc name
-	cnsVolumeToPvcMap map[string]string
-
-	// Create a mapping of CNS volume to entity Namespace name
-	// Here entity can be either PVC or Pod - both will
-	// belong to the same namespace
-	cnsVolumeToEntityNamespaceMap map[string]string
-
 	// cnsDeletionMap tracks volumes that exist in CNS but not in K8s
 	// If a volume exists in this map across two fullsync cycles,
 	// the volume is deleted from CNS
@@ -69,12 +135,46 @@ var (
 	// the volume is created in CNS
 	cnsCreationMap map[string]bool
 
+	// cnsSyncedMetadataMap maps volume ID to the hash of the K8s metadata
+	// which was found in sync with CNS in a previous fullsync cycle.
+	// Incremental full sync skips querying CNS for volumes whose K8s
+	// metadata hash has not changed since then.
+	cnsSyncedMetadataMap map[string]uint64
+
+	// cnsVolumeDatastoreMap maps volume ID to the URL of the datastore
+	// the volume was found on in the previous fullsync cycle
+	cnsVolumeDatastoreMap map[string]string
+
+	// fullSyncCycle counts the fullsync cycles since the syncer started
+	fullSyncCycle int
+
+	// fullSyncGeneration counts the completed fullsync cycles since the syncer started
+	fullSyncGeneration int64
+
+	// fullSyncQueryFailed is set when the previous fullsync cycle failed to query CNS
+	fullSyncQueryFailed bool
+
+	// fullSyncCnsVolumeCount is the number of volumes returned by CNS in the previous fullsync cycle
+	fullSyncCnsVolumeCount int
+
 	// Metadata syncer and full sync share a global lock
 	// to mitigate race conditions related to
 	// static provisioning of volumes
 	volumeOperationsLock sync.Mutex
 )
 
+// fullSyncVolume is the state of a K8s volume in a full sync cycle
+type fullSyncVolume struct {
+	// operation to perform on CNS, empty if none
+	operation string
+	// Name of the PVC and Pod of the volume on CNS, and their namespace,
+	// as this mapping does not exist in K8s in case their entries need
+	// to be deleted from CNS
+	cnsPVCName         string
+	cnsPodName         string
+	cnsEntityNamespace string
+}
+
 type (
 	// Maps K8s PV names to respective PVC object
 	pvcMap = map[string]*v1.PersistentVolumeClaim
@@ -86,9 +186,17 @@ type (
 type MetadataSyncInformer struct {
 	cfg                  *cnsconfig.Config
 	vcconfig             *cnsvsphere.VirtualCenterConfig
+	k8sclient            clientset.Interface
 	k8sInformerManager   *k8s.InformerManager
 	virtualcentermanager cnsvsphere.VirtualCenterManager
 	vcenter              *cnsvsphere.VirtualCenter
 	pvLister             corelisters.PersistentVolumeLister
 	pvcLister            corelisters.PersistentVolumeClaimLister
+	eventRecorder        record.EventRecorder
+	// cnsBackend pushes volume metadata to CNS
+	cnsBackend metadataBackend
+	// externalBackends receive the volume metadata pushed to CNS, on a best effort basis
+	externalBackends []metadataBackend
+	// metadataRetries retries metadata updates which failed on CNS
+	metadataRetries *metadataRetryQueue
 }
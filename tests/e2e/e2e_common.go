@@ -33,6 +33,7 @@ const (
 	diskSizeInMb                               = int64(2048)
 	e2evSphereCSIBlockDriverName               = "csi.vsphere.vmware.com"
 	envVolumeOperationsScale                   = "VOLUME_OPS_SCALE"
+	envPerfVolumeScale                         = "PERF_VOLUME_SCALE"
 	envStoragePolicyNameForSharedDatastores    = "STORAGE_POLICY_FOR_SHARED_DATASTORES"
 	envStoragePolicyNameForNonSharedDatastores = "STORAGE_POLICY_FOR_NONSHARED_DATASTORES"
 	envStoragePolicyNameFromInaccessibleZone   = "STORAGE_POLICY_FROM_INACCESSIBLE_ZONE"
@@ -44,6 +45,9 @@ const (
 	envFullSyncWaitTime                        = "FULL_SYNC_WAIT_TIME"
 	defaultPandoraSyncWaitTime                 = 90
 	defaultFullSyncWaitTime                    = 1800
+	fullSyncStatusConfigMapName                = "vsphere-csi-fullsync-status"
+	fullSyncStatusConfigMapNamespace           = "kube-system"
+	fullSyncStatusResultKey                    = "result"
 	sleepTimeOut                               = 30
 	k8sPodTerminationTimeOut                   = 7 * time.Minute
 	vsanhealthServiceName                      = "vsan-health"
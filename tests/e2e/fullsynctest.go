@@ -157,8 +157,9 @@ var _ bool = ginkgo.Describe("[csi-block-e2e] full-sync-test", func() {
 		err = invokeVCenterServiceControl(startVsanHealthOperation, vsanhealthServiceName, vcAddress)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		ginkgo.By(fmt.Sprintf("Sleeping for %v seconds to allow full sync finish", fullSyncWaitTime))
-		time.Sleep(time.Duration(fullSyncWaitTime) * time.Second)
+		ginkgo.By(fmt.Sprintf("Waiting up to %v seconds for two full sync cycles to finish", fullSyncWaitTime))
+		err = waitForFullSyncCycles(client, 2, time.Duration(fullSyncWaitTime)*time.Second)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		ginkgo.By(fmt.Sprintf("Waiting for volume %s to be created", fcdID))
 		err = e2eVSphere.waitForCNSVolumeToBeCreated(fcdID)
@@ -210,8 +211,9 @@ var _ bool = ginkgo.Describe("[csi-block-e2e] full-sync-test", func() {
 		err = invokeVCenterServiceControl(startVsanHealthOperation, vsanhealthServiceName, vcAddress)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		ginkgo.By(fmt.Sprintf("Sleeping for %v seconds to allow full sync finish", fullSyncWaitTime))
-		time.Sleep(time.Duration(fullSyncWaitTime) * time.Second)
+		ginkgo.By(fmt.Sprintf("Waiting up to %v seconds for two full sync cycles to finish", fullSyncWaitTime))
+		err = waitForFullSyncCycles(client, 2, time.Duration(fullSyncWaitTime)*time.Second)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		ginkgo.By(fmt.Sprintf("Waiting for labels %+v to be updated for pvc %s in namespace %s", labels, pvc.Name, pvc.Namespace))
 		err = e2eVSphere.waitForLabelsToBeUpdated(pv.Spec.CSI.VolumeHandle, labels, string(cnstypes.CnsKubernetesEntityTypePVC), pvc.Name, pvc.Namespace)
@@ -283,8 +285,9 @@ var _ bool = ginkgo.Describe("[csi-block-e2e] full-sync-test", func() {
 		err = invokeVCenterServiceControl(startVsanHealthOperation, vsanhealthServiceName, vcAddress)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		ginkgo.By(fmt.Sprintf("Sleeping for %v seconds to allow full sync finish", fullSyncWaitTime))
-		time.Sleep(time.Duration(fullSyncWaitTime) * time.Second)
+		ginkgo.By(fmt.Sprintf("Waiting up to %v seconds for two full sync cycles to finish", fullSyncWaitTime))
+		err = waitForFullSyncCycles(client, 2, time.Duration(fullSyncWaitTime)*time.Second)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		ginkgo.By(fmt.Sprintf("Waiting for volume %s to be deleted", fcdID))
 		err = e2eVSphere.waitForCNSVolumeToBeDeleted(fcdID)
@@ -362,8 +365,9 @@ var _ bool = ginkgo.Describe("[csi-block-e2e] full-sync-test", func() {
 		err = invokeVCenterServiceControl(startVsanHealthOperation, vsanhealthServiceName, vcAddress)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		ginkgo.By(fmt.Sprintf("Sleeping for %v seconds to allow full sync finish", fullSyncWaitTime))
-		time.Sleep(time.Duration(fullSyncWaitTime) * time.Second)
+		ginkgo.By(fmt.Sprintf("Waiting up to %v seconds for two full sync cycles to finish", fullSyncWaitTime))
+		err = waitForFullSyncCycles(client, 2, time.Duration(fullSyncWaitTime)*time.Second)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		ginkgo.By(fmt.Sprintf("Waiting for pvc metadata to be deleted for pvc %s in namespace %s", pvclaims[0].Name, pvclaims[0].Namespace))
 		err = e2eVSphere.waitForMetadataToBeDeleted(pvs[0].Spec.CSI.VolumeHandle, string(cnstypes.CnsKubernetesEntityTypePVC), pvclaims[0].Name, pvclaims[0].Namespace)
@@ -454,8 +458,9 @@ var _ bool = ginkgo.Describe("[csi-block-e2e] full-sync-test", func() {
 		err = invokeVCenterServiceControl(startVsanHealthOperation, vsanhealthServiceName, vcAddress)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		ginkgo.By(fmt.Sprintf("Sleeping for %v seconds to allow full sync finish", fullSyncWaitTime))
-		time.Sleep(time.Duration(fullSyncWaitTime) * time.Second)
+		ginkgo.By(fmt.Sprintf("Waiting up to %v seconds for two full sync cycles to finish", fullSyncWaitTime))
+		err = waitForFullSyncCycles(client, 2, time.Duration(fullSyncWaitTime)*time.Second)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		ginkgo.By("Verify container volume metadata is matching the one in CNS cache")
 		err = verifyVolumeMetadataInCNS(&e2eVSphere, pv.Spec.CSI.VolumeHandle, pvc.Name, pv.ObjectMeta.Name, "")
@@ -535,8 +540,9 @@ var _ bool = ginkgo.Describe("[csi-block-e2e] full-sync-test", func() {
 		err = invokeVCenterServiceControl(startVsanHealthOperation, vsanhealthServiceName, vcAddress)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		ginkgo.By(fmt.Sprintf("Sleeping for %v seconds to allow full sync finish", fullSyncWaitTime))
-		time.Sleep(time.Duration(fullSyncWaitTime) * time.Second)
+		ginkgo.By(fmt.Sprintf("Waiting up to %v seconds for two full sync cycles to finish", fullSyncWaitTime))
+		err = waitForFullSyncCycles(client, 2, time.Duration(fullSyncWaitTime)*time.Second)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		ginkgo.By(fmt.Sprintf("Waiting for pvc metadata to be deleted for pvc %s in namespace %s", pvc.Name, pvc.Namespace))
 		err = e2eVSphere.waitForMetadataToBeDeleted(pv.Spec.CSI.VolumeHandle, string(cnstypes.CnsKubernetesEntityTypePVC), pvc.Name, pvc.Namespace)
@@ -598,8 +604,9 @@ var _ bool = ginkgo.Describe("[csi-block-e2e] full-sync-test", func() {
 		statefulSet = updateStatefulSetReplica(client, 1, vSphereCSIControllerPodNamePrefix, kubeSystemNamespace)
 		ginkgo.By(fmt.Sprintf("Successfully scaled up the csi driver statefulset:%s to one replica", statefulSet.Name))
 
-		ginkgo.By(fmt.Sprintf("Sleeping for %v seconds to allow full sync finish", fullSyncWaitTime))
-		time.Sleep(time.Duration(fullSyncWaitTime) * time.Second)
+		ginkgo.By(fmt.Sprintf("Waiting up to %v seconds for two full sync cycles to finish", fullSyncWaitTime))
+		err = waitForFullSyncCycles(client, 2, time.Duration(fullSyncWaitTime)*time.Second)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		ginkgo.By(fmt.Sprintf("Waiting for volume %s to be created", fcdID))
 		err = e2eVSphere.waitForCNSVolumeToBeCreated(fcdID)
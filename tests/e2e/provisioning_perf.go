@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/kubernetes/test/e2e/framework"
+	"k8s.io/kubernetes/test/e2e/storage/utils"
+)
+
+/*
+	Test to measure the provisioning and deletion throughput of the driver at scale.
+	The durations are logged as a baseline to compare releases against.
+
+	Steps
+		1. Create storage class for dynamic volume provisioning using CSI driver.
+		2. Create PERF_VOLUME_SCALE PVCs using above storage class.
+		3. Measure the time until all PVCs are bound.
+		4. Delete all PVCs.
+		5. Measure the time until all volumes are deleted from kubernetes and CNS.
+		6. Delete storage class.
+*/
+
+var _ = utils.SIGDescribe("[csi-perf] Volume Provisioning Performance", func() {
+	f := framework.NewDefaultFramework("volume-provisioning-perf")
+	const defaultPerfVolumeScale = 100
+	var (
+		client      clientset.Interface
+		namespace   string
+		volumeScale int
+		err         error
+	)
+	ginkgo.BeforeEach(func() {
+		client = f.ClientSet
+		namespace = f.Namespace.Name
+		bootstrap()
+		if v := os.Getenv(envPerfVolumeScale); v != "" {
+			volumeScale, err = strconv.Atoi(v)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		} else {
+			volumeScale = defaultPerfVolumeScale
+		}
+	})
+
+	ginkgo.It("measure time to provision and delete volumes", func() {
+		ginkgo.By(fmt.Sprintf("Running test with %s: %v", envPerfVolumeScale, volumeScale))
+		ginkgo.By("Creating Storage Class")
+		var storageclass *storage.StorageClass
+		storageclass, err = client.StorageV1().StorageClasses().Create(getVSphereStorageClassSpec("", nil, nil, "", ""))
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		defer client.StorageV1().StorageClasses().Delete(storageclass.Name, nil)
+
+		ginkgo.By("Creating PVCs using the Storage Class")
+		pvclaims := make([]*v1.PersistentVolumeClaim, volumeScale)
+		start := time.Now()
+		for i := range pvclaims {
+			pvclaims[i], err = framework.CreatePVC(client, namespace, getPersistentVolumeClaimSpecWithStorageClass(namespace, diskSize, storageclass, nil))
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		}
+
+		ginkgo.By("Waiting for all claims to be in bound state")
+		persistentvolumes, err := framework.WaitForPVClaimBoundPhase(client, pvclaims, framework.ClaimProvisionTimeout*time.Duration(1+volumeScale/defaultPerfVolumeScale))
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		provisionDuration := time.Since(start)
+		framework.Logf("Provisioned %d volumes in %v (%v per volume)", volumeScale, provisionDuration, provisionDuration/time.Duration(volumeScale))
+
+		ginkgo.By("Deleting all PVCs")
+		start = time.Now()
+		for _, claim := range pvclaims {
+			err = framework.DeletePersistentVolumeClaim(client, claim.Name, namespace)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		}
+		ginkgo.By("Wait until all PVs are deleted from Kubernetes and CNS")
+		for _, pv := range persistentvolumes {
+			err = framework.WaitForPersistentVolumeDeleted(client, pv.Name, framework.Poll, framework.PodDeleteTimeout)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			err = e2eVSphere.waitForCNSVolumeToBeDeleted(pv.Spec.CSI.VolumeHandle)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		}
+		deleteDuration := time.Since(start)
+		framework.Logf("Deleted %d volumes in %v (%v per volume)", volumeScale, deleteDuration, deleteDuration/time.Duration(volumeScale))
+	})
+})
@@ -18,6 +18,7 @@ package e2e
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"path/filepath"
@@ -36,8 +37,10 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/kubernetes/test/e2e/framework"
 	"k8s.io/kubernetes/test/e2e/manifest"
@@ -367,6 +370,57 @@ func invokeVCenterServiceControl(command, service, host string) error {
 	return nil
 }
 
+// fullSyncResult holds the fields of the full sync result published by the syncer used by the tests
+type fullSyncResult struct {
+	Generation int64     `json:"generation"`
+	Completed  bool      `json:"completed"`
+	StartTime  time.Time `json:"startTime"`
+}
+
+// getFullSyncResult returns the result of the last full sync cycle, or nil if no cycle completed yet
+func getFullSyncResult(client clientset.Interface) (*fullSyncResult, error) {
+	configMap, err := client.CoreV1().ConfigMaps(fullSyncStatusConfigMapNamespace).Get(fullSyncStatusConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &fullSyncResult{}
+	if err := json.Unmarshal([]byte(configMap.Data[fullSyncStatusResultKey]), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// waitForFullSyncCycles waits until the syncer completed the given number of
+// full sync cycles which started after this function was called. Cycles
+// which returned early, e.g. as CNS couldn't be queried, are not counted.
+func waitForFullSyncCycles(client clientset.Interface, cycles int64, timeout time.Duration) error {
+	since := time.Now()
+	var firstGeneration int64
+	return wait.Poll(poll, timeout, func() (bool, error) {
+		result, err := getFullSyncResult(client)
+		if err != nil {
+			framework.Logf("Failed to get full sync result: %v", err)
+			return false, nil
+		}
+		if result == nil || !result.StartTime.After(since) {
+			return false, nil
+		}
+		if !result.Completed {
+			framework.Logf("Full sync cycle started at %v didn't complete", result.StartTime)
+			return false, nil
+		}
+		if firstGeneration == 0 || result.Generation < firstGeneration {
+			// The generation starts over when the syncer restarts
+			firstGeneration = result.Generation
+		}
+		framework.Logf("Full sync generation %d completed", result.Generation)
+		return result.Generation-firstGeneration+1 >= cycles, nil
+	})
+}
+
 // verifyVolumeTopology verifies that the Node Affinity rules in the volume
 // match the topology constraints specified in the storage class
 func verifyVolumeTopology(pv *v1.PersistentVolume, zoneValues []string, regionValues []string) (string, string, error) {